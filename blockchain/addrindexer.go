@@ -132,7 +132,7 @@ func (a *AddrIndexer) SyncAttachBlock(bindingState state.Trie, block *massutil.B
 		sha := block.Hash()
 		height := block.Height()
 
-		txAddrIndex, btxAddrIndex, btxSpentIndex, err := a.indexBlockAddrs(bindingState, block, txStore)
+		addrIndexData, err := a.indexBlockAddrs(bindingState, block, txStore)
 		if err != nil {
 			logging.CPrint(logging.PANIC,
 				"Unable to index transactions of block",
@@ -144,11 +144,6 @@ func (a *AddrIndexer) SyncAttachBlock(bindingState state.Trie, block *massutil.B
 			return err
 		}
 
-		addrIndexData := &database.AddrIndexData{
-			TxIndex:             txAddrIndex,
-			BindingTxIndex:      btxAddrIndex,
-			BindingTxSpentIndex: btxSpentIndex,
-		}
 		err = a.db.SubmitAddrIndex(sha, height, addrIndexData)
 		if err != nil {
 			logging.CPrint(logging.PANIC, "Unable to write index for block",
@@ -295,13 +290,14 @@ func indexScriptPubKeyForTxOut(txAddrIndex shTxLoc, btxAddrIndex btxIndex, scrip
 	return nil
 }
 
-func (a *AddrIndexer) indexBlockAddrs(bindingState state.Trie, blk *massutil.Block, txStore TxStore) (database.TxAddrIndex, database.BindingTxAddrIndex, database.BindingTxSpentAddrIndex, error) {
+func (a *AddrIndexer) indexBlockAddrs(bindingState state.Trie, blk *massutil.Block, txStore TxStore) (*database.AddrIndexData, error) {
 	addrIndex := make(database.TxAddrIndex)
 	bindingTxAddrIndex := make(database.BindingTxAddrIndex)
 	bindingTxSpentIndex := make(database.BindingTxSpentAddrIndex)
+	var outputs, spentOutputs []*database.AddrIndexOutput
 	txLocs, err := blk.TxLoc()
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 
 	txAddrIndex := make(shTxLoc)
@@ -310,7 +306,7 @@ func (a *AddrIndexer) indexBlockAddrs(bindingState state.Trie, blk *massutil.Blo
 
 	networkBinding, err := GetNetworkBinding(bindingState)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 	oldNetworkBinding := networkBinding
 
@@ -333,18 +329,18 @@ func (a *AddrIndexer) indexBlockAddrs(bindingState state.Trie, blk *massutil.Blo
 				prevOut := txIn.PreviousOutPoint
 				txD, ok := txStore[prevOut.Hash]
 				if !ok {
-					return nil, nil, nil, fmt.Errorf("transaction %v not found",
+					return nil, fmt.Errorf("transaction %v not found",
 						prevOut.Hash)
 				}
 				if txD.Err != nil {
-					return nil, nil, nil, txD.Err
+					return nil, txD.Err
 				}
 
 				blkHeightBefore, txOffsetBefore, txLenBefore, err := a.db.GetUnspentTxData(&prevOut.Hash)
 				if err != nil {
 					txIdx, ok := txRecord[prevOut.Hash]
 					if !ok {
-						return nil, nil, nil, fmt.Errorf("transaction %v not found in both db and this block",
+						return nil, fmt.Errorf("transaction %v not found in both db and this block",
 							prevOut.Hash)
 					}
 					txBeforeLoc := txLocs[txIdx]
@@ -356,22 +352,30 @@ func (a *AddrIndexer) indexBlockAddrs(bindingState state.Trie, blk *massutil.Blo
 					TxStart: txOffsetBefore,
 					TxLen:   txLenBefore,
 				}
+				spentOutputs = append(spentOutputs, &database.AddrIndexOutput{
+					OutPoint:   prevOut,
+					TxOut:      txD.Tx.MsgTx().TxOut[prevOut.Index],
+					Coinbase:   IsCoinBase(txD.Tx),
+					Height:     blkHeightBefore,
+					TxLoc:      txBeforeLoc,
+					SpentTxLoc: locInBlock,
+				})
 
 				if !forks.EnforceMASSIP0002WarmUp(blkHeightBefore) {
 					err = indexScriptPubKeyForTxIn(txAddrIndex, btxSpentIndex, txD.Tx.MsgTx().TxOut[prevOut.Index].PkScript, locInBlock, blkHeightBefore, txBeforeLoc, prevOut.Index)
 					if err != nil {
 						// TODO: Assess the risk of this error
-						return nil, nil, nil, err
+						return nil, err
 					}
 				} else {
 					isBinding, err := indexTxInPkScriptForMassip2(bindingState, txD.Tx.MsgTx().TxOut[prevOut.Index], txAddrIndex, locInBlock)
 					if err != nil {
-						return nil, nil, nil, err
+						return nil, err
 					}
 					if isBinding {
 						// subtract
 						if networkBinding, err = networkBinding.AddInt((-1) * txD.Tx.MsgTx().TxOut[prevOut.Index].Value); err != nil {
-							return nil, nil, nil, err
+							return nil, err
 						}
 					}
 				}
@@ -379,20 +383,27 @@ func (a *AddrIndexer) indexBlockAddrs(bindingState state.Trie, blk *massutil.Blo
 		}
 
 		for index, txOut := range tx.MsgTx().TxOut {
+			outputs = append(outputs, &database.AddrIndexOutput{
+				OutPoint: wire.OutPoint{Hash: *txSha, Index: uint32(index)},
+				TxOut:    txOut,
+				Coinbase: IsCoinBase(tx),
+				Height:   blk.Height(),
+				TxLoc:    locInBlock,
+			})
 			if !enforceMassIp2WarmUp {
 				err = indexScriptPubKeyForTxOut(txAddrIndex, btxAddrIndex, txOut.PkScript, locInBlock, index)
 				if err != nil {
-					return nil, nil, nil, err
+					return nil, err
 				}
 			} else {
 				isBinding, err := indexTxOutPkScriptForMassip2(bindingState, txOut, txAddrIndex, locInBlock)
 				if err != nil {
-					return nil, nil, nil, err
+					return nil, err
 				}
 				if isBinding {
 					// add
 					if networkBinding, err = networkBinding.AddInt(txOut.Value); err != nil {
-						return nil, nil, nil, err
+						return nil, err
 					}
 				}
 			}
@@ -407,13 +418,13 @@ func (a *AddrIndexer) indexBlockAddrs(bindingState state.Trie, blk *massutil.Blo
 					"tx":     tx.Hash(),
 					"err":    err,
 				})
-				return nil, nil, nil, err
+				return nil, err
 			}
 		}
 	}
 	if enforceMassIp2WarmUp && oldNetworkBinding.Cmp(networkBinding) != 0 {
 		if err := PutNetworkBinding(bindingState, networkBinding); err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
 	}
 
@@ -438,7 +449,13 @@ func (a *AddrIndexer) indexBlockAddrs(bindingState state.Trie, blk *massutil.Blo
 		})
 	}
 
-	return addrIndex, bindingTxAddrIndex, bindingTxSpentIndex, nil
+	return &database.AddrIndexData{
+		TxIndex:             addrIndex,
+		BindingTxIndex:      bindingTxAddrIndex,
+		BindingTxSpentIndex: bindingTxSpentIndex,
+		Outputs:             outputs,
+		SpentOutputs:        spentOutputs,
+	}, nil
 }
 
 func (a *AddrIndexer) SyncDetachBlock(block *massutil.Block) error {
@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"path/filepath"
+
+	"github.com/wangxinyu2018/mass-core/database/backup"
+	"github.com/wangxinyu2018/mass-core/logging"
+)
+
+// Backup copies the chain database, the block files and the binding state into
+// dir, which must be empty or not exist, and writes the manifest of the copy.
+// Block commits are paused until the stores are copied, so the copy is of the
+// best block when Backup is called or right after.  The block cache is not
+// copied since it is recreated on start.
+func (chain *Blockchain) Backup(dir string) (*backup.Manifest, error) {
+	if err := backup.PrepareDir(dir); err != nil {
+		return nil, err
+	}
+
+	triedb := chain.stateBindingDb.TrieDB()
+	hash, height, err := chain.db.Backup(dir, func() error {
+		// binding trie nodes buffered in memory are part of the copied state
+		if err := triedb.Flush(); err != nil {
+			return err
+		}
+		return backup.CopyKeyValueStore(filepath.Join(dir, backup.BindingStateDir), triedb.DiskDB())
+	})
+	if err != nil {
+		logging.CPrint(logging.ERROR, "failed to back up chain stores", logging.LogFormat{
+			"dir": dir,
+			"err": err,
+		})
+		return nil, err
+	}
+
+	m, err := backup.NewManifest(dir, hash, height)
+	if err != nil {
+		return nil, err
+	}
+	if err = backup.WriteManifest(dir, m); err != nil {
+		return nil, err
+	}
+	logging.CPrint(logging.INFO, "backed up chain stores", logging.LogFormat{
+		"dir":    dir,
+		"height": height,
+		"hash":   hash,
+		"files":  len(m.Files),
+	})
+	return m, nil
+}
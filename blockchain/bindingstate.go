@@ -0,0 +1,223 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/wangxinyu2018/mass-core/blockchain/state"
+	"github.com/wangxinyu2018/mass-core/consensus/forks"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/trie"
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb"
+	"github.com/wangxinyu2018/mass-core/trie/rawdb"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// FlushBindingState writes the binding trie nodes buffered by the dirty node
+// cache of the binding state database to disk.  It is to be called before the
+// binding state database is closed, nodes not flushed are recovered by
+// reconnecting their blocks on the next start.
+func (chain *Blockchain) FlushBindingState() error {
+	return chain.stateBindingDb.TrieDB().Flush()
+}
+
+// BindingStateStats returns the node cache and flush stats of the binding
+// state database.
+func (chain *Blockchain) BindingStateStats() trie.DatabaseStats {
+	return chain.stateBindingDb.TrieDB().Stats()
+}
+
+// recoverBindingState rebuilds the binding tries of the main chain blocks
+// committed to the chain database whose nodes were still buffered in memory
+// when the node stopped.  The binding state of the last block whose trie is
+// wholly on disk is reconnected with the blocks above it.
+//
+// That block is found by the root recorded by the last flush.  The tries are
+// walked to find it only if the store has no root recorded, or the recorded
+// one is not in the main chain, as after a crash before its block was
+// committed to the chain database.
+func (chain *Blockchain) recoverBindingState() error {
+	best := chain.blockTree.bestBlockNode()
+	stateDb := chain.stateBindingDb
+
+	base, root, flushed, err := chain.flushedBindingState(best.Height)
+	if err != nil {
+		return err
+	}
+	// blocks before the warm-up have the empty binding state
+	for !flushed && forks.EnforceMASSIP0002WarmUp(base) {
+		header, err := chain.GetHeaderByHeight(base)
+		if err != nil {
+			return err
+		}
+		err = checkBindingTrie(stateDb, header.BindingRoot)
+		if err == nil {
+			root = header.BindingRoot
+			break
+		}
+		if _, ok := err.(*trie.MissingNodeError); !ok {
+			return err
+		}
+		if base <= chain.bindingPrunedHeight || base == 0 {
+			return fmt.Errorf("no binding state on disk to recover from below height %d: %v", best.Height, err)
+		}
+		base--
+	}
+	if base == best.Height {
+		return nil
+	}
+
+	logging.CPrint(logging.WARN, "recovering unflushed binding state", logging.LogFormat{
+		"from": base,
+		"to":   best.Height,
+	})
+	bindingState, err := stateDb.OpenBindingTrie(root)
+	if err != nil {
+		return err
+	}
+	for height := base + 1; height <= best.Height; height++ {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+		if err = chain.connectState(bindingState, block); err != nil {
+			return err
+		}
+		if !forks.EnforceMASSIP0002WarmUp(height) {
+			continue
+		}
+		if _, err = bindingState.Commit(); err != nil {
+			return err
+		}
+	}
+	return chain.FlushBindingState()
+}
+
+// flushedBindingState returns the height and binding root of the main chain
+// block up to bestHeight whose binding root was recorded by the last flush of
+// the binding state database, and false with bestHeight if there is none.
+func (chain *Blockchain) flushedBindingState(bestHeight uint64) (uint64, common.Hash, bool, error) {
+	flushed, ok := rawdb.ReadFlushedRoot(chain.stateBindingDb.TrieDB().DiskDB())
+	if !ok {
+		return bestHeight, common.Hash{}, false, nil
+	}
+	for height := bestHeight; forks.EnforceMASSIP0002WarmUp(height); height-- {
+		header, err := chain.GetHeaderByHeight(height)
+		if err != nil {
+			return 0, common.Hash{}, false, err
+		}
+		if header.BindingRoot == flushed {
+			return height, flushed, true, nil
+		}
+		if height <= chain.bindingPrunedHeight || height == 0 {
+			break
+		}
+	}
+	return bestHeight, common.Hash{}, false, nil
+}
+
+// checkBindingTrie returns a MissingNodeError if any node of the trie of root
+// is not on disk.  Resolving the root is not enough, the nodes of an older
+// store may have been flushed without their children before a crash.
+func checkBindingTrie(stateDb state.Database, root common.Hash) error {
+	tr, err := stateDb.OpenBindingTrie(root)
+	if err != nil {
+		return err
+	}
+	it := tr.NodeIterator(nil)
+	for it.Next(true) {
+	}
+	return it.Error()
+}
+
+// BindingStateDiff returns an iterator over the binding state keys added,
+// removed and changed from the block of fromHash to the block of toHash, such
+// as the binding scripts and pool pks touched by the blocks in between.  Both
+// blocks must be in the main chain and have their binding tries kept.
+func (chain *Blockchain) BindingStateDiff(fromHash, toHash *wire.Hash) (*trie.DiffIterator, error) {
+	from, err := chain.blockBindingState(fromHash)
+	if err != nil {
+		return nil, err
+	}
+	to, err := chain.blockBindingState(toHash)
+	if err != nil {
+		return nil, err
+	}
+	return trie.NewDiffIterator(from.NodeIterator(nil), to.NodeIterator(nil)), nil
+}
+
+// blockBindingState opens the binding trie committed by the block of hash.
+func (chain *Blockchain) blockBindingState(hash *wire.Hash) (state.Trie, error) {
+	header, err := chain.GetHeaderByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !forks.EnforceMASSIP0002WarmUp(header.Height) {
+		return chain.stateBindingDb.OpenBindingTrie(common.Hash{})
+	}
+	return chain.openBindingTrie(header.Height, header.BindingRoot)
+}
+
+// GetBindingTrieNodes returns the encoded binding trie nodes of hashes, in
+// order, for peers syncing the binding state.  Nodes not found are skipped,
+// and no more nodes are returned once their size reaches maxBytes.
+func (chain *Blockchain) GetBindingTrieNodes(hashes []common.Hash, maxBytes int) [][]byte {
+	triedb := chain.stateBindingDb.TrieDB()
+	nodes := make([][]byte, 0, len(hashes))
+	size := 0
+	for _, hash := range hashes {
+		enc, err := triedb.Node(hash)
+		if err != nil || len(enc) == 0 {
+			continue
+		}
+		nodes = append(nodes, enc)
+		if size += len(enc); size >= maxBytes {
+			break
+		}
+	}
+	return nodes
+}
+
+// GetBindingStateRange returns at most limit leaves of the binding trie of
+// root from origin on, origin included, along with the proofs of origin and
+// the last returned key to be checked by trie.VerifyRangeProof.  An empty
+// origin starts at the first leaf, and needs no proof of its own.  No more
+// leaves are returned once their size reaches maxBytes, though at least two
+// so that a peer continuing from the last key moves forward.
+func (chain *Blockchain) GetBindingStateRange(root common.Hash, origin []byte, limit, maxBytes int) (keys, values, proof [][]byte, err error) {
+	tr, err := chain.stateBindingDb.OpenBindingTrie(root)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	it := trie.NewIterator(tr.NodeIterator(origin))
+	size := 0
+	for len(keys) < limit && (len(keys) < 2 || size < maxBytes) && it.Next() {
+		keys = append(keys, common.CopyBytes(it.Key))
+		values = append(values, common.CopyBytes(it.Value))
+		size += len(it.Key) + len(it.Value)
+	}
+	if it.Err != nil {
+		return nil, nil, nil, it.Err
+	}
+
+	if len(origin) > 0 || len(keys) == 0 {
+		if proof, err = tr.Prove(origin); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if len(keys) > 0 && !bytes.Equal(keys[len(keys)-1], origin) {
+		lastProof, err := tr.Prove(keys[len(keys)-1])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		proof = append(proof, lastProof...)
+	}
+	return keys, values, proof, nil
+}
+
+// BindingStateDB returns the key-value store the binding trie nodes are kept
+// in, for syncing binding tries from peers into.
+func (chain *Blockchain) BindingStateDB() massdb.KeyValueStore {
+	return chain.stateBindingDb.TrieDB().DiskDB()
+}
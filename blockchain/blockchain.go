@@ -46,17 +46,33 @@ type processBlockMsg struct {
 	reply chan processBlockResponse
 }
 
-type Listener interface {
-	OnBlockConnected(*wire.MsgBlock) error
-	OnTransactionReceived(tx *wire.MsgTx) error
-}
-
 type Config struct {
-	DB             database.Db
-	StateBindingDb state.Database
-	ChainParams    *chaincfg.Params
-	Checkpoints    []chaincfg.Checkpoint
-	CachePath      string
+	DB               database.Db
+	StateBindingDb   state.Database
+	ChainParams      *chaincfg.Params
+	Checkpoints      []chaincfg.Checkpoint
+	CachePath        string
+	PruneDepth       uint64
+	CFIndex          bool
+	SpentIndex       bool
+	MempoolLimits    TxPoolLimits
+	MempoolPath      string // file to save the mempool to and reload it from, empty to disable
+	FeeEstimatesPath string // file to save the fee estimator to and reload it from, empty to disable
+	MaxReorgDepth    uint64 // max number of blocks a reorganization may detach, 0 for no limit
+
+	// BindingStateArchive keeps the binding tries of all blocks, the chain
+	// refuses a pruned binding state and PruneBindingState.
+	BindingStateArchive bool
+
+	// AssumeUtxo is a utxo snapshot on the allow-list of ChainParams. An
+	// empty chain database is initialized by its chain state, and the chain
+	// starts at the snapshot block.
+	AssumeUtxo *UtxoSnapshot
+
+	// History is the config of the chain validating the history up to the
+	// utxo snapshot the chain started from, see SnapshotValidator.  Its
+	// databases must not be those of the chain itself.
+	History *Config
 }
 
 type Blockchain struct {
@@ -69,6 +85,14 @@ type Blockchain struct {
 	db                  database.Db
 	stateBindingDb      state.Database
 	info                *chainInfo
+	pruneDepth          uint64
+	maxReorgDepth       uint64
+	bindingStateArchive bool
+	bindingPrunedHeight uint64 // binding tries below are pruned, except those of checkpoints
+
+	snapshotBase      *database.UtxoSnapshotBase // utxo snapshot the chain started from, nil if genesis
+	snapshotValidator *SnapshotValidator         // validator of the history up to snapshotBase, nil if none
+	snapshotInvalid   int32                      // set once the history does not match snapshotBase
 
 	l              sync.RWMutex
 	cond           sync.Cond
@@ -77,14 +101,20 @@ type Blockchain struct {
 	txPool         *TxPool               // pool of transactions
 	proposalPool   *ProposalPool         // pool of proposals
 	addrIndexer    *AddrIndexer          // address indexer
+	cfIndexer      *CFIndexer            // compact filter indexer, nil if disabled
+	spentIndexer   *SpentIndexer         // spent indexer, nil if disabled
 	dmd            *DoubleMiningDetector // double mining detector
 	processBlockCh chan *processBlockMsg
-	listeners      map[Listener]struct{}
+	pruneCh        chan uint64 // best heights to prune block files for
+	eventBus       *EventBus
 
 	errCache  *lru.Cache
 	sigCache  *txscript.SigCache
 	hashCache *txscript.HashCache
 
+	reorgOverrideMtx sync.Mutex
+	reorgOverrides   map[wire.Hash]struct{} // blocks allowed to fork below maxReorgDepth
+
 	// These fields are related to checkpoint handling.  They are protected
 	// by the chain lock.
 	nextCheckpoint *config.Checkpoint
@@ -116,13 +146,18 @@ func NewBlockchain(config *Config) (*Blockchain, error) {
 		db:                  config.DB,
 		chainParams:         config.ChainParams,
 		stateBindingDb:      config.StateBindingDb,
+		pruneDepth:          config.PruneDepth,
+		maxReorgDepth:       config.MaxReorgDepth,
+		bindingStateArchive: config.BindingStateArchive,
 
 		blockTree:      NewBlockTree(),
 		dmd:            NewDoubleMiningDetector(config.DB),
 		processBlockCh: make(chan *processBlockMsg, maxProcessBlockChSize),
+		pruneCh:        make(chan uint64, 1),
 		errCache:       lru.New(blockErrCacheSize),
 		hashCache:      txscript.NewHashCache(hashCacheMaxSize),
-		listeners:      make(map[Listener]struct{}),
+		eventBus:       NewEventBus(),
+		reorgOverrides: make(map[wire.Hash]struct{}),
 	}
 	chain.cond.L = &sync.Mutex{}
 
@@ -132,6 +167,9 @@ func NewBlockchain(config *Config) (*Blockchain, error) {
 	}
 
 	chain.txPool = NewTxPool(chain, chain.sigCache, chain.hashCache)
+	chain.txPool.limits = config.MempoolLimits
+	chain.txPool.mempoolPath = config.MempoolPath
+	chain.txPool.feeEstimatesPath = config.FeeEstimatesPath
 
 	if punishments, err := chain.RetrievePunishment(); err == nil {
 		chain.proposalPool = NewProposalPool(punishments)
@@ -150,13 +188,21 @@ func NewBlockchain(config *Config) (*Blockchain, error) {
 			return nil, err
 		}
 		genesisBlock = massutil.NewBlock(config.ChainParams.GenesisBlock)
-		if err := chain.db.InitByGenesisBlock(genesisBlock); err != nil {
+		if config.AssumeUtxo != nil {
+			err = chain.initUtxoSnapshot(config.AssumeUtxo, genesisBlock)
+		} else {
+			err = chain.db.InitByGenesisBlock(genesisBlock)
+		}
+		if err != nil {
 			return nil, err
 		}
 		genesisHash = genesisBlock.Hash()
 	} else {
 		if genesisBlock, err = chain.db.FetchBlockBySha(genesisHash); err != nil {
-			return nil, err
+			if err != database.ErrBlockPruned {
+				return nil, err
+			}
+			genesisBlock = massutil.NewBlock(config.ChainParams.GenesisBlock)
 		}
 	}
 	if *genesisHash != *config.ChainParams.GenesisHash {
@@ -169,11 +215,78 @@ func NewBlockchain(config *Config) (*Blockchain, error) {
 		chainID:      genesisBlock.MsgBlock().Header.ChainID,
 	}
 
+	if err = chain.initBindingStatePruning(config.BindingStateArchive); err != nil {
+		return nil, err
+	}
+
+	if chain.snapshotBase, err = chain.db.FetchUtxoSnapshotBase(); err != nil {
+		return nil, err
+	}
+	if config.AssumeUtxo != nil && (chain.snapshotBase == nil || chain.snapshotBase.ContentHash != config.AssumeUtxo.ContentHash) {
+		return nil, ErrUtxoSnapshotDatabase
+	}
+	if base := chain.snapshotBase; base != nil {
+		if base.State == database.UtxoSnapshotInvalid {
+			return nil, ErrUtxoSnapshotInvalid
+		}
+		chain.checkpoints = snapshotCheckpoints(chain.checkpoints, base)
+		chain.checkpointsByHeight = make(map[uint64]*chaincfg.Checkpoint, len(chain.checkpoints))
+		for i := range chain.checkpoints {
+			chain.checkpointsByHeight[chain.checkpoints[i].Height] = &chain.checkpoints[i]
+		}
+	}
+
 	if err := chain.generateInitialIndex(); err != nil {
 		return nil, err
 	}
 
+	if err := chain.recoverBindingState(); err != nil {
+		return nil, err
+	}
+
+	if base := chain.snapshotBase; base != nil && base.State == database.UtxoSnapshotAssumed {
+		if config.History == nil {
+			logging.CPrint(logging.WARN, "history up to utxo snapshot not validated", logging.LogFormat{
+				"height": base.Height,
+				"block":  base.BlockHash,
+			})
+		} else if chain.snapshotValidator, err = newSnapshotValidator(chain, base, config.History); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.CFIndex {
+		chain.cfIndexer = NewCFIndexer(chain.db)
+		if err := chain.cfIndexer.catchUp(chain.BestBlockHeight()); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.SpentIndex {
+		chain.spentIndexer = NewSpentIndexer(chain.db)
+		if err := chain.spentIndexer.catchUp(chain.BestBlockHeight()); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.FeeEstimatesPath != "" {
+		if err := chain.txPool.loadFeeEstimates(); err != nil {
+			logging.CPrint(logging.WARN, "fail to load fee estimates file",
+				logging.LogFormat{"path": config.FeeEstimatesPath, "err": err})
+		}
+	}
+	if config.MempoolPath != "" {
+		if err := chain.txPool.loadMempool(); err != nil {
+			logging.CPrint(logging.WARN, "fail to load mempool file",
+				logging.LogFormat{"path": config.MempoolPath, "err": err})
+		}
+	}
+
 	go chain.blockProcessor()
+	if chain.pruneDepth > 0 {
+		go chain.blockFilePruner()
+		chain.schedulePruning(chain.BestBlockHeight())
+	}
 
 	return chain, nil
 }
@@ -196,6 +309,10 @@ func (chain *Blockchain) generateInitialIndex() error {
 	if endHeight >= minMemoryNodes {
 		startHeight = endHeight - minMemoryNodes
 	}
+	// blocks below those of a utxo snapshot are not stored
+	if chain.snapshotBase != nil && startHeight < chain.snapshotBase.LowestHeight {
+		startHeight = chain.snapshotBase.LowestHeight
+	}
 
 	// Loop forwards through each block loading the node into the index for
 	// the block.
@@ -391,6 +508,30 @@ func (chain *Blockchain) GetHeaderByHeight(height uint64) (*wire.BlockHeader, er
 	return chain.db.FetchBlockHeaderBySha(hash)
 }
 
+// GetCFilterByHeight returns the block hash, filter header and compact filter
+// of the main chain block at height.
+func (chain *Blockchain) GetCFilterByHeight(height uint64) (*wire.Hash, *wire.Hash, []byte, error) {
+	if chain.cfIndexer == nil {
+		return nil, nil, nil, ErrCFIndexDisabled
+	}
+	return chain.db.FetchCFilterByHeight(height)
+}
+
+// GetSpendingTx returns the hash of the transaction spending outPoint and the
+// height of its block. Spends of mempool transactions are answered from the
+// pool with inMempool set and zero height, main chain spends require the spent
+// index.
+func (chain *Blockchain) GetSpendingTx(outPoint *wire.OutPoint) (txHash *wire.Hash, height uint64, inMempool bool, err error) {
+	if tx := chain.txPool.FetchOutPointSpender(outPoint); tx != nil {
+		return tx.Hash(), 0, true, nil
+	}
+	if chain.spentIndexer == nil {
+		return nil, 0, false, ErrSpentIndexDisabled
+	}
+	txHash, height, err = chain.db.FetchSpendingTx(outPoint)
+	return txHash, height, false, err
+}
+
 func (chain *Blockchain) GetBlockHashByHeight(height uint64) (*wire.Hash, error) {
 	return chain.db.FetchBlockShaByHeight(height)
 }
@@ -473,44 +614,11 @@ func (chain *Blockchain) BlockWaiter(height uint64) (<-chan *BlockNode, error) {
 	return ch, nil
 }
 
-func (chain *Blockchain) RegisterListener(listener Listener) {
-	chain.l.Lock()
-	defer chain.l.Unlock()
-
-	chain.listeners[listener] = struct{}{}
-}
-
-func (chain *Blockchain) UnregisterListener(listener Listener) {
-	chain.l.Lock()
-	defer chain.l.Unlock()
-
-	delete(chain.listeners, listener)
-}
-
-func (chain *Blockchain) notifyBlockConnected(block *massutil.Block) error {
-	if block == nil {
-		return errNilArgument
-	}
-	for listener := range chain.listeners {
-		err := listener.OnBlockConnected(block.MsgBlock())
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (chain *Blockchain) notifyTransactionReceived(tx *massutil.Tx) error {
-	if tx == nil {
-		return errNilArgument
-	}
-	for listener := range chain.listeners {
-		err := listener.OnTransactionReceived(tx.MsgTx())
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+// Subscribe returns a subscription of chain events of the given types, or of
+// all types if none is given. Unsubscribe must be called when the events are
+// no longer consumed.
+func (chain *Blockchain) Subscribe(bufferSize int, policy SubscribePolicy, types ...EventType) *Subscription {
+	return chain.eventBus.Subscribe(bufferSize, policy, types...)
 }
 
 func (chain *Blockchain) CurrentIndexHeight() uint64 {
@@ -534,6 +642,24 @@ func (chain *Blockchain) FetchOldBinding(scriptHash []byte) ([]*database.Binding
 	return chain.db.FetchOldBinding(scriptHash)
 }
 
+// FetchScriptHashBalance returns the balances of the normal, staking and
+// binding outputs paid to scriptHash.
+func (chain *Blockchain) FetchScriptHashBalance(scriptHash []byte) (*database.AddrBalances, error) {
+	return chain.db.FetchScriptHashBalance(scriptHash)
+}
+
+// FetchScriptHashUtxos returns a page of the unspent outputs of kind paid to
+// scriptHash, limit 0 means no limit.
+func (chain *Blockchain) FetchScriptHashUtxos(scriptHash []byte, kind database.AddrUtxoKind, offset, limit int) ([]*database.UtxoReply, error) {
+	return chain.db.FetchScriptHashUtxos(scriptHash, kind, offset, limit)
+}
+
+// FetchScriptHashHistory returns a page of the transactions related to the
+// outputs of kind paid to scriptHash, limit 0 means no limit.
+func (chain *Blockchain) FetchScriptHashHistory(scriptHash []byte, kind database.AddrUtxoKind, offset, limit int) ([]*database.AddrHistory, error) {
+	return chain.db.FetchScriptHashHistory(scriptHash, kind, offset, limit)
+}
+
 func (chain *Blockchain) GetNewBinding(script []byte) (massutil.Amount, error) {
 	if len(script) != txscript.OP_DATA_22 {
 		return massutil.ZeroAmount(), fmt.Errorf("invalid new binding script length %d", len(script))
@@ -550,6 +676,37 @@ func (chain *Blockchain) GetNewBinding(script []byte) (massutil.Amount, error) {
 	return massutil.NewAmountFromInt(bi.Amount)
 }
 
+// GetNewBindingProof returns the binding amount of the new binding script in
+// the main chain block at height, together with the binding root of the block
+// and the Merkle proof of the amount against it.  A script without binding is
+// proven absent.  The proof is checked by VerifyNewBindingProof.
+func (chain *Blockchain) GetNewBindingProof(script []byte, height uint64) (massutil.Amount, common.Hash, [][]byte, error) {
+	if len(script) != txscript.OP_DATA_22 {
+		return massutil.ZeroAmount(), common.Hash{}, nil, fmt.Errorf("invalid new binding script length %d", len(script))
+	}
+	header, err := chain.GetHeaderByHeight(height)
+	if err != nil {
+		return massutil.ZeroAmount(), common.Hash{}, nil, err
+	}
+	trie, err := chain.openBindingTrie(height, header.BindingRoot)
+	if err != nil {
+		return massutil.ZeroAmount(), common.Hash{}, nil, err
+	}
+	data, err := trie.TryGet(script)
+	if err != nil {
+		return massutil.ZeroAmount(), common.Hash{}, nil, err
+	}
+	proof, err := trie.Prove(script)
+	if err != nil {
+		return massutil.ZeroAmount(), common.Hash{}, nil, err
+	}
+	amount, err := massutil.NewAmountFromInt(state.DecodeBindingInfo(data).Amount)
+	if err != nil {
+		return massutil.ZeroAmount(), common.Hash{}, nil, err
+	}
+	return amount, header.BindingRoot, proof, nil
+}
+
 func (chain *Blockchain) GetPoolPkCoinbase(poolPks [][]byte) (map[string]string, map[string]uint32, error) {
 	poolPkToCoinbase := make(map[string]string)
 	poolPkToNonce := make(map[string]uint32)
@@ -588,7 +745,7 @@ func (chain *Blockchain) GetNetworkBinding(height uint64) (massutil.Amount, erro
 	if !forks.EnforceMASSIP0002WarmUp(block.Height()) {
 		trie, err = chain.stateBindingDb.OpenBindingTrie(common.Hash{})
 	} else {
-		trie, err = chain.stateBindingDb.OpenBindingTrie(block.MsgBlock().Header.BindingRoot)
+		trie, err = chain.openBindingTrie(block.Height(), block.MsgBlock().Header.BindingRoot)
 	}
 	if err != nil {
 		return massutil.MaxAmount(), err
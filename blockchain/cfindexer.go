@@ -0,0 +1,216 @@
+package blockchain
+
+import (
+	"sync"
+
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/massutil/gcs"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// CFIndexer builds the compact filter and filter header of each main chain
+// block, which are served to light clients.
+type CFIndexer struct {
+	blockLogger *BlockProgressLogger
+	db          database.Db
+	sync.Mutex
+}
+
+// NewCFIndexer creates a new compact filter indexer.
+func NewCFIndexer(db database.Db) *CFIndexer {
+	return &CFIndexer{
+		db:          db,
+		blockLogger: NewBlockProgressLogger("compact filter indexed"),
+	}
+}
+
+// SyncAttachBlock indexes the compact filter of a newly connected block, txStore
+// provides the outputs spent by the block.
+func (c *CFIndexer) SyncAttachBlock(block *massutil.Block, txStore TxStore) error {
+	c.Lock()
+	defer c.Unlock()
+
+	prevOutScripts, err := blockPrevOutScripts(block, func(hash *wire.Hash) (*wire.MsgTx, error) {
+		txD, ok := txStore[*hash]
+		if !ok {
+			return nil, database.ErrTxShaMissing
+		}
+		if txD.Err != nil {
+			return nil, txD.Err
+		}
+		return txD.Tx.MsgTx(), nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.indexBlock(block, prevOutScripts)
+}
+
+// SyncDetachBlock removes the compact filter of a disconnected block.
+func (c *CFIndexer) SyncDetachBlock(block *massutil.Block) error {
+	c.Lock()
+	defer c.Unlock()
+
+	tipHash, tipHeight, err := c.db.FetchCFilterTip()
+	if err != nil {
+		return err
+	}
+	if tipHeight != block.Height() || !tipHash.IsEqual(block.Hash()) {
+		logging.CPrint(logging.ERROR, errUnexpectedCFilterTip.Error(), logging.LogFormat{
+			"tip hash":     tipHash,
+			"tip height":   tipHeight,
+			"block hash":   block.Hash(),
+			"block height": block.Height(),
+		})
+		return errUnexpectedCFilterTip
+	}
+	return c.db.DeleteCFilter(block.Hash(), block.Height())
+}
+
+// catchUp indexes main chain blocks from the index tip up to bestHeight. Filters
+// of blocks which are no longer on the main chain, e.g. disconnected while the
+// index was disabled, are removed first.  The filters chain up from genesis, so
+// it fails with ErrIndexBlocksPruned if the index is behind the chain on a
+// pruned store, rather than building a partial index.
+func (c *CFIndexer) catchUp(bestHeight uint64) error {
+	c.Lock()
+	defer c.Unlock()
+
+	start := uint64(0)
+	for {
+		tipHash, tipHeight, err := c.db.FetchCFilterTip()
+		if err == database.ErrCFilterIndexDoesNotExist {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if tipHeight <= bestHeight {
+			mainHash, err := c.db.FetchBlockShaByHeight(tipHeight)
+			if err != nil {
+				return err
+			}
+			if mainHash.IsEqual(tipHash) {
+				start = tipHeight + 1
+				break
+			}
+		}
+		if err = c.db.DeleteCFilter(tipHash, tipHeight); err != nil {
+			return err
+		}
+	}
+
+	if start <= bestHeight {
+		// blocks are replayed from start, reading the txs they spend
+		// which may be pruned even if the blocks are not
+		pruned, err := c.db.HasPrunedBlocks()
+		if err != nil {
+			return err
+		}
+		if pruned {
+			logging.CPrint(logging.ERROR, "unable to catch up compact filter index on a pruned store", logging.LogFormat{
+				"start": start,
+				"best":  bestHeight,
+			})
+			return ErrIndexBlocksPruned
+		}
+		logging.CPrint(logging.INFO, "catching up compact filter index", logging.LogFormat{
+			"start": start,
+			"best":  bestHeight,
+		})
+	}
+	fetchTx := func(hash *wire.Hash) (*wire.MsgTx, error) {
+		replies := c.db.FetchTxByShaList([]*wire.Hash{hash})
+		if len(replies) == 0 {
+			return nil, database.ErrTxShaMissing
+		}
+		if replies[0].Err != nil {
+			return nil, replies[0].Err
+		}
+		return replies[0].Tx, nil
+	}
+	for height := start; height <= bestHeight; height++ {
+		hash, err := c.db.FetchBlockShaByHeight(height)
+		if err != nil {
+			return err
+		}
+		block, err := c.db.FetchBlockBySha(hash)
+		if err != nil {
+			return err
+		}
+		prevOutScripts, err := blockPrevOutScripts(block, fetchTx)
+		if err != nil {
+			return err
+		}
+		if err = c.indexBlock(block, prevOutScripts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexBlock builds the compact filter of block and submits it on top of the
+// filter of its parent.
+func (c *CFIndexer) indexBlock(block *massutil.Block, prevOutScripts [][]byte) error {
+	prevHeader := &wire.Hash{}
+	if block.Height() > 0 {
+		tipHash, tipHeight, err := c.db.FetchCFilterTip()
+		if err != nil && err != database.ErrCFilterIndexDoesNotExist {
+			return err
+		}
+		if err != nil || tipHeight+1 != block.Height() || !tipHash.IsEqual(&block.MsgBlock().Header.Previous) {
+			logging.CPrint(logging.ERROR, errUnexpectedCFilterTip.Error(), logging.LogFormat{
+				"tip hash":     tipHash,
+				"tip height":   tipHeight,
+				"block hash":   block.Hash(),
+				"block height": block.Height(),
+			})
+			return errUnexpectedCFilterTip
+		}
+		if _, prevHeader, _, err = c.db.FetchCFilterByHeight(tipHeight); err != nil {
+			return err
+		}
+	}
+
+	filter, err := gcs.BuildBasicFilter(block.MsgBlock(), prevOutScripts)
+	if err != nil {
+		return err
+	}
+	header := gcs.MakeHeaderForFilter(filter, prevHeader)
+	if err = c.db.SubmitCFilter(block.Hash(), block.Height(), &header, filter.NBytes()); err != nil {
+		logging.CPrint(logging.ERROR, "Unable to write compact filter for block", logging.LogFormat{
+			"block hash": block.Hash(),
+			"height":     block.Height(),
+			"error":      err,
+		})
+		return err
+	}
+	c.blockLogger.LogBlockHeight(block)
+	return nil
+}
+
+// blockPrevOutScripts returns the pkScripts of outputs spent by block.
+func blockPrevOutScripts(block *massutil.Block, fetchTx func(*wire.Hash) (*wire.MsgTx, error)) ([][]byte, error) {
+	scripts := make([][]byte, 0)
+	for _, tx := range block.Transactions() {
+		txIns := tx.MsgTx().TxIn
+		if IsCoinBase(tx) {
+			// the first input of coinbase spends nothing
+			txIns = txIns[1:]
+		}
+		for _, txIn := range txIns {
+			prevOut := txIn.PreviousOutPoint
+			prevTx, err := fetchTx(&prevOut.Hash)
+			if err != nil {
+				return nil, err
+			}
+			if int(prevOut.Index) >= len(prevTx.TxOut) {
+				return nil, ErrBadTxInput
+			}
+			scripts = append(scripts, prevTx.TxOut[prevOut.Index].PkScript)
+		}
+	}
+	return scripts, nil
+}
@@ -344,6 +344,16 @@ func (chain *Blockchain) connectBlock(node *BlockNode, block *massutil.Block) (e
 	if err = chain.addrIndexer.SyncAttachBlock(bindingState, block, txInputStore); err != nil {
 		return err
 	}
+	if chain.cfIndexer != nil {
+		if err = chain.cfIndexer.SyncAttachBlock(block, txInputStore); err != nil {
+			return err
+		}
+	}
+	if chain.spentIndexer != nil {
+		if err = chain.spentIndexer.SyncAttachBlock(block); err != nil {
+			return err
+		}
+	}
 	if forks.EnforceMASSIP0002WarmUp(node.Height) {
 		root := bindingState.Hash()
 		if root != block.MsgBlock().Header.BindingRoot {
@@ -410,9 +420,16 @@ func (chain *Blockchain) connectBlock(node *BlockNode, block *massutil.Block) (e
 		}
 	}
 
+	// delete old block files in pruned mode
+	if chain.pruneDepth > 0 && node.Height%pruneInterval == 0 {
+		chain.schedulePruning(node.Height)
+	}
+
 	// wait for other modules to attach block
 	chain.attachBlock(block)
 
+	chain.eventBus.Publish(EventBlockConnected, &BlockConnectedEvent{Block: block})
+
 	return nil
 }
 
@@ -438,6 +455,16 @@ func (chain *Blockchain) disconnectBlock(node *BlockNode, block *massutil.Block)
 	if err := chain.addrIndexer.SyncDetachBlock(block); err != nil {
 		return err
 	}
+	if chain.cfIndexer != nil {
+		if err := chain.cfIndexer.SyncDetachBlock(block); err != nil {
+			return err
+		}
+	}
+	if chain.spentIndexer != nil {
+		if err := chain.spentIndexer.SyncDetachBlock(block); err != nil {
+			return err
+		}
+	}
 	if err := chain.db.Commit(*node.Hash); err != nil {
 		return err
 	}
@@ -452,6 +479,8 @@ func (chain *Blockchain) disconnectBlock(node *BlockNode, block *massutil.Block)
 	// detach block from other modules
 	chain.detachBlock(block)
 
+	chain.eventBus.Publish(EventBlockDisconnected, &BlockDisconnectedEvent{Block: block})
+
 	return nil
 }
 
@@ -517,15 +546,23 @@ func (chain *Blockchain) reorganizeChain(detachNodes, attachNodes *list.List, fl
 
 	chain.l.Lock()
 	defer chain.l.Unlock()
+
+	reorg := &ReorgEvent{Detached: nodeListHashes(detachNodes), Attached: nodeListHashes(attachNodes)}
+	chain.eventBus.Publish(EventReorgStarted, reorg)
+	finished := &ReorgEvent{Detached: reorg.Detached, Attached: reorg.Attached}
+	defer chain.eventBus.Publish(EventReorgFinished, finished)
+
 	// Disconnect blocks from the main chain.
 	for e := detachNodes.Front(); e != nil; e = e.Next() {
 		n := e.Value.(*BlockNode)
 		block, err := chain.db.FetchBlockBySha(n.Hash)
 		if err != nil {
+			finished.Err = err
 			return err
 		}
 		err = chain.disconnectBlock(n, block)
 		if err != nil {
+			finished.Err = err
 			return err
 		}
 	}
@@ -535,6 +572,7 @@ func (chain *Blockchain) reorganizeChain(detachNodes, attachNodes *list.List, fl
 		n := e.Value.(*BlockNode)
 		block, _ := chain.blockCache.getBlock(n.Hash)
 		if err := chain.connectBlock(n, block); err != nil {
+			finished.Err = err
 			return err
 		}
 		// Do not delete, there's no need for this
@@ -568,6 +606,15 @@ func (chain *Blockchain) reorganizeChain(detachNodes, attachNodes *list.List, fl
 	return nil
 }
 
+// nodeListHashes returns hashes of the BlockNode list in order.
+func nodeListHashes(nodes *list.List) []wire.Hash {
+	hashes := make([]wire.Hash, 0, nodes.Len())
+	for e := nodes.Front(); e != nil; e = e.Next() {
+		hashes = append(hashes, *e.Value.(*BlockNode).Hash)
+	}
+	return hashes
+}
+
 func (chain *Blockchain) connectBestChain(node *BlockNode, block *massutil.Block, flags BehaviorFlags) error {
 	// extend block on current best chain
 	if node.Parent.Hash.IsEqual(chain.blockTree.bestBlockNode().Hash) {
@@ -596,11 +643,6 @@ func (chain *Blockchain) connectBestChain(node *BlockNode, block *massutil.Block
 		if !block.IsImport() {
 			// Broadcast new block on best chain
 			chain.cond.Broadcast()
-
-			err = chain.notifyBlockConnected(block)
-			if err != nil {
-				return err
-			}
 		}
 
 		return nil
@@ -690,11 +732,6 @@ func (chain *Blockchain) connectBestChain(node *BlockNode, block *massutil.Block
 	if !block.IsImport() {
 		// Broadcast new block on best chain
 		chain.cond.Broadcast()
-
-		err = chain.notifyBlockConnected(block)
-		if err != nil {
-			return err
-		}
 	}
 
 	return nil
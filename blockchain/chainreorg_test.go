@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/wangxinyu2018/mass-core/config"
+	"github.com/wangxinyu2018/mass-core/database/disk"
 	"github.com/wangxinyu2018/mass-core/database/ldb"
 	"github.com/wangxinyu2018/mass-core/database/storage"
 	_ "github.com/wangxinyu2018/mass-core/database/storage/ldbstorage"
@@ -447,6 +448,9 @@ func checkEntries(t *testing.T, entries map[string][]byte) {
 			prefix = "STG"
 		case strings.HasPrefix(key, "STSG"):
 			prefix = "STSG"
+		case key == "fversion":
+			assert.Equal(t, 2, len(value))
+			continue
 		case strings.HasPrefix(key, "fb"):
 			if key == "fblatest" {
 				blkFileTotal = binary.LittleEndian.Uint32(value) + 1
@@ -460,6 +464,14 @@ func checkEntries(t *testing.T, entries map[string][]byte) {
 			continue
 		case strings.HasPrefix(key, "PUBKBL"):
 			prefix = "PUBKBL"
+		case strings.HasPrefix(key, "SHU"):
+			prefix = "SHU"
+		case strings.HasPrefix(key, "SHB"):
+			prefix = "SHB"
+		case strings.HasPrefix(key, "SHH"):
+			prefix = "SHH"
+		case strings.HasPrefix(key, "SHD"):
+			prefix = "SHD"
 		default:
 			t.Fatal(key, value)
 		}
@@ -550,6 +562,18 @@ func checkEntry(t *testing.T, prefix, key string, value []byte) {
 		assert.Equal(t, 0, len(value))
 	case "PUBKBL":
 		assert.Equal(t, 9, len(value))
+	case "SHU":
+		assert.Equal(t, 80, len(key))
+		assert.Equal(t, 17, len(value))
+	case "SHB":
+		assert.Equal(t, 35, len(key))
+		assert.Equal(t, 36, len(value))
+	case "SHH":
+		assert.Equal(t, 52, len(key))
+		assert.Equal(t, 16, len(value))
+	case "SHD":
+		assert.Equal(t, 11, len(key))
+		assert.True(t, len(value) >= 12)
 	default:
 		t.Fatal(prefix, key, value)
 	}
@@ -579,7 +603,7 @@ func SumBlkFileSize(t *testing.T, blks ...*massutil.Block) (sum uint64) {
 	for _, blk := range blks {
 		raw, err := blk.Bytes(wire.DB)
 		assert.Nil(t, err)
-		sum += uint64(12 + len(raw))
+		sum += uint64(disk.BlkMessageHeaderLengthV1 + len(raw))
 	}
 	return sum
 }
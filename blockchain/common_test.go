@@ -262,6 +262,165 @@ func mkTmpDir(dir string) (func(), error) {
 	}, nil
 }
 
+// newMockTx returns a tx named by its payload, spending prevs with seq, or a
+// made-up outpoint of its name if there are none.  It pays to a single
+// output, which is never signed nor found in any chain.
+func newMockTx(name string, seq uint64, prevs ...wire.OutPoint) *massutil.Tx {
+	msgTx := wire.NewMsgTx()
+	msgTx.Payload = []byte(name)
+	if len(prevs) == 0 {
+		prevs = []wire.OutPoint{{Hash: wire.DoubleHashH([]byte(name))}}
+	}
+	for i := range prevs {
+		txIn := wire.NewTxIn(&prevs[i], nil)
+		txIn.Sequence = seq
+		msgTx.AddTxIn(txIn)
+	}
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{0x00, 0x20}))
+	return massutil.NewTx(msgTx)
+}
+
+// mockOutPoint returns the outpoint of the only output of a mocked tx.
+func mockOutPoint(tx *massutil.Tx) wire.OutPoint {
+	return *wire.NewOutPoint(tx.Hash(), 0)
+}
+
+// newMockTxDesc returns the pool entry of a mocked tx paying fee.
+func newMockTxDesc(t *testing.T, name string, seq uint64, fee int64, prevs ...wire.OutPoint) *TxDesc {
+	amt, err := massutil.NewAmountFromInt(fee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TxDesc{Tx: newMockTx(name, seq, prevs...), Fee: amt}
+}
+
+// newMockTxPool returns a pool without any chain behind it, holding txDs as
+// if they were accepted.
+func newMockTxPool(txDs ...*TxDesc) *TxPool {
+	tp := &TxPool{
+		pool:              make(map[wire.Hash]*TxDesc),
+		outpoints:         make(map[wire.OutPoint]*massutil.Tx),
+		addrindex:         make(map[string]map[wire.Hash]struct{}),
+		bindingTargets:    make(map[string]wire.Hash),
+		replacement:       DefaultReplacementPolicy(),
+		rollingMinFeeRate: massutil.ZeroAmount(),
+	}
+	for _, txD := range txDs {
+		tp.pool[*txD.Tx.Hash()] = txD
+		for _, txIn := range txD.Tx.MsgTx().TxIn {
+			tp.outpoints[txIn.PreviousOutPoint] = txD.Tx
+		}
+		tp.totalSize += uint64(txD.Tx.MsgTx().PlainSize())
+		tp.totalMemory += txMemoryUsage(txD.Tx)
+	}
+	return tp
+}
+
+// newMockPrioItem returns the block template item of a mocked tx depending on
+// the txs of parents.
+func newMockPrioItem(name string, fee, size int64, parents ...*txPrioItem) *txPrioItem {
+	var prevs []wire.OutPoint
+	for _, parent := range parents {
+		prevs = append(prevs, mockOutPoint(parent.tx))
+	}
+	item := &txPrioItem{tx: newMockTx(name, wire.MaxTxInSequenceNum, prevs...), fee: fee, size: size}
+	for _, parent := range parents {
+		if item.dependsOn == nil {
+			item.dependsOn = make(map[wire.Hash]struct{})
+		}
+		item.dependsOn[*parent.tx.Hash()] = struct{}{}
+	}
+	return item
+}
+
+// mockWallet holds the keys the mocked blocks pay to, each of them through
+// the witness script hash of its 1-of-1 multisig script.
+type mockWallet struct {
+	keys          map[string]*btcec.PrivateKey // by serialized public key
+	redeemScripts map[string][]byte            // by witness program
+	pkScripts     [][]byte                     // in the order of the key file
+}
+
+// loadMockWallet loads the wallet keys of the mocked blocks.
+func loadMockWallet() (*mockWallet, error) {
+	f, err := os.Open("../wire/mock/template_data/walletkey.dat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	w := &mockWallet{
+		keys:          make(map[string]*btcec.PrivateKey),
+		redeemScripts: make(map[string][]byte),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		buf, err := hex.DecodeString(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		priv, pub := btcec.PrivKeyFromBytes(btcec.S256(), buf)
+		redeemScript, addr, err := newWitnessScriptAddress([]*btcec.PublicKey{pub}, 1,
+			massutil.AddressClassWitnessV0, &config.ChainParams)
+		if err != nil {
+			return nil, err
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		w.keys[string(pub.SerializeCompressed())] = priv
+		w.redeemScripts[string(addr.ScriptAddress())] = redeemScript
+		w.pkScripts = append(w.pkScripts, pkScript)
+	}
+	return w, scanner.Err()
+}
+
+// newTx returns a tx spending the outputs indexes of prev, signed by the
+// wallet.  It pays to outs, and the rest but fee to the first wallet key.
+func (w *mockWallet) newTx(seq uint64, fee int64, outs []*wire.TxOut, prev *wire.MsgTx, indexes ...uint32) (*massutil.Tx, error) {
+	msgTx := wire.NewMsgTx()
+	prevHash := prev.TxHash()
+	change := -fee
+	for _, index := range indexes {
+		txIn := wire.NewTxIn(wire.NewOutPoint(&prevHash, index), nil)
+		txIn.Sequence = seq
+		msgTx.AddTxIn(txIn)
+		change += prev.TxOut[index].Value
+	}
+	for _, out := range outs {
+		msgTx.AddTxOut(out)
+		change -= out.Value
+	}
+	msgTx.AddTxOut(wire.NewTxOut(change, w.pkScripts[0]))
+
+	hashes := txscript.NewTxSigHashes(msgTx)
+	getSign := txscript.SignClosure(func(pub *btcec.PublicKey, hash []byte) (*btcec.Signature, error) {
+		key, ok := w.keys[string(pub.SerializeCompressed())]
+		if !ok {
+			return nil, errors.New("private key not found")
+		}
+		return key.Sign(hash)
+	})
+	getScript := txscript.ScriptClosure(func(addr massutil.Address) ([]byte, error) {
+		redeemScript, ok := w.redeemScripts[string(addr.ScriptAddress())]
+		if !ok {
+			return nil, errors.New("redeem script not found")
+		}
+		return redeemScript, nil
+	})
+	for i, index := range indexes {
+		prevOut := prev.TxOut[index]
+		witness, err := txscript.SignTxOutputWit(&config.ChainParams, msgTx, i, prevOut.Value, prevOut.PkScript,
+			hashes, txscript.SigHashAll, getSign, getScript)
+		if err != nil {
+			return nil, err
+		}
+		msgTx.TxIn[i].Witness = witness
+	}
+	return massutil.NewTx(msgTx), nil
+}
+
 var (
 	comTestCoinbaseMaturity     uint64 = 20
 	comTestMinStakingValue             = 100 * consensus.MaxwellPerMass
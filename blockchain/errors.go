@@ -8,6 +8,23 @@ var (
 	errConnectMainChain        = errors.New("connectBlock must be called with a block that extends the main chain")
 	errDisconnectMainChain     = errors.New("disconnectBlock must be called with the block at the end of the main chain")
 	errWaitForOldBlockHeight   = errors.New("blockWaiter wait for old block height")
+	ErrCFIndexDisabled         = errors.New("compact filter index is disabled")
+	ErrSpentIndexDisabled      = errors.New("spent index is disabled")
+	ErrIndexBlocksPruned       = errors.New("index can not catch up with the chain, blocks have been pruned")
+	ErrReorgTooDeep            = errors.New("block forks the main chain below the max reorg depth")
+	ErrBindingStatePruned      = errors.New("binding state has been pruned")
+	ErrBindingStateArchive     = errors.New("binding state is not pruned in archive mode")
+
+	// UtxoSnapshot
+	ErrUtxoSnapshotMalformed  = errors.New("malformed utxo snapshot")
+	ErrUtxoSnapshotVersion    = errors.New("unsupported utxo snapshot version")
+	ErrUtxoSnapshotHash       = errors.New("utxo snapshot content hash mismatched")
+	ErrUtxoSnapshotNotAllowed = errors.New("utxo snapshot is not on the allow-list")
+	ErrUtxoSnapshotMismatch   = errors.New("validated chain state does not match the assumed utxo snapshot")
+	ErrUtxoSnapshotHeight     = errors.New("utxo snapshot height out of range")
+	ErrUtxoSnapshotArchive    = errors.New("utxo snapshot can not be loaded in archive mode")
+	ErrUtxoSnapshotDatabase   = errors.New("chain database is not initialized by the utxo snapshot")
+	ErrUtxoSnapshotInvalid    = errors.New("utxo snapshot the chain started from has been found invalid")
 
 	// BlockTree
 	errExpandOrphanRootBlockNode = errors.New("can not expand orphan block on root of blockTree")
@@ -25,6 +42,8 @@ var (
 
 	// Block Validate
 	errUnexpectedHeight          = errors.New("illegal block for AddrIndexer, unexpected block height")
+	errUnexpectedCFilterTip      = errors.New("illegal block for CFIndexer, unexpected compact filter tip")
+	errUnexpectedSpentIndexTip   = errors.New("illegal block for SpentIndexer, unexpected spent index tip")
 	errIncompleteCoinbasePayload = errors.New("size of coinbase payload less than block height need")
 	errBlockNoTransactions       = errors.New("block does not contain any transactions")
 	errBlockCacheNotExists       = errors.New("required block cache not exists")
@@ -71,6 +90,25 @@ var (
 	ErrProhibitionOrphanTx = errors.New("Do not accept orphan transactions")
 	ErrInvalidTxVersion    = errors.New("transaction version is invalid")
 
+	// Replacement
+	ErrReplacementEvictions      = errors.New("replacement evicts too many transactions")
+	ErrReplacementSpendsConflict = errors.New("replacement spends an output of a transaction it replaces")
+	ErrReplacementFeeRate        = errors.New("replacement fee rate is not higher than the replaced transactions")
+	ErrReplacementFee            = errors.New("replacement fee is insufficient to replace the transactions")
+
+	// Mempool
+	ErrMempoolFull          = errors.New("memory pool is full")
+	ErrMempoolFileMalformed = errors.New("malformed mempool file")
+
+	// Package
+	ErrPackageSize      = errors.New("package has too few or too many transactions")
+	ErrPackageMalformed = errors.New("package is not sorted or contains unrelated transactions")
+
+	// FeeEstimator
+	ErrFeeEstimateTarget           = errors.New("fee estimate target out of range")
+	ErrFeeEstimateInsufficientData = errors.New("insufficient data to estimate fee")
+	ErrFeeEstimatesMalformed       = errors.New("malformed fee estimates file")
+
 	// Coinbase
 	ErrCoinbaseTxInWitness = errors.New("coinbaseTx txIn`s witness size must be 0")
 	ErrBadCoinbaseValue    = errors.New("coinbase transaction for block pays is more than expected value")
@@ -137,7 +175,4 @@ var (
 	ErrScriptMalformed     = errors.New("failed to construct vm engine")
 	ErrScriptValidation    = errors.New("failed to validate signature")
 	ErrWitnessLength       = errors.New("invalid witness length")
-
-	// Listener
-	errNilArgument = errors.New("nil argument")
 )
@@ -0,0 +1,270 @@
+package blockchain
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// EventType identifies the kind of a chain event.
+type EventType int
+
+const (
+	EventBlockConnected EventType = iota
+	EventBlockDisconnected
+	EventReorgStarted
+	EventReorgFinished
+	EventTxAccepted
+	EventTxRemoved
+	EventOrphanAdded
+	EventReorgRefused
+)
+
+var eventTypeStrings = map[EventType]string{
+	EventBlockConnected:    "BlockConnected",
+	EventBlockDisconnected: "BlockDisconnected",
+	EventReorgStarted:      "ReorgStarted",
+	EventReorgFinished:     "ReorgFinished",
+	EventTxAccepted:        "TxAccepted",
+	EventTxRemoved:         "TxRemoved",
+	EventOrphanAdded:       "OrphanAdded",
+	EventReorgRefused:      "ReorgRefused",
+}
+
+func (t EventType) String() string {
+	if s, ok := eventTypeStrings[t]; ok {
+		return s
+	}
+	return "Unknown"
+}
+
+// Event is published on the EventBus, Data is one of the *XxxEvent types
+// below depending on Type.
+type Event struct {
+	Type EventType
+	Data interface{}
+}
+
+// BlockConnectedEvent is published when a block is connected to the main chain,
+// including blocks connected by a reorganization.
+type BlockConnectedEvent struct {
+	Block *massutil.Block
+}
+
+// BlockDisconnectedEvent is published when a block is disconnected from the
+// main chain by a reorganization.
+type BlockDisconnectedEvent struct {
+	Block *massutil.Block
+}
+
+// ReorgEvent is published when a reorganization starts and finishes. Detached
+// hashes are ordered from the old best block down, attached hashes from the
+// fork point up. Err is the reason of a failed reorganization, and is always
+// nil for EventReorgStarted.
+type ReorgEvent struct {
+	Detached []wire.Hash
+	Attached []wire.Hash
+	Err      error
+}
+
+// ReorgRefusedEvent is published when a block forks the main chain deeper than
+// the max reorg depth.  The block is refused unless Overridden, which is set
+// when the operator allowed it by AllowDeepReorg.
+type ReorgRefusedEvent struct {
+	Block      wire.Hash
+	Height     uint64
+	ForkHash   wire.Hash
+	ForkHeight uint64
+	BestHash   wire.Hash
+	BestHeight uint64
+	Depth      uint64 // blocks the reorganization would detach
+	MaxDepth   uint64
+	Overridden bool
+}
+
+// TxRemovalReason describes why a transaction left the mempool.
+type TxRemovalReason int
+
+const (
+	// TxRemovedMined means the tx has been included in a connected block.
+	TxRemovedMined TxRemovalReason = iota
+	// TxRemovedConflict means the tx spends an output spent by a connected
+	// block.
+	TxRemovedConflict
+	// TxRemovedReorg means the tx of a disconnected block failed to be
+	// accepted back to the mempool.
+	TxRemovedReorg
+	// TxRemovedRedeemer means the tx spends an output of a removed tx.
+	TxRemovedRedeemer
+	// TxRemovedManual means the tx is removed by RemoveTransaction.
+	TxRemovedManual
+	// TxRemovedReplaced means the tx is replaced by a tx paying a higher fee.
+	TxRemovedReplaced
+	// TxRemovedPackage means the tx is rolled back along with the rest of
+	// the package it was accepted in.
+	TxRemovedPackage
+	// TxRemovedEvicted means the tx is evicted to keep the pool within its
+	// limits.
+	TxRemovedEvicted
+)
+
+var txRemovalReasonStrings = map[TxRemovalReason]string{
+	TxRemovedMined:    "mined",
+	TxRemovedConflict: "conflict",
+	TxRemovedReorg:    "reorg",
+	TxRemovedRedeemer: "redeemer",
+	TxRemovedManual:   "manual",
+	TxRemovedReplaced: "replaced",
+	TxRemovedPackage:  "package",
+	TxRemovedEvicted:  "evicted",
+}
+
+func (r TxRemovalReason) String() string {
+	if s, ok := txRemovalReasonStrings[r]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// TxAcceptedEvent is published when a tx is accepted to the mempool.
+type TxAcceptedEvent struct {
+	Tx *massutil.Tx
+}
+
+// TxRemovedEvent is published when a tx is removed from the mempool.
+type TxRemovedEvent struct {
+	Tx     *massutil.Tx
+	Reason TxRemovalReason
+}
+
+// OrphanAddedEvent is published when an orphan tx or an orphan block is added
+// to its orphan pool, only one of Tx and Block is set.
+type OrphanAddedEvent struct {
+	Tx    *massutil.Tx
+	Block *massutil.Block
+}
+
+// SubscribePolicy decides what happens to an event when the channel of a
+// subscriber is full.
+type SubscribePolicy int
+
+const (
+	// PolicyDrop drops the event, so that a slow subscriber never stalls
+	// the publisher.
+	PolicyDrop SubscribePolicy = iota
+	// PolicyBlock waits until the subscriber has room for the event. Events
+	// are published with the chain lock held, so the subscriber must keep
+	// up or block processing is stalled.
+	PolicyBlock
+)
+
+// Subscription receives events published on an EventBus.
+type Subscription struct {
+	bus    *EventBus
+	ch     chan Event
+	quit   chan struct{}
+	once   sync.Once
+	policy SubscribePolicy
+	types  map[EventType]struct{} // nil for all types
+	lag    uint64
+}
+
+// Events returns the channel of events, which is closed on Unsubscribe.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Lag returns the number of events which found the channel full, they are
+// dropped with PolicyDrop and delayed with PolicyBlock.
+func (s *Subscription) Lag() uint64 {
+	return atomic.LoadUint64(&s.lag)
+}
+
+// Unsubscribe stops delivering events and closes the channel.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		// wake up the publisher if it is blocked on this subscriber
+		close(s.quit)
+
+		s.bus.l.Lock()
+		delete(s.bus.subs, s)
+		s.bus.l.Unlock()
+		close(s.ch)
+	})
+}
+
+func (s *Subscription) wants(t EventType) bool {
+	if s.types == nil {
+		return true
+	}
+	_, ok := s.types[t]
+	return ok
+}
+
+func (s *Subscription) deliver(ev Event) {
+	select {
+	case s.ch <- ev:
+		return
+	default:
+	}
+
+	atomic.AddUint64(&s.lag, 1)
+	if s.policy == PolicyDrop {
+		return
+	}
+	select {
+	case s.ch <- ev:
+	case <-s.quit:
+	}
+}
+
+// EventBus delivers chain events to subscribers, each of which has its own
+// buffered channel.
+type EventBus struct {
+	l    sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewEventBus returns an EventBus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe returns a subscription of the given event types, or of all types
+// if none is given.
+func (bus *EventBus) Subscribe(bufferSize int, policy SubscribePolicy, types ...EventType) *Subscription {
+	s := &Subscription{
+		bus:    bus,
+		ch:     make(chan Event, bufferSize),
+		quit:   make(chan struct{}),
+		policy: policy,
+	}
+	if len(types) > 0 {
+		s.types = make(map[EventType]struct{}, len(types))
+		for _, t := range types {
+			s.types[t] = struct{}{}
+		}
+	}
+
+	bus.l.Lock()
+	bus.subs[s] = struct{}{}
+	bus.l.Unlock()
+	return s
+}
+
+// Publish delivers the event to all subscribers of its type.
+func (bus *EventBus) Publish(t EventType, data interface{}) {
+	bus.l.RLock()
+	defer bus.l.RUnlock()
+
+	ev := Event{Type: t, Data: data}
+	for s := range bus.subs {
+		if s.wants(t) {
+			s.deliver(ev)
+		}
+	}
+}
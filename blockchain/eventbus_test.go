@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventBusFilterAndDrop(t *testing.T) {
+	bus := NewEventBus()
+	all := bus.Subscribe(8, PolicyDrop)
+	defer all.Unsubscribe()
+	txOnly := bus.Subscribe(1, PolicyDrop, EventTxAccepted, EventTxRemoved)
+	defer txOnly.Unsubscribe()
+
+	bus.Publish(EventBlockConnected, &BlockConnectedEvent{})
+	bus.Publish(EventTxAccepted, &TxAcceptedEvent{})
+	bus.Publish(EventTxRemoved, &TxRemovedEvent{Reason: TxRemovedMined})
+
+	if n := len(all.Events()); n != 3 {
+		t.Errorf("all events, got = %d, want = 3", n)
+	}
+	if all.Lag() != 0 {
+		t.Errorf("all lag, got = %d, want = 0", all.Lag())
+	}
+
+	// buffer of txOnly only holds the first tx event
+	ev := <-txOnly.Events()
+	if ev.Type != EventTxAccepted {
+		t.Errorf("first tx event, got = %v, want = %v", ev.Type, EventTxAccepted)
+	}
+	if txOnly.Lag() != 1 {
+		t.Errorf("txOnly lag, got = %d, want = 1", txOnly.Lag())
+	}
+	select {
+	case ev := <-txOnly.Events():
+		t.Errorf("unexpected event %v", ev.Type)
+	default:
+	}
+}
+
+func TestEventBusBlockPolicy(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe(1, PolicyBlock, EventBlockConnected)
+
+	bus.Publish(EventBlockConnected, &BlockConnectedEvent{})
+	done := make(chan struct{})
+	go func() {
+		bus.Publish(EventBlockConnected, &BlockConnectedEvent{})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("publisher should wait for a full subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	<-sub.Events()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publisher should continue once the subscriber has room")
+	}
+	if sub.Lag() != 1 {
+		t.Errorf("lag, got = %d, want = 1", sub.Lag())
+	}
+
+	// the channel is full again, unsubscribing releases a blocked publisher
+	// and closes the channel
+	done = make(chan struct{})
+	go func() {
+		bus.Publish(EventBlockConnected, &BlockConnectedEvent{})
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	sub.Unsubscribe()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publisher should be released by Unsubscribe")
+	}
+	for range sub.Events() {
+	}
+	sub.Unsubscribe()
+}
@@ -0,0 +1,361 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	// FeeEstimatesFileName is the file name the fee estimator is saved to.
+	FeeEstimatesFileName = "fee_estimates.dat"
+
+	// MaxFeeEstimateTarget is the largest confirmation target, in blocks,
+	// the estimator tracks.
+	MaxFeeEstimateTarget = 25
+
+	// numFeeBuckets is the number of fee rate buckets, the lower bound of
+	// bucket i is the minimum relay fee multiplied by feeBucketSpacing^i.
+	numFeeBuckets    = 60
+	feeBucketSpacing = 1.2
+
+	// feeStatsDecay is applied to the history on every block, so that
+	// older confirmations weigh less.
+	feeStatsDecay = 0.995
+
+	// feeEstimateSuccess is the share of txs in a fee rate range which must
+	// have confirmed within the target for the range to be good enough.
+	feeEstimateSuccess = 0.85
+
+	// minFeeEstimateSamples is the weighted number of txs a fee rate range
+	// needs before the estimator trusts it.
+	minFeeEstimateSamples = 10
+
+	feeEstimatesVersion uint32 = 1
+)
+
+var feeEstimatesMagic = []byte("MASSFEES")
+
+// FeeEstimate is the fee rate expected to confirm a tx within a target.
+type FeeEstimate struct {
+	// FeePerKB is the fee rate in Maxwell/kB.
+	FeePerKB massutil.Amount
+
+	// Confidence is the share of recent txs paying at least FeePerKB which
+	// confirmed within the target.
+	Confidence float64
+}
+
+// observedTx is a pool tx waiting for confirmation.
+type observedTx struct {
+	height uint64 // chain height when it entered the pool
+	bucket int
+}
+
+// feeBucket houses the decayed confirmation history of a fee rate range.
+type feeBucket struct {
+	// confirmed[i] counts the txs confirmed within i+1 blocks.
+	confirmed [MaxFeeEstimateTarget]float64
+	// total counts all the txs confirmed, no matter how long it took.
+	total float64
+}
+
+// FeeEstimator estimates the fee rate for a tx to confirm within a number of
+// blocks, from the time txs of the pool took to be confirmed.
+type FeeEstimator struct {
+	l          sync.Mutex
+	bucketFees [numFeeBuckets]float64 // lower bound of each bucket, Maxwell/kB
+	buckets    [numFeeBuckets]feeBucket
+	observed   map[wire.Hash]*observedTx
+	bestHeight uint64
+}
+
+// NewFeeEstimator returns a FeeEstimator without history.
+func NewFeeEstimator() *FeeEstimator {
+	fe := &FeeEstimator{
+		observed: make(map[wire.Hash]*observedTx),
+	}
+	rate := float64(massutil.MinRelayTxFee().UintValue())
+	for i := range fe.bucketFees {
+		fe.bucketFees[i] = rate
+		rate *= feeBucketSpacing
+	}
+	return fe
+}
+
+// bucketIndex returns the bucket of the fee rate, fee rates below the
+// minimum relay fee are in the first bucket.
+func (fe *FeeEstimator) bucketIndex(feePerKB float64) int {
+	i := 0
+	for i+1 < numFeeBuckets && fe.bucketFees[i+1] <= feePerKB {
+		i++
+	}
+	return i
+}
+
+// observeTx starts tracking a tx entering the pool.
+func (fe *FeeEstimator) observeTx(txD *TxDesc) {
+	fe.l.Lock()
+	defer fe.l.Unlock()
+
+	size := txD.Tx.MsgTx().PlainSize()
+	feePerKB := float64(txD.Fee.IntValue()) / (float64(size) / 1000)
+	fe.observed[*txD.Tx.Hash()] = &observedTx{
+		height: txD.Height,
+		bucket: fe.bucketIndex(feePerKB),
+	}
+}
+
+// forgetTx stops tracking a tx leaving the pool.
+func (fe *FeeEstimator) forgetTx(hash *wire.Hash) {
+	fe.l.Lock()
+	defer fe.l.Unlock()
+
+	delete(fe.observed, *hash)
+}
+
+// processBlock records the confirmation of the tracked txs among the
+// non-coinbase txs of the block at height.
+func (fe *FeeEstimator) processBlock(height uint64, txs []*massutil.Tx) {
+	fe.l.Lock()
+	defer fe.l.Unlock()
+
+	for i := range fe.buckets {
+		b := &fe.buckets[i]
+		for j := range b.confirmed {
+			b.confirmed[j] *= feeStatsDecay
+		}
+		b.total *= feeStatsDecay
+	}
+
+	for _, tx := range txs {
+		otx, ok := fe.observed[*tx.Hash()]
+		if !ok {
+			continue
+		}
+		delete(fe.observed, *tx.Hash())
+		if height <= otx.height {
+			continue
+		}
+		b := &fe.buckets[otx.bucket]
+		b.total++
+		for j := height - otx.height - 1; j < MaxFeeEstimateTarget; j++ {
+			b.confirmed[j]++
+		}
+	}
+	fe.bestHeight = height
+}
+
+// EstimateFee returns the lowest fee rate at which recent txs confirmed within
+// targetBlocks with a success rate of at least 85%.  Txs still waiting in the
+// pool for at least targetBlocks count as failures.
+//
+// This function is safe for concurrent access.
+func (fe *FeeEstimator) EstimateFee(targetBlocks uint32) (*FeeEstimate, error) {
+	if targetBlocks == 0 || targetBlocks > MaxFeeEstimateTarget {
+		return nil, ErrFeeEstimateTarget
+	}
+
+	fe.l.Lock()
+	defer fe.l.Unlock()
+
+	var waiting [numFeeBuckets]float64
+	for _, otx := range fe.observed {
+		if fe.bestHeight >= otx.height+uint64(targetBlocks) {
+			waiting[otx.bucket]++
+		}
+	}
+
+	// Starting from the highest fee rate, extend the range of buckets
+	// down until it has enough samples, and keep on with the next range as
+	// long as the range confirms txs often enough.
+	best := -1
+	var bestConfidence, confirmed, total float64
+	for i := numFeeBuckets - 1; i >= 0; i-- {
+		confirmed += fe.buckets[i].confirmed[targetBlocks-1]
+		total += fe.buckets[i].total + waiting[i]
+		if total < minFeeEstimateSamples {
+			continue
+		}
+		confidence := confirmed / total
+		if confidence < feeEstimateSuccess {
+			break
+		}
+		best, bestConfidence = i, confidence
+		confirmed, total = 0, 0
+	}
+	if best < 0 {
+		return nil, ErrFeeEstimateInsufficientData
+	}
+
+	feePerKB, err := massutil.NewAmountFromInt(int64(math.Ceil(fe.bucketFees[best])))
+	if err != nil {
+		return nil, err
+	}
+	return &FeeEstimate{FeePerKB: feePerKB, Confidence: bestConfidence}, nil
+}
+
+// Serialize writes the confirmation history of the estimator to w.  Txs still
+// waiting for confirmation are not included.
+func (fe *FeeEstimator) Serialize(w io.Writer) error {
+	fe.l.Lock()
+	defer fe.l.Unlock()
+
+	var buf [8]byte
+	putUint64 := func(v uint64) error {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		_, err := w.Write(buf[:])
+		return err
+	}
+
+	if _, err := w.Write(feeEstimatesMagic); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(buf[:4], feeEstimatesVersion)
+	if _, err := w.Write(buf[:4]); err != nil {
+		return err
+	}
+	if err := putUint64(fe.bestHeight); err != nil {
+		return err
+	}
+	for i := range fe.buckets {
+		b := &fe.buckets[i]
+		if err := putUint64(math.Float64bits(fe.bucketFees[i])); err != nil {
+			return err
+		}
+		if err := putUint64(math.Float64bits(b.total)); err != nil {
+			return err
+		}
+		for _, c := range b.confirmed {
+			if err := putUint64(math.Float64bits(c)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RestoreFeeEstimator reads an estimator written by Serialize.
+func RestoreFeeEstimator(r io.Reader) (*FeeEstimator, error) {
+	var buf [8]byte
+	readUint64 := func() (uint64, error) {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint64(buf[:]), nil
+	}
+
+	magic := make([]byte, len(feeEstimatesMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, feeEstimatesMagic) {
+		return nil, ErrFeeEstimatesMalformed
+	}
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(buf[:4]) != feeEstimatesVersion {
+		return nil, ErrFeeEstimatesMalformed
+	}
+
+	fe := NewFeeEstimator()
+	var err error
+	if fe.bestHeight, err = readUint64(); err != nil {
+		return nil, err
+	}
+	for i := range fe.buckets {
+		b := &fe.buckets[i]
+		bits, err := readUint64()
+		if err != nil {
+			return nil, err
+		}
+		// history of another bucket layout is of no use
+		if math.Float64frombits(bits) != fe.bucketFees[i] {
+			return nil, ErrFeeEstimatesMalformed
+		}
+		if bits, err = readUint64(); err != nil {
+			return nil, err
+		}
+		b.total = math.Float64frombits(bits)
+		for j := range b.confirmed {
+			if bits, err = readUint64(); err != nil {
+				return nil, err
+			}
+			b.confirmed[j] = math.Float64frombits(bits)
+		}
+	}
+	logging.CPrint(logging.DEBUG, "restored fee estimator", logging.LogFormat{"height": fe.bestHeight})
+	return fe, nil
+}
+
+// saveFeeEstimates writes the fee estimator to the fee estimates file.  The
+// file is replaced atomically.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (tp *TxPool) saveFeeEstimates() error {
+	tmpPath := tp.feeEstimatesPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err = tp.feeEstimator.Serialize(w); err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, tp.feeEstimatesPath)
+}
+
+// loadFeeEstimates restores the fee estimator from the fee estimates file.  A
+// missing file leaves the estimator without history.
+//
+// This function is safe for concurrent access.
+func (tp *TxPool) loadFeeEstimates() error {
+	f, err := os.Open(tp.feeEstimatesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	fe, err := RestoreFeeEstimator(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	tp.Lock()
+	tp.feeEstimator = fe
+	tp.Unlock()
+	return nil
+}
+
+// EstimateFee returns the fee rate for a tx to confirm within targetBlocks,
+// see FeeEstimator.EstimateFee.
+//
+// This function is safe for concurrent access.
+func (tp *TxPool) EstimateFee(targetBlocks uint32) (*FeeEstimate, error) {
+	tp.RLock()
+	fe := tp.feeEstimator
+	tp.RUnlock()
+
+	return fe.EstimateFee(targetBlocks)
+}
@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// feeEstimatorTx returns a distinct tx paying feePerKB, entering the pool at
+// height.
+func feeEstimatorTx(t *testing.T, seq uint64, height uint64, feePerKB int64) *TxDesc {
+	msgTx := wire.NewMsgTx()
+	msgTx.LockTime = seq
+	msgTx.AddTxOut(wire.NewTxOut(1, make([]byte, 200)))
+	fee, err := massutil.NewAmountFromInt(feePerKB * int64(msgTx.PlainSize()) / 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TxDesc{Tx: massutil.NewTx(msgTx), Height: height, Fee: fee}
+}
+
+func TestFeeEstimator(t *testing.T) {
+	fe := NewFeeEstimator()
+	if _, err := fe.EstimateFee(0); err != ErrFeeEstimateTarget {
+		t.Errorf("zero target, got = %v, want = %v", err, ErrFeeEstimateTarget)
+	}
+	if _, err := fe.EstimateFee(1); err != ErrFeeEstimateInsufficientData {
+		t.Errorf("no history, got = %v, want = %v", err, ErrFeeEstimateInsufficientData)
+	}
+
+	// every block confirms the high fee txs of the previous height at once,
+	// and the low fee txs of 5 blocks before
+	var seq uint64
+	var pending [][]*massutil.Tx
+	for height := uint64(1); height <= 50; height++ {
+		var confirmed []*massutil.Tx
+		if height > 1 {
+			confirmed = append(confirmed, pending[height-2][:2]...)
+		}
+		if height > 5 {
+			confirmed = append(confirmed, pending[height-6][2:]...)
+		}
+		fe.processBlock(height, confirmed)
+
+		var txs []*massutil.Tx
+		for _, rate := range []int64{100000, 100000, 20000, 20000} {
+			seq++
+			txD := feeEstimatorTx(t, seq, height, rate)
+			fe.observeTx(txD)
+			txs = append(txs, txD.Tx)
+		}
+		pending = append(pending, txs)
+	}
+
+	fast, err := fe.EstimateFee(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fast.FeePerKB.IntValue() <= 20000 || fast.FeePerKB.IntValue() > 100000 {
+		t.Errorf("1 block estimate, got = %v", fast.FeePerKB)
+	}
+	if fast.Confidence < feeEstimateSuccess {
+		t.Errorf("confidence, got = %v", fast.Confidence)
+	}
+	slow, err := fe.EstimateFee(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slow.FeePerKB.IntValue() > 20000 {
+		t.Errorf("5 blocks estimate, got = %v", slow.FeePerKB)
+	}
+
+	var buf bytes.Buffer
+	if err := fe.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreFeeEstimator(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := restored.EstimateFee(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FeePerKB.Cmp(slow.FeePerKB) != 0 {
+		t.Errorf("restored estimate, got = %v, want = %v", got.FeePerKB, slow.FeePerKB)
+	}
+
+	raw := buf.Bytes()
+	raw[0] ^= 0xff
+	if _, err := RestoreFeeEstimator(bytes.NewReader(raw)); err != ErrFeeEstimatesMalformed {
+		t.Errorf("bad magic, got = %v, want = %v", err, ErrFeeEstimatesMalformed)
+	}
+}
@@ -592,9 +592,12 @@ func TestCheckInputsStandard(t *testing.T) {
 	//reset
 	txStore[prevHash].Tx.MsgTx().TxOut[prevOut].PkScript = oriPks
 
+	oriWitness := tx.MsgTx().TxIn[0].Witness
 	tx.MsgTx().TxIn[0].Witness = tx.MsgTx().TxIn[0].Witness[1:]
 	err = checkInputsStandard(tx, txStore)
 	assert.Equal(t, ErrWitnessLength, err)
+	//reset, the block is shared by other tests
+	tx.MsgTx().TxIn[0].Witness = oriWitness
 }
 
 func TestCheckTransactionStandard(t *testing.T) {
@@ -106,6 +106,11 @@ func (chain *Blockchain) InsertChain(block *massutil.Block) (isOrphan bool, err
 func (chain *Blockchain) processBlock(block *massutil.Block, flags BehaviorFlags) (isOrphan bool, err error) {
 	var startProcessing = time.Now()
 
+	// the chain state started from an invalid utxo snapshot can't be trusted
+	if chain.isUtxoSnapshotInvalid() {
+		return false, ErrUtxoSnapshotInvalid
+	}
+
 	if flags.isFlagSet(BFNoPoCCheck) {
 		return false, chain.checkConnectBlockTemplate(block, flags)
 		// // Perform preliminary sanity checks on the block and its transactions.
@@ -147,8 +152,9 @@ func (chain *Blockchain) processBlock(block *massutil.Block, flags BehaviorFlags
 		return true, nil
 	}
 
-	// Return fail if block error already been cached.
-	if v, ok := chain.errCache.Get(blockHash.String()); ok {
+	// Return fail if block error already been cached, unless the block is
+	// allowed to fork below the max reorg depth after being refused.
+	if v, ok := chain.errCache.Get(blockHash.String()); ok && !chain.isReorgAllowed(blockHash) {
 		return false, v.(error)
 	}
 
@@ -187,6 +193,7 @@ func (chain *Blockchain) processBlock(block *massutil.Block, flags BehaviorFlags
 				"flags":   fmt.Sprintf("%b", flags),
 			})
 			chain.blockTree.orphanBlockPool.addOrphanBlock(block)
+			chain.eventBus.Publish(EventOrphanAdded, &OrphanAddedEvent{Block: block})
 			return true, nil
 		}
 	}
@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/wangxinyu2018/mass-core/blockchain/state"
+	"github.com/wangxinyu2018/mass-core/consensus"
+	"github.com/wangxinyu2018/mass-core/consensus/forks"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/trie"
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/rawdb"
+)
+
+// pruneInterval is the number of blocks between two pruning attempts.
+const pruneInterval = 1000
+
+// pruneTargetHeight returns the height below which data of blocks may be
+// deleted keeping depth recent blocks, 0 means nothing could be pruned.
+//
+// Blocks above the latest checkpoint may be disconnected by a reorganization,
+// and blocks within PrunedNodeMinBlocks are still read for staking and binding
+// validation, so neither of them is pruned.
+func (chain *Blockchain) pruneTargetHeight(bestHeight, depth uint64) uint64 {
+	if depth < consensus.PrunedNodeMinBlocks {
+		depth = consensus.PrunedNodeMinBlocks
+	}
+	if bestHeight <= depth {
+		return 0
+	}
+	target := bestHeight - depth
+
+	checkpoint := chain.LatestCheckpoint()
+	if checkpoint == nil {
+		return 0
+	}
+	if checkpoint.Height < target {
+		target = checkpoint.Height
+	}
+	return target
+}
+
+// schedulePruning queues pruning block files for bestHeight to
+// blockFilePruner, it is skipped if an earlier one is still queued.
+func (chain *Blockchain) schedulePruning(bestHeight uint64) {
+	select {
+	case chain.pruneCh <- bestHeight:
+	default:
+	}
+}
+
+// blockFilePruner prunes block files off the block connecting path, since
+// unspent transactions are copied from each pruned file.
+func (chain *Blockchain) blockFilePruner() {
+	for bestHeight := range chain.pruneCh {
+		chain.pruneBlockFiles(bestHeight)
+	}
+}
+
+// pruneBlockFiles deletes block files lower than the prune target height,
+// errors are logged since pruning would be retried later.
+func (chain *Blockchain) pruneBlockFiles(bestHeight uint64) {
+	target := chain.pruneTargetHeight(bestHeight, chain.pruneDepth)
+	if target == 0 {
+		return
+	}
+	n, err := chain.db.PruneBlockFiles(target)
+	if err != nil {
+		logging.CPrint(logging.ERROR, "failed to prune block files", logging.LogFormat{
+			"target": target,
+			"err":    err,
+		})
+		return
+	}
+	if n > 0 {
+		logging.CPrint(logging.INFO, "pruned block files", logging.LogFormat{
+			"target": target,
+			"files":  n,
+		})
+	}
+}
+
+// initBindingStatePruning loads the height the binding state has been pruned
+// below.  An archive node keeps the binding tries of all blocks, so it refuses
+// a pruned binding state.
+func (chain *Blockchain) initBindingStatePruning(archive bool) error {
+	height, pruned := rawdb.ReadPrunedHeight(chain.stateBindingDb.TrieDB().DiskDB())
+	if archive && pruned {
+		return fmt.Errorf("%w below height %d, archive mode needs a reindexed binding state", ErrBindingStatePruned, height)
+	}
+	chain.bindingPrunedHeight = height
+	return nil
+}
+
+// PruneBindingState deletes the binding trie nodes which are not reachable from
+// the binding roots of the keepBlocks recent main chain blocks, nor from those
+// of checkpoints.  Older blocks kept for reorganizations are kept as for block
+// file pruning.  It returns the height the binding state is pruned below, 0
+// if nothing could be pruned.
+//
+// The binding state must not be written to while pruning, so PruneBindingState
+// is not to be called on the chain of a running node, nor in archive mode.
+func (chain *Blockchain) PruneBindingState(keepBlocks uint64) (uint64, *trie.PruneStats, error) {
+	if chain.bindingStateArchive {
+		return 0, nil, ErrBindingStateArchive
+	}
+	bestHeight := chain.BestBlockHeight()
+	target := chain.pruneTargetHeight(bestHeight, keepBlocks)
+	if target == 0 {
+		return 0, nil, nil
+	}
+	// the tries below an earlier target are gone already
+	if target < chain.bindingPrunedHeight {
+		target = chain.bindingPrunedHeight
+	}
+
+	triedb := chain.stateBindingDb.TrieDB()
+	if err := triedb.Flush(); err != nil {
+		return 0, nil, err
+	}
+	diskdb := triedb.DiskDB()
+	roots := make([]common.Hash, 0, bestHeight-target+1+uint64(len(chain.checkpoints)))
+	for _, checkpoint := range chain.checkpoints {
+		if checkpoint.Height >= target || !forks.EnforceMASSIP0002WarmUp(checkpoint.Height) {
+			continue
+		}
+		header, err := chain.GetHeaderByHeight(checkpoint.Height)
+		if err != nil {
+			return 0, nil, err
+		}
+		// a checkpoint added after an earlier pruning has no trie to keep
+		if has, err := diskdb.Has(header.BindingRoot[:]); err != nil {
+			return 0, nil, err
+		} else if !has && checkpoint.Height < chain.bindingPrunedHeight {
+			continue
+		}
+		roots = append(roots, header.BindingRoot)
+	}
+	for height := target; height <= bestHeight; height++ {
+		if !forks.EnforceMASSIP0002WarmUp(height) {
+			continue
+		}
+		header, err := chain.GetHeaderByHeight(height)
+		if err != nil {
+			return 0, nil, err
+		}
+		roots = append(roots, header.BindingRoot)
+	}
+
+	// the pruned height is recorded before any trie is deleted, so that the
+	// tries missing after an interrupted sweep are known as pruned
+	stats, err := triedb.Prune(roots, func() error {
+		if err := rawdb.WritePrunedHeight(diskdb, target); err != nil {
+			return err
+		}
+		chain.bindingPrunedHeight = target
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	logging.CPrint(logging.INFO, "pruned binding state", logging.LogFormat{
+		"target":  target,
+		"roots":   stats.Roots,
+		"kept":    stats.Kept,
+		"deleted": stats.Deleted,
+		"size":    stats.Size,
+	})
+	return target, stats, nil
+}
+
+// openBindingTrie opens the binding trie of root committed by the main chain
+// block at height, a trie deleted by PruneBindingState gives
+// ErrBindingStatePruned.
+func (chain *Blockchain) openBindingTrie(height uint64, root common.Hash) (state.Trie, error) {
+	tr, err := chain.stateBindingDb.OpenBindingTrie(root)
+	if _, ok := err.(*trie.MissingNodeError); ok && height < chain.bindingPrunedHeight {
+		return nil, fmt.Errorf("%w below height %d, trie of block %d is not kept", ErrBindingStatePruned, chain.bindingPrunedHeight, height)
+	}
+	return tr, err
+}
@@ -0,0 +1,62 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wangxinyu2018/mass-core/config"
+	"github.com/wangxinyu2018/mass-core/consensus"
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/disk"
+)
+
+func TestBlockchain_PruneBlockFiles(t *testing.T) {
+	// spread the blocks over files of a few blocks, keep 10 recent blocks
+	defer func(size, minBlocks uint64) {
+		disk.MaxBlockfileSize = size
+		consensus.PrunedNodeMinBlocks = minBlocks
+	}(disk.MaxBlockfileSize, consensus.PrunedNodeMinBlocks)
+	disk.MaxBlockfileSize = 16 * 1024
+	consensus.PrunedNodeMinBlocks = 10
+
+	bc, teardown, err := newBlockChain()
+	require.NoError(t, err)
+	defer teardown()
+	bc.pruneDepth = 10
+
+	blks, err := loadTopNBlk(80)
+	require.NoError(t, err)
+	for i := 1; i < 60; i++ {
+		_, err = bc.processBlock(blks[i], BFNone)
+		require.NoError(t, err, i)
+	}
+
+	// nothing is pruned above the latest checkpoint
+	bc.pruneBlockFiles(bc.BestBlockHeight())
+	pruned, err := bc.db.HasPrunedBlocks()
+	require.NoError(t, err)
+	require.False(t, pruned)
+
+	bc.checkpoints = []config.Checkpoint{{Height: 40, Hash: blks[40].Hash()}}
+	require.Equal(t, uint64(40), bc.pruneTargetHeight(bc.BestBlockHeight(), bc.pruneDepth))
+	bc.pruneBlockFiles(bc.BestBlockHeight())
+	pruned, err = bc.db.HasPrunedBlocks()
+	require.NoError(t, err)
+	require.True(t, pruned)
+	_, err = bc.db.FetchBlockBySha(blks[1].Hash())
+	require.Equal(t, database.ErrBlockPruned, err)
+	_, err = bc.db.FetchBlockBySha(blks[40].Hash())
+	require.NoError(t, err)
+
+	// outputs of pruned blocks are still spent by new blocks
+	for i := 60; i < len(blks); i++ {
+		_, err = bc.processBlock(blks[i], BFNone)
+		require.NoError(t, err, i)
+	}
+	require.Equal(t, uint64(len(blks)-1), bc.BestBlockHeight())
+
+	// scheduling never blocks block connecting, pending pruning is skipped
+	bc.schedulePruning(bc.BestBlockHeight())
+	bc.schedulePruning(bc.BestBlockHeight())
+	require.Equal(t, 1, len(bc.pruneCh))
+}
@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// checkReorgDepth refuses a block whose chain forks from the main chain more
+// than maxReorgDepth blocks below the best block, as switching to that chain
+// would revert blocks considered final.  The block is allowed anyway if the
+// operator has allowed it, or any of its side chain ancestors, with
+// AllowDeepReorg.
+func (chain *Blockchain) checkReorgDepth(blockHash *wire.Hash, prevNode *BlockNode) error {
+	if chain.maxReorgDepth == 0 {
+		return nil
+	}
+
+	allowed := chain.isReorgAllowed(blockHash)
+	fork := prevNode
+	for ; fork != nil && !fork.InMainChain; fork = fork.Parent {
+		allowed = allowed || chain.isReorgAllowed(fork.Hash)
+	}
+	best := chain.blockTree.bestBlockNode()
+	if fork == nil || fork.Height+chain.maxReorgDepth >= best.Height {
+		return nil
+	}
+
+	event := &ReorgRefusedEvent{
+		Block:      *blockHash,
+		Height:     prevNode.Height + 1,
+		ForkHash:   *fork.Hash,
+		ForkHeight: fork.Height,
+		BestHash:   *best.Hash,
+		BestHeight: best.Height,
+		Depth:      best.Height - fork.Height,
+		MaxDepth:   chain.maxReorgDepth,
+		Overridden: allowed,
+	}
+	chain.eventBus.Publish(EventReorgRefused, event)
+	if allowed {
+		logging.CPrint(logging.WARN, "accepting block forking below the max reorg depth by operator override",
+			logging.LogFormat{"block": blockHash, "fork_height": fork.Height, "best_height": best.Height, "depth": event.Depth})
+		return nil
+	}
+	logging.CPrint(logging.ERROR, "block forks the main chain below the max reorg depth",
+		logging.LogFormat{
+			"block":       blockHash,
+			"fork_hash":   fork.Hash,
+			"fork_height": fork.Height,
+			"best_height": best.Height,
+			"depth":       event.Depth,
+			"max_depth":   chain.maxReorgDepth,
+		})
+	return ErrReorgTooDeep
+}
+
+// isReorgAllowed returns whether the operator allowed the block to fork below
+// the max reorg depth.
+func (chain *Blockchain) isReorgAllowed(blockHash *wire.Hash) bool {
+	chain.reorgOverrideMtx.Lock()
+	defer chain.reorgOverrideMtx.Unlock()
+
+	_, ok := chain.reorgOverrides[*blockHash]
+	return ok
+}
+
+// AllowDeepReorg allows the block, and the blocks built on it, to fork the main
+// chain below the max reorg depth.  A block refused before is processed again
+// once it is received again.
+//
+// This function is safe for concurrent access.
+func (chain *Blockchain) AllowDeepReorg(blockHash *wire.Hash) {
+	chain.reorgOverrideMtx.Lock()
+	defer chain.reorgOverrideMtx.Unlock()
+
+	chain.reorgOverrides[*blockHash] = struct{}{}
+	logging.CPrint(logging.WARN, "allowed block to fork below the max reorg depth", logging.LogFormat{"block": blockHash})
+}
+
+// MaxReorgDepth returns the number of blocks a reorganization may detach at
+// most, zero means no limit.
+func (chain *Blockchain) MaxReorgDepth() uint64 {
+	return chain.maxReorgDepth
+}
@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+func TestCheckReorgDepth(t *testing.T) {
+	chain := &Blockchain{
+		blockTree:      NewBlockTree(),
+		eventBus:       NewEventBus(),
+		maxReorgDepth:  3,
+		reorgOverrides: make(map[wire.Hash]struct{}),
+	}
+	sub := chain.Subscribe(4, PolicyDrop, EventReorgRefused)
+	defer sub.Unsubscribe()
+
+	newNode := func(parent *BlockNode, seq byte, inMainChain bool) *BlockNode {
+		node := &BlockNode{Hash: &wire.Hash{seq}, Parent: parent, InMainChain: inMainChain}
+		if parent != nil {
+			node.Height = parent.Height + 1
+		}
+		return node
+	}
+
+	// main chain of height 10
+	main := []*BlockNode{newNode(nil, 0, true)}
+	for i := 1; i <= 10; i++ {
+		main = append(main, newNode(main[i-1], byte(i), true))
+	}
+	chain.blockTree.setBestBlockNode(main[10])
+
+	// forking at height 7 detaches 3 blocks
+	if err := chain.checkReorgDepth(&wire.Hash{100}, main[7]); err != nil {
+		t.Errorf("fork at max depth, got = %v, want = nil", err)
+	}
+
+	// side chain forking at height 6 extending above the best height
+	side := newNode(main[6], 101, false)
+	for i := 0; i < 5; i++ {
+		side = newNode(side, byte(102+i), false)
+	}
+	if err := chain.checkReorgDepth(&wire.Hash{110}, side); err != ErrReorgTooDeep {
+		t.Errorf("fork below max depth, got = %v, want = %v", err, ErrReorgTooDeep)
+	}
+	ev := (<-sub.Events()).Data.(*ReorgRefusedEvent)
+	if ev.Depth != 4 || ev.ForkHeight != 6 || ev.Overridden {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	// allowing a side chain ancestor allows its descendants
+	chain.AllowDeepReorg(&wire.Hash{102})
+	if err := chain.checkReorgDepth(&wire.Hash{110}, side); err != nil {
+		t.Errorf("overridden fork, got = %v, want = nil", err)
+	}
+	if ev := (<-sub.Events()).Data.(*ReorgRefusedEvent); !ev.Overridden {
+		t.Errorf("event should be overridden")
+	}
+	if err := chain.checkReorgDepth(&wire.Hash{111}, main[5]); err != ErrReorgTooDeep {
+		t.Errorf("other fork, got = %v, want = %v", err, ErrReorgTooDeep)
+	}
+
+	chain.maxReorgDepth = 0
+	if err := chain.checkReorgDepth(&wire.Hash{111}, main[0]); err != nil {
+		t.Errorf("no limit, got = %v, want = nil", err)
+	}
+}
@@ -0,0 +1,167 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// SnapshotValidator validates the history of a chain started from a utxo
+// snapshot in the background.  Blocks from genesis up to the snapshot block
+// are connected to a separate history chain, whose chain state at the
+// snapshot block is then compared with the snapshot.  On a mismatch the
+// snapshot is marked invalid, and the chain started from it refuses blocks
+// from then on.
+type SnapshotValidator struct {
+	chain   *Blockchain
+	history *Blockchain
+	base    database.UtxoSnapshotBase
+	done    int32
+}
+
+// newSnapshotValidator creates the history chain of historyConfig for chain,
+// which has been started from the utxo snapshot of base.
+func newSnapshotValidator(chain *Blockchain, base *database.UtxoSnapshotBase, historyConfig *Config) (*SnapshotValidator, error) {
+	cfg := *historyConfig
+	cfg.AssumeUtxo, cfg.History = nil, nil
+	cfg.CFIndex, cfg.SpentIndex = false, false
+	cfg.MempoolPath, cfg.FeeEstimatesPath = "", ""
+	history, err := NewBlockchain(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &SnapshotValidator{
+		chain:   chain,
+		history: history,
+		base:    *base,
+	}
+	bestHeight := history.BestBlockHeight()
+	if bestHeight > base.Height {
+		return nil, fmt.Errorf("%w: history chain at height %d above the snapshot", ErrUtxoSnapshotHeight, bestHeight)
+	}
+	// an earlier run may have stopped before comparing
+	if bestHeight == base.Height {
+		if err = v.finish(); err != nil {
+			return nil, err
+		}
+	}
+	logging.CPrint(logging.INFO, "validating utxo snapshot history", logging.LogFormat{
+		"height":  base.Height,
+		"block":   base.BlockHash,
+		"history": bestHeight,
+	})
+	return v, nil
+}
+
+// ProcessBlock connects a block of the history to the history chain, blocks
+// above the snapshot block or forking it are refused.  Once the history chain
+// reaches the snapshot block its chain state is compared with the snapshot,
+// ErrUtxoSnapshotMismatch is returned if they differ.
+func (v *SnapshotValidator) ProcessBlock(block *massutil.Block) (bool, error) {
+	if v.Done() {
+		return false, nil
+	}
+	height := block.MsgBlock().Header.Height
+	if height > v.base.Height {
+		return false, fmt.Errorf("%w: block at height %d above the snapshot", ErrUtxoSnapshotHeight, height)
+	}
+	if height == v.base.Height && !block.Hash().IsEqual(&v.base.BlockHash) {
+		return false, fmt.Errorf("%s: block at height %d does not match utxo snapshot", ErrBadCheckpoint, height)
+	}
+
+	isOrphan, err := v.history.ProcessBlock(block)
+	if err != nil || isOrphan {
+		return isOrphan, err
+	}
+	if v.history.BestBlockHeight() == v.base.Height {
+		return false, v.finish()
+	}
+	return false, nil
+}
+
+// finish compares the chain state of the history chain at the snapshot block
+// with the snapshot and records the result.
+func (v *SnapshotValidator) finish() error {
+	snap, err := v.history.UtxoSnapshotAt(v.base.Height)
+	if err != nil {
+		return err
+	}
+	if snap.BlockHash == v.base.BlockHash && snap.ContentHash == v.base.ContentHash {
+		if err = v.chain.db.SetUtxoSnapshotState(database.UtxoSnapshotValidated); err != nil {
+			return err
+		}
+		atomic.StoreInt32(&v.done, 1)
+		logging.CPrint(logging.INFO, "utxo snapshot validated", logging.LogFormat{
+			"height": v.base.Height,
+			"hash":   v.base.ContentHash,
+		})
+		return nil
+	}
+
+	// blocks are refused before the state is recorded, a chain restarted
+	// after a failed write validates the history once more
+	atomic.StoreInt32(&v.chain.snapshotInvalid, 1)
+	atomic.StoreInt32(&v.done, 1)
+	logging.CPrint(logging.ERROR, ErrUtxoSnapshotMismatch.Error(), logging.LogFormat{
+		"height":        v.base.Height,
+		"expected":      v.base.BlockHash,
+		"actual":        snap.BlockHash,
+		"expected hash": v.base.ContentHash,
+		"actual hash":   snap.ContentHash,
+	})
+	if err = v.chain.db.SetUtxoSnapshotState(database.UtxoSnapshotInvalid); err != nil {
+		return err
+	}
+	return ErrUtxoSnapshotMismatch
+}
+
+// Done returns whether the history has been validated, whatever the result.
+func (v *SnapshotValidator) Done() bool {
+	return atomic.LoadInt32(&v.done) == 1
+}
+
+// TargetHeight returns the height of the snapshot block.
+func (v *SnapshotValidator) TargetHeight() uint64 {
+	return v.base.Height
+}
+
+// TargetHash returns the hash of the snapshot block.
+func (v *SnapshotValidator) TargetHash() *wire.Hash {
+	hash := v.base.BlockHash
+	return &hash
+}
+
+// BestBlockHeader returns the header of the best block of the history chain.
+func (v *SnapshotValidator) BestBlockHeader() *wire.BlockHeader {
+	return v.history.BestBlockHeader()
+}
+
+// GetHeaderByHeight returns the header of the history chain block at height.
+func (v *SnapshotValidator) GetHeaderByHeight(height uint64) (*wire.BlockHeader, error) {
+	return v.history.GetHeaderByHeight(height)
+}
+
+// FlushBindingState writes the buffered binding trie nodes of the history
+// chain to disk, see Blockchain.FlushBindingState.
+func (v *SnapshotValidator) FlushBindingState() error {
+	return v.history.FlushBindingState()
+}
+
+// SnapshotValidator returns the validator of the history up to the utxo
+// snapshot the chain started from, nil if the chain started from genesis, the
+// history had been validated before the chain was created, or no history chain
+// is configured.
+func (chain *Blockchain) SnapshotValidator() *SnapshotValidator {
+	return chain.snapshotValidator
+}
+
+// isUtxoSnapshotInvalid returns whether the history has been found not
+// matching the utxo snapshot the chain started from.
+func (chain *Blockchain) isUtxoSnapshotInvalid() bool {
+	return atomic.LoadInt32(&chain.snapshotInvalid) == 1
+}
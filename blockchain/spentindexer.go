@@ -0,0 +1,149 @@
+package blockchain
+
+import (
+	"sync"
+
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+)
+
+// SpentIndexer records the spending tx of each outpoint spent by main chain
+// blocks, so that spends can be looked up by outpoint.
+type SpentIndexer struct {
+	blockLogger *BlockProgressLogger
+	db          database.Db
+	sync.Mutex
+}
+
+// NewSpentIndexer creates a new spent indexer.
+func NewSpentIndexer(db database.Db) *SpentIndexer {
+	return &SpentIndexer{
+		db:          db,
+		blockLogger: NewBlockProgressLogger("spent outpoints indexed"),
+	}
+}
+
+// SyncAttachBlock indexes the outpoints spent by a newly connected block.
+func (s *SpentIndexer) SyncAttachBlock(block *massutil.Block) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.indexBlock(block)
+}
+
+// SyncDetachBlock removes the spends of a disconnected block.
+func (s *SpentIndexer) SyncDetachBlock(block *massutil.Block) error {
+	s.Lock()
+	defer s.Unlock()
+
+	tipHash, tipHeight, err := s.db.FetchSpentIndexTip()
+	if err != nil {
+		return err
+	}
+	if tipHeight != block.Height() || !tipHash.IsEqual(block.Hash()) {
+		logging.CPrint(logging.ERROR, errUnexpectedSpentIndexTip.Error(), logging.LogFormat{
+			"tip hash":     tipHash,
+			"tip height":   tipHeight,
+			"block hash":   block.Hash(),
+			"block height": block.Height(),
+		})
+		return errUnexpectedSpentIndexTip
+	}
+	return s.db.DeleteSpentIndex(block.Hash(), block.Height())
+}
+
+// catchUp indexes main chain blocks from the index tip up to bestHeight. Spends
+// of blocks which are no longer on the main chain are removed first.  Blocks
+// are read from the block files, so it fails with ErrIndexBlocksPruned if the
+// index is behind the chain on a pruned store, the index is never partial.
+func (s *SpentIndexer) catchUp(bestHeight uint64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	start := uint64(0)
+	for {
+		tipHash, tipHeight, err := s.db.FetchSpentIndexTip()
+		if err == database.ErrSpentIndexDoesNotExist {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if tipHeight <= bestHeight {
+			mainHash, err := s.db.FetchBlockShaByHeight(tipHeight)
+			if err != nil {
+				return err
+			}
+			if mainHash.IsEqual(tipHash) {
+				start = tipHeight + 1
+				break
+			}
+		}
+		if err = s.db.DeleteSpentIndex(tipHash, tipHeight); err != nil {
+			return err
+		}
+	}
+
+	if start <= bestHeight {
+		// blocks are replayed from start, which may be pruned
+		pruned, err := s.db.HasPrunedBlocks()
+		if err != nil {
+			return err
+		}
+		if pruned {
+			logging.CPrint(logging.ERROR, "unable to catch up spent index on a pruned store", logging.LogFormat{
+				"start": start,
+				"best":  bestHeight,
+			})
+			return ErrIndexBlocksPruned
+		}
+		logging.CPrint(logging.INFO, "catching up spent index", logging.LogFormat{
+			"start": start,
+			"best":  bestHeight,
+		})
+	}
+	for height := start; height <= bestHeight; height++ {
+		hash, err := s.db.FetchBlockShaByHeight(height)
+		if err != nil {
+			return err
+		}
+		block, err := s.db.FetchBlockBySha(hash)
+		if err != nil {
+			return err
+		}
+		if err = s.indexBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexBlock submits the spends of block on top of its parent.
+func (s *SpentIndexer) indexBlock(block *massutil.Block) error {
+	if block.Height() > 0 {
+		tipHash, tipHeight, err := s.db.FetchSpentIndexTip()
+		if err != nil && err != database.ErrSpentIndexDoesNotExist {
+			return err
+		}
+		if err != nil || tipHeight+1 != block.Height() || !tipHash.IsEqual(&block.MsgBlock().Header.Previous) {
+			logging.CPrint(logging.ERROR, errUnexpectedSpentIndexTip.Error(), logging.LogFormat{
+				"tip hash":     tipHash,
+				"tip height":   tipHeight,
+				"block hash":   block.Hash(),
+				"block height": block.Height(),
+			})
+			return errUnexpectedSpentIndexTip
+		}
+	}
+
+	if err := s.db.SubmitSpentIndex(block); err != nil {
+		logging.CPrint(logging.ERROR, "Unable to write spent index for block", logging.LogFormat{
+			"block hash": block.Hash(),
+			"height":     block.Height(),
+			"error":      err,
+		})
+		return err
+	}
+	s.blockLogger.LogBlockHeight(block)
+	return nil
+}
@@ -53,14 +53,14 @@ type Trie interface {
 	// starts at the key after the given start key.
 	NodeIterator(startKey []byte) trie.NodeIterator
 
-	// // Prove constructs a Merkle proof for key. The result contains all encoded nodes
-	// // on the path to the value at key. The value itself is also included in the last
-	// // node and can be retrieved by verifying the proof.
-	// //
-	// // If the trie does not contain a value for key, the returned proof contains all
-	// // nodes of the longest existing prefix of the key (at least the root), ending
-	// // with the node that proves the absence of the key.
-	// Prove(key []byte, fromLevel uint, proofDb massdb.KeyValueWriter) error
+	// Prove constructs a Merkle proof for key. The result contains all encoded nodes
+	// on the path to the value at key. The value itself is also included in the last
+	// node and can be retrieved by verifying the proof with trie.VerifyProof.
+	//
+	// If the trie does not contain a value for key, the returned proof contains all
+	// nodes of the longest existing prefix of the key (at least the root), ending
+	// with the node that proves the absence of the key.
+	Prove(key []byte) ([][]byte, error)
 }
 
 // NewDatabase creates a backing store for state. The returned database is safe for
@@ -72,6 +72,15 @@ func NewDatabase(db massdb.Database) Database {
 	}
 }
 
+// NewDatabaseWithConfig creates a backing store for state with the trie node
+// caching of config, nil for none.  Nodes buffered by a dirty node cache reach
+// the disk on TrieDB().Flush.
+func NewDatabaseWithConfig(db massdb.Database, config *trie.Config) Database {
+	return &cachingDB{
+		db: trie.NewDatabaseWithConfig(db, config),
+	}
+}
+
 type cachingDB struct {
 	db *trie.Database
 }
@@ -59,8 +59,17 @@ func init() {
 type txPrioItem struct {
 	tx       *massutil.Tx
 	fee      int64
+	size     int64
 	priority float64
-	feePerKB float64
+	feePerKB float64 // of the ancestor package once sorted by fee
+
+	// queued, included and skipped track whether the transaction is waiting
+	// in the priority queue, has been added to the block, or has been given
+	// up on its own.  A skipped transaction may still be added as the
+	// ancestor of another one.
+	queued   bool
+	included bool
+	skipped  bool
 
 	// dependsOn holds a map of transaction hashes which this one depends
 	// on.  It will only be set when the transaction references other
@@ -69,6 +78,36 @@ type txPrioItem struct {
 	dependsOn map[wire.Hash]struct{}
 }
 
+// ancestorPackage returns the transaction along with all of its ancestors which
+// are not included yet, parents ordered before their children.
+func (item *txPrioItem) ancestorPackage(items map[wire.Hash]*txPrioItem) []*txPrioItem {
+	pkg := make([]*txPrioItem, 0, len(item.dependsOn)+1)
+	visited := make(map[*txPrioItem]struct{})
+	var visit func(*txPrioItem)
+	visit = func(it *txPrioItem) {
+		if _, ok := visited[it]; ok {
+			return
+		}
+		visited[it] = struct{}{}
+		for hash := range it.dependsOn {
+			visit(items[hash])
+		}
+		pkg = append(pkg, it)
+	}
+	visit(item)
+	return pkg
+}
+
+// packageFeePerKB returns the fee in Maxwell/KB paid by the package as a whole.
+func packageFeePerKB(pkg []*txPrioItem) float64 {
+	var fee, size int64
+	for _, item := range pkg {
+		fee += item.fee
+		size += item.size
+	}
+	return float64(fee) / (float64(size) / 1000)
+}
+
 // txPriorityQueueLessFunc describes a function that can be used as a compare
 // function for a transaction priority queue (txPriorityQueue).
 type txPriorityQueueLessFunc func(*txPriorityQueue, int, int) bool
@@ -450,20 +489,6 @@ func calcNodeReward(totalReward massutil.Amount, totalWeight, nodeWeight *safety
 	return massutil.NewAmount(u)
 }
 
-// logSkippedDeps logs any dependencies which are also skipped as a result of
-// skipping a transaction while generating a block template at the trace level.
-func logSkippedDeps(tx *massutil.Tx, deps *list.List) {
-	if deps == nil {
-		return
-	}
-
-	for e := deps.Front(); e != nil; e = e.Next() {
-		item := e.Value.(*txPrioItem)
-		logging.CPrint(logging.TRACE, "skipping tx since it depends on tx which is already skipped", logging.LogFormat{"txid": item.tx.Hash().String(), "depend": tx.Hash().String()})
-
-	}
-}
-
 // NewBlockTemplate returns a new block template that is ready to be solved
 // using the transactions from the passed transaction memory pool and a coinbase
 // that either pays to the passed address if it is not nil, or a coinbase that
@@ -775,6 +800,7 @@ func newBlockTemplate(chain *Blockchain, payoutAddresses []massutil.Address, tem
 	}
 
 	//mempoolLoop:
+	items := make(map[wire.Hash]*txPrioItem, len(mempoolTxns))
 	for _, txDesc := range mempoolTxns {
 		// A block can't have more than one coinbase or contain
 		// non-finalized transactions.
@@ -784,6 +810,7 @@ func newBlockTemplate(chain *Blockchain, payoutAddresses []massutil.Address, tem
 		// other transactions in the mempool so they can be properly
 		// ordered below.
 		prioItem := &txPrioItem{tx: txDesc.Tx}
+		items[*tx.Hash()] = prioItem
 		for _, txIn := range tx.MsgTx().TxIn {
 			originHash := &txIn.PreviousOutPoint.Hash
 			// because mempoolTxns is snapshot of mempool, its tx can not orphan
@@ -818,13 +845,30 @@ func newBlockTemplate(chain *Blockchain, payoutAddresses []massutil.Address, tem
 		txSize := tx.MsgTx().PlainSize()
 		prioItem.feePerKB = float64(txDesc.Fee.IntValue()) / (float64(txSize) / 1000)
 		prioItem.fee = txDesc.Fee.IntValue()
+		prioItem.size = int64(txSize)
+	}
 
-		// Add the transaction to the priority queue to mark it ready
-		// for inclusion in the block unless it has dependencies.
-		if prioItem.dependsOn == nil {
-			heap.Push(priorityQueue, prioItem)
+	// Add the transactions to the priority queue to mark them ready for
+	// inclusion in the block.  Sorted by priority, a transaction waits
+	// until its dependencies are included.  Sorted by fee, every
+	// transaction is ranked by the fee rate of its ancestor package, so
+	// that a child paying a high fee pulls its parents into the block.
+	pushPending := func() {
+		for _, item := range items {
+			if item.queued || item.included || item.skipped {
+				continue
+			}
+			if !sortedByFee && len(item.dependsOn) != 0 {
+				continue
+			}
+			if sortedByFee {
+				item.feePerKB = packageFeePerKB(item.ancestorPackage(items))
+			}
+			item.queued = true
+			heap.Push(priorityQueue, item)
 		}
 	}
+	pushPending()
 
 	logging.CPrint(logging.TRACE, "Check the length of priority queue and dependent",
 		logging.LogFormat{"priority": priorityQueue.Len(), "dependent": len(dependers)})
@@ -842,34 +886,59 @@ func newBlockTemplate(chain *Blockchain, payoutAddresses []massutil.Address, tem
 		// Grab the highest priority (or highest fee per kilobyte
 		// depending on the sort order) transaction.
 		prioItem := heap.Pop(priorityQueue).(*txPrioItem)
+		prioItem.queued = false
 		tx := prioItem.tx
 
-		// Grab the list of transactions which depend on this one (if
-		// any) and remove the entry for this transaction as it will
-		// either be included or skipped, but in either case the deps
-		// are no longer needed.
-		deps := dependers[*tx.Hash()]
-		delete(dependers, *tx.Hash())
+		// The transaction may have been included already as an ancestor
+		// of another package.
+		if prioItem.included {
+			continue
+		}
+
+		// Sorted by fee, the transaction is included together with its
+		// ancestors which are not included yet.  The package changes as
+		// ancestors get included by other packages, so re-rank the
+		// transaction if its package fee rate is stale.
+		pkg := []*txPrioItem{prioItem}
+		if sortedByFee {
+			pkg = prioItem.ancestorPackage(items)
+			if feePerKB := packageFeePerKB(pkg); feePerKB != prioItem.feePerKB {
+				prioItem.feePerKB = feePerKB
+				prioItem.queued = true
+				heap.Push(priorityQueue, prioItem)
+				continue
+			}
+		}
+
 		// Enforce maximum block size.  Also check for overflow.
-		txSize := uint32(tx.PlainSize())
+		var txSize uint32
+		for _, item := range pkg {
+			txSize += uint32(item.size)
+		}
 		blockPlusTxSize := blockSize + txSize
 		// Enforce maximum block size.  Also check for overflow.
 
 		if blockPlusTxSize < blockSize ||
 			blockPlusTxSize >= config.BlockMaxSize {
 			logging.CPrint(logging.TRACE, "Skipping tx because it would exceed the max block weight",
-				logging.LogFormat{"txid": tx.Hash().String()})
-			logSkippedDeps(tx, deps)
+				logging.LogFormat{"txid": tx.Hash().String(), "package": len(pkg)})
+			prioItem.skipped = true
 			continue
 		}
 
 		// Enforce maximum signature operations per block.  Also check
 		// for overflow.
-		numSigOps := int64(CountSigOps(tx))
+		var numSigOps int64
+		pkgSigOps := make([]int64, 0, len(pkg))
+		for _, item := range pkg {
+			n := int64(CountSigOps(item.tx))
+			pkgSigOps = append(pkgSigOps, n)
+			numSigOps += n
+		}
 		if blockSigOps+numSigOps < blockSigOps || blockSigOps+numSigOps > MaxSigOpsPerBlock {
 			logging.CPrint(logging.TRACE, "Skipping tx because it would exceed the maximum sigops per block",
-				logging.LogFormat{"txid": tx.Hash().String()})
-			logSkippedDeps(tx, deps)
+				logging.LogFormat{"txid": tx.Hash().String(), "package": len(pkg)})
+			prioItem.skipped = true
 			continue
 		}
 
@@ -881,7 +950,7 @@ func newBlockTemplate(chain *Blockchain, payoutAddresses []massutil.Address, tem
 
 			logging.CPrint(logging.TRACE, "Skipping tx with feePerKB < TxMinFreeFee and block weight >= minBlockSize",
 				logging.LogFormat{"txid": tx.Hash().String(), "feePerKB": prioItem.feePerKB, "TxMinFreeFee": consensus.MinRelayTxFee, "block weight": blockPlusTxSize, "minBlockSize": config.BlockMinSize})
-			logSkippedDeps(tx, deps)
+			prioItem.skipped = true
 			continue
 		}
 
@@ -910,50 +979,62 @@ func newBlockTemplate(chain *Blockchain, payoutAddresses []massutil.Address, tem
 			if blockPlusTxSize > config.BlockPrioritySize ||
 				prioItem.priority < minHighPriority {
 
+				prioItem.queued = true
 				heap.Push(priorityQueue, prioItem)
+				pushPending()
 				continue
 			}
+			pushPending()
 		}
 
-		temp, err := totalFee.AddInt(prioItem.fee)
+		var pkgFee int64
+		for _, item := range pkg {
+			pkgFee += item.fee
+		}
+		temp, err := totalFee.AddInt(pkgFee)
 		if err != nil {
 			logging.CPrint(logging.ERROR, "calc total fee error",
 				logging.LogFormat{
 					"err":  err,
 					"txid": tx.Hash().String(),
 				})
+			prioItem.skipped = true
 			continue
 		}
 
-		// Add the transaction to the block, increment counters, and
+		// Add the transactions to the block, increment counters, and
 		// save the fees and signature operation counts to the block
 		// template.
-		blockTxns = append(blockTxns, tx.MsgTx())
-		blockSize += txSize
-		blockSigOps += numSigOps
-		totalFee = temp
-		txSigOpCounts = append(txSigOpCounts, numSigOps)
-
-		logging.CPrint(logging.TRACE, "Adding tx",
-			logging.LogFormat{"txid": tx.Hash().String(),
-				"priority": fmt.Sprintf("%.2f", prioItem.priority),
-				"feePerKB": fmt.Sprintf("%.2f", prioItem.feePerKB)})
-
-		// Add transactions which depend on this one (and also do not
-		// have any other unsatisified dependencies) to the priority
-		// queue.
-		if deps != nil {
-			for e := deps.Front(); e != nil; e = e.Next() {
-				// Add the transaction to the priority queue if
-				// there are no more dependencies after this
-				// one.
-				item := e.Value.(*txPrioItem)
-				delete(item.dependsOn, *tx.Hash())
-				if len(item.dependsOn) == 0 {
-					heap.Push(priorityQueue, item)
+		for i, item := range pkg {
+			blockTxns = append(blockTxns, item.tx.MsgTx())
+			txSigOpCounts = append(txSigOpCounts, pkgSigOps[i])
+			item.included = true
+
+			logging.CPrint(logging.TRACE, "Adding tx",
+				logging.LogFormat{"txid": item.tx.Hash().String(),
+					"priority": fmt.Sprintf("%.2f", item.priority),
+					"feePerKB": fmt.Sprintf("%.2f", prioItem.feePerKB)})
+
+			// Add transactions which depend on this one (and also do not
+			// have any other unsatisified dependencies) to the priority
+			// queue.
+			if deps := dependers[*item.tx.Hash()]; deps != nil {
+				for e := deps.Front(); e != nil; e = e.Next() {
+					// Add the transaction to the priority queue if
+					// there are no more dependencies after this
+					// one.
+					dep := e.Value.(*txPrioItem)
+					delete(dep.dependsOn, *item.tx.Hash())
+					if len(dep.dependsOn) == 0 && !dep.queued && !dep.included && !dep.skipped {
+						dep.queued = true
+						heap.Push(priorityQueue, dep)
+					}
 				}
 			}
 		}
+		blockSize += txSize
+		blockSigOps += numSigOps
+		totalFee = temp
 	}
 
 	// Next, obtain the merkle root of a tree which consists of the
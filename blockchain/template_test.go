@@ -9,6 +9,7 @@ import (
 	"github.com/wangxinyu2018/mass-core/massutil"
 	"github.com/wangxinyu2018/mass-core/massutil/safetype"
 	"github.com/wangxinyu2018/mass-core/txscript"
+	"github.com/wangxinyu2018/mass-core/wire"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -388,3 +389,32 @@ func TestPayload(t *testing.T) {
 	assert.Equal(t, uint32(1), coinbasePayload.NumStakingReward())
 	assert.Equal(t, uint64(30379), coinbasePayload.height)
 }
+
+func TestTxPrioItem_AncestorPackage(t *testing.T) {
+	grandParent := newMockPrioItem("grandParent", 0, 1000)
+	parent := newMockPrioItem("parent", 100, 1000, grandParent)
+	uncle := newMockPrioItem("uncle", 100, 1000)
+	child := newMockPrioItem("child", 5800, 1000, parent, uncle)
+	items := make(map[wire.Hash]*txPrioItem)
+	for _, item := range []*txPrioItem{grandParent, parent, uncle, child} {
+		items[*item.tx.Hash()] = item
+	}
+
+	pkg := child.ancestorPackage(items)
+	assert.Equal(t, 4, len(pkg))
+	assert.Equal(t, child, pkg[3])
+	pos := make(map[*txPrioItem]int)
+	for i, item := range pkg {
+		pos[item] = i
+	}
+	assert.True(t, pos[grandParent] < pos[parent])
+	assert.Equal(t, float64(1500), packageFeePerKB(pkg))
+
+	// included ancestors are no longer part of the package
+	delete(parent.dependsOn, *grandParent.tx.Hash())
+	delete(child.dependsOn, *uncle.tx.Hash())
+	pkg = child.ancestorPackage(items)
+	assert.Equal(t, []*txPrioItem{parent, child}, pkg)
+	assert.Equal(t, float64(2950), packageFeePerKB(pkg))
+	assert.Equal(t, []*txPrioItem{grandParent}, grandParent.ancestorPackage(items))
+}
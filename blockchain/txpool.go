@@ -74,6 +74,17 @@ type TxPool struct {
 	NewTxCh       chan *massutil.Tx
 
 	bindingTargets map[string]wire.Hash // binding.ScriptAddress -> TxHash
+	replacement    ReplacementPolicy
+
+	limits               TxPoolLimits
+	totalSize            uint64 // serialized size of pool txs
+	totalMemory          uint64 // estimated memory of pool txs
+	rollingMinFeeRate    massutil.Amount
+	rollingMinFeeUpdated time.Time
+	mempoolPath          string // file the pool is saved to on Stop, empty to disable
+
+	feeEstimator     *FeeEstimator
+	feeEstimatesPath string // file the fee estimator is saved to on Stop, empty to disable
 }
 
 func (tp *TxPool) SetNewTxCh(ch chan *massutil.Tx) {
@@ -149,14 +160,14 @@ func (tp *TxPool) HaveTransaction(hash *wire.Hash) bool {
 // RemoveTransaction.  See the comment for RemoveTransaction for more details.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (tp *TxPool) removeTransaction(tx *massutil.Tx, removeRedeemers bool) {
+func (tp *TxPool) removeTransaction(tx *massutil.Tx, removeRedeemers bool, reason TxRemovalReason) {
 	txHash := tx.Hash()
 	if removeRedeemers {
 		// Remove any transactions which rely on this one.
 		for i := uint32(0); i < uint32(len(tx.MsgTx().TxOut)); i++ {
 			outpoint := wire.NewOutPoint(txHash, i)
 			if txRedeemer, exists := tp.outpoints[*outpoint]; exists {
-				tp.removeTransaction(txRedeemer, true)
+				tp.removeTransaction(txRedeemer, true, TxRemovedRedeemer)
 			}
 		}
 	}
@@ -176,8 +187,21 @@ func (tp *TxPool) removeTransaction(tx *massutil.Tx, removeRedeemers bool) {
 		for _, txIn := range txDesc.Tx.MsgTx().TxIn {
 			delete(tp.outpoints, txIn.PreviousOutPoint)
 		}
+		for i := range tx.TxOut() {
+			psi := tx.GetPkScriptInfo(i)
+			if txscript.ScriptClass(psi.Class) == txscript.BindingScriptHashTy {
+				if hash, ok := tp.bindingTargets[string(psi.BoundPkScript)]; ok && hash == *txHash {
+					delete(tp.bindingTargets, string(psi.BoundPkScript))
+				}
+			}
+		}
 		delete(tp.pool, *txHash)
+		tp.feeEstimator.forgetTx(txHash)
+		tp.totalSize -= uint64(tx.MsgTx().PlainSize())
+		tp.totalMemory -= txMemoryUsage(tx)
 		tp.lastUpdated = time.Now()
+
+		tp.chain.eventBus.Publish(EventTxRemoved, &TxRemovedEvent{Tx: tx, Reason: reason})
 	}
 }
 
@@ -247,7 +271,7 @@ func (tp *TxPool) RemoveTransaction(tx *massutil.Tx, removeRedeemers bool) {
 	tp.Lock()
 	defer tp.Unlock()
 
-	tp.removeTransaction(tx, removeRedeemers)
+	tp.removeTransaction(tx, removeRedeemers, TxRemovedManual)
 }
 
 // RemoveDoubleSpends removes all transactions which spend outputs spent by the
@@ -269,7 +293,7 @@ func (tp *TxPool) removeDoubleSpends(tx *massutil.Tx) {
 	for _, txIn := range tx.MsgTx().TxIn {
 		if txRedeemer, ok := tp.outpoints[txIn.PreviousOutPoint]; ok {
 			if !txRedeemer.Hash().IsEqual(tx.Hash()) {
-				tp.removeTransaction(txRedeemer, true)
+				tp.removeTransaction(txRedeemer, true, TxRemovedConflict)
 			}
 		}
 	}
@@ -301,6 +325,8 @@ func (tp *TxPool) addTransaction(tx *massutil.Tx, height uint64, startingPriorit
 	for _, txIn := range tx.MsgTx().TxIn {
 		tp.outpoints[txIn.PreviousOutPoint] = tx
 	}
+	tp.totalSize += uint64(tx.MsgTx().PlainSize())
+	tp.totalMemory += txMemoryUsage(tx)
 
 	for i := range tx.TxOut() {
 		psi := tx.GetPkScriptInfo(i)
@@ -314,7 +340,10 @@ func (tp *TxPool) addTransaction(tx *massutil.Tx, height uint64, startingPriorit
 
 	tp.lastUpdated = time.Now()
 
-	tp.NewTxCh <- tx
+	// txs reloaded at startup are added before anyone relays them
+	if tp.NewTxCh != nil {
+		tp.NewTxCh <- tx
+	}
 
 	if config.AddrIndex {
 		err := tp.addTransactionToAddrIndex(tx)
@@ -454,6 +483,14 @@ func (tp *TxPool) CheckPoolOutPointSpend(op *wire.OutPoint) bool {
 	return exist
 }
 
+// FetchOutPointSpender returns the pool transaction spending op, nil if no
+// transaction in the pool spends it.
+func (tp *TxPool) FetchOutPointSpender(op *wire.OutPoint) *massutil.Tx {
+	tp.RLock()
+	defer tp.RUnlock()
+	return tp.outpoints[*op]
+}
+
 // FetchInputTransactions fetches the input transactions referenced by the
 // passed transaction.  First, it fetches from the main chain, then it tries to
 // fetch any missing inputs from the transaction pool.
@@ -531,6 +568,15 @@ func (tp *TxPool) FilterTransactionsByAddress(addr massutil.Address) ([]*massuti
 //
 // This function MUST be called with the mempool lock held (for writes).
 func (tp *TxPool) maybeAcceptTransaction(tx *massutil.Tx, isNew, rateLimit bool) ([]*wire.Hash, error) {
+	return tp.acceptTransaction(tx, isNew, rateLimit, false)
+}
+
+// acceptTransaction implements maybeAcceptTransaction.  The minimum fee and
+// priority of the transaction are not checked if inPackage is set, the caller
+// checks them for the package as a whole instead.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (tp *TxPool) acceptTransaction(tx *massutil.Tx, isNew, rateLimit, inPackage bool) ([]*wire.Hash, error) {
 	txHash := tx.Hash()
 
 	// Don't accept the transaction if it already exists in the pool.  This
@@ -594,6 +640,21 @@ func (tp *TxPool) maybeAcceptTransaction(tx *massutil.Tx, isNew, rateLimit bool)
 
 	delete(txStore, *txHash)
 
+	// The transaction may not use any of the same outputs as other
+	// transactions already in the pool as that would ultimately result in a
+	// double spend, unless all of them signal replaceability, in which case
+	// they are evicted once the transaction is accepted.  This check is
+	// intended to be quick and therefore only detects double spends within
+	// the transaction pool itself.  The transaction could still be double
+	// spending coins from the main chain at this point.  There is a more
+	// in-depth check that happens later after fetching the referenced
+	// transaction inputs from the main chain which examines the actual spend
+	// data and prevents double spends.
+	replaced, err := tp.fetchReplacement(tx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Don't allow non-standard transactions if the network parameters
 	// forbid their relaying.
 	if !tp.chain.chainParams.RelayNonStdTxs {
@@ -604,6 +665,12 @@ func (tp *TxPool) maybeAcceptTransaction(tx *massutil.Tx, isNew, rateLimit bool)
 		err = checkTransactionStandard(bst, tx, nextBlockHeight, massutil.MinRelayTxFee(), txStore, func(script []byte) bool {
 			hash, ok := tp.bindingTargets[string(script)]
 			if ok {
+				if replaced != nil {
+					// binding of a replaced tx is released by the replacement
+					if _, evicted := replaced.evicted[hash]; evicted {
+						return false
+					}
+				}
 				if !tp.haveTransaction(&hash) {
 					delete(tp.bindingTargets, string(script))
 					return false
@@ -616,19 +683,6 @@ func (tp *TxPool) maybeAcceptTransaction(tx *massutil.Tx, isNew, rateLimit bool)
 		}
 	}
 
-	// The transaction may not use any of the same outputs as other
-	// transactions already in the pool as that would ultimately result in a
-	// double spend.  This check is intended to be quick and therefore only
-	// detects double spends within the transaction pool itself.  The
-	// transaction could still be double spending coins from the main chain
-	// at this point.  There is a more in-depth check that happens later
-	// after fetching the referenced transaction inputs from the main chain
-	// which examines the actual spend data and prevents double spends.
-	err = tp.checkPoolDoubleSpend(tx)
-	if err != nil {
-		return nil, err
-	}
-
 	// Transaction is an orphan if any of the referenced input transactions
 	// don't exist.  Adding orphans to the orphan pool is not handled by
 	// this function, and the caller should use maybeAddOrphan if this
@@ -723,7 +777,7 @@ func (tp *TxPool) maybeAcceptTransaction(tx *massutil.Tx, isNew, rateLimit bool)
 		return nil, err
 	}
 
-	if txFee.Cmp(requiredFee) < 0 {
+	if txFee.Cmp(requiredFee) < 0 && !inPackage {
 		if serializedSize >= (defaultBlockPrioritySize - 1000) {
 			logging.CPrint(logging.ERROR, "transaction`s fees is under the required amount",
 				logging.LogFormat{"txHash": txHash, "txFee": txFee, "requiredFee": requiredFee})
@@ -775,6 +829,20 @@ func (tp *TxPool) maybeAcceptTransaction(tx *massutil.Tx, isNew, rateLimit bool)
 		}
 	}
 
+	// Once the pool has been full, txs must pay the rolling minimum fee.
+	if !inPackage {
+		if err := tp.checkRollingMinFee(tx, txFee, serializedSize); err != nil {
+			return nil, err
+		}
+	}
+
+	// A replacement must pay for the txs it evicts from the pool.
+	if replaced != nil {
+		if err := tp.checkReplacement(tx, txFee, serializedSize, replaced); err != nil {
+			return nil, err
+		}
+	}
+
 	// Verify crypto signatures for each input and reject the transaction if
 	// any don't verify.
 	err = ValidateTransactionScripts(tp.chain, tx, txStore,
@@ -789,15 +857,29 @@ func (tp *TxPool) maybeAcceptTransaction(tx *massutil.Tx, isNew, rateLimit bool)
 	if err != nil {
 		return nil, err
 	}
-	err = tp.addTransaction(tx, curHeight, startingPriority, totalInputValue, txFee)
+	evicts, err := tp.makeRoom(tx, txFee, serializedSize, replaced)
 	if err != nil {
 		return nil, err
 	}
-
-	err = tp.chain.notifyTransactionReceived(tx)
+	if replaced != nil {
+		for _, txD := range replaced.conflicts {
+			tp.removeTransaction(txD.Tx, true, TxRemovedReplaced)
+		}
+	}
+	for _, c := range evicts {
+		tp.evict(c)
+	}
+	err = tp.addTransaction(tx, curHeight, startingPriority, totalInputValue, txFee)
 	if err != nil {
 		return nil, err
 	}
+	// Txs re-accepted after a reorg or from the mempool file did not wait in
+	// the pool from the height they are added at.
+	if isNew {
+		tp.feeEstimator.observeTx(tp.pool[*tx.Hash()])
+	}
+
+	tp.chain.eventBus.Publish(EventTxAccepted, &TxAcceptedEvent{Tx: tx})
 
 	return nil, nil
 }
@@ -936,6 +1018,7 @@ func (tp *TxPool) ProcessTransaction(tx *massutil.Tx, allowOrphan, rateLimit boo
 		if err != nil {
 			return isOrphan, err
 		}
+		tp.chain.eventBus.Publish(EventOrphanAdded, &OrphanAddedEvent{Tx: tx})
 	}
 
 	return isOrphan, nil
@@ -1010,8 +1093,9 @@ func (tp *TxPool) SyncAttachBlock(block *massutil.Block) {
 	tp.Lock()
 	defer tp.Unlock()
 
+	tp.feeEstimator.processBlock(block.Height(), block.Transactions()[1:])
 	for _, tx := range block.Transactions()[1:] {
-		tp.removeTransaction(tx, false)
+		tp.removeTransaction(tx, false, TxRemovedMined)
 		tp.removeDoubleSpends(tx)
 		tp.orphanTxPool.removeOrphan(tx.Hash())
 		tp.processOrphans(tx.Hash())
@@ -1027,7 +1111,7 @@ func (tp *TxPool) SyncDetachBlock(block *massutil.Block) {
 			// Remove the transaction and all transactions
 			// that depend on it if it wasn't accepted into
 			// the transaction pool.
-			tp.removeTransaction(tx, true)
+			tp.removeTransaction(tx, true, TxRemovedReorg)
 		}
 	}
 }
@@ -1036,14 +1120,17 @@ func (tp *TxPool) SyncDetachBlock(block *massutil.Block) {
 // transactions until they are mined into a block.
 func NewTxPool(chain *Blockchain, sigCache *txscript.SigCache, hashCache *txscript.HashCache) *TxPool {
 	memPool := &TxPool{
-		sigCache:       sigCache,
-		hashCache:      hashCache,
-		chain:          chain,
-		pool:           make(map[wire.Hash]*TxDesc),
-		orphanTxPool:   newOrphanTxPool(),
-		errCache:       lru.New(500),
-		outpoints:      make(map[wire.OutPoint]*massutil.Tx),
-		bindingTargets: make(map[string]wire.Hash),
+		sigCache:          sigCache,
+		hashCache:         hashCache,
+		chain:             chain,
+		pool:              make(map[wire.Hash]*TxDesc),
+		orphanTxPool:      newOrphanTxPool(),
+		errCache:          lru.New(500),
+		outpoints:         make(map[wire.OutPoint]*massutil.Tx),
+		bindingTargets:    make(map[string]wire.Hash),
+		replacement:       DefaultReplacementPolicy(),
+		rollingMinFeeRate: massutil.ZeroAmount(),
+		feeEstimator:      NewFeeEstimator(),
 	}
 	if config.AddrIndex {
 		memPool.addrindex = make(map[string]map[wire.Hash]struct{})
@@ -0,0 +1,265 @@
+package blockchain
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	// rollingMinFeeHalfLife is the time it takes the rolling minimum fee to
+	// halve once the pool stops evicting transactions.
+	rollingMinFeeHalfLife = 12 * time.Hour
+
+	// txDescMemoryOverhead and txInMemoryOverhead roughly estimate the
+	// memory used by a pool entry besides the transaction itself, the latter
+	// for each input tracked in outpoints.
+	txDescMemoryOverhead = 256
+	txInMemoryOverhead   = 96
+)
+
+// TxPoolLimits houses the size budget of a TxPool, zero means no limit.
+type TxPoolLimits struct {
+	// MaxSize is the total serialized size of the pool transactions.
+	MaxSize uint64
+
+	// MaxMemory is the estimated memory used by the pool transactions.
+	MaxMemory uint64
+}
+
+// txMemoryUsage estimates the memory used by the pool entry of the tx.
+func txMemoryUsage(tx *massutil.Tx) uint64 {
+	msgTx := tx.MsgTx()
+	return uint64(txDescMemoryOverhead + 2*msgTx.PlainSize() + txInMemoryOverhead*len(msgTx.TxIn))
+}
+
+// evictionCandidate is a pool tx scored by the fee rate of itself together
+// with all of its descendants, which are evicted along with it.
+type evictionCandidate struct {
+	txD         *TxDesc
+	descendants map[wire.Hash]*TxDesc // including txD itself
+	feePerKB    float64
+}
+
+// collectDescendants adds the passed tx and all of its descendants in the pool
+// to set.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (tp *TxPool) collectDescendants(txD *TxDesc, set map[wire.Hash]*TxDesc) {
+	txHash := txD.Tx.Hash()
+	if _, ok := set[*txHash]; ok {
+		return
+	}
+	set[*txHash] = txD
+	for i := range txD.Tx.MsgTx().TxOut {
+		if txRedeemer, exists := tp.outpoints[*wire.NewOutPoint(txHash, uint32(i))]; exists {
+			tp.collectDescendants(tp.pool[*txRedeemer.Hash()], set)
+		}
+	}
+}
+
+// collectAncestors adds the in-pool ancestors of the passed tx to set.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (tp *TxPool) collectAncestors(tx *massutil.Tx, set map[wire.Hash]struct{}) {
+	for _, txIn := range tx.MsgTx().TxIn {
+		parent, exists := tp.pool[txIn.PreviousOutPoint.Hash]
+		if !exists {
+			continue
+		}
+		if _, ok := set[*parent.Tx.Hash()]; ok {
+			continue
+		}
+		set[*parent.Tx.Hash()] = struct{}{}
+		tp.collectAncestors(parent.Tx, set)
+	}
+}
+
+// makeRoom selects the pool packages to evict so that the passed tx fits into
+// the pool limits, lowest fee rate packages first.  Only packages paying a lower
+// fee rate than the tx are evicted, and neither its ancestors nor the txs it
+// replaces are.  ErrMempoolFull is returned if there is no room for the tx.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (tp *TxPool) makeRoom(tx *massutil.Tx, txFee massutil.Amount, size int64, replaced *replacement) ([]*evictionCandidate, error) {
+	needSize := tp.totalSize + uint64(size)
+	needMemory := tp.totalMemory + txMemoryUsage(tx)
+	if replaced != nil {
+		for _, txD := range replaced.evicted {
+			needSize -= uint64(txD.Tx.MsgTx().PlainSize())
+			needMemory -= txMemoryUsage(txD.Tx)
+		}
+	}
+	fits := func() bool {
+		return (tp.limits.MaxSize == 0 || needSize <= tp.limits.MaxSize) &&
+			(tp.limits.MaxMemory == 0 || needMemory <= tp.limits.MaxMemory)
+	}
+	if fits() {
+		return nil, nil
+	}
+
+	protected := make(map[wire.Hash]struct{})
+	tp.collectAncestors(tx, protected)
+	if replaced != nil {
+		for hash := range replaced.evicted {
+			protected[hash] = struct{}{}
+		}
+	}
+
+	txFeePerKB := float64(txFee.IntValue()) / (float64(size) / 1000)
+	candidates := make([]*evictionCandidate, 0, len(tp.pool))
+	for hash, txD := range tp.pool {
+		if _, ok := protected[hash]; ok {
+			continue
+		}
+		c := &evictionCandidate{txD: txD, descendants: make(map[wire.Hash]*TxDesc)}
+		tp.collectDescendants(txD, c.descendants)
+		var fee, pkgSize int64
+		for _, d := range c.descendants {
+			fee += d.Fee.IntValue()
+			pkgSize += int64(d.Tx.MsgTx().PlainSize())
+		}
+		c.feePerKB = float64(fee) / (float64(pkgSize) / 1000)
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].feePerKB < candidates[j].feePerKB
+	})
+
+	evicted := make(map[wire.Hash]struct{})
+	if replaced != nil {
+		for hash := range replaced.evicted {
+			evicted[hash] = struct{}{}
+		}
+	}
+	var evicts []*evictionCandidate
+	for _, c := range candidates {
+		if fits() || c.feePerKB >= txFeePerKB {
+			break
+		}
+		if _, ok := evicted[*c.txD.Tx.Hash()]; ok {
+			continue
+		}
+		evicts = append(evicts, c)
+		for hash, d := range c.descendants {
+			if _, ok := evicted[hash]; ok {
+				continue
+			}
+			evicted[hash] = struct{}{}
+			needSize -= uint64(d.Tx.MsgTx().PlainSize())
+			needMemory -= txMemoryUsage(d.Tx)
+		}
+	}
+	if !fits() {
+		logging.CPrint(logging.ERROR, "no room for transaction in the memory pool",
+			logging.LogFormat{"txHash": tx.Hash(), "feePerKB": txFeePerKB, "size": tp.totalSize, "memory": tp.totalMemory})
+		return nil, ErrMempoolFull
+	}
+	return evicts, nil
+}
+
+// rollingMinFee returns the minimum fee rate (Maxwell/kB) required by the pool
+// after having evicted transactions.  It decays over time, and is zero once
+// it has fallen below half of the minimum relay fee.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (tp *TxPool) rollingMinFee() massutil.Amount {
+	if tp.rollingMinFeeRate.IsZero() {
+		return tp.rollingMinFeeRate
+	}
+	now := time.Now()
+	elapsed := now.Sub(tp.rollingMinFeeUpdated)
+	tp.rollingMinFeeUpdated = now
+	decayed, err := tp.rollingMinFeeRate.MulF64(math.Pow(0.5, float64(elapsed)/float64(rollingMinFeeHalfLife)))
+	if err != nil || decayed.UintValue() < massutil.MinRelayTxFee().UintValue()/2 {
+		decayed = massutil.ZeroAmount()
+	}
+	tp.rollingMinFeeRate = decayed
+	return tp.rollingMinFeeRate
+}
+
+// evict removes the package of the candidate from the pool, and raises the
+// rolling minimum fee above its fee rate.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (tp *TxPool) evict(c *evictionCandidate) {
+	logging.CPrint(logging.DEBUG, "evicting transaction from the memory pool",
+		logging.LogFormat{"txHash": c.txD.Tx.Hash(), "package": len(c.descendants), "feePerKB": c.feePerKB})
+	tp.removeTransaction(c.txD.Tx, true, TxRemovedEvicted)
+	tp.bumpRollingMinFee(c.feePerKB)
+}
+
+// bumpRollingMinFee raises the rolling minimum fee above the fee rate of an
+// evicted package.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (tp *TxPool) bumpRollingMinFee(evictedFeePerKB float64) {
+	rate, err := massutil.NewAmountFromInt(int64(math.Ceil(evictedFeePerKB)))
+	if err != nil {
+		return
+	}
+	if rate, err = rate.Add(massutil.MinRelayTxFee()); err != nil {
+		return
+	}
+	if rate.Cmp(tp.rollingMinFee()) > 0 {
+		tp.rollingMinFeeRate = rate
+		tp.rollingMinFeeUpdated = time.Now()
+	}
+}
+
+// checkRollingMinFee checks that the tx pays the rolling minimum fee.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (tp *TxPool) checkRollingMinFee(tx *massutil.Tx, txFee massutil.Amount, size int64) error {
+	rate := tp.rollingMinFee()
+	if rate.IsZero() {
+		return nil
+	}
+	requiredFee, err := CalcMinRequiredTxRelayFee(size, rate)
+	if err != nil {
+		return err
+	}
+	if txFee.Cmp(requiredFee) < 0 {
+		logging.CPrint(logging.ERROR, "transaction`s fees is under the mempool minimum fee",
+			logging.LogFormat{"txHash": tx.Hash(), "txFee": txFee, "requiredFee": requiredFee})
+		return ErrInsufficientFee
+	}
+	return nil
+}
+
+// SetLimits sets the size budget of the pool.  It takes effect on the next
+// transaction accepted to the pool.
+//
+// This function is safe for concurrent access.
+func (tp *TxPool) SetLimits(limits TxPoolLimits) {
+	tp.Lock()
+	defer tp.Unlock()
+
+	tp.limits = limits
+}
+
+// Usage returns the total serialized size and the estimated memory used by the
+// pool transactions.
+//
+// This function is safe for concurrent access.
+func (tp *TxPool) Usage() (size, memory uint64) {
+	tp.RLock()
+	defer tp.RUnlock()
+
+	return tp.totalSize, tp.totalMemory
+}
+
+// MinFee returns the rolling minimum fee rate (Maxwell/kB) a transaction pays
+// to be accepted to the pool, or zero if the pool has not been full lately.
+//
+// This function is safe for concurrent access.
+func (tp *TxPool) MinFee() massutil.Amount {
+	tp.Lock()
+	defer tp.Unlock()
+
+	return tp.rollingMinFee()
+}
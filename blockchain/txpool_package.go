@@ -0,0 +1,148 @@
+package blockchain
+
+import (
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// maxPackageCount is the maximum number of transactions in a package.
+const maxPackageCount = 25
+
+// checkPackageTopology checks that parents come before their children in the
+// package, and that each transaction but the last one is spent by a later
+// one, so that the package is a set of ancestors paid for by its last
+// transaction.
+func checkPackageTopology(txs []*massutil.Tx) error {
+	if len(txs) < 2 || len(txs) > maxPackageCount {
+		return ErrPackageSize
+	}
+
+	index := make(map[wire.Hash]int, len(txs))
+	for i, tx := range txs {
+		if _, exists := index[*tx.Hash()]; exists {
+			return ErrPackageMalformed
+		}
+		index[*tx.Hash()] = i
+	}
+
+	spent := make([]bool, len(txs))
+	for i, tx := range txs {
+		for _, txIn := range tx.MsgTx().TxIn {
+			if j, ok := index[txIn.PreviousOutPoint.Hash]; ok {
+				if j >= i {
+					return ErrPackageMalformed
+				}
+				spent[j] = true
+			}
+		}
+	}
+	for _, s := range spent[:len(txs)-1] {
+		if !s {
+			return ErrPackageMalformed
+		}
+	}
+	return nil
+}
+
+// checkPackageFee checks that the transactions of the package which are not
+// in the pool yet pay the minimum relay fee rate as a whole, or the rolling
+// minimum fee rate if it is higher.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (tp *TxPool) checkPackageFee(txs []*massutil.Tx) error {
+	nextBlockHeight := tp.chain.blockTree.bestBlockNode().Height + 1
+	pkgTxs := make(map[wire.Hash]*massutil.Tx, len(txs))
+	totalFee := massutil.ZeroAmount()
+	var totalSize int64
+	for _, tx := range txs {
+		if tp.isTransactionInPool(tx.Hash()) {
+			continue
+		}
+
+		// Inputs spending earlier package txs are taken from the package.
+		txStore := tp.FetchInputTransactions(tx, false)
+		for _, txD := range txStore {
+			if txD.Err != database.ErrTxShaMissing {
+				continue
+			}
+			if parent, ok := pkgTxs[*txD.Hash]; ok {
+				txD.Tx = parent
+				txD.BlockHeight = mempoolHeight
+				txD.Spent = make([]bool, len(parent.MsgTx().TxOut))
+				txD.Err = nil
+			}
+		}
+		txFee, err := CheckTransactionInputs(tx, nextBlockHeight, txStore)
+		if err != nil {
+			return err
+		}
+		if totalFee, err = totalFee.Add(txFee); err != nil {
+			return err
+		}
+		totalSize += int64(tx.MsgTx().PlainSize())
+		pkgTxs[*tx.Hash()] = tx
+	}
+
+	minFee := massutil.MinRelayTxFee()
+	if rollingMinFee := tp.rollingMinFee(); rollingMinFee.Cmp(minFee) > 0 {
+		minFee = rollingMinFee
+	}
+	requiredFee, err := CalcMinRequiredTxRelayFee(totalSize, minFee)
+	if err != nil {
+		return err
+	}
+	if totalFee.Cmp(requiredFee) < 0 {
+		logging.CPrint(logging.ERROR, "package fees is under the required amount",
+			logging.LogFormat{"totalFee": totalFee, "requiredFee": requiredFee, "size": totalSize})
+		return ErrInsufficientFee
+	}
+	return nil
+}
+
+// ProcessPackage accepts a package of related transactions to the memory pool
+// as a whole.  Parents come before their children in txs, and each of them is
+// spent by a later transaction.  A parent paying less than the minimum relay
+// fee is accepted as long as the package as a whole pays the minimum fee rate,
+// so that a child can pay for its parents.  Either all transactions of the
+// package not yet in the pool are accepted, or none of them.
+//
+// This function is safe for concurrent access.
+func (tp *TxPool) ProcessPackage(txs []*massutil.Tx) error {
+	// Protect concurrent access.
+	tp.Lock()
+	defer tp.Unlock()
+
+	if err := checkPackageTopology(txs); err != nil {
+		return err
+	}
+	if err := tp.checkPackageFee(txs); err != nil {
+		return err
+	}
+
+	accepted := make([]*massutil.Tx, 0, len(txs))
+	for _, tx := range txs {
+		if tp.isTransactionInPool(tx.Hash()) {
+			continue
+		}
+		missingParents, err := tp.acceptTransaction(tx, true, false, true)
+		if err == nil && len(missingParents) > 0 {
+			err = ErrMissingTx
+		}
+		if err != nil {
+			// Don't leave parents behind which rely on the rest of
+			// the package to pay for them.
+			for i := len(accepted) - 1; i >= 0; i-- {
+				tp.removeTransaction(accepted[i], true, TxRemovedPackage)
+			}
+			return err
+		}
+		accepted = append(accepted, tx)
+	}
+
+	for _, tx := range accepted {
+		tp.processOrphans(tx.Hash())
+	}
+	return nil
+}
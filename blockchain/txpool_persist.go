@@ -0,0 +1,213 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	// MempoolFileName is the file name the pool is saved to.
+	MempoolFileName = "mempool.dat"
+
+	// mempoolFileVersion is the version of the mempool file format.
+	mempoolFileVersion uint32 = 1
+)
+
+var mempoolFileMagic = []byte("MASSMPOL")
+
+// sortedPoolTxs returns the pool txs ordered so that parents come before
+// their children.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (tp *TxPool) sortedPoolTxs() []*massutil.Tx {
+	txs := make([]*massutil.Tx, 0, len(tp.pool))
+	visited := make(map[wire.Hash]struct{}, len(tp.pool))
+	var visit func(txD *TxDesc)
+	visit = func(txD *TxDesc) {
+		if _, ok := visited[*txD.Tx.Hash()]; ok {
+			return
+		}
+		visited[*txD.Tx.Hash()] = struct{}{}
+		for _, txIn := range txD.Tx.MsgTx().TxIn {
+			if parent, exists := tp.pool[txIn.PreviousOutPoint.Hash]; exists {
+				visit(parent)
+			}
+		}
+		txs = append(txs, txD.Tx)
+	}
+	for _, txD := range tp.pool {
+		visit(txD)
+	}
+	return txs
+}
+
+// writeMempool writes the txs in the mempool file format to w.
+func writeMempool(w io.Writer, txs []*massutil.Tx) error {
+	var buf [8]byte
+	if _, err := w.Write(mempoolFileMagic); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(buf[:4], mempoolFileVersion)
+	if _, err := w.Write(buf[:4]); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(txs)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	for _, tx := range txs {
+		raw, err := tx.MsgTx().Bytes(wire.DB)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(raw)))
+		if _, err = w.Write(buf[:4]); err != nil {
+			return err
+		}
+		if _, err = w.Write(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMempool reads the txs written by writeMempool from r.
+func readMempool(r io.Reader) ([]*massutil.Tx, error) {
+	var buf [8]byte
+	magic := make([]byte, len(mempoolFileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, mempoolFileMagic) {
+		return nil, ErrMempoolFileMalformed
+	}
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(buf[:4]) != mempoolFileVersion {
+		return nil, ErrMempoolFileMalformed
+	}
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, err
+	}
+	count := binary.LittleEndian.Uint64(buf[:])
+
+	txs := make([]*massutil.Tx, 0)
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(r, buf[:4]); err != nil {
+			return nil, err
+		}
+		txLen := binary.LittleEndian.Uint32(buf[:4])
+		if txLen > wire.MaxBlockPayload {
+			return nil, ErrMempoolFileMalformed
+		}
+		raw := make([]byte, txLen)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, err
+		}
+		msgTx := new(wire.MsgTx)
+		if err := msgTx.SetBytes(raw, wire.DB); err != nil {
+			return nil, err
+		}
+		txs = append(txs, massutil.NewTx(msgTx))
+	}
+	return txs, nil
+}
+
+// saveMempool writes the pool txs to the mempool file.  The file is replaced
+// atomically, so that a crash while saving leaves the previous file intact.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (tp *TxPool) saveMempool() error {
+	tmpPath := tp.mempoolPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	txs := tp.sortedPoolTxs()
+	if err = writeMempool(w, txs); err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, tp.mempoolPath); err != nil {
+		return err
+	}
+	logging.CPrint(logging.INFO, "saved memory pool", logging.LogFormat{"txs": len(txs), "path": tp.mempoolPath})
+	return nil
+}
+
+// loadMempool re-validates the txs of the mempool file and accepts them to
+// the pool.  Txs no longer valid against the current chain are dropped.
+func (tp *TxPool) loadMempool() error {
+	f, err := os.Open(tp.mempoolPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	txs, err := readMempool(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+
+	var accepted int
+	for _, tx := range txs {
+		missingParents, err := tp.MaybeAcceptTransaction(tx, false, false)
+		if err == nil && len(missingParents) == 0 {
+			accepted++
+			continue
+		}
+		logging.CPrint(logging.DEBUG, "dropped transaction of mempool file",
+			logging.LogFormat{"txHash": tx.Hash(), "err": err, "missing": len(missingParents)})
+	}
+	logging.CPrint(logging.INFO, "loaded memory pool",
+		logging.LogFormat{"accepted": accepted, "dropped": len(txs) - accepted, "path": tp.mempoolPath})
+	return nil
+}
+
+// Stop saves the pool txs to the mempool file and the fee estimator to the fee
+// estimates file, for those which are persisted.
+//
+// This function is safe for concurrent access.
+func (tp *TxPool) Stop() error {
+	tp.RLock()
+	defer tp.RUnlock()
+
+	// A failure on one file does not keep the other from being saved.
+	var feeErr, mempoolErr error
+	if tp.feeEstimatesPath != "" {
+		feeErr = tp.saveFeeEstimates()
+	}
+	if tp.mempoolPath != "" {
+		mempoolErr = tp.saveMempool()
+	}
+	switch {
+	case feeErr != nil && mempoolErr != nil:
+		return fmt.Errorf("failed to save fee estimates: %v, failed to save mempool: %v", feeErr, mempoolErr)
+	case feeErr != nil:
+		return feeErr
+	default:
+		return mempoolErr
+	}
+}
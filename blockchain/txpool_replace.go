@@ -0,0 +1,214 @@
+package blockchain
+
+import (
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	// MaxReplaceableSequence is the largest input sequence number signalling
+	// that the transaction may be replaced while it is in the mempool.
+	MaxReplaceableSequence = wire.MaxTxInSequenceNum - 2
+
+	// DefaultMaxReplacementEvictions is the default number of transactions
+	// one replacement may evict, counting the conflicts and their
+	// descendants.
+	DefaultMaxReplacementEvictions = 100
+)
+
+// ReplacementPolicy houses the replace-by-fee knobs of a TxPool.
+type ReplacementPolicy struct {
+	// Enabled allows signalling transactions to be replaced.
+	Enabled bool
+
+	// MaxEvictions is the maximum number of transactions evicted by one
+	// replacement.
+	MaxEvictions int
+
+	// IncrementalRelayFee is the fee rate (Maxwell/kB) a replacement pays
+	// for its own size on top of the fees of all evicted transactions.
+	IncrementalRelayFee massutil.Amount
+}
+
+// DefaultReplacementPolicy returns the replacement policy of a new TxPool.
+func DefaultReplacementPolicy() ReplacementPolicy {
+	return ReplacementPolicy{
+		Enabled:             true,
+		MaxEvictions:        DefaultMaxReplacementEvictions,
+		IncrementalRelayFee: massutil.MinRelayTxFee(),
+	}
+}
+
+// SignalsReplacement returns whether the transaction opts in to be replaced,
+// which is the case when any of its inputs has a sequence number no more
+// than MaxReplaceableSequence.
+func SignalsReplacement(tx *massutil.Tx) bool {
+	for _, txIn := range tx.MsgTx().TxIn {
+		if txIn.Sequence <= MaxReplaceableSequence {
+			return true
+		}
+	}
+	return false
+}
+
+// replacement describes the transactions a new transaction is to replace.
+type replacement struct {
+	conflicts []*TxDesc             // spend the same outputs as the new tx
+	evicted   map[wire.Hash]*TxDesc // conflicts and all their descendants
+}
+
+// fetchReplacement returns the transactions in the pool which conflict with
+// the passed transaction, or nil if there is none.  ErrDoubleSpend is returned
+// if any of them is not replaceable.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (tp *TxPool) fetchReplacement(tx *massutil.Tx) (*replacement, error) {
+	if !tp.replacement.Enabled {
+		return nil, tp.checkPoolDoubleSpend(tx)
+	}
+
+	var conflicts []*TxDesc
+	seen := make(map[wire.Hash]struct{})
+	for _, txIn := range tx.MsgTx().TxIn {
+		txR, exists := tp.outpoints[txIn.PreviousOutPoint]
+		if !exists {
+			continue
+		}
+		if !SignalsReplacement(txR) {
+			logging.CPrint(logging.ERROR, "output already spent by transaction in the memory pool",
+				logging.LogFormat{
+					"output":      txIn.PreviousOutPoint,
+					"transcation": txR.Hash(),
+				})
+			return nil, ErrDoubleSpend
+		}
+		if _, ok := seen[*txR.Hash()]; ok {
+			continue
+		}
+		seen[*txR.Hash()] = struct{}{}
+		conflicts = append(conflicts, tp.pool[*txR.Hash()])
+	}
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+
+	r := &replacement{
+		conflicts: conflicts,
+		evicted:   make(map[wire.Hash]*TxDesc),
+	}
+	for _, txD := range conflicts {
+		if err := tp.collectEvictions(txD, r.evicted); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// collectEvictions adds the passed tx and all of its descendants in the pool
+// to evicted.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (tp *TxPool) collectEvictions(txD *TxDesc, evicted map[wire.Hash]*TxDesc) error {
+	txHash := txD.Tx.Hash()
+	if _, ok := evicted[*txHash]; ok {
+		return nil
+	}
+	if len(evicted) >= tp.replacement.MaxEvictions {
+		logging.CPrint(logging.ERROR, "replacement evicts too many transactions",
+			logging.LogFormat{"max": tp.replacement.MaxEvictions})
+		return ErrReplacementEvictions
+	}
+	evicted[*txHash] = txD
+
+	for i := range txD.Tx.MsgTx().TxOut {
+		outpoint := wire.NewOutPoint(txHash, uint32(i))
+		if txRedeemer, exists := tp.outpoints[*outpoint]; exists {
+			if err := tp.collectEvictions(tp.pool[*txRedeemer.Hash()], evicted); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkReplacement checks that the passed transaction pays enough to replace
+// the transactions described by r.  It must pay a higher fee rate than each
+// evicted transaction, and a higher absolute fee than all of them together,
+// plus the incremental relay fee for its own size.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (tp *TxPool) checkReplacement(tx *massutil.Tx, txFee massutil.Amount, size int64, r *replacement) error {
+	txHash := tx.Hash()
+	for _, txIn := range tx.MsgTx().TxIn {
+		if _, ok := r.evicted[txIn.PreviousOutPoint.Hash]; ok {
+			logging.CPrint(logging.ERROR, "replacement spends an output of an evicted transaction",
+				logging.LogFormat{"txHash": txHash, "output": txIn.PreviousOutPoint})
+			return ErrReplacementSpendsConflict
+		}
+	}
+
+	evictedFees := massutil.ZeroAmount()
+	for hash, txD := range r.evicted {
+		higher, err := feeRateGreater(txFee, size, txD.Fee, int64(txD.Tx.MsgTx().PlainSize()))
+		if err != nil {
+			return err
+		}
+		if !higher {
+			logging.CPrint(logging.ERROR, "replacement fee rate is not higher than an evicted transaction",
+				logging.LogFormat{"txHash": txHash, "evicted": hash})
+			return ErrReplacementFeeRate
+		}
+		if evictedFees, err = evictedFees.Add(txD.Fee); err != nil {
+			return err
+		}
+	}
+
+	incrementalFee, err := CalcMinRequiredTxRelayFee(size, tp.replacement.IncrementalRelayFee)
+	if err != nil {
+		return err
+	}
+	requiredFee, err := evictedFees.Add(incrementalFee)
+	if err != nil {
+		return err
+	}
+	if txFee.Cmp(evictedFees) <= 0 || txFee.Cmp(requiredFee) < 0 {
+		logging.CPrint(logging.ERROR, "replacement fee is insufficient",
+			logging.LogFormat{"txHash": txHash, "txFee": txFee, "requiredFee": requiredFee})
+		return ErrReplacementFee
+	}
+	return nil
+}
+
+// feeRateGreater returns whether fee1/size1 is greater than fee2/size2.
+func feeRateGreater(fee1 massutil.Amount, size1 int64, fee2 massutil.Amount, size2 int64) (bool, error) {
+	l, err := fee1.Value().MulInt(size2)
+	if err != nil {
+		return false, err
+	}
+	r, err := fee2.Value().MulInt(size1)
+	if err != nil {
+		return false, err
+	}
+	return l.Gt(r), nil
+}
+
+// SetReplacementPolicy sets the replace-by-fee policy of the pool.
+//
+// This function is safe for concurrent access.
+func (tp *TxPool) SetReplacementPolicy(policy ReplacementPolicy) {
+	tp.Lock()
+	defer tp.Unlock()
+
+	tp.replacement = policy
+}
+
+// ReplacementPolicy returns the replace-by-fee policy of the pool.
+//
+// This function is safe for concurrent access.
+func (tp *TxPool) ReplacementPolicy() ReplacementPolicy {
+	tp.RLock()
+	defer tp.RUnlock()
+
+	return tp.replacement
+}
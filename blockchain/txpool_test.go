@@ -7,14 +7,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/wangxinyu2018/mass-core/config"
 	"github.com/wangxinyu2018/mass-core/consensus"
+	"github.com/wangxinyu2018/mass-core/consensus/forks"
 	"github.com/wangxinyu2018/mass-core/errors"
 	"github.com/wangxinyu2018/mass-core/massutil"
 	"github.com/wangxinyu2018/mass-core/massutil/safetype"
+	"github.com/wangxinyu2018/mass-core/poc"
 	"github.com/wangxinyu2018/mass-core/txscript"
 	"github.com/wangxinyu2018/mass-core/wire"
 )
@@ -884,3 +889,363 @@ func TestTxPool_MaybeAcceptTransaction_LockTime(t *testing.T) {
 	_, err = txP.maybeAcceptTransaction(tx, true, true)
 	assert.Equal(t, ErrImmatureSpend, err)
 }
+
+func TestTxPool_Replacement(t *testing.T) {
+	utxo := wire.OutPoint{Hash: wire.DoubleHashH([]byte("utxo"))}
+	original := newMockTxDesc(t, "original", MaxReplaceableSequence, 1000, utxo)
+	child := newMockTxDesc(t, "child", wire.MaxTxInSequenceNum, 1000, mockOutPoint(original.Tx))
+	txP := newMockTxPool(original, child)
+	assert.True(t, SignalsReplacement(original.Tx))
+	assert.False(t, SignalsReplacement(child.Tx))
+
+	// conflicts with the child, which does not signal
+	bump := newMockTxDesc(t, "bump", wire.MaxTxInSequenceNum, 100000, mockOutPoint(original.Tx))
+	_, err := txP.fetchReplacement(bump.Tx)
+	assert.Equal(t, ErrDoubleSpend, err)
+
+	bump = newMockTxDesc(t, "bump", wire.MaxTxInSequenceNum, 100000, utxo)
+	r, err := txP.fetchReplacement(bump.Tx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(r.conflicts))
+	assert.Equal(t, 2, len(r.evicted))
+	size := int64(bump.Tx.MsgTx().PlainSize())
+	assert.Nil(t, txP.checkReplacement(bump.Tx, bump.Fee, size, r))
+
+	// absolute fee must exceed the fees of the conflict and its descendants
+	low := newMockTxDesc(t, "low", wire.MaxTxInSequenceNum, 1500, utxo)
+	assert.Equal(t, ErrReplacementFee, txP.checkReplacement(low.Tx, low.Fee, size, r))
+
+	// fee rate must exceed the fee rate of each evicted tx
+	assert.Equal(t, ErrReplacementFeeRate, txP.checkReplacement(low.Tx, low.Fee, size*100, r))
+
+	// must not spend outputs of the txs it replaces
+	spender := newMockTxDesc(t, "spender", wire.MaxTxInSequenceNum, 100000, utxo, mockOutPoint(child.Tx))
+	assert.Equal(t, ErrReplacementSpendsConflict, txP.checkReplacement(spender.Tx, spender.Fee, size, r))
+
+	txP.replacement.MaxEvictions = 1
+	_, err = txP.fetchReplacement(bump.Tx)
+	assert.Equal(t, ErrReplacementEvictions, err)
+
+	txP.replacement.Enabled = false
+	_, err = txP.fetchReplacement(bump.Tx)
+	assert.Equal(t, ErrDoubleSpend, err)
+}
+
+func TestCheckPackageTopology(t *testing.T) {
+	seq := wire.MaxTxInSequenceNum
+	parent1 := newMockTx("parent1", seq)
+	parent2 := newMockTx("parent2", seq)
+	child := newMockTx("child", seq, mockOutPoint(parent1), mockOutPoint(parent2))
+	unrelated := newMockTx("unrelated", seq)
+
+	assert.Nil(t, checkPackageTopology([]*massutil.Tx{parent1, parent2, child}))
+	assert.Nil(t, checkPackageTopology([]*massutil.Tx{parent2, parent1, child}))
+	assert.Equal(t, ErrPackageSize, checkPackageTopology([]*massutil.Tx{child}))
+	assert.Equal(t, ErrPackageMalformed, checkPackageTopology([]*massutil.Tx{child, parent1, parent2}))
+	assert.Equal(t, ErrPackageMalformed, checkPackageTopology([]*massutil.Tx{parent1, parent1, child}))
+	assert.Equal(t, ErrPackageMalformed, checkPackageTopology([]*massutil.Tx{unrelated, parent1, parent2, child}))
+}
+
+func TestTxPool_Limits(t *testing.T) {
+	seq := wire.MaxTxInSequenceNum
+	cheap := newMockTxDesc(t, "cheap", seq, 100)
+	cheapChild := newMockTxDesc(t, "cheapChild", seq, 300, mockOutPoint(cheap.Tx))
+	rich := newMockTxDesc(t, "rich", seq, 100000)
+	richChild := newMockTxDesc(t, "richChild", seq, 1000, mockOutPoint(rich.Tx))
+	txP := newMockTxPool(cheap, cheapChild, rich, richChild)
+	size := int64(cheap.Tx.MsgTx().PlainSize())
+
+	// no limit
+	evicts, err := txP.makeRoom(newMockTx("new", seq), massutil.ZeroAmount(), size, nil)
+	assert.Nil(t, err)
+	assert.Zero(t, len(evicts))
+
+	// room for the new tx is made by evicting the cheap package
+	txP.limits.MaxSize = txP.totalSize
+	newTx := newMockTxDesc(t, "new", seq, 50000)
+	evicts, err = txP.makeRoom(newTx.Tx, newTx.Fee, size, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(evicts))
+	assert.Equal(t, cheap, evicts[0].txD)
+	assert.Equal(t, 2, len(evicts[0].descendants))
+
+	// ancestors of the new tx are never evicted
+	newChild := newMockTxDesc(t, "newChild", seq, 50000, mockOutPoint(cheapChild.Tx))
+	evicts, err = txP.makeRoom(newChild.Tx, newChild.Fee, size, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(evicts))
+	assert.Equal(t, richChild, evicts[0].txD)
+
+	// a tx paying less than every package is rejected
+	poor := newMockTxDesc(t, "poor", seq, 1)
+	_, err = txP.makeRoom(poor.Tx, poor.Fee, size, nil)
+	assert.Equal(t, ErrMempoolFull, err)
+
+	// eviction raises the rolling minimum fee
+	txP.bumpRollingMinFee(evicts[0].feePerKB)
+	assert.True(t, txP.rollingMinFee().Cmp(massutil.MinRelayTxFee()) > 0)
+	assert.Equal(t, ErrInsufficientFee, txP.checkRollingMinFee(poor.Tx, poor.Fee, size))
+	assert.Nil(t, txP.checkRollingMinFee(newTx.Tx, newTx.Fee, size))
+	txP.rollingMinFeeUpdated = txP.rollingMinFeeUpdated.Add(-10 * rollingMinFeeHalfLife)
+	assert.True(t, txP.rollingMinFee().IsZero())
+
+	// mempool file keeps parents before their children
+	var buf bytes.Buffer
+	assert.Nil(t, writeMempool(&buf, txP.sortedPoolTxs()))
+	txs, err := readMempool(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(txs))
+	pos := make(map[wire.Hash]int)
+	for i, tx := range txs {
+		pos[*tx.Hash()] = i
+	}
+	assert.True(t, pos[*cheap.Tx.Hash()] < pos[*cheapChild.Tx.Hash()])
+	assert.True(t, pos[*rich.Tx.Hash()] < pos[*richChild.Tx.Hash()])
+	_, err = readMempool(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.NotNil(t, err)
+}
+
+// newAcceptTxPool returns the pool of a chain connecting the first n mocked
+// blocks, with the wallet paid by them and the last tx of the best block.  The
+// datastore of the chain is created in dir, which is removed by the returned
+// teardown once the binding state is closed.
+func newAcceptTxPool(dir string, n int) (*TxPool, *mockWallet, *wire.MsgTx, func(), error) {
+	db, err := newTestChainDb()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	removeDir, err := mkTmpDir(dir)
+	if err != nil {
+		db.Close()
+		return nil, nil, nil, nil, err
+	}
+	bc, err := newTestBlockchain(db, dir)
+	if err != nil {
+		db.Close()
+		removeDir()
+		return nil, nil, nil, nil, err
+	}
+	teardown := func() {
+		bc.stateBindingDb.TrieDB().DiskDB().Close()
+		db.Close()
+		removeDir()
+	}
+
+	blks, err := loadTopNBlk(n)
+	if err == nil {
+		for _, blk := range blks[1:] {
+			if _, err = bc.processBlock(blk, BFNone); err != nil {
+				break
+			}
+		}
+	}
+	var w *mockWallet
+	if err == nil {
+		w, err = loadMockWallet()
+	}
+	if err != nil {
+		teardown()
+		return nil, nil, nil, nil, err
+	}
+	txs := blks[n-1].MsgBlock().Transactions
+	return bc.GetTxPool(), w, txs[len(txs)-1], teardown, nil
+}
+
+// assertPoolState checks that the pool holds exactly txs, and that its
+// outpoints, address index and total size are those of txs.
+func assertPoolState(t *testing.T, tp *TxPool, txs ...*massutil.Tx) {
+	outpoints := make(map[wire.OutPoint]wire.Hash)
+	addrindex := make(map[string]map[wire.Hash]struct{})
+	index := func(pkScript []byte, tx *massutil.Tx) {
+		_, addrs, _, _, err := txscript.ExtractPkScriptAddrs(pkScript, &config.ChainParams)
+		assert.Nil(t, err)
+		for _, addr := range addrs {
+			if addrindex[addr.EncodeAddress()] == nil {
+				addrindex[addr.EncodeAddress()] = make(map[wire.Hash]struct{})
+			}
+			addrindex[addr.EncodeAddress()][*tx.Hash()] = struct{}{}
+		}
+	}
+	var size, memory uint64
+	for _, tx := range txs {
+		assert.NotNil(t, tp.pool[*tx.Hash()], tx.Hash().String())
+		for _, txIn := range tx.MsgTx().TxIn {
+			outpoints[txIn.PreviousOutPoint] = *tx.Hash()
+		}
+		scripts, err := tp.fetchReferencedOutputScripts(tx)
+		assert.Nil(t, err)
+		for _, pkScript := range scripts {
+			index(pkScript, tx)
+		}
+		for _, txOut := range tx.MsgTx().TxOut {
+			index(txOut.PkScript, tx)
+		}
+		size += uint64(tx.MsgTx().PlainSize())
+		memory += txMemoryUsage(tx)
+	}
+	assert.Equal(t, len(txs), len(tp.pool))
+	poolOutpoints := make(map[wire.OutPoint]wire.Hash)
+	for outpoint, tx := range tp.outpoints {
+		poolOutpoints[outpoint] = *tx.Hash()
+	}
+	assert.Equal(t, outpoints, poolOutpoints)
+	assert.Equal(t, addrindex, tp.addrindex)
+	assert.Equal(t, size, tp.totalSize)
+	assert.Equal(t, memory, tp.totalMemory)
+}
+
+func TestTxPool_AcceptReplacement(t *testing.T) {
+	defer func(addrIndex bool, warmUp uint64) {
+		config.AddrIndex = addrIndex
+		consensus.MASSIP0002WarmUpHeight = warmUp
+	}(config.AddrIndex, consensus.MASSIP0002WarmUpHeight)
+	config.AddrIndex = true
+
+	txP, w, parent, teardown, err := newAcceptTxPool(filepath.Join(dataDir, t.Name()), 25)
+	require.NoError(t, err)
+	defer teardown()
+
+	// binding targets are tracked by the pool once the warm-up has begun
+	nextHeight := txP.chain.BestBlockHeight() + 1
+	consensus.MASSIP0002WarmUpHeight = nextHeight
+	target := append(make([]byte, 20), byte(poc.ProofTypeDefault), 32)
+	bindingScript, err := txscript.PayToBindingScriptHashScript(w.pkScripts[0][2:], target)
+	require.NoError(t, err)
+	required, err := forks.GetRequiredBinding(nextHeight, poc.ProofTypeDefault.PlotSize(32), -1, massutil.ZeroAmount())
+	require.NoError(t, err)
+	binding := []*wire.TxOut{wire.NewTxOut(required.IntValue(), bindingScript)}
+
+	original, err := w.newTx(MaxReplaceableSequence, 100000, binding, parent, 0)
+	require.NoError(t, err)
+	_, err = txP.MaybeAcceptTransaction(original, true, false)
+	require.NoError(t, err)
+	child, err := w.newTx(wire.MaxTxInSequenceNum, 100000, nil, original.MsgTx(), 1)
+	require.NoError(t, err)
+	_, err = txP.MaybeAcceptTransaction(child, true, false)
+	require.NoError(t, err)
+	assertPoolState(t, txP, original, child)
+	assert.Equal(t, map[string]wire.Hash{string(target): *original.Hash()}, txP.bindingTargets)
+
+	// a target is bound once in the pool
+	dup, err := w.newTx(wire.MaxTxInSequenceNum, 100000, binding, parent, 1)
+	require.NoError(t, err)
+	_, err = txP.MaybeAcceptTransaction(dup, true, false)
+	assert.Equal(t, ErrPlotPKAlreadyBound, err)
+
+	// a replacement must pay for the original and its child
+	low, err := w.newTx(wire.MaxTxInSequenceNum, 150000, binding, parent, 0)
+	require.NoError(t, err)
+	_, err = txP.MaybeAcceptTransaction(low, true, false)
+	assert.Equal(t, ErrReplacementFee, err)
+	assertPoolState(t, txP, original, child)
+
+	// the replacement evicts both and binds the target released by the original
+	bump, err := w.newTx(wire.MaxTxInSequenceNum, 1000000, binding, parent, 0)
+	require.NoError(t, err)
+	_, err = txP.MaybeAcceptTransaction(bump, true, false)
+	require.NoError(t, err)
+	assertPoolState(t, txP, bump)
+	assert.Equal(t, map[string]wire.Hash{string(target): *bump.Hash()}, txP.bindingTargets)
+
+	txP.RemoveTransaction(bump, true)
+	assertPoolState(t, txP)
+	assert.Zero(t, len(txP.bindingTargets))
+}
+
+func TestTxPool_AcceptEviction(t *testing.T) {
+	defer func(addrIndex bool) { config.AddrIndex = addrIndex }(config.AddrIndex)
+	config.AddrIndex = true
+
+	txP, w, parent, teardown, err := newAcceptTxPool(filepath.Join(dataDir, t.Name()), 25)
+	require.NoError(t, err)
+	defer teardown()
+	seq := wire.MaxTxInSequenceNum
+
+	// the child pays for its parent
+	cheap, err := w.newTx(seq, 0, nil, parent, 1)
+	require.NoError(t, err)
+	cheapChild, err := w.newTx(seq, 10000, nil, cheap.MsgTx(), 0)
+	require.NoError(t, err)
+	require.NoError(t, txP.ProcessPackage([]*massutil.Tx{cheap, cheapChild}))
+	rich, err := w.newTx(seq, 100000, nil, parent, 2)
+	require.NoError(t, err)
+	_, err = txP.MaybeAcceptTransaction(rich, true, false)
+	require.NoError(t, err)
+	assertPoolState(t, txP, cheap, cheapChild, rich)
+	size, _ := txP.Usage()
+	txP.SetLimits(TxPoolLimits{MaxSize: size})
+
+	// paying more than the minimum relay fee, but less than the cheap package
+	feeAbove := func(rate massutil.Amount, index uint32) *massutil.Tx {
+		tx, err := w.newTx(seq, 0, nil, parent, index)
+		require.NoError(t, err)
+		fee, err := CalcMinRequiredTxRelayFee(int64(tx.MsgTx().PlainSize()), rate)
+		require.NoError(t, err)
+		tx, err = w.newTx(seq, fee.IntValue()+100, nil, parent, index)
+		require.NoError(t, err)
+		return tx
+	}
+	poor := feeAbove(massutil.MinRelayTxFee(), 3)
+	_, err = txP.MaybeAcceptTransaction(poor, true, false)
+	assert.Equal(t, ErrMempoolFull, err)
+	assertPoolState(t, txP, cheap, cheapChild, rich)
+	assert.True(t, txP.MinFee().IsZero())
+
+	// room is made by evicting the cheap package, which raises the minimum fee
+	newTx, err := w.newTx(seq, 50000, nil, parent, 3)
+	require.NoError(t, err)
+	_, err = txP.MaybeAcceptTransaction(newTx, true, false)
+	require.NoError(t, err)
+	assertPoolState(t, txP, rich, newTx)
+	minFee := txP.MinFee()
+	assert.True(t, minFee.Cmp(massutil.MinRelayTxFee()) > 0)
+
+	poor = feeAbove(massutil.MinRelayTxFee(), 4)
+	_, err = txP.MaybeAcceptTransaction(poor, true, false)
+	assert.Equal(t, ErrInsufficientFee, err)
+	assertPoolState(t, txP, rich, newTx)
+}
+
+func TestTxPool_LoadMempool(t *testing.T) {
+	defer func(addrIndex bool) { config.AddrIndex = addrIndex }(config.AddrIndex)
+	config.AddrIndex = true
+
+	txP, w, parent, teardown, err := newAcceptTxPool(filepath.Join(dataDir, t.Name()), 25)
+	require.NoError(t, err)
+	defer teardown()
+	newPackage := func(prev *wire.MsgTx, index uint32) []*massutil.Tx {
+		tx, err := w.newTx(wire.MaxTxInSequenceNum, 100000, nil, prev, index)
+		require.NoError(t, err)
+		child, err := w.newTx(wire.MaxTxInSequenceNum, 100000, nil, tx.MsgTx(), 0)
+		require.NoError(t, err)
+		return []*massutil.Tx{tx, child}
+	}
+
+	// the next block spends the output spent by the stale package
+	stale := newPackage(parent, 4)
+	for _, tx := range stale {
+		_, err = txP.MaybeAcceptTransaction(tx, true, false)
+		require.NoError(t, err)
+	}
+	blk, err := loadNthBlk(26)
+	require.NoError(t, err)
+	_, err = txP.chain.processBlock(blk, BFNone)
+	require.NoError(t, err)
+	assert.Zero(t, len(txP.pool))
+	assert.Zero(t, len(txP.outpoints))
+	assert.Zero(t, txP.totalSize)
+	blkTxs := blk.MsgBlock().Transactions
+	fresh := newPackage(blkTxs[len(blkTxs)-1], 0)
+
+	// a mempool file saved before the block, with txs accepted since
+	path := filepath.Join(dataDir, t.Name(), "mempool.dat")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, writeMempool(f, append(stale, fresh...)))
+	require.NoError(t, f.Close())
+
+	loaded := NewTxPool(txP.chain, txP.sigCache, txP.hashCache)
+	loaded.mempoolPath = path
+	require.NoError(t, loaded.loadMempool())
+	assertPoolState(t, loaded, fresh...)
+}
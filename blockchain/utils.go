@@ -15,6 +15,7 @@ import (
 	"github.com/wangxinyu2018/mass-core/massutil/safetype"
 	"github.com/wangxinyu2018/mass-core/poc/chiapos"
 	"github.com/wangxinyu2018/mass-core/pocec"
+	"github.com/wangxinyu2018/mass-core/trie"
 	"github.com/wangxinyu2018/mass-core/trie/common"
 	"github.com/wangxinyu2018/mass-core/txscript"
 )
@@ -103,6 +104,20 @@ func PutNetworkBinding(bindingState state.Trie, totalBinding massutil.Amount) er
 	return bindingState.TryUpdate(keyNetworkBinding, bytes[:])
 }
 
+// VerifyNewBindingProof checks a proof made by Blockchain.GetNewBindingProof
+// against the binding root of a block header and returns the proven binding
+// amount of script, which is zero if the proof shows script has no binding.
+func VerifyNewBindingProof(bindingRoot common.Hash, script []byte, proof [][]byte) (massutil.Amount, error) {
+	if len(script) != txscript.OP_DATA_22 {
+		return massutil.ZeroAmount(), fmt.Errorf("invalid new binding script length %d", len(script))
+	}
+	data, err := trie.VerifyProof(bindingRoot, script, proof)
+	if err != nil {
+		return massutil.ZeroAmount(), err
+	}
+	return massutil.NewAmountFromInt(state.DecodeBindingInfo(data).Amount)
+}
+
 func makePoolPkKey(poolPk []byte) []byte {
 	key := make([]byte, 0, len(keyPoolPkCoinbasePrefix)+len(poolPk))
 	key = append(key, keyPoolPkCoinbasePrefix...)
@@ -0,0 +1,822 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+
+	"github.com/wangxinyu2018/mass-core/config"
+	"github.com/wangxinyu2018/mass-core/consensus/forks"
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/trie"
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb"
+	"github.com/wangxinyu2018/mass-core/trie/rawdb"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// UtxoSnapshotVersion is the version of utxo snapshot files written by this
+// package.
+const UtxoSnapshotVersion uint32 = 2
+
+const (
+	// maxSnapshotEntrySize limits the size of a single block, transaction,
+	// fault pk or binding leaf read from a snapshot file, so that a corrupted
+	// length can't exhaust memory.
+	maxSnapshotEntrySize = wire.MaxBlockPayload
+
+	// snapshotTailBlocks is the number of main chain blocks ending at the
+	// snapshot block carried by a snapshot.  A chain started from the
+	// snapshot needs them to validate the blocks following it.
+	snapshotTailBlocks = 64
+)
+
+var utxoSnapshotMagic = []byte("MASSUTXO")
+
+// UtxoSnapshot is the chain state of the main chain at a height: the unspent
+// transaction set, staking transactions, fault pks and binding state, along
+// with the blocks ending at the snapshot block.  Entries are kept in a
+// canonical order, so that nodes at the same block produce identical
+// snapshots and content hashes.
+type UtxoSnapshot struct {
+	Version       uint32
+	Height        uint64
+	BlockHash     wire.Hash
+	BindingRoot   common.Hash
+	Blocks        []*massutil.Block // lowest first, the last one is the snapshot block
+	Txs           []*database.UtxoSnapshotTx
+	Stakings      []*database.UtxoSnapshotStakingTx
+	FaultPks      []*database.UtxoSnapshotFaultPk
+	BindingKeys   [][]byte // leaves of the binding trie of BindingRoot
+	BindingValues [][]byte
+
+	// ContentHash is the sha256 of all serialized fields above.
+	ContentHash wire.Hash
+}
+
+// UtxoSnapshotAt builds the utxo snapshot of the main chain block at height.
+// The unspent transaction set and staking transactions of the best block are
+// reverted block by block down to height, so the blocks above it, and those
+// creating the outputs they spend, must not have been pruned.  Neither must
+// the binding trie of the block at height.
+//
+// This function is safe for concurrent access, no block is connected while
+// it runs.
+func (chain *Blockchain) UtxoSnapshotAt(height uint64) (*UtxoSnapshot, error) {
+	chain.l.RLock()
+	defer chain.l.RUnlock()
+	return chain.buildUtxoSnapshot(height)
+}
+
+// buildUtxoSnapshot builds the utxo snapshot of the main chain block at
+// height.
+//
+// This function MUST be called with the chain lock held.
+func (chain *Blockchain) buildUtxoSnapshot(height uint64) (*UtxoSnapshot, error) {
+	best := chain.blockTree.bestBlockNode()
+	if height > best.Height || height < snapshotTailBlocks {
+		return nil, fmt.Errorf("%w: %d not in [%d, %d]", ErrUtxoSnapshotHeight, height, snapshotTailBlocks, best.Height)
+	}
+
+	snap := &UtxoSnapshot{
+		Version:  UtxoSnapshotVersion,
+		Height:   height,
+		Blocks:   make([]*massutil.Block, 0, snapshotTailBlocks),
+		Stakings: make([]*database.UtxoSnapshotStakingTx, 0),
+		FaultPks: make([]*database.UtxoSnapshotFaultPk, 0),
+	}
+	for h := height - snapshotTailBlocks + 1; h <= height; h++ {
+		block, err := chain.GetBlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+		snap.Blocks = append(snap.Blocks, block)
+	}
+	header := &snap.Blocks[len(snap.Blocks)-1].MsgBlock().Header
+	snap.BlockHash = header.BlockHash()
+	snap.BindingRoot = header.BindingRoot
+
+	var err error
+	if snap.Txs, err = chain.snapshotTxs(best.Height, height); err != nil {
+		return nil, err
+	}
+
+	// staking txs expired above height were still unexpired at height
+	nodes, err := chain.db.FetchStakingTxMap()
+	if err != nil {
+		return nil, err
+	}
+	snap.appendStakings(nodes)
+	for expiredHeight := height + 1; expiredHeight <= best.Height; expiredHeight++ {
+		if nodes, err = chain.db.FetchExpiredStakingTxListByHeight(expiredHeight); err != nil {
+			return nil, err
+		}
+		snap.appendStakings(nodes)
+	}
+
+	fpks, heights, err := chain.db.FetchAllFaultPks()
+	if err != nil {
+		return nil, err
+	}
+	for i, fpk := range fpks {
+		if heights[i] <= height {
+			snap.FaultPks = append(snap.FaultPks, &database.UtxoSnapshotFaultPk{Height: heights[i], FaultPk: fpk})
+		}
+	}
+
+	if forks.EnforceMASSIP0002WarmUp(height) {
+		tr, err := chain.openBindingTrie(height, snap.BindingRoot)
+		if err != nil {
+			return nil, err
+		}
+		it := trie.NewIterator(tr.NodeIterator(nil))
+		for it.Next() {
+			snap.BindingKeys = append(snap.BindingKeys, common.CopyBytes(it.Key))
+			snap.BindingValues = append(snap.BindingValues, common.CopyBytes(it.Value))
+		}
+		if it.Err != nil {
+			return nil, it.Err
+		}
+	}
+
+	snap.sort()
+	if snap.ContentHash, err = snap.computeHash(); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// snapshotTxs returns the transactions with unspent outputs at height.  The
+// blocks from the best one down to height+1 are reverted from the current
+// unspent transaction set: their transactions are removed and the outputs
+// they spent are unspent again.
+//
+// This function MUST be called with the chain lock held.
+func (chain *Blockchain) snapshotTxs(bestHeight, height uint64) ([]*database.UtxoSnapshotTx, error) {
+	utxos := make(map[wire.Hash]*database.UtxoSnapshotTx)
+	err := chain.db.ForEachUnspentTx(func(reply *database.TxReply) error {
+		_, txOff, txLen, err := chain.db.GetUnspentTxData(reply.Sha)
+		if err != nil {
+			return err
+		}
+		utxos[*reply.Sha] = &database.UtxoSnapshotTx{
+			Height: reply.Height,
+			BlkSha: *reply.BlkSha,
+			TxLoc:  wire.TxLoc{TxStart: txOff, TxLen: txLen},
+			Spent:  reply.TxSpent,
+			Tx:     reply.Tx,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for h := bestHeight; h > height; h-- {
+		block, err := chain.GetBlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+		txs := block.Transactions()
+		for i := len(txs) - 1; i >= 0; i-- {
+			delete(utxos, *txs[i].Hash())
+			if i == 0 {
+				continue // coinbase
+			}
+			for _, txIn := range txs[i].MsgTx().TxIn {
+				prev := txIn.PreviousOutPoint
+				stx, ok := utxos[prev.Hash]
+				if !ok {
+					if stx, err = chain.fetchSnapshotSpentTx(&prev.Hash, height); err != nil {
+						return nil, err
+					}
+					if stx == nil {
+						continue // created above height as well
+					}
+					utxos[prev.Hash] = stx
+				}
+				if int(prev.Index) >= len(stx.Spent) {
+					return nil, fmt.Errorf("%w: input of tx %s out of range", ErrUtxoSnapshotMalformed, txs[i].Hash())
+				}
+				stx.Spent[prev.Index] = false
+			}
+		}
+	}
+
+	result := make([]*database.UtxoSnapshotTx, 0, len(utxos))
+	for _, stx := range utxos {
+		for _, spent := range stx.Spent {
+			if !spent {
+				result = append(result, stx)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// fetchSnapshotSpentTx returns the fully spent transaction of hash with all
+// outputs marked spent, nil if it was created above height.
+func (chain *Blockchain) fetchSnapshotSpentTx(hash *wire.Hash, height uint64) (*database.UtxoSnapshotTx, error) {
+	reply := chain.db.FetchTxByShaList([]*wire.Hash{hash})[0]
+	if reply.Err != nil {
+		return nil, reply.Err
+	}
+	if reply.Height > height {
+		return nil, nil
+	}
+	block, err := chain.db.FetchBlockBySha(reply.BlkSha)
+	if err != nil {
+		return nil, err
+	}
+	locs, err := block.TxLoc()
+	if err != nil {
+		return nil, err
+	}
+	for i, tx := range block.Transactions() {
+		if tx.Hash().IsEqual(hash) {
+			spent := make([]bool, len(reply.Tx.TxOut))
+			for j := range spent {
+				spent[j] = true
+			}
+			return &database.UtxoSnapshotTx{
+				Height: reply.Height,
+				BlkSha: *reply.BlkSha,
+				TxLoc:  locs[i],
+				Spent:  spent,
+				Tx:     reply.Tx,
+			}, nil
+		}
+	}
+	return nil, database.ErrTxShaMissing
+}
+
+// appendStakings appends the staking txs of nodes created at or below the
+// snapshot height.
+func (snap *UtxoSnapshot) appendStakings(nodes database.StakingNodes) {
+	for scriptHash, atHeight := range nodes {
+		for blkHeight, outPoints := range atHeight {
+			if blkHeight > snap.Height {
+				continue
+			}
+			for op, info := range outPoints {
+				snap.Stakings = append(snap.Stakings, &database.UtxoSnapshotStakingTx{
+					ScriptHash: scriptHash,
+					OutPoint:   op,
+					Info:       info,
+				})
+			}
+		}
+	}
+}
+
+// sort puts snapshot entries into the canonical order, binding leaves are in
+// key order as iterated.
+func (snap *UtxoSnapshot) sort() {
+	hashes := make(map[*database.UtxoSnapshotTx]wire.Hash, len(snap.Txs))
+	for _, stx := range snap.Txs {
+		hashes[stx] = stx.Tx.TxHash()
+	}
+	sort.Slice(snap.Txs, func(i, j int) bool {
+		hi, hj := hashes[snap.Txs[i]], hashes[snap.Txs[j]]
+		return bytes.Compare(hi[:], hj[:]) < 0
+	})
+	sort.Slice(snap.Stakings, func(i, j int) bool {
+		si, sj := snap.Stakings[i], snap.Stakings[j]
+		if c := bytes.Compare(si.ScriptHash[:], sj.ScriptHash[:]); c != 0 {
+			return c < 0
+		}
+		if c := bytes.Compare(si.OutPoint.Hash[:], sj.OutPoint.Hash[:]); c != 0 {
+			return c < 0
+		}
+		return si.OutPoint.Index < sj.OutPoint.Index
+	})
+	sort.Slice(snap.FaultPks, func(i, j int) bool {
+		fi, fj := snap.FaultPks[i], snap.FaultPks[j]
+		if fi.Height != fj.Height {
+			return fi.Height < fj.Height
+		}
+		hi, hj := fi.FaultPk.Hash(0), fj.FaultPk.Hash(0)
+		return bytes.Compare(hi[:], hj[:]) < 0
+	})
+}
+
+// computeHash returns the content hash of the serialized snapshot.
+func (snap *UtxoSnapshot) computeHash() (wire.Hash, error) {
+	hasher := sha256.New()
+	if err := snap.serializeContent(hasher); err != nil {
+		return wire.Hash{}, err
+	}
+	return snapshotHash(hasher), nil
+}
+
+// Serialize writes the snapshot followed by its content hash to w.
+func (snap *UtxoSnapshot) Serialize(w io.Writer) error {
+	if err := snap.serializeContent(w); err != nil {
+		return err
+	}
+	_, err := w.Write(snap.ContentHash[:])
+	return err
+}
+
+func (snap *UtxoSnapshot) serializeContent(w io.Writer) error {
+	var buf [8]byte
+	putUint32 := func(v uint32) error {
+		binary.LittleEndian.PutUint32(buf[:4], v)
+		_, err := w.Write(buf[:4])
+		return err
+	}
+	putUint64 := func(v uint64) error {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		_, err := w.Write(buf[:])
+		return err
+	}
+	putBytes := func(b []byte) error {
+		if err := putUint32(uint32(len(b))); err != nil {
+			return err
+		}
+		_, err := w.Write(b)
+		return err
+	}
+
+	if _, err := w.Write(utxoSnapshotMagic); err != nil {
+		return err
+	}
+	if err := putUint32(snap.Version); err != nil {
+		return err
+	}
+	if err := putUint64(snap.Height); err != nil {
+		return err
+	}
+	if _, err := w.Write(snap.BlockHash[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(snap.BindingRoot[:]); err != nil {
+		return err
+	}
+
+	if err := putUint32(uint32(len(snap.Blocks))); err != nil {
+		return err
+	}
+	for _, block := range snap.Blocks {
+		raw, err := block.Bytes(wire.DB)
+		if err != nil {
+			return err
+		}
+		if err = putBytes(raw); err != nil {
+			return err
+		}
+	}
+
+	if err := putUint64(uint64(len(snap.Txs))); err != nil {
+		return err
+	}
+	for _, stx := range snap.Txs {
+		raw, err := stx.Tx.Bytes(wire.DB)
+		if err != nil {
+			return err
+		}
+		if err = putUint64(stx.Height); err != nil {
+			return err
+		}
+		if _, err = w.Write(stx.BlkSha[:]); err != nil {
+			return err
+		}
+		if err = putUint32(uint32(stx.TxLoc.TxStart)); err != nil {
+			return err
+		}
+		if err = putUint32(uint32(len(stx.Spent))); err != nil {
+			return err
+		}
+		if _, err = w.Write(packSpentBits(stx.Spent)); err != nil {
+			return err
+		}
+		if err = putBytes(raw); err != nil {
+			return err
+		}
+	}
+
+	if err := putUint64(uint64(len(snap.Stakings))); err != nil {
+		return err
+	}
+	for _, stk := range snap.Stakings {
+		if _, err := w.Write(stk.ScriptHash[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(stk.OutPoint.Hash[:]); err != nil {
+			return err
+		}
+		if err := putUint32(stk.OutPoint.Index); err != nil {
+			return err
+		}
+		if err := putUint64(stk.Info.Value); err != nil {
+			return err
+		}
+		if err := putUint64(stk.Info.FrozenPeriod); err != nil {
+			return err
+		}
+		if err := putUint64(stk.Info.BlkHeight); err != nil {
+			return err
+		}
+	}
+
+	if err := putUint64(uint64(len(snap.FaultPks))); err != nil {
+		return err
+	}
+	for _, fpk := range snap.FaultPks {
+		raw, err := fpk.FaultPk.Bytes(wire.DB)
+		if err != nil {
+			return err
+		}
+		if err = putUint64(fpk.Height); err != nil {
+			return err
+		}
+		if err = putBytes(raw); err != nil {
+			return err
+		}
+	}
+
+	if err := putUint64(uint64(len(snap.BindingKeys))); err != nil {
+		return err
+	}
+	for i, key := range snap.BindingKeys {
+		if err := putBytes(key); err != nil {
+			return err
+		}
+		if err := putBytes(snap.BindingValues[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadUtxoSnapshot reads a snapshot written by Serialize, it returns
+// ErrUtxoSnapshotHash if the content doesn't match the trailing hash.
+func ReadUtxoSnapshot(r io.Reader) (*UtxoSnapshot, error) {
+	hasher := sha256.New()
+	tr := io.TeeReader(r, hasher)
+
+	var buf [8]byte
+	readUint32 := func() (uint32, error) {
+		if _, err := io.ReadFull(tr, buf[:4]); err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint32(buf[:4]), nil
+	}
+	readUint64 := func() (uint64, error) {
+		if _, err := io.ReadFull(tr, buf[:]); err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint64(buf[:]), nil
+	}
+	readBytes := func() ([]byte, error) {
+		n, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+		if n > maxSnapshotEntrySize {
+			return nil, ErrUtxoSnapshotMalformed
+		}
+		b := make([]byte, n)
+		if _, err = io.ReadFull(tr, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+
+	magic := make([]byte, len(utxoSnapshotMagic))
+	if _, err := io.ReadFull(tr, magic); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, utxoSnapshotMagic) {
+		return nil, ErrUtxoSnapshotMalformed
+	}
+
+	snap := &UtxoSnapshot{}
+	var err error
+	if snap.Version, err = readUint32(); err != nil {
+		return nil, err
+	}
+	if snap.Version != UtxoSnapshotVersion {
+		return nil, ErrUtxoSnapshotVersion
+	}
+	if snap.Height, err = readUint64(); err != nil {
+		return nil, err
+	}
+	if _, err = io.ReadFull(tr, snap.BlockHash[:]); err != nil {
+		return nil, err
+	}
+	if _, err = io.ReadFull(tr, snap.BindingRoot[:]); err != nil {
+		return nil, err
+	}
+
+	numBlocks, err := readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if numBlocks > snapshotTailBlocks {
+		return nil, ErrUtxoSnapshotMalformed
+	}
+	snap.Blocks = make([]*massutil.Block, 0, numBlocks)
+	for i := uint32(0); i < numBlocks; i++ {
+		raw, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		block, err := massutil.NewBlockFromBytes(raw, wire.DB)
+		if err != nil {
+			return nil, err
+		}
+		snap.Blocks = append(snap.Blocks, block)
+	}
+
+	numTxs, err := readUint64()
+	if err != nil {
+		return nil, err
+	}
+	snap.Txs = make([]*database.UtxoSnapshotTx, 0)
+	for i := uint64(0); i < numTxs; i++ {
+		stx := &database.UtxoSnapshotTx{}
+		if stx.Height, err = readUint64(); err != nil {
+			return nil, err
+		}
+		if _, err = io.ReadFull(tr, stx.BlkSha[:]); err != nil {
+			return nil, err
+		}
+		txStart, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+		numOut, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+		if numOut > maxSnapshotEntrySize {
+			return nil, ErrUtxoSnapshotMalformed
+		}
+		bits := make([]byte, (numOut+7)/8)
+		if _, err = io.ReadFull(tr, bits); err != nil {
+			return nil, err
+		}
+		stx.Spent = unpackSpentBits(bits, int(numOut))
+
+		raw, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		stx.TxLoc = wire.TxLoc{TxStart: int(txStart), TxLen: len(raw)}
+		stx.Tx = new(wire.MsgTx)
+		if err = stx.Tx.SetBytes(raw, wire.DB); err != nil {
+			return nil, err
+		}
+		if len(stx.Tx.TxOut) != len(stx.Spent) {
+			return nil, ErrUtxoSnapshotMalformed
+		}
+		snap.Txs = append(snap.Txs, stx)
+	}
+
+	numStakings, err := readUint64()
+	if err != nil {
+		return nil, err
+	}
+	snap.Stakings = make([]*database.UtxoSnapshotStakingTx, 0)
+	for i := uint64(0); i < numStakings; i++ {
+		stk := &database.UtxoSnapshotStakingTx{}
+		if _, err = io.ReadFull(tr, stk.ScriptHash[:]); err != nil {
+			return nil, err
+		}
+		if _, err = io.ReadFull(tr, stk.OutPoint.Hash[:]); err != nil {
+			return nil, err
+		}
+		if stk.OutPoint.Index, err = readUint32(); err != nil {
+			return nil, err
+		}
+		if stk.Info.Value, err = readUint64(); err != nil {
+			return nil, err
+		}
+		if stk.Info.FrozenPeriod, err = readUint64(); err != nil {
+			return nil, err
+		}
+		if stk.Info.BlkHeight, err = readUint64(); err != nil {
+			return nil, err
+		}
+		snap.Stakings = append(snap.Stakings, stk)
+	}
+
+	numFaultPks, err := readUint64()
+	if err != nil {
+		return nil, err
+	}
+	snap.FaultPks = make([]*database.UtxoSnapshotFaultPk, 0)
+	for i := uint64(0); i < numFaultPks; i++ {
+		fpk := &database.UtxoSnapshotFaultPk{}
+		if fpk.Height, err = readUint64(); err != nil {
+			return nil, err
+		}
+		raw, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		if fpk.FaultPk, err = wire.NewFaultPubKeyFromBytes(raw, wire.DB); err != nil {
+			return nil, err
+		}
+		snap.FaultPks = append(snap.FaultPks, fpk)
+	}
+
+	numLeaves, err := readUint64()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numLeaves; i++ {
+		key, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		snap.BindingKeys = append(snap.BindingKeys, key)
+		snap.BindingValues = append(snap.BindingValues, value)
+	}
+
+	// the trailing hash is not part of the content
+	contentHash := snapshotHash(hasher)
+	if _, err = io.ReadFull(r, snap.ContentHash[:]); err != nil {
+		return nil, err
+	}
+	if contentHash != snap.ContentHash {
+		return nil, ErrUtxoSnapshotHash
+	}
+	return snap, nil
+}
+
+// checkAssumeUtxo returns an error if the snapshot is not on the allow-list
+// of params.
+func checkAssumeUtxo(params *config.Params, snap *UtxoSnapshot) error {
+	for _, allowed := range params.AssumeUtxos {
+		if allowed.Height == snap.Height && allowed.BlockHash.IsEqual(&snap.BlockHash) &&
+			allowed.ContentHash.IsEqual(&snap.ContentHash) {
+			return nil
+		}
+	}
+	return ErrUtxoSnapshotNotAllowed
+}
+
+// checkSnapshotBlocks checks that the blocks of snap are a chain ending at the
+// snapshot block.
+func checkSnapshotBlocks(snap *UtxoSnapshot) error {
+	if len(snap.Blocks) == 0 || uint64(len(snap.Blocks)) > snap.Height {
+		return fmt.Errorf("%w: %d blocks at height %d", ErrUtxoSnapshotMalformed, len(snap.Blocks), snap.Height)
+	}
+	lowest := snap.Height - uint64(len(snap.Blocks)-1)
+	for i, block := range snap.Blocks {
+		header := &block.MsgBlock().Header
+		if header.Height != lowest+uint64(i) {
+			return fmt.Errorf("%w: block %s at height %d, want %d", ErrUtxoSnapshotMalformed, block.Hash(), header.Height, lowest+uint64(i))
+		}
+		if i > 0 && !header.Previous.IsEqual(snap.Blocks[i-1].Hash()) {
+			return fmt.Errorf("%w: block %s not following the previous one", ErrUtxoSnapshotMalformed, block.Hash())
+		}
+	}
+	last := snap.Blocks[len(snap.Blocks)-1]
+	if !last.Hash().IsEqual(&snap.BlockHash) || last.MsgBlock().Header.BindingRoot != snap.BindingRoot {
+		return fmt.Errorf("%w: last block not matching the snapshot block", ErrUtxoSnapshotMalformed)
+	}
+	return nil
+}
+
+// writeSnapshotBindingState rebuilds the binding trie of snap from its leaves
+// into the binding state database, the tries below the snapshot block are
+// recorded as pruned.
+func (chain *Blockchain) writeSnapshotBindingState(snap *UtxoSnapshot) error {
+	diskdb := chain.stateBindingDb.TrieDB().DiskDB()
+	if len(snap.BindingKeys) != len(snap.BindingValues) {
+		return ErrUtxoSnapshotMalformed
+	}
+	if snap.BindingRoot != (common.Hash{}) {
+		batch := diskdb.NewBatch()
+		st := trie.NewStackTrie(batch)
+		for i, key := range snap.BindingKeys {
+			if err := st.TryUpdate(key, snap.BindingValues[i]); err != nil {
+				return err
+			}
+			if batch.ValueSize() >= massdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					return err
+				}
+				batch.Reset()
+			}
+		}
+		root, err := st.Commit()
+		if err != nil {
+			return err
+		}
+		if root != snap.BindingRoot {
+			return fmt.Errorf("%w: binding leaves not matching root %s", ErrUtxoSnapshotMalformed, snap.BindingRoot)
+		}
+		if err = rawdb.WriteFlushedRoot(batch, root); err != nil {
+			return err
+		}
+		if err = batch.Write(); err != nil {
+			return err
+		}
+	} else if len(snap.BindingKeys) != 0 {
+		return fmt.Errorf("%w: binding leaves of the empty binding state", ErrUtxoSnapshotMalformed)
+	}
+	return rawdb.WritePrunedHeight(diskdb, snap.Height)
+}
+
+// initUtxoSnapshot inits the empty chain database by the chain state of snap,
+// which must be on the allow-list of the chain params.  The chain then starts
+// at the snapshot block, the history below is not validated by the chain
+// itself but by its SnapshotValidator.
+func (chain *Blockchain) initUtxoSnapshot(snap *UtxoSnapshot, genesis *massutil.Block) error {
+	if chain.bindingStateArchive {
+		return ErrUtxoSnapshotArchive
+	}
+	if err := checkAssumeUtxo(chain.chainParams, snap); err != nil {
+		return err
+	}
+	if err := checkSnapshotBlocks(snap); err != nil {
+		return err
+	}
+	if err := chain.writeSnapshotBindingState(snap); err != nil {
+		return err
+	}
+
+	base := &database.UtxoSnapshotBase{
+		Height:       snap.Height,
+		BlockHash:    snap.BlockHash,
+		ContentHash:  snap.ContentHash,
+		LowestHeight: snap.Blocks[0].Height(),
+		State:        database.UtxoSnapshotAssumed,
+	}
+	if err := chain.db.InitByUtxoSnapshot(base, genesis, snap.Blocks, snap.Txs, snap.Stakings, snap.FaultPks); err != nil {
+		return err
+	}
+	logging.CPrint(logging.INFO, "chain initialized by utxo snapshot", logging.LogFormat{
+		"height": snap.Height,
+		"block":  snap.BlockHash,
+		"hash":   snap.ContentHash,
+	})
+	return nil
+}
+
+// snapshotCheckpoints returns checkpoints without those below the lowest
+// block stored by a chain started from the utxo snapshot of base, which can't
+// be looked up, and with the snapshot block as a checkpoint, so that the chain
+// never forks below it.
+func snapshotCheckpoints(checkpoints []config.Checkpoint, base *database.UtxoSnapshotBase) []config.Checkpoint {
+	hash := base.BlockHash
+	snapCheckpoint := config.Checkpoint{Height: base.Height, Hash: &hash}
+
+	result := make([]config.Checkpoint, 0, len(checkpoints)+1)
+	added := false
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Height < base.LowestHeight {
+			continue
+		}
+		if !added && checkpoint.Height >= base.Height {
+			if checkpoint.Height > base.Height {
+				result = append(result, snapCheckpoint)
+			}
+			added = true
+		}
+		result = append(result, checkpoint)
+	}
+	if !added {
+		result = append(result, snapCheckpoint)
+	}
+	return result
+}
+
+func snapshotHash(h hash.Hash) wire.Hash {
+	var sum wire.Hash
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+func packSpentBits(spent []bool) []byte {
+	bits := make([]byte, (len(spent)+7)/8)
+	for i, s := range spent {
+		if s {
+			bits[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return bits
+}
+
+func unpackSpentBits(bits []byte, n int) []bool {
+	spent := make([]bool, n)
+	for i := range spent {
+		spent[i] = bits[i/8]&(1<<uint(i%8)) != 0
+	}
+	return spent
+}
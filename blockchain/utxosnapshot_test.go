@@ -0,0 +1,223 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wangxinyu2018/mass-core/config"
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/ldb"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+func newSnapshotTestData(t *testing.T) *UtxoSnapshot {
+	blks, err := loadTopNBlk(4)
+	require.NoError(t, err)
+	last := blks[3].MsgBlock().Header
+	snap := &UtxoSnapshot{
+		Version:     UtxoSnapshotVersion,
+		Height:      last.Height,
+		BlockHash:   last.BlockHash(),
+		BindingRoot: last.BindingRoot,
+		Blocks:      blks[1:],
+	}
+	for i := 0; i < 3; i++ {
+		tx := wire.NewMsgTx()
+		prevHash := wire.DoubleHashH([]byte{byte(i)})
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, uint32(i)), nil))
+		for j := 0; j <= i; j++ {
+			tx.AddTxOut(wire.NewTxOut(int64(1000*(j+1)), []byte{0x00, 0x20, byte(i), byte(j)}))
+		}
+		raw, err := tx.Bytes(wire.DB)
+		require.NoError(t, err)
+		spent := make([]bool, len(tx.TxOut))
+		spent[0] = i%2 == 1
+		snap.Txs = append(snap.Txs, &database.UtxoSnapshotTx{
+			Height: uint64(i + 1),
+			BlkSha: *blks[i+1].Hash(),
+			TxLoc:  wire.TxLoc{TxStart: 100 * (i + 1), TxLen: len(raw)},
+			Spent:  spent,
+			Tx:     tx,
+		})
+	}
+	snap.Stakings = append(snap.Stakings, &database.UtxoSnapshotStakingTx{
+		ScriptHash: wire.DoubleHashH([]byte("script")),
+		OutPoint:   wire.OutPoint{Hash: snap.Txs[1].Tx.TxHash(), Index: 1},
+		Info:       database.StakingTxInfo{Value: 2000, FrozenPeriod: 64, BlkHeight: 2},
+	})
+	fpk := wire.NewEmptyFaultPubKey()
+	fpk.Testimony[0], fpk.Testimony[1] = &blks[1].MsgBlock().Header, &blks[2].MsgBlock().Header
+	fpk.PubKey = fpk.Testimony[0].PublicKey()
+	snap.FaultPks = append(snap.FaultPks, &database.UtxoSnapshotFaultPk{Height: 3, FaultPk: fpk})
+	for i := 0; i < 3; i++ {
+		key := wire.DoubleHashH([]byte{byte(i)})
+		snap.BindingKeys = append(snap.BindingKeys, key[:])
+		snap.BindingValues = append(snap.BindingValues, []byte{byte(i), 0x01})
+	}
+	snap.sort()
+
+	snap.ContentHash, err = snap.computeHash()
+	require.NoError(t, err)
+	return snap
+}
+
+func TestUtxoSnapshotSerialize(t *testing.T) {
+	snap := newSnapshotTestData(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, snap.Serialize(&buf))
+	raw := buf.Bytes()
+
+	restored, err := ReadUtxoSnapshot(bytes.NewReader(raw))
+	require.NoError(t, err)
+	require.Equal(t, snap.Height, restored.Height)
+	require.Equal(t, snap.BlockHash, restored.BlockHash)
+	require.Equal(t, snap.BindingRoot, restored.BindingRoot)
+	require.Equal(t, snap.ContentHash, restored.ContentHash)
+	require.NoError(t, checkSnapshotBlocks(restored))
+	require.Equal(t, len(snap.Blocks), len(restored.Blocks))
+	for i, block := range snap.Blocks {
+		require.Equal(t, block.Hash(), restored.Blocks[i].Hash())
+	}
+	require.Equal(t, len(snap.Txs), len(restored.Txs))
+	for i, stx := range snap.Txs {
+		require.Equal(t, stx.Tx.TxHash(), restored.Txs[i].Tx.TxHash())
+		require.Equal(t, stx.Height, restored.Txs[i].Height)
+		require.Equal(t, stx.BlkSha, restored.Txs[i].BlkSha)
+		require.Equal(t, stx.TxLoc, restored.Txs[i].TxLoc)
+		require.Equal(t, stx.Spent, restored.Txs[i].Spent)
+	}
+	require.Equal(t, snap.Stakings, restored.Stakings)
+	require.Equal(t, len(snap.FaultPks), len(restored.FaultPks))
+	for i, fpk := range snap.FaultPks {
+		require.Equal(t, fpk.Height, restored.FaultPks[i].Height)
+		require.Equal(t, fpk.FaultPk.Hash(0), restored.FaultPks[i].FaultPk.Hash(0))
+	}
+	require.Equal(t, snap.BindingKeys, restored.BindingKeys)
+	require.Equal(t, snap.BindingValues, restored.BindingValues)
+
+	// tampered content
+	tampered := make([]byte, len(raw))
+	copy(tampered, raw)
+	tampered[len(utxoSnapshotMagic)+4] ^= 0x01
+	_, err = ReadUtxoSnapshot(bytes.NewReader(tampered))
+	require.Equal(t, ErrUtxoSnapshotHash, err)
+
+	// truncated file
+	_, err = ReadUtxoSnapshot(bytes.NewReader(raw[:len(raw)-1]))
+	require.Error(t, err)
+
+	// blocks not ending at the snapshot block
+	snap.Blocks = snap.Blocks[:len(snap.Blocks)-1]
+	require.True(t, errors.Is(checkSnapshotBlocks(snap), ErrUtxoSnapshotMalformed))
+}
+
+func TestSnapshotCheckpoints(t *testing.T) {
+	hashes := make([]wire.Hash, 3)
+	for i := range hashes {
+		hashes[i] = wire.DoubleHashH([]byte{byte(i)})
+	}
+	checkpoints := []config.Checkpoint{
+		{Height: 100, Hash: &hashes[0]},
+		{Height: 300, Hash: &hashes[1]},
+		{Height: 500, Hash: &hashes[2]},
+	}
+	heights := func(checkpoints []config.Checkpoint) []uint64 {
+		result := make([]uint64, 0, len(checkpoints))
+		for _, checkpoint := range checkpoints {
+			result = append(result, checkpoint.Height)
+		}
+		return result
+	}
+
+	snapHash := wire.DoubleHashH([]byte("snapshot"))
+	tests := []struct {
+		height, lowest uint64
+		want           []uint64
+	}{
+		{height: 400, lowest: 337, want: []uint64{400, 500}},
+		{height: 400, lowest: 300, want: []uint64{300, 400, 500}},
+		{height: 300, lowest: 237, want: []uint64{300, 500}},
+		{height: 600, lowest: 537, want: []uint64{600}},
+	}
+	for _, test := range tests {
+		base := &database.UtxoSnapshotBase{Height: test.height, BlockHash: snapHash, LowestHeight: test.lowest}
+		result := snapshotCheckpoints(checkpoints, base)
+		require.Equal(t, test.want, heights(result))
+		for _, checkpoint := range result {
+			if checkpoint.Height == test.height && test.height != 300 {
+				require.Equal(t, snapHash, *checkpoint.Hash)
+			}
+		}
+	}
+	require.Equal(t, 3, len(checkpoints))
+}
+
+func TestCheckAssumeUtxo(t *testing.T) {
+	snap := newSnapshotTestData(t)
+	params := config.ChainParams
+	require.Equal(t, ErrUtxoSnapshotNotAllowed, checkAssumeUtxo(&params, snap))
+
+	otherHash := wire.DoubleHashH([]byte("other"))
+	params.AssumeUtxos = []config.AssumeUtxo{
+		{Height: snap.Height, BlockHash: &snap.BlockHash, ContentHash: &otherHash},
+	}
+	require.Equal(t, ErrUtxoSnapshotNotAllowed, checkAssumeUtxo(&params, snap))
+
+	params.AssumeUtxos = append(params.AssumeUtxos, config.AssumeUtxo{
+		Height: snap.Height, BlockHash: &snap.BlockHash, ContentHash: &snap.ContentHash,
+	})
+	require.NoError(t, checkAssumeUtxo(&params, snap))
+}
+
+// newSnapshotTestChain returns a chain on a leveldb store in dir, with the
+// mocked blocks connected up to height.
+func newSnapshotTestChain(t *testing.T, dir string, height int) (*Blockchain, func()) {
+	teardown, err := mkTmpDir(dir)
+	require.NoError(t, err)
+	path := filepath.Join(dir, "blocks.db")
+	stor, err := storage.CreateStorage("leveldb", path, nil)
+	require.NoError(t, err)
+	db, err := ldb.NewChainDb(path, stor)
+	require.NoError(t, err)
+	require.NoError(t, db.InitByGenesisBlock(blks200[0]))
+	copy(config.ChainParams.GenesisHash[:], blks200[0].Hash()[:])
+
+	chain, err := newTestBlockchain(db, dir)
+	require.NoError(t, err)
+	for _, block := range blks200[1 : height+1] {
+		_, err = chain.processBlock(block, BFNone)
+		require.NoError(t, err, block.Height())
+	}
+	require.Equal(t, uint64(height), chain.BestBlockHeight())
+	return chain, func() {
+		db.Close()
+		teardown()
+	}
+}
+
+func TestUtxoSnapshotAtBelowTip(t *testing.T) {
+	const height = 80
+	tip, closeTip := newSnapshotTestChain(t, "./testdata/snapshottip", 120)
+	defer closeTip()
+	at, closeAt := newSnapshotTestChain(t, "./testdata/snapshotat", height)
+	defer closeAt()
+
+	// reverting the blocks above height gives the chain state at height
+	expected, err := at.UtxoSnapshotAt(height)
+	require.NoError(t, err)
+	snap, err := tip.UtxoSnapshotAt(height)
+	require.NoError(t, err)
+	require.Equal(t, expected.BlockHash, snap.BlockHash)
+	require.Equal(t, len(expected.Txs), len(snap.Txs))
+	require.Equal(t, len(expected.Stakings), len(snap.Stakings))
+	require.Equal(t, expected.ContentHash, snap.ContentHash)
+
+	tipSnap, err := tip.UtxoSnapshotAt(tip.BestBlockHeight())
+	require.NoError(t, err)
+	require.NotEqual(t, expected.ContentHash, tipSnap.ContentHash)
+}
@@ -655,6 +655,9 @@ func (chain *Blockchain) checkBlockHeaderContext(header *wire.BlockHeader, prevN
 		return fmt.Errorf("%s: block at height %d forks the main chain before the previous checkpoint at height %d",
 			ErrForkTooOld, blockHeight, checkpointNode.Height)
 	}
+	if err := chain.checkReorgDepth(&blockHash, prevNode); err != nil {
+		return err
+	}
 
 	// Ensure the provided challenge in header is right.
 	// The calculated challenge based on some rules.
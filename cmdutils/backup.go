@@ -0,0 +1,49 @@
+package cmdutils
+
+import (
+	"github.com/wangxinyu2018/mass-core/blockchain"
+	"github.com/wangxinyu2018/mass-core/database/backup"
+	"github.com/wangxinyu2018/mass-core/logging"
+)
+
+// Backup writes a consistent copy of the chain stores of bc into dir, which
+// must be empty or not exist.  bc may be serving a running node, its block
+// commits are paused while the stores are copied.
+func Backup(bc *blockchain.Blockchain, dir string) error {
+	logging.CPrint(logging.INFO, "Backing up chain stores", logging.LogFormat{"dir": dir})
+
+	m, err := bc.Backup(dir)
+	if err != nil {
+		return err
+	}
+
+	logging.CPrint(logging.INFO, "Backed up chain stores", logging.LogFormat{
+		"dir":    dir,
+		"height": m.TipHeight,
+		"hash":   m.TipHash,
+	})
+	return nil
+}
+
+// Restore validates the backup in backupDir against its manifest and copies it
+// into chainstoreDir, which must not be in use by a running node and must not
+// have a chain database, block files or binding state.
+func Restore(backupDir, chainstoreDir string) error {
+	logging.CPrint(logging.INFO, "Restoring chain stores", logging.LogFormat{
+		"backup": backupDir,
+		"dir":    chainstoreDir,
+	})
+
+	m, err := backup.Restore(backupDir, chainstoreDir)
+	if err != nil {
+		return err
+	}
+
+	logging.CPrint(logging.INFO, "Restored chain stores", logging.LogFormat{
+		"dir":     chainstoreDir,
+		"height":  m.TipHeight,
+		"hash":    m.TipHash,
+		"created": m.Created,
+	})
+	return nil
+}
@@ -27,7 +27,53 @@ import (
 )
 
 func MakeChain(chainstoreDir string, readonly bool, chainParams *config.Params) (*blockchain.Blockchain, func(), error) {
+	return makeChain(chainstoreDir, readonly, chainParams, nil)
+}
+
+func makeChain(chainstoreDir string, readonly bool, chainParams *config.Params, assumeUtxo *blockchain.UtxoSnapshot) (*blockchain.Blockchain, func(), error) {
+	cfg, close, err := makeChainConfig(chainstoreDir, readonly, chainParams)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the history up to the snapshot is validated by a chain of its own
+	if assumeUtxo != nil {
+		history, closeHistory, err := makeChainConfig(filepath.Join(chainstoreDir, "history"), readonly, chainParams)
+		if err != nil {
+			close()
+			return nil, nil, err
+		}
+		closeMain := close
+		close = func() {
+			closeHistory()
+			closeMain()
+		}
+		cfg.AssumeUtxo = assumeUtxo
+		cfg.History = history
+	}
+
+	bc, err := blockchain.NewBlockchain(cfg)
+	if err != nil {
+		close()
+		return nil, nil, err
+	}
+	closeChain := func() {
+		if err := bc.FlushBindingState(); err != nil {
+			logging.CPrint(logging.ERROR, "failed to flush binding state", logging.LogFormat{"err": err})
+		}
+		if v := bc.SnapshotValidator(); v != nil {
+			if err := v.FlushBindingState(); err != nil {
+				logging.CPrint(logging.ERROR, "failed to flush history binding state", logging.LogFormat{"err": err})
+			}
+		}
+		close()
+	}
+	return bc, closeChain, nil
+}
 
+// makeChainConfig opens the chain and binding state databases in
+// chainstoreDir, creating them if they don't exist.
+func makeChainConfig(chainstoreDir string, readonly bool, chainParams *config.Params) (*blockchain.Config, func(), error) {
 	chainDb, err := database.OpenDB("leveldb", filepath.Join(chainstoreDir, "blocks.db"), readonly)
 	if err != nil {
 		if !strings.Contains(err.Error(), "file does not exist") || readonly {
@@ -64,19 +110,13 @@ func MakeChain(chainstoreDir string, readonly bool, chainParams *config.Params)
 		chainDb.Close()
 		bindingDb.Close()
 	}
-
-	bc, err := blockchain.NewBlockchain(&blockchain.Config{
+	return &blockchain.Config{
 		DB:             chainDb,
 		ChainParams:    chainParams,
 		StateBindingDb: state.NewDatabase(bindingDb),
 		Checkpoints:    chainParams.Checkpoints,
 		CachePath:      filepath.Join(chainstoreDir, blockchain.BlockCacheFileName),
-	})
-	if err != nil {
-		close()
-		return nil, nil, err
-	}
-	return bc, close, nil
+	}, close, nil
 }
 
 func encodeBlock(writer io.Writer, block *wire.MsgBlock) error {
@@ -0,0 +1,30 @@
+package cmdutils
+
+import (
+	"path/filepath"
+
+	"github.com/wangxinyu2018/mass-core/database/ldb"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+)
+
+// PlanMigrations returns the storage migrations pending on the chain database
+// in chainstoreDir along with their estimated work, without running them.  The
+// migrations are run when the chain database is opened writable.
+func PlanMigrations(chainstoreDir string) ([]*storage.MigrationPlan, error) {
+	path := filepath.Join(chainstoreDir, "blocks.db")
+	dbtype, err := chainDbType(path)
+	if err != nil {
+		return nil, err
+	}
+	stor, err := storage.OpenStorage(dbtype, path, true)
+	if err != nil {
+		return nil, err
+	}
+	db, err := ldb.NewChainDb(path, stor)
+	if err != nil {
+		stor.Close()
+		return nil, err
+	}
+	defer db.Close()
+	return storage.Upgrade(db, stor, path, dbtype, &storage.MigrationOptions{DryRun: true})
+}
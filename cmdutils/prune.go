@@ -0,0 +1,44 @@
+package cmdutils
+
+import (
+	"github.com/wangxinyu2018/mass-core/config"
+	"github.com/wangxinyu2018/mass-core/logging"
+)
+
+// PruneBindingState deletes the binding tries of the chain in chainstoreDir
+// which are not needed by the keepBlocks recent blocks nor by checkpoints, see
+// Blockchain.PruneBindingState.  The chain store must not be in use by a
+// running node, and is not to be pruned for a node running in archive mode.
+func PruneBindingState(chainstoreDir string, chainParams *config.Params, keepBlocks uint64) error {
+	bc, closeChain, err := MakeChain(chainstoreDir, false, chainParams)
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	logging.CPrint(logging.INFO, "Pruning binding state", logging.LogFormat{
+		"dir":    chainstoreDir,
+		"height": bc.BestBlockHeight(),
+		"keep":   keepBlocks,
+	})
+
+	target, stats, err := bc.PruneBindingState(keepBlocks)
+	if err != nil {
+		return err
+	}
+	if target == 0 {
+		logging.CPrint(logging.INFO, "Binding state not pruned, no block is old enough or checkpointed", logging.LogFormat{
+			"height": bc.BestBlockHeight(),
+			"keep":   keepBlocks,
+		})
+		return nil
+	}
+
+	logging.CPrint(logging.INFO, "Pruned binding state", logging.LogFormat{
+		"dir":     chainstoreDir,
+		"target":  target,
+		"deleted": stats.Deleted,
+		"size":    stats.Size,
+	})
+	return nil
+}
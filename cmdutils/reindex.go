@@ -0,0 +1,255 @@
+package cmdutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/wangxinyu2018/mass-core/blockchain"
+	"github.com/wangxinyu2018/mass-core/config"
+	"github.com/wangxinyu2018/mass-core/database/disk"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	// reindexDir holds the block files being replayed by Reindex, the block
+	// files of the rebuilt database are written to "blocks" again.
+	reindexDir = "blocks.reindex"
+
+	// reindexProgressFile under reindexDir records the position replayed up
+	// to, so that an interrupted reindex resumes from there.
+	reindexProgressFile = "progress.json"
+)
+
+var (
+	reindexProgressInterval = 2000
+
+	reindexCheckpointHook func(*reindexProgress) // for testing
+)
+
+type reindexProgress struct {
+	FileNo    uint32 `json:"file"`
+	Offset    int64  `json:"offset"`
+	Blocks    uint64 `json:"blocks"`
+	Orphans   uint64 `json:"orphans"`
+	Skipped   int    `json:"skipped"`
+	MaxHeight uint64 `json:"max_height"`
+}
+
+// Reindex rebuilds the chain database and the binding state in chainstoreDir
+// by replaying the blocks of the block files through Blockchain, which must
+// not be in use by a running node.  The block files are moved aside while
+// being replayed and deleted once the reindex completes.  They are kept, and
+// an error returned, if any block could not be replayed or the rebuilt chain
+// ends below the highest block seen, as they are the only copy of those
+// blocks.  An interrupted reindex resumes when called again.
+func Reindex(chainstoreDir string, chainParams *config.Params, noExpensiveValidation bool) error {
+	// Watch for Ctrl-C while the reindex is running.
+	// If a signal is received, the reindex will stop at the next block.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	return reindex(chainstoreDir, chainParams, noExpensiveValidation, interrupt)
+}
+
+func reindex(chainstoreDir string, chainParams *config.Params, noExpensiveValidation bool, interrupt <-chan os.Signal) error {
+	srcDir := filepath.Join(chainstoreDir, reindexDir)
+	progress, err := prepareReindex(chainstoreDir)
+	if err != nil {
+		return err
+	}
+	fileOffsets, totalSize, err := blockFileOffsets(srcDir)
+	if err != nil {
+		return err
+	}
+
+	bc, closeChain, err := MakeChain(chainstoreDir, false, chainParams)
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	logging.CPrint(logging.INFO, "Reindexing blockchain", logging.LogFormat{
+		"dir":    srcDir,
+		"file":   progress.FileNo,
+		"offset": progress.Offset,
+		"height": bc.BestBlockHeight(),
+	})
+
+	reader := disk.NewBlockFileReader(srcDir, disk.NewFlatFilePos(progress.FileNo, progress.Offset))
+	// skipped counts the blocks not replayed besides those skipped by reader
+	skipped := progress.Skipped
+	prog, unsaved := -1, 0
+	for {
+		select {
+		case <-interrupt:
+			return fmt.Errorf("interrupted")
+		default:
+		}
+
+		rawBlk, next, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		block, err := massutil.NewBlockFromBytes(rawBlk, wire.DB)
+		if err != nil {
+			logging.CPrint(logging.WARN, "skip undecodable block", logging.LogFormat{
+				"file":   next.FileNo(),
+				"offset": next.Pos(),
+				"err":    err,
+			})
+			skipped++
+			continue
+		}
+		if block.Height() > progress.MaxHeight {
+			progress.MaxHeight = block.Height()
+		}
+		block.ImportOptions = &massutil.ImportOptions{NotRunScripts: noExpensiveValidation}
+		isOrphan, err := bc.InsertChain(block)
+		if err != nil {
+			return fmt.Errorf("invalid block %d %s: %v", block.Height(), block.Hash(), err)
+		}
+		if isOrphan {
+			// blocks of a chain reorganized away may miss their parents
+			logging.CPrint(logging.WARN, "orphan block", logging.LogFormat{
+				"height": block.Height(),
+				"hash":   block.Hash(),
+			})
+			progress.Orphans++
+		}
+		progress.Blocks++
+		unsaved++
+
+		// The replay is only resumable after the best block, the blocks
+		// of a side chain before it would be lost with the side chain.
+		if unsaved >= reindexProgressInterval && *bc.BestBlockHash() == *block.Hash() {
+			progress.FileNo, progress.Offset = next.FileNo(), next.Pos()
+			progress.Skipped = skipped + reader.Skipped()
+			if err = writeReindexProgress(srcDir, progress); err != nil {
+				return err
+			}
+			unsaved = 0
+			if reindexCheckpointHook != nil {
+				reindexCheckpointHook(progress)
+			}
+		}
+		replayed := next.Pos()
+		if int(next.FileNo()) < len(fileOffsets) {
+			replayed += fileOffsets[next.FileNo()]
+		}
+		if newProg := int(replayed * 100 / totalSize); newProg > prog {
+			prog = newProg
+			logging.CPrint(logging.INFO, fmt.Sprintf("Reindexing %d%%", prog), logging.LogFormat{
+				"height": bc.BestBlockHeight(),
+				"hash":   bc.BestBlockHash(),
+			})
+		}
+	}
+
+	skipped += reader.Skipped()
+	logging.CPrint(logging.INFO, "Reindexed blockchain", logging.LogFormat{
+		"blocks":  progress.Blocks,
+		"orphans": progress.Orphans,
+		"skipped": skipped,
+		"height":  bc.BestBlockHeight(),
+		"hash":    bc.BestBlockHash(),
+	})
+	if progress.Orphans > 0 || skipped > 0 || bc.BestBlockHeight() < progress.MaxHeight {
+		return fmt.Errorf("reindex incomplete with %d orphan and %d skipped blocks, best height %d of %d seen, block files kept in %s",
+			progress.Orphans, skipped, bc.BestBlockHeight(), progress.MaxHeight, srcDir)
+	}
+	return os.RemoveAll(srcDir)
+}
+
+// prepareReindex moves the block files aside and removes the databases to be
+// rebuilt, unless a reindex is being resumed.
+func prepareReindex(chainstoreDir string) (*reindexProgress, error) {
+	srcDir := filepath.Join(chainstoreDir, reindexDir)
+	if _, err := os.Stat(srcDir); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		blkDir := filepath.Join(chainstoreDir, "blocks")
+		if _, err = os.Stat(filepath.Join(blkDir, "blk00000.dat")); err != nil {
+			return nil, fmt.Errorf("no block files to reindex, first block file is missing or pruned: %v", err)
+		}
+		if err = os.Rename(blkDir, srcDir); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(srcDir, reindexProgressFile))
+	if err == nil {
+		progress := &reindexProgress{}
+		if err = json.Unmarshal(data, progress); err != nil {
+			return nil, fmt.Errorf("invalid reindex progress: %v", err)
+		}
+		return progress, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// start over, nothing replayed is known to be kept
+	for _, name := range []string{"blocks.db", "bindingstate", "blocks", blockchain.BlockCacheFileName} {
+		if err = os.RemoveAll(filepath.Join(chainstoreDir, name)); err != nil {
+			return nil, err
+		}
+	}
+	return &reindexProgress{}, nil
+}
+
+func writeReindexProgress(srcDir string, progress *reindexProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(srcDir, reindexProgressFile)
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// blockFileOffsets returns the offsets of the block files in dir as if they
+// were concatenated, and their total size.
+func blockFileOffsets(dir string) ([]int64, int64, error) {
+	var offsets []int64
+	var total int64
+	for fileNo := uint32(0); ; fileNo++ {
+		info, err := os.Stat(filepath.Join(dir, fmt.Sprintf("blk%05d.dat", fileNo)))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		offsets = append(offsets, total)
+		total += info.Size()
+	}
+	if total == 0 {
+		total = 1
+	}
+	return offsets, total, nil
+}
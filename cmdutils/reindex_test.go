@@ -0,0 +1,175 @@
+package cmdutils
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wangxinyu2018/mass-core/config"
+	"github.com/wangxinyu2018/mass-core/consensus"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// loadMockBlocks loads the first n mocked blocks shared with the blockchain
+// tests, the first one is genesis.
+func loadMockBlocks(t *testing.T, n int) []*massutil.Block {
+	f, err := os.Open("../blockchain/data/mockBlks.dat")
+	require.NoError(t, err)
+	defer f.Close()
+
+	var blks []*massutil.Block
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() && len(blks) < n {
+		buf, err := hex.DecodeString(scanner.Text())
+		require.NoError(t, err)
+		blk, err := massutil.NewBlockFromBytes(buf, wire.Packet)
+		require.NoError(t, err)
+		blks = append(blks, blk)
+	}
+	require.Equal(t, n, len(blks))
+	return blks
+}
+
+// useMockChainParams makes the chain params consistent with the mocked blocks
+// and returns a function restoring them.
+func useMockChainParams(genesis *massutil.Block) func() {
+	genesisBlock, genesisHash := config.ChainParams.GenesisBlock, config.ChainParams.GenesisHash
+	coinbaseMaturity, minStakingValue := consensus.CoinbaseMaturity, consensus.MinStakingValue
+	minFrozenPeriod, stakingTxRewardStart := consensus.MinFrozenPeriod, consensus.StakingTxRewardStart
+
+	config.ChainParams.GenesisBlock, config.ChainParams.GenesisHash = genesis.MsgBlock(), genesis.Hash()
+	consensus.CoinbaseMaturity = 20
+	consensus.MinStakingValue = 100 * consensus.MaxwellPerMass
+	consensus.MinFrozenPeriod = 4
+	consensus.StakingTxRewardStart = 2
+	return func() {
+		config.ChainParams.GenesisBlock, config.ChainParams.GenesisHash = genesisBlock, genesisHash
+		consensus.CoinbaseMaturity, consensus.MinStakingValue = coinbaseMaturity, minStakingValue
+		consensus.MinFrozenPeriod, consensus.StakingTxRewardStart = minFrozenPeriod, stakingTxRewardStart
+	}
+}
+
+type chainIndexes struct {
+	bestHash   wire.Hash
+	bestHeight uint64
+	blocks     []wire.Hash
+	txs        map[wire.Hash]*txIndex
+	utxoHash   wire.Hash
+	binding    massutil.Amount
+}
+
+type txIndex struct {
+	block   wire.Hash
+	height  uint64
+	txSpent []bool
+}
+
+// fetchChainIndexes collects the best block, the block and tx indexes, and the
+// utxo set and binding state of the chain in chainstoreDir.
+func fetchChainIndexes(t *testing.T, chainstoreDir string, blks []*massutil.Block) *chainIndexes {
+	bc, closeChain, err := MakeChain(chainstoreDir, false, &config.ChainParams)
+	require.NoError(t, err)
+	defer closeChain()
+
+	indexes := &chainIndexes{
+		bestHash:   *bc.BestBlockHash(),
+		bestHeight: bc.BestBlockHeight(),
+		txs:        make(map[wire.Hash]*txIndex),
+	}
+	for height := uint64(0); height <= indexes.bestHeight; height++ {
+		hash, err := bc.GetBlockHashByHeight(height)
+		require.NoError(t, err)
+		indexes.blocks = append(indexes.blocks, *hash)
+	}
+	for _, blk := range blks {
+		for _, tx := range blk.Transactions() {
+			replies, err := bc.GetTransactionInDB(tx.Hash())
+			require.NoError(t, err)
+			require.Equal(t, 1, len(replies), tx.Hash())
+			indexes.txs[*tx.Hash()] = &txIndex{
+				block:   *replies[0].BlkSha,
+				height:  replies[0].Height,
+				txSpent: replies[0].TxSpent,
+			}
+		}
+	}
+	snap, err := bc.UtxoSnapshotAt(indexes.bestHeight)
+	require.NoError(t, err)
+	indexes.utxoHash = snap.ContentHash
+	indexes.binding, err = bc.GetNetworkBinding(indexes.bestHeight)
+	require.NoError(t, err)
+	return indexes
+}
+
+func TestReindex(t *testing.T) {
+	defer func(interval int) {
+		reindexProgressInterval = interval
+		reindexCheckpointHook = nil
+	}(reindexProgressInterval)
+	reindexProgressInterval = 10
+
+	blks := loadMockBlocks(t, 80)
+	defer useMockChainParams(blks[0])()
+
+	dir, err := ioutil.TempDir("", "reindex")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bc, closeChain, err := MakeChain(dir, false, &config.ChainParams)
+	require.NoError(t, err)
+	for _, blk := range blks[1:] {
+		_, err = bc.InsertChain(blk)
+		require.NoError(t, err, blk.Height())
+	}
+	closeChain()
+	expected := fetchChainIndexes(t, dir, blks)
+	require.Equal(t, uint64(len(blks)-1), expected.bestHeight)
+
+	// interrupt right after the first progress checkpoint
+	interrupt := make(chan os.Signal, 1)
+	reindexCheckpointHook = func(progress *reindexProgress) {
+		reindexCheckpointHook = nil
+		interrupt <- syscall.SIGINT
+	}
+	require.EqualError(t, reindex(dir, &config.ChainParams, false, interrupt), "interrupted")
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, reindexDir, reindexProgressFile))
+	require.NoError(t, err)
+	progress := &reindexProgress{}
+	require.NoError(t, json.Unmarshal(data, progress))
+	require.Equal(t, uint64(reindexProgressInterval), progress.Blocks)
+	bc, closeChain, err = MakeChain(dir, false, &config.ChainParams)
+	require.NoError(t, err)
+	require.True(t, bc.BestBlockHeight() < expected.bestHeight)
+	require.Equal(t, expected.blocks[bc.BestBlockHeight()], *bc.BestBlockHash())
+	closeChain()
+
+	// the resumed reindex replays the blocks after the checkpoint
+	progress, err = prepareReindex(dir)
+	require.NoError(t, err)
+	require.Equal(t, uint64(reindexProgressInterval), progress.Blocks)
+	var resumed *reindexProgress
+	reindexCheckpointHook = func(progress *reindexProgress) {
+		resumed = progress
+	}
+	require.NoError(t, reindex(dir, &config.ChainParams, false, make(chan os.Signal, 1)))
+	reindexCheckpointHook = nil
+	require.NotNil(t, resumed)
+	require.Equal(t, uint64(len(blks)), resumed.Blocks)
+	_, err = os.Stat(filepath.Join(dir, reindexDir))
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, expected, fetchChainIndexes(t, dir, blks))
+	_, err = os.Stat(filepath.Join(dir, "blocks", "blk00000.dat"))
+	require.NoError(t, err)
+
+	// reindexing the rebuilt chain store starts over
+	require.NoError(t, Reindex(dir, &config.ChainParams, false))
+	require.Equal(t, expected, fetchChainIndexes(t, dir, blks))
+}
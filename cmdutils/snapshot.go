@@ -0,0 +1,93 @@
+package cmdutils
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wangxinyu2018/mass-core/blockchain"
+	"github.com/wangxinyu2018/mass-core/config"
+	"github.com/wangxinyu2018/mass-core/logging"
+)
+
+// ExportUtxoSnapshot writes the utxo snapshot of the main chain block at
+// height into the specified file, truncating any data already present in the
+// file. Height 0 exports the snapshot of the best block.
+func ExportUtxoSnapshot(bc *blockchain.Blockchain, fn string, height uint64) error {
+	if height == 0 {
+		height = bc.BestBlockHeight()
+	}
+
+	logging.CPrint(logging.INFO, "Exporting utxo snapshot", logging.LogFormat{"file": fn, "height": height})
+
+	snap, err := bc.UtxoSnapshotAt(height)
+	if err != nil {
+		return err
+	}
+
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var writer io.Writer = fh
+	if strings.HasSuffix(fn, ".gz") {
+		writer = gzip.NewWriter(writer)
+		defer writer.(*gzip.Writer).Close()
+	}
+	if err = snap.Serialize(writer); err != nil {
+		return err
+	}
+
+	logging.CPrint(logging.INFO, "Exported utxo snapshot", logging.LogFormat{
+		"file":         fn,
+		"height":       snap.Height,
+		"block":        snap.BlockHash,
+		"binding_root": snap.BindingRoot,
+		"blocks":       len(snap.Blocks),
+		"txs":          len(snap.Txs),
+		"stakings":     len(snap.Stakings),
+		"fault_pks":    len(snap.FaultPks),
+		"binding_keys": len(snap.BindingKeys),
+		"content_hash": snap.ContentHash,
+	})
+	return nil
+}
+
+// ReadUtxoSnapshot reads a utxo snapshot file written by ExportUtxoSnapshot.
+func ReadUtxoSnapshot(fn string) (*blockchain.UtxoSnapshot, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var reader io.Reader = fh
+	if strings.HasSuffix(fn, ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			return nil, err
+		}
+	}
+	return blockchain.ReadUtxoSnapshot(reader)
+}
+
+// MakeChainFromSnapshot is like MakeChain, but an empty chain store is
+// initialized by the utxo snapshot in file fn, which must be on the allow-list
+// of chainParams, and the chain starts at the snapshot block. The history up to
+// the snapshot is synced into a separate chain under the "history" directory,
+// see blockchain.SnapshotValidator.
+func MakeChainFromSnapshot(chainstoreDir string, fn string, chainParams *config.Params) (*blockchain.Blockchain, func(), error) {
+	snap, err := ReadUtxoSnapshot(fn)
+	if err != nil {
+		return nil, nil, err
+	}
+	logging.CPrint(logging.INFO, "Loaded utxo snapshot", logging.LogFormat{
+		"file":         fn,
+		"height":       snap.Height,
+		"block":        snap.BlockHash,
+		"content_hash": snap.ContentHash,
+	})
+	return makeChain(chainstoreDir, false, chainParams, snap)
+}
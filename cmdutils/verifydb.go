@@ -0,0 +1,81 @@
+package cmdutils
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/wangxinyu2018/mass-core/blockchain/state"
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/database/verifydb"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/trie/rawdb"
+)
+
+// defaultChainDbType is the type of chain databases without version file,
+// which are written by versions only supporting leveldb.
+const defaultChainDbType = "leveldb"
+
+// chainDbType returns the type of the chain database in path, as recorded in
+// its version file.
+func chainDbType(path string) (string, error) {
+	dbtype, _, err := storage.ReadVersion(filepath.Join(path, storage.VersionFile))
+	if os.IsNotExist(err) {
+		return defaultChainDbType, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return dbtype, nil
+}
+
+// VerifyDB runs the verifydb checks selected by opts against the chain
+// database in chainstoreDir, which must not be in use by a running node.  The
+// chain database is opened with the type recorded in its version file,
+// read-only unless opts asks for repair.  The binding state database is always
+// opened read-only, and its checks are reported failed if it does not exist.
+func VerifyDB(chainstoreDir string, opts *verifydb.Options) ([]*verifydb.Report, error) {
+	readonly := opts == nil || !opts.Repair
+	chainPath := filepath.Join(chainstoreDir, "blocks.db")
+	dbtype, err := chainDbType(chainPath)
+	if err != nil {
+		return nil, err
+	}
+	chainDb, err := database.OpenDB(dbtype, chainPath, readonly)
+	if err != nil {
+		return nil, err
+	}
+	defer chainDb.Close()
+
+	var bindingDb state.Database
+	path := filepath.Join(chainstoreDir, "bindingstate")
+	if _, err = os.Stat(path); err == nil {
+		db, err := rawdb.NewLevelDBDatabase(path, 0, 0, "", true)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		bindingDb = state.NewDatabase(db)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	reports, err := verifydb.Run(chainDb, bindingDb, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, report := range reports {
+		level := logging.INFO
+		if !report.OK() {
+			level = logging.ERROR
+		}
+		logging.CPrint(level, "verifydb report", logging.LogFormat{
+			"check":    report.Check,
+			"checked":  report.Checked,
+			"issues":   report.NumIssues,
+			"repaired": report.Repaired,
+			"err":      report.Err,
+		})
+	}
+	return reports, nil
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"time"
+
 	"github.com/wangxinyu2018/mass-core/consensus"
+	"github.com/wangxinyu2018/mass-core/trie"
 	"github.com/wangxinyu2018/mass-core/wire"
 )
 
@@ -35,6 +38,14 @@ type Config struct {
 type Chain struct {
 	DisableCheckpoints bool     `json:"disable_checkpoints"`
 	AddCheckpoints     []string `json:"add_checkpoints"`
+	PruneDepth         uint64   `json:"prune_depth"`        // keep recent blocks only, 0 to disable
+	CFIndex            bool     `json:"cf_index"`           // build and serve compact block filters
+	SpentIndex         bool     `json:"spent_index"`        // index the spending tx of each spent outpoint
+	MempoolMaxSize     uint64   `json:"mempool_max_size"`   // total size of mempool txs in bytes, 0 for no limit
+	MempoolMaxMemory   uint64   `json:"mempool_max_memory"` // estimated memory of mempool txs in bytes, 0 for no limit
+	PersistMempool     bool     `json:"persist_mempool"`    // save the mempool on stop and reload it on start
+	MaxReorgDepth      uint64   `json:"max_reorg_depth"`    // refuse side chains forking deeper below the best block, 0 for no limit
+	BindingArchive     bool     `json:"binding_archive"`    // keep the binding state of all blocks, refuses pruned binding state
 }
 
 type P2P struct {
@@ -55,8 +66,25 @@ type Log struct {
 }
 
 type Datastore struct {
-	Dir    string `json:"dir"`
-	DBType string `json:"db_type"`
+	Dir                  string `json:"dir"`
+	DBType               string `json:"db_type"`
+	Instrumented         bool   `json:"instrumented"`           // collect operation stats of the storage, see storage.DbType
+	BindingCache         int    `json:"binding_cache"`          // MB of binding trie nodes cached in memory, 0 to disable
+	BindingDirtyCache    int    `json:"binding_dirty_cache"`    // MB of committed binding trie nodes buffered before flushing, 0 to write through
+	BindingFlushInterval uint32 `json:"binding_flush_interval"` // max seconds committed binding trie nodes stay buffered, 0 for no limit
+}
+
+// BindingTrieConfig returns the node caching of the binding state trie
+// database, nil if none is configured.
+func (d *Datastore) BindingTrieConfig() *trie.Config {
+	if d.BindingCache <= 0 && d.BindingDirtyCache <= 0 {
+		return nil
+	}
+	return &trie.Config{
+		Cache:         d.BindingCache,
+		DirtyCache:    d.BindingDirtyCache,
+		FlushInterval: time.Duration(d.BindingFlushInterval) * time.Second,
+	}
 }
 
 type Metrics struct {
@@ -64,6 +64,15 @@ type Checkpoint struct {
 	Hash   *wire.Hash
 }
 
+// AssumeUtxo identifies a utxo snapshot which is trusted to bootstrap a node.
+// ContentHash is the hash of the snapshot file content, which is printed when
+// the snapshot is exported.
+type AssumeUtxo struct {
+	Height      uint64
+	BlockHash   *wire.Hash
+	ContentHash *wire.Hash
+}
+
 // Params defines a Mass network by its parameters.  These parameters may be
 // used by Mass applications to differentiate networks as well as addresses
 // and keys for one network from those intended for use on another network.
@@ -83,6 +92,9 @@ type Params struct {
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints []Checkpoint
 
+	// Utxo snapshots allowed to bootstrap a node.
+	AssumeUtxos []AssumeUtxo
+
 	// Mempool parameters
 	RelayNonStdTxs bool
 
@@ -132,6 +144,9 @@ var ChainParams = Params{
 		{1390000, newHashFromStr("bd02ce24fa5dbf6354a19def8e1de746a832bf904e4f9421d7355d166e8acf79")},
 	},
 
+	// Utxo snapshots allowed to bootstrap a node.
+	AssumeUtxos: []AssumeUtxo{},
+
 	// Mempool parameters
 	RelayNonStdTxs: false,
 
@@ -11,10 +11,21 @@ const (
 	SFFastSync
 	// SFSPV indicate peer support spv mode
 	SFSPV
+	// SFPrunedNode indicate peer only keeps recent blocks on disk
+	SFPrunedNode
+	// SFCompactFilters indicate peer serves compact block filters
+	SFCompactFilters
 	// DefaultServices is the server that this node support
 	DefaultServices = SFFullNode | SFFastSync
+	// PrunedServices is the server that a pruned node support, it is
+	// unable to serve header first sync from old heights
+	PrunedServices = SFFullNode | SFPrunedNode
 )
 
+// PrunedNodeMinBlocks is the least number of recent blocks kept by a pruned
+// node, which covers the longest staking frozen period.
+var PrunedNodeMinBlocks = MASSIP0001MaxValidPeriod
+
 // IsEnable check does the flag support the input flag function
 func (f ServiceFlag) IsEnable(checkFlag ServiceFlag) bool {
 	return f&checkFlag == checkFlag
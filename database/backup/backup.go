@@ -0,0 +1,333 @@
+// Package backup writes and restores consistent copies of the chain store of a
+// node, which are the chain database, the block files and the binding state.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/trie/massdb"
+	"github.com/wangxinyu2018/mass-core/trie/rawdb"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	// ManifestVersion is the version of manifests written by this package.
+	ManifestVersion = 1
+
+	// ManifestFile is the name of the manifest in a backup directory, it is
+	// written last so that an incomplete backup has none.
+	ManifestFile = "manifest.json"
+
+	// Stores of the chain store directory, which are laid out the same in a
+	// backup directory.
+	ChainDbDir      = "blocks.db"
+	BlockFileDir    = "blocks"
+	BindingStateDir = "bindingstate"
+
+	// restoreDir under the chain store directory holds the files being
+	// restored, which are moved in place once all are copied.
+	restoreDir = "restore.tmp"
+
+	copyBatchSize = 4 * storage.MiB
+)
+
+var (
+	ErrBackupExists          = errors.New("backup directory is not empty")
+	ErrManifestMissing       = errors.New("backup manifest is missing, the backup may be incomplete")
+	ErrManifestVersion       = errors.New("unsupported backup manifest version")
+	ErrBackupFileMissing     = errors.New("file listed in backup manifest is missing")
+	ErrBackupFileUnlisted    = errors.New("file is not listed in backup manifest")
+	ErrBackupFileMismatch    = errors.New("backup file does not match manifest")
+	ErrBackupStoreMissing    = errors.New("backup misses a store")
+	ErrRestoreTargetNotEmpty = errors.New("chain store to restore into already has data")
+)
+
+// File is a file of a backup.
+type File struct {
+	Path   string `json:"path"` // relative to the backup directory, slash separated
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes a backup, it is saved as ManifestFile in the backup
+// directory.
+type Manifest struct {
+	Version        int     `json:"version"`
+	Created        int64   `json:"created"` // unix time
+	TipHash        string  `json:"tip_hash"`
+	TipHeight      uint64  `json:"tip_height"`
+	StorageVersion int32   `json:"storage_version"`
+	Files          []*File `json:"files"`
+}
+
+// PrepareDir creates dir for a backup, an existing dir must be empty.
+func PrepareDir(dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return os.MkdirAll(dir, 0700)
+		}
+		return err
+	}
+	if len(infos) > 0 {
+		return fmt.Errorf("%w: %s", ErrBackupExists, dir)
+	}
+	return nil
+}
+
+// NewManifest checksums the files copied into dir for the backup of tip.
+func NewManifest(dir string, tipHash *wire.Hash, tipHeight uint64) (*Manifest, error) {
+	_, version, err := storage.ReadVersion(filepath.Join(dir, ChainDbDir, storage.VersionFile))
+	if err != nil {
+		return nil, err
+	}
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		Version:        ManifestVersion,
+		Created:        time.Now().Unix(),
+		TipHash:        tipHash.String(),
+		TipHeight:      tipHeight,
+		StorageVersion: version,
+		Files:          make([]*File, 0, len(files)),
+	}
+	for _, path := range files {
+		size, sum, err := checksumFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, &File{Path: path, Size: size, SHA256: sum})
+	}
+	return m, nil
+}
+
+// WriteManifest saves m in dir.
+func WriteManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, ManifestFile)
+	if err = ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// ReadManifest loads the manifest of the backup in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrManifestMissing
+		}
+		return nil, err
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %v", err)
+	}
+	return m, nil
+}
+
+// Validate checks that the backup in dir is exactly the files of m, and that
+// its storage can be opened by this version.
+func (m *Manifest) Validate(dir string) error {
+	if m.Version != ManifestVersion {
+		return fmt.Errorf("%w: %d", ErrManifestVersion, m.Version)
+	}
+	if m.StorageVersion > storage.CurrentStorageVersion {
+		return fmt.Errorf("%w: version %d, supported %d", storage.ErrStorageDowngrade, m.StorageVersion, storage.CurrentStorageVersion)
+	}
+	if _, err := wire.NewHashFromStr(m.TipHash); err != nil {
+		return fmt.Errorf("invalid tip hash in backup manifest: %v", err)
+	}
+
+	listed := make(map[string]*File, len(m.Files))
+	for _, f := range m.Files {
+		listed[f.Path] = f
+	}
+	// leveldb keeps the name of its manifest in CURRENT
+	for _, path := range []string{ChainDbDir + "/" + storage.VersionFile, ChainDbDir + "/CURRENT", BindingStateDir + "/CURRENT"} {
+		if _, ok := listed[path]; !ok {
+			return fmt.Errorf("%w: %s", ErrBackupStoreMissing, path)
+		}
+	}
+
+	files, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if _, ok := listed[path]; !ok {
+			return fmt.Errorf("%w: %s", ErrBackupFileUnlisted, path)
+		}
+	}
+	for _, f := range m.Files {
+		size, sum, err := checksumFile(filepath.Join(dir, filepath.FromSlash(f.Path)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("%w: %s", ErrBackupFileMissing, f.Path)
+			}
+			return err
+		}
+		if size != f.Size || sum != f.SHA256 {
+			return fmt.Errorf("%w: %s", ErrBackupFileMismatch, f.Path)
+		}
+	}
+	return nil
+}
+
+// CopyKeyValueStore writes all records of src to a new leveldb in dst.
+func CopyKeyValueStore(dst string, src massdb.Iteratee) error {
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return err
+	}
+	db, err := rawdb.NewLevelDBDatabase(dst, 0, 0, "", false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	batch := db.NewBatch()
+	iter := src.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err = batch.Put(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+		if batch.ValueSize() >= copyBatchSize {
+			if err = batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err = iter.Error(); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// Restore validates the backup in backupDir and copies it into chainstoreDir,
+// which must not have any of the stores.  It returns the manifest of the
+// restored backup.
+func Restore(backupDir, chainstoreDir string) (*Manifest, error) {
+	m, err := ReadManifest(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Validate(backupDir); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{ChainDbDir, BlockFileDir, BindingStateDir} {
+		if _, err = os.Stat(filepath.Join(chainstoreDir, name)); !os.IsNotExist(err) {
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %s", ErrRestoreTargetNotEmpty, name)
+		}
+	}
+
+	// copy aside first, so that an interrupted restore leaves no partial store
+	tmpDir := filepath.Join(chainstoreDir, restoreDir)
+	if err = os.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		dst := filepath.Join(tmpDir, filepath.FromSlash(f.Path))
+		if err = os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return nil, err
+		}
+		if err = copyFile(dst, filepath.Join(backupDir, filepath.FromSlash(f.Path))); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range []string{ChainDbDir, BlockFileDir, BindingStateDir} {
+		src := filepath.Join(tmpDir, name)
+		if _, err = os.Stat(src); os.IsNotExist(err) {
+			// a pruned node may have no block files
+			continue
+		}
+		if err = os.Rename(src, filepath.Join(chainstoreDir, name)); err != nil {
+			return nil, err
+		}
+	}
+	return m, os.RemoveAll(tmpDir)
+}
+
+// listFiles returns the slash separated paths of files under dir, relative to
+// dir and sorted, except the manifest.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != ManifestFile {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func checksumFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/trie/rawdb"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// makeBackup lays out a backup of a chain database with a block file and a
+// binding state holding records into dir.
+func makeBackup(t *testing.T, dir string, records map[string]string) *Manifest {
+	assert.Nil(t, PrepareDir(dir))
+
+	dbDir := filepath.Join(dir, ChainDbDir)
+	assert.Nil(t, os.MkdirAll(dbDir, 0700))
+	assert.Nil(t, storage.WriteVersion(filepath.Join(dbDir, storage.VersionFile), "leveldb", storage.CurrentStorageVersion))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dbDir, "CURRENT"), []byte("MANIFEST-000001\n"), 0644))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, BlockFileDir), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, BlockFileDir, "blk00000.dat"), []byte("blocks"), 0644))
+
+	src := rawdb.NewMemoryDatabase()
+	for k, v := range records {
+		assert.Nil(t, src.Put([]byte(k), []byte(v)))
+	}
+	assert.Nil(t, CopyKeyValueStore(filepath.Join(dir, BindingStateDir), src))
+
+	tip := wire.DoubleHashH([]byte("tip"))
+	m, err := NewManifest(dir, &tip, 100)
+	assert.Nil(t, err)
+	assert.Nil(t, WriteManifest(dir, m))
+	return m
+}
+
+func TestBackupRestore(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "backup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+
+	backupDir := filepath.Join(tmp, "backup")
+	records := map[string]string{"k1": "v1", "k2": "v2"}
+	m := makeBackup(t, backupDir, records)
+	assert.Equal(t, uint64(100), m.TipHeight)
+	assert.Equal(t, storage.CurrentStorageVersion, m.StorageVersion)
+
+	err = PrepareDir(backupDir)
+	assert.True(t, errors.Is(err, ErrBackupExists))
+
+	read, err := ReadManifest(backupDir)
+	assert.Nil(t, err)
+	assert.Equal(t, m, read)
+	assert.Nil(t, read.Validate(backupDir))
+
+	chainstoreDir := filepath.Join(tmp, "chain")
+	restored, err := Restore(backupDir, chainstoreDir)
+	assert.Nil(t, err)
+	assert.Equal(t, m.TipHash, restored.TipHash)
+	_, err = os.Stat(filepath.Join(chainstoreDir, restoreDir))
+	assert.True(t, os.IsNotExist(err))
+	data, err := ioutil.ReadFile(filepath.Join(chainstoreDir, BlockFileDir, "blk00000.dat"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("blocks"), data)
+
+	db, err := rawdb.NewLevelDBDatabase(filepath.Join(chainstoreDir, BindingStateDir), 0, 0, "", true)
+	assert.Nil(t, err)
+	for k, v := range records {
+		value, err := db.Get([]byte(k))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(v), value)
+	}
+	db.Close()
+
+	// the chain store now has data
+	_, err = Restore(backupDir, chainstoreDir)
+	assert.True(t, errors.Is(err, ErrRestoreTargetNotEmpty))
+}
+
+func TestManifestValidate(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "backup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+
+	tests := []struct {
+		name   string
+		modify func(dir string, m *Manifest)
+		err    error
+	}{
+		{
+			name: "tampered file",
+			modify: func(dir string, m *Manifest) {
+				ioutil.WriteFile(filepath.Join(dir, BlockFileDir, "blk00000.dat"), []byte("BLOCKS"), 0644)
+			},
+			err: ErrBackupFileMismatch,
+		},
+		{
+			name: "missing file",
+			modify: func(dir string, m *Manifest) {
+				os.Remove(filepath.Join(dir, BlockFileDir, "blk00000.dat"))
+			},
+			err: ErrBackupFileMissing,
+		},
+		{
+			name: "unlisted file",
+			modify: func(dir string, m *Manifest) {
+				ioutil.WriteFile(filepath.Join(dir, BlockFileDir, "blk00001.dat"), []byte("more"), 0644)
+			},
+			err: ErrBackupFileUnlisted,
+		},
+		{
+			name: "missing store",
+			modify: func(dir string, m *Manifest) {
+				os.RemoveAll(filepath.Join(dir, BindingStateDir))
+				files := m.Files[:0]
+				for _, f := range m.Files {
+					if filepath.Dir(filepath.FromSlash(f.Path)) != BindingStateDir {
+						files = append(files, f)
+					}
+				}
+				m.Files = files
+			},
+			err: ErrBackupStoreMissing,
+		},
+		{
+			name: "newer storage",
+			modify: func(dir string, m *Manifest) {
+				m.StorageVersion = storage.CurrentStorageVersion + 1
+			},
+			err: storage.ErrStorageDowngrade,
+		},
+		{
+			name: "newer manifest",
+			modify: func(dir string, m *Manifest) {
+				m.Version = ManifestVersion + 1
+			},
+			err: ErrManifestVersion,
+		},
+	}
+	for i, test := range tests {
+		dir := filepath.Join(tmp, test.name)
+		m := makeBackup(t, dir, map[string]string{"k": "v"})
+		test.modify(dir, m)
+		err := m.Validate(dir)
+		assert.True(t, errors.Is(err, test.err), "#%d %s: %v", i, test.name, err)
+	}
+
+	// no manifest
+	dir := filepath.Join(tmp, "incomplete")
+	assert.Nil(t, PrepareDir(dir))
+	_, err = ReadManifest(dir)
+	assert.Equal(t, ErrManifestMissing, err)
+}
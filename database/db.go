@@ -26,6 +26,11 @@ var (
 	ErrInvalidBlockStorageMeta  = errors.New("invalid block storage meta")
 	ErrInvalidAddrIndexMeta     = errors.New("invalid addr index meta")
 	ErrDeleteNonNewestBlock     = errors.New("delete block that is not newest")
+	ErrBlockPruned              = errors.New("requested block data has been pruned")
+	ErrCFilterIndexDoesNotExist = errors.New("compact filter index hasn't been built")
+	ErrCFilterMissing           = errors.New("requested compact filter does not exist")
+	ErrSpentIndexDoesNotExist   = errors.New("spent index hasn't been built")
+	ErrSpendingTxMissing        = errors.New("requested outpoint is not spent in main chain")
 )
 
 // Db defines a generic interface that is used to request and insert data into
@@ -48,9 +53,31 @@ type Db interface {
 	// Commit commits batches in a single transaction
 	Commit(hash wire.Hash) error
 
+	// Backup copies the database and its block files into dir while commits
+	// are paused, fn is called before commits resume to copy other stores
+	// consistently with the database. It returns the hash and height of the
+	// copied tip.
+	Backup(dir string, fn func() error) (sha *wire.Hash, height uint64, err error)
+
 	// InitByGenesisBlock init database by setting genesis block
 	InitByGenesisBlock(block *massutil.Block) (err error)
 
+	// InitByUtxoSnapshot inits an empty database by the chain state of a
+	// utxo snapshot instead of replaying blocks from genesis.  The genesis
+	// block and the main chain blocks ending at the snapshot block are stored
+	// without being applied, the unspent transactions, staking transactions
+	// and fault pks are written as they are at the snapshot height.
+	InitByUtxoSnapshot(base *UtxoSnapshotBase, genesis *massutil.Block, blocks []*massutil.Block,
+		txs []*UtxoSnapshotTx, stakings []*UtxoSnapshotStakingTx, faultPks []*UtxoSnapshotFaultPk) error
+
+	// FetchUtxoSnapshotBase returns the utxo snapshot the database has been
+	// initialized by, nil if it has been initialized by the genesis block.
+	FetchUtxoSnapshotBase() (*UtxoSnapshotBase, error)
+
+	// SetUtxoSnapshotState records the result of validating the history up
+	// to the utxo snapshot the database has been initialized by.
+	SetUtxoSnapshotState(state UtxoSnapshotState) error
+
 	// SubmitBlock inserts raw block and transaction data from a block
 	// into the database.  The first block inserted into the database
 	// will be treated as the genesis block.  Every subsequent block insert
@@ -68,7 +95,8 @@ type Db interface {
 	ExistsSha(sha *wire.Hash) (exists bool, err error)
 
 	// FetchBlockBySha returns a massutil Block.  The implementation may
-	// cache the underlying data if desired.
+	// cache the underlying data if desired.  It returns ErrBlockPruned if
+	// the block file has been deleted in pruned mode.
 	FetchBlockBySha(sha *wire.Hash) (blk *massutil.Block, err error)
 
 	// FetchBlockHeightBySha returns the block height for the given hash.
@@ -88,6 +116,8 @@ type Db interface {
 	// the database
 	ExistsTxSha(sha *wire.Hash) (exists bool, err error)
 
+	// FetchTxByLoc returns ErrBlockPruned if the block holding the tx
+	// has been pruned.
 	FetchTxByLoc(blkHeight uint64, txOff int, txLen int) (*wire.MsgTx, error)
 
 	// FetchTxByFileLoc returns transactions saved in file, including
@@ -127,12 +157,18 @@ type Db interface {
 	// which can be used to detect errors.
 	FetchUnSpentTxByShaList(txShaList []*wire.Hash) []*TxReply
 
+	// ForEachUnspentTx calls fn with every transaction which still has
+	// unspent outputs, in no particular order. It stops at the first error
+	// returned by fn.
+	ForEachUnspentTx(fn func(reply *TxReply) error) error
+
 	// FetchUnexpiredStakingRank returns only currently unexpired staking rank at
 	// target height. This function is for mining and validating block.
 	FetchUnexpiredStakingRank(height uint64, onlyOnList bool) ([]Rank, error)
 
 	// FetchStakingRank returns staking rank at any height. This
-	// function may be slow.
+	// function may be slow. The rank is read from a point-in-time
+	// snapshot, so it is not affected by a concurrent Commit.
 	FetchStakingRank(height uint64, onlyOnList bool) ([]Rank, error)
 
 	// fetch a map of all staking transactions in database
@@ -186,14 +222,78 @@ type Db interface {
 
 	DeleteAddrIndex(hash *wire.Hash, height uint64) (err error)
 
-	// FetchScriptHashRelatedTx  returns all relevant txhash mapped by block height
+	// FetchScriptHashRelatedTx  returns all relevant txhash mapped by block height.
+	// All the heights are read from a point-in-time snapshot, so they are
+	// not affected by a concurrent Commit.
 	FetchScriptHashRelatedTx(scriptHashes [][]byte, startBlock, stopBlock uint64) (map[uint64][]*wire.TxLoc, error)
 
 	CheckScriptHashUsed(scriptHash []byte) (bool, error)
 
+	// FetchScriptHashBalance returns the balances of the unspent outputs
+	// paid to scriptHash, indexed by AddrUtxoKind.
+	FetchScriptHashBalance(scriptHash []byte) (*AddrBalances, error)
+
+	// FetchScriptHashUtxos returns at most limit unspent outputs of kind
+	// paid to scriptHash, ordered by height and skipping the first offset
+	// ones. All the outputs are returned from offset if limit is 0.
+	FetchScriptHashUtxos(scriptHash []byte, kind AddrUtxoKind, offset, limit int) ([]*UtxoReply, error)
+
+	// FetchScriptHashHistory returns at most limit transactions paying to
+	// or spending outputs of kind paid to scriptHash, ordered by height and
+	// skipping the first offset ones. All the transactions are returned
+	// from offset if limit is 0.
+	FetchScriptHashHistory(scriptHash []byte, kind AddrUtxoKind, offset, limit int) ([]*AddrHistory, error)
+
 	// pubkeyHash is hash of MASS plot pubkey
 	FetchOldBinding(pubkeyHash []byte) ([]*BindingTxReply, error)
 
+	// FetchCFilterTip returns the hash and block height of the most recent
+	// block which has had its compact filter indexed. It will return
+	// ErrCFilterIndexDoesNotExist if no filter has been indexed.
+	FetchCFilterTip() (sha *wire.Hash, height uint64, err error)
+
+	// SubmitCFilter puts the compact filter and filter header of a block.
+	// It is committed along with the block if the block is being submitted.
+	SubmitCFilter(hash *wire.Hash, height uint64, header *wire.Hash, filter []byte) (err error)
+
+	// DeleteCFilter removes the compact filter of the most recent indexed
+	// block. It is committed along with the block if the block is being
+	// deleted.
+	DeleteCFilter(hash *wire.Hash, height uint64) (err error)
+
+	// FetchCFilterByHeight returns the block hash, filter header and compact
+	// filter of the main chain block at height. It will return
+	// ErrCFilterMissing if the filter has not been indexed.
+	FetchCFilterByHeight(height uint64) (sha, header *wire.Hash, filter []byte, err error)
+
+	// FetchSpentIndexTip returns the hash and block height of the most recent
+	// block which has had its spent outpoints indexed. It will return
+	// ErrSpentIndexDoesNotExist if no block has been indexed.
+	FetchSpentIndexTip() (sha *wire.Hash, height uint64, err error)
+
+	// SubmitSpentIndex records the spending tx of the outpoints spent by a
+	// block. It is committed along with the block if the block is being
+	// submitted.
+	SubmitSpentIndex(block *massutil.Block) (err error)
+
+	// DeleteSpentIndex removes the records of the most recent indexed block.
+	// It is committed along with the block if the block is being deleted.
+	DeleteSpentIndex(hash *wire.Hash, height uint64) (err error)
+
+	// FetchSpendingTx returns the hash of the main chain tx spending an
+	// outpoint and the height of its block. It will return
+	// ErrSpendingTxMissing if the outpoint is unspent or not indexed.
+	FetchSpendingTx(outPoint *wire.OutPoint) (sha *wire.Hash, height uint64, err error)
+
+	// PruneBlockFiles deletes finalized block files which only hold blocks
+	// lower than height, transactions with unspent outputs are kept in the
+	// database. It returns the number of deleted files.
+	PruneBlockFiles(height uint64) (int, error)
+
+	// HasPrunedBlocks returns whether blocks of the main chain have been
+	// deleted, so that the history can not be replayed.
+	HasPrunedBlocks() (bool, error)
+
 	// For testing purpose
 	TestExportDbEntries() map[string][]byte
 }
@@ -362,6 +462,70 @@ type AddrIndexData struct {
 	TxIndex             TxAddrIndex
 	BindingTxIndex      BindingTxAddrIndex
 	BindingTxSpentIndex BindingTxSpentAddrIndex
+
+	// Outputs are the outputs created by the block, SpentOutputs are the
+	// outputs spent by the block.  Outputs not paid to a script hash are
+	// left out of the UTXO index.
+	Outputs      []*AddrIndexOutput
+	SpentOutputs []*AddrIndexOutput
+}
+
+// AddrUtxoKind is the kind of an output in the address UTXO index.
+type AddrUtxoKind uint8
+
+const (
+	AddrUtxoNormal AddrUtxoKind = iota
+	AddrUtxoStaking
+	AddrUtxoBinding
+
+	AddrUtxoKindCount = 3
+)
+
+func (k AddrUtxoKind) String() string {
+	switch k {
+	case AddrUtxoNormal:
+		return "normal"
+	case AddrUtxoStaking:
+		return "staking"
+	case AddrUtxoBinding:
+		return "binding"
+	default:
+		return "unknown"
+	}
+}
+
+// AddrIndexOutput is an output created or spent by a block.
+type AddrIndexOutput struct {
+	OutPoint wire.OutPoint
+	TxOut    *wire.TxOut
+	Coinbase bool
+
+	// Height and TxLoc locate the transaction of the output.
+	Height uint64
+	TxLoc  *wire.TxLoc
+
+	// SpentTxLoc locates the spending transaction in the block, it is only
+	// set for the spent outputs.
+	SpentTxLoc *wire.TxLoc
+}
+
+// AddrBalance is the total value and number of the unspent outputs of a kind
+// paid to a script hash.
+type AddrBalance struct {
+	Value massutil.Amount
+	Utxos uint32
+}
+
+// AddrBalances are the balances of a script hash indexed by AddrUtxoKind.
+type AddrBalances [AddrUtxoKindCount]AddrBalance
+
+// AddrHistory is a transaction paying to or spending outputs paid to a script
+// hash, with the values of the outputs of a kind it pays and spends.
+type AddrHistory struct {
+	Height   uint64
+	TxLoc    *wire.TxLoc
+	Received massutil.Amount
+	Spent    massutil.Amount
 }
 
 type BLHeight struct {
@@ -376,3 +540,59 @@ type BlockLoc struct {
 	Offset uint64
 	Length uint64
 }
+
+// UtxoSnapshotTx is a transaction with unspent outputs in a utxo snapshot,
+// TxLoc locates it in the block of BlkSha at Height.
+type UtxoSnapshotTx struct {
+	Height uint64
+	BlkSha wire.Hash
+	TxLoc  wire.TxLoc
+	Spent  []bool
+	Tx     *wire.MsgTx
+}
+
+// UtxoSnapshotStakingTx is an unexpired staking output in a utxo snapshot.
+type UtxoSnapshotStakingTx struct {
+	ScriptHash [sha256.Size]byte
+	OutPoint   wire.OutPoint
+	Info       StakingTxInfo
+}
+
+// UtxoSnapshotFaultPk is a fault pk banned at Height in a utxo snapshot.
+type UtxoSnapshotFaultPk struct {
+	Height  uint64
+	FaultPk *wire.FaultPubKey
+}
+
+// UtxoSnapshotState is the state of the validation of the history up to a
+// utxo snapshot.
+type UtxoSnapshotState uint8
+
+const (
+	UtxoSnapshotAssumed UtxoSnapshotState = iota
+	UtxoSnapshotValidated
+	UtxoSnapshotInvalid
+)
+
+func (s UtxoSnapshotState) String() string {
+	switch s {
+	case UtxoSnapshotAssumed:
+		return "assumed"
+	case UtxoSnapshotValidated:
+		return "validated"
+	case UtxoSnapshotInvalid:
+		return "invalid"
+	default:
+		return "unknown"
+	}
+}
+
+// UtxoSnapshotBase is the utxo snapshot a database has been initialized by.
+// Blocks lower than LowestHeight, except the genesis block, are not stored.
+type UtxoSnapshotBase struct {
+	Height       uint64
+	BlockHash    wire.Hash
+	ContentHash  wire.Hash
+	LowestHeight uint64
+	State        UtxoSnapshotState
+}
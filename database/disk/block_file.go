@@ -28,6 +28,7 @@ type BlockFile struct {
 	lastAccessAt time.Time
 	file         *os.File
 	readonly     bool
+	pruned       bool
 }
 
 func NewBlockFile(fileNo uint32, readonly bool) *BlockFile {
@@ -74,6 +75,30 @@ func (b *BlockFile) Number() uint32 {
 	return b.fileNo
 }
 
+func (b *BlockFile) NumBlocks() uint32 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.numBlocks
+}
+
+func (b *BlockFile) HeightFirst() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.heightFirst
+}
+
+func (b *BlockFile) HeightLast() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.heightLast
+}
+
+func (b *BlockFile) Pruned() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.pruned
+}
+
 func (b *BlockFile) AddBlock(height, size uint64, timestamp uint64) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -143,18 +168,21 @@ func (b *BlockFile) WriteRawBlock(flatFileSeq *FlatFileSeq, offset int64, data [
 	return err
 }
 
-// read raw data
+// read raw data, a short read returns the bytes read along with the error
 func (b *BlockFile) ReadRawData(flatFileSeq *FlatFileSeq, offset int64, size int) ([]byte, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if b.pruned {
+		return nil, ErrFilePruned
+	}
 	file, err := b.openFile(flatFileSeq, offset)
 	if err != nil {
 		return nil, err
 	}
 	buf := make([]byte, size)
-	_, err = file.ReadAt(buf, offset)
-	return buf, err
+	n, err := file.ReadAt(buf, offset)
+	return buf[:n], err
 }
 
 // get or open file
@@ -211,6 +239,20 @@ func (b *BlockFile) Close() {
 	}
 }
 
+// remove closes and deletes the underlying blkXXXXX.dat, the meta
+// is kept so that heights and sizes are still known.
+func (b *BlockFile) remove(flatFileSeq *FlatFileSeq) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.file != nil {
+		b.file.Close()
+		b.file = nil
+	}
+	b.pruned = true
+	return flatFileSeq.Remove(NewFlatFilePos(b.fileNo, 0))
+}
+
 func (b *BlockFile) Flush(size int64, finalize bool) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
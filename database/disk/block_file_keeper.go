@@ -1,9 +1,8 @@
 package disk
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
+	"io"
 	"sync"
 
 	"github.com/wangxinyu2018/mass-core/logging"
@@ -17,14 +16,19 @@ type BlockFileKeeper struct {
 	lastBlockFile uint32
 	blockFiles    []*BlockFile
 	closed        bool
+
+	verified *recordSet
 }
 
-func NewBlockFileKeeper(dir string, records [][]byte) *BlockFileKeeper {
+// NewBlockFileKeeper loads block files from metas in records, files listed
+// in pruned have been deleted and are no longer readable.
+func NewBlockFileKeeper(dir string, records [][]byte, pruned []uint32) *BlockFileKeeper {
 	keeper := &BlockFileKeeper{
 		flatFileSeq:   NewFlatFileSeq(dir, "blk", BlockfileChunkSize),
 		blockFiles:    make([]*BlockFile, len(records)),
 		lastBlockFile: uint32(len(records) - 1),
 		closed:        false,
+		verified:      newRecordSet(maxVerifiedRecords),
 	}
 	for i, data := range records {
 		readonly := i < len(records)-1
@@ -36,7 +40,7 @@ func NewBlockFileKeeper(dir string, records [][]byte) *BlockFileKeeper {
 		keeper.blockFiles[bf.fileNo] = bf
 
 		// check file exist
-		if i < len(records)-1 {
+		if i < len(records)-1 && !containsFileNo(pruned, bf.fileNo) {
 			exist, err := keeper.flatFileSeq.ExistFile(NewFlatFilePos(bf.fileNo, 0))
 			if err != nil {
 				logging.CPrint(logging.ERROR, fmt.Sprintf("check blk%05d.dat existence error", bf.fileNo), logging.LogFormat{"err": err})
@@ -55,9 +59,31 @@ func NewBlockFileKeeper(dir string, records [][]byte) *BlockFileKeeper {
 			return nil
 		}
 	}
+
+	// a pruned file may be left on disk if the node stopped right after
+	// the prune records were written, remove it again.
+	for _, fileNo := range pruned {
+		if fileNo >= keeper.lastBlockFile {
+			logging.CPrint(logging.ERROR, fmt.Sprintf("unexpected pruned blk%05d.dat", fileNo), logging.LogFormat{"last": keeper.lastBlockFile})
+			return nil
+		}
+		if err := keeper.blockFiles[fileNo].remove(keeper.flatFileSeq); err != nil {
+			logging.CPrint(logging.ERROR, fmt.Sprintf("remove pruned blk%05d.dat error", fileNo), logging.LogFormat{"err": err})
+			return nil
+		}
+	}
 	return keeper
 }
 
+func containsFileNo(list []uint32, fileNo uint32) bool {
+	for _, n := range list {
+		if n == fileNo {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *BlockFileKeeper) Close() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -101,16 +127,12 @@ func (b *BlockFileKeeper) SaveRawBlockToDisk(rawBlk []byte, height uint64, times
 	if len(rawBlk) == 0 {
 		return nil, 0, nil
 	}
-	blkSize := len(rawBlk)                      // serialized block size
-	msgSize := BlkMessageHeaderLength + blkSize // disk data size
+	buf := encodeRecord(rawBlk)
+	msgSize := len(buf) // disk data size
 	pos, err := b.findBlockPos(height, uint64(msgSize))
 	if err != nil {
 		return nil, 0, err
 	}
-	buf := make([]byte, msgSize)
-	copy(buf[0:MagicNoLength], MagicNo[:])
-	binary.LittleEndian.PutUint64(buf[MagicNoLength:BlkMessageHeaderLength], uint64(blkSize))
-	copy(buf[BlkMessageHeaderLength:], rawBlk)
 
 	err = b.blockFiles[pos.FileNo()].WriteRawBlock(b.flatFileSeq, pos.Pos(), buf)
 	if err != nil {
@@ -146,32 +168,113 @@ func (b *BlockFileKeeper) findBlockPos(height, blkSize uint64) (*FlatFilePos, er
 	return pos, nil
 }
 
-// ReadRawBlock returns raw block bytes
+// ReadRawBlock returns raw block bytes, verifying the checksum of the record.
 func (b *BlockFileKeeper) ReadRawBlock(fileNo uint32, offset int64, blkSize int) ([]byte, error) {
 	if fileNo > b.lastBlockFile {
 		return nil, ErrFileOutOfRange
 	}
-	msgSize := BlkMessageHeaderLength + blkSize
-	data, err := b.blockFiles[fileNo].ReadRawData(b.flatFileSeq, offset, msgSize)
+	data, err := b.blockFiles[fileNo].ReadRawData(b.flatFileSeq, offset, BlkMessageHeaderLengthV1+blkSize)
+	if err == io.EOF && len(data) >= BlkMessageHeaderLength+blkSize {
+		// a legacy record ending the file
+		err = nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if !bytes.Equal(data[:MagicNoLength], MagicNo[:]) ||
-		binary.LittleEndian.Uint64(data[MagicNoLength:BlkMessageHeaderLength]) != uint64(blkSize) {
+	h, err := parseRecordHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.size != uint64(blkSize) {
 		return nil, ErrReadBrokenData
 	}
-	return data[BlkMessageHeaderLength:], nil
+	rawBlk := data[h.length : h.length+blkSize]
+	if err = h.verify(rawBlk); err != nil {
+		logging.CPrint(logging.ERROR, "corrupt block record", logging.LogFormat{
+			"file":   fileNo,
+			"offset": offset,
+			"size":   blkSize,
+			"err":    err,
+		})
+		return nil, err
+	}
+	b.verified.add(fileNo, offset)
+	return rawBlk, nil
 }
 
-// ReadRawTx returns raw transaction bytes
+// ReadRawTx returns raw transaction bytes.  The checksum of the record holding
+// the transaction is verified on the first read of the record, which reads the
+// whole block, and is trusted by the following reads of the records kept in
+// the least recently used set of verified records.
 func (b *BlockFileKeeper) ReadRawTx(fileNo uint32, offsetBlk, offsetTxInBlk int64, txSize int) ([]byte, error) {
 	if fileNo > b.lastBlockFile {
 		return nil, ErrFileOutOfRange
 	}
 
-	// | ------ block message header ---- | ---------- raw block ---------- |
-	// |    magic no    |  block size     |    tx0    |    tx1    |   ...   |
-	targetOffset := offsetBlk + int64(BlkMessageHeaderLength) + offsetTxInBlk
+	// | ------------- block message header ------------- | ---------- raw block ---------- |
+	// |    magic no    |  block size     | (checksum)    |    tx0    |    tx1    |   ...   |
+	data, err := b.blockFiles[fileNo].ReadRawData(b.flatFileSeq, offsetBlk, BlkMessageHeaderLengthV1)
+	if err == io.EOF && len(data) >= BlkMessageHeaderLength {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	h, err := parseRecordHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if offsetTxInBlk < 0 || uint64(offsetTxInBlk)+uint64(txSize) > h.size {
+		return nil, ErrReadBrokenData
+	}
+	if h.version != RecordVersionLegacy && !b.verified.has(fileNo, offsetBlk) {
+		rawBlk, err := b.ReadRawBlock(fileNo, offsetBlk, int(h.size))
+		if err != nil {
+			return nil, err
+		}
+		return rawBlk[offsetTxInBlk : offsetTxInBlk+int64(txSize)], nil
+	}
+	targetOffset := offsetBlk + int64(h.length) + offsetTxInBlk
 	return b.blockFiles[fileNo].ReadRawData(b.flatFileSeq, targetOffset, txSize)
 }
+
+// PruneCandidates returns finalized block files which are not pruned yet and
+// only hold blocks lower than height, ordered by file number.
+func (b *BlockFileKeeper) PruneCandidates(height uint64) []*BlockFile {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	candidates := make([]*BlockFile, 0)
+	for i := uint32(0); i < b.lastBlockFile; i++ {
+		bf := b.blockFiles[i]
+		if bf.Pruned() || bf.NumBlocks() == 0 || bf.HeightLast() >= height {
+			continue
+		}
+		candidates = append(candidates, bf)
+	}
+	return candidates
+}
+
+// PruneFile deletes blkXXXXX.dat of fileNo, reading blocks from a pruned
+// file returns ErrFilePruned.
+func (b *BlockFileKeeper) PruneFile(fileNo uint32) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	// never delete the file being written
+	if fileNo >= b.lastBlockFile {
+		return ErrFileOutOfRange
+	}
+	if err := b.blockFiles[fileNo].remove(b.flatFileSeq); err != nil {
+		return err
+	}
+	logging.CPrint(logging.INFO, fmt.Sprintf("pruned blk%05d.dat", fileNo), logging.LogFormat{
+		"heightFirst": b.blockFiles[fileNo].HeightFirst(),
+		"heightLast":  b.blockFiles[fileNo].HeightLast(),
+	})
+	return nil
+}
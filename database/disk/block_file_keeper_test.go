@@ -0,0 +1,59 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockFileKeeper_PruneFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockfilekeeper")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// write a block into blk00000.dat
+	keeper := NewBlockFileKeeper(dir, [][]byte{make([]byte, 48)}, nil)
+	assert.NotNil(t, keeper)
+	raw := []byte("raw block data")
+	file, offset, err := keeper.SaveRawBlockToDisk(raw, 1, 1000)
+	assert.Nil(t, err)
+	keeper.CommitRecentChange()
+	meta0 := file.Bytes()
+	keeper.Close()
+
+	// blk00000.dat is finalized once blk00001.dat exists
+	records := [][]byte{meta0, NewBlockFile(1, false).Bytes()}
+	keeper = NewBlockFileKeeper(dir, records, nil)
+	assert.NotNil(t, keeper)
+	data, err := keeper.ReadRawBlock(0, offset, len(raw))
+	assert.Nil(t, err)
+	assert.Equal(t, raw, data)
+
+	assert.Equal(t, 0, len(keeper.PruneCandidates(1)))
+	candidates := keeper.PruneCandidates(2)
+	assert.Equal(t, 1, len(candidates))
+	assert.Equal(t, uint32(0), candidates[0].Number())
+
+	assert.Equal(t, ErrFileOutOfRange, keeper.PruneFile(1))
+	assert.Nil(t, keeper.PruneFile(0))
+	exist, err := keeper.flatFileSeq.ExistFile(NewFlatFilePos(0, 0))
+	assert.Nil(t, err)
+	assert.False(t, exist)
+
+	_, err = keeper.ReadRawBlock(0, offset, len(raw))
+	assert.Equal(t, ErrFilePruned, err)
+	_, err = keeper.ReadRawTx(0, offset, 0, 4)
+	assert.Equal(t, ErrFilePruned, err)
+	assert.Equal(t, 0, len(keeper.PruneCandidates(2)))
+	keeper.Close()
+
+	// missing pruned file is allowed on reopen
+	assert.Nil(t, NewBlockFileKeeper(dir, records, nil))
+	keeper = NewBlockFileKeeper(dir, records, []uint32{0})
+	assert.NotNil(t, keeper)
+	_, err = keeper.ReadRawBlock(0, offset, len(raw))
+	assert.Equal(t, ErrFilePruned, err)
+	keeper.Close()
+}
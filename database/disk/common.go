@@ -6,12 +6,15 @@ import (
 )
 
 const (
-	MaxBlockfileSize   = 128 * 1024 * 1024 // 128 MiB
-	BlockfileChunkSize = 16 * 1024 * 1024  // 16 MiB
+	BlockfileChunkSize = 16 * 1024 * 1024 // 16 MiB
 
 	MinDiskSpace = 256 * 1024 * 1024 // 256 MiB
 )
 
+// MaxBlockfileSize is the size at which a block file is finalized, it is
+// lowered by tests to spread a few blocks over several files.
+var MaxBlockfileSize uint64 = 128 * 1024 * 1024 // 128 MiB
+
 var (
 	ErrInvalidFlatFilePos    = errors.New("invalid FlatFilePos")
 	ErrOutOfSpace            = errors.New("out of space")
@@ -20,6 +23,17 @@ var (
 	ErrReadBrokenData        = errors.New("read broken data")
 	ErrFileOutOfRange        = errors.New("file out of range")
 	ErrClosed                = errors.New("file writer closed")
+	ErrFilePruned            = errors.New("file pruned")
+	ErrChecksumMismatch      = errors.New("block record checksum mismatch")
+)
+
+// Versions of the block records in blkXXXXX.dat, records of all versions are
+// readable, new records are written in CurrentRecordVersion.
+const (
+	RecordVersionLegacy   = 0 // no checksum
+	RecordVersionChecksum = 1 // CRC32C of block size and raw block
+
+	CurrentRecordVersion = RecordVersionChecksum
 )
 
 var (
@@ -27,6 +41,11 @@ var (
 	MagicNoLength          = len(MagicNo)
 	RawBlkSizeLength       = 8
 	BlkMessageHeaderLength = MagicNoLength + RawBlkSizeLength
+
+	// MagicNoV1 leads the records of RecordVersionChecksum.
+	MagicNoV1                = [4]byte{0xf9, 0xbe, 0xb4, 0xda}
+	ChecksumLength           = 4
+	BlkMessageHeaderLengthV1 = BlkMessageHeaderLength + ChecksumLength
 )
 
 var CheckDiskSpaceStub func(path string, additional uint64) bool
@@ -35,6 +35,18 @@ func (f *FlatFileSeq) ExistFile(pos *FlatFilePos) (bool, error) {
 	return true, nil
 }
 
+// Remove deletes the file pos belongs to, a missing file is not an error.
+func (f *FlatFileSeq) Remove(pos *FlatFilePos) error {
+	if pos == nil {
+		return ErrInvalidFlatFilePos
+	}
+	err := os.Remove(f.FilePath(pos))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func (f *FlatFileSeq) Open(pos *FlatFilePos, readOnly bool) (file *os.File, err error) {
 	if pos == nil {
 		return nil, ErrInvalidFlatFilePos
@@ -0,0 +1,111 @@
+package disk
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/wangxinyu2018/mass-core/logging"
+)
+
+// migratedFileSuffix is appended to the name of a block file rewritten by
+// MigrateFile until CommitMigratedFile replaces the block file with it.
+const migratedFileSuffix = ".new"
+
+// MigratedFile describes a block file rewritten in CurrentRecordVersion.
+type MigratedFile struct {
+	FileNo  uint32
+	Size    uint64
+	Records int
+	// Offsets maps the offsets of the rewritten records to their new ones.
+	Offsets map[int64]int64
+}
+
+// MigrateFile rewrites the records of fileNo in CurrentRecordVersion into a
+// new file beside it, the block file itself is left untouched until
+// CommitMigratedFile.  It returns nil if the file holds no legacy records.
+func (b *BlockFileKeeper) MigrateFile(fileNo uint32) (*MigratedFile, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	if fileNo > b.lastBlockFile {
+		return nil, ErrFileOutOfRange
+	}
+	bf := b.blockFiles[fileNo]
+	if bf.Pruned() || bf.Size() == 0 {
+		return nil, nil
+	}
+	data, err := bf.ReadRawData(b.flatFileSeq, 0, int(bf.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := &MigratedFile{FileNo: fileNo, Offsets: make(map[int64]int64)}
+	buf := make([]byte, 0, len(data)+int(bf.NumBlocks())*ChecksumLength)
+	for pos := 0; pos < len(data); {
+		h, err := parseRecordHeader(data[pos:])
+		if err == nil && h.size > uint64(len(data)-pos-h.length) {
+			err = ErrReadBrokenData
+		}
+		if err != nil {
+			return nil, fmt.Errorf("blk%05d.dat offset %d: %v", fileNo, pos, err)
+		}
+		end := pos + h.length + int(h.size)
+		rawBlk := data[pos+h.length : end]
+		if err = h.verify(rawBlk); err != nil {
+			return nil, fmt.Errorf("blk%05d.dat offset %d: %v", fileNo, pos, err)
+		}
+		migrated.Offsets[int64(pos)] = int64(len(buf))
+		if h.version == CurrentRecordVersion {
+			buf = append(buf, data[pos:end]...)
+		} else {
+			buf = append(buf, encodeRecord(rawBlk)...)
+			migrated.Records++
+		}
+		pos = end
+	}
+	if migrated.Records == 0 {
+		return nil, nil
+	}
+
+	path := b.flatFileSeq.FilePath(NewFlatFilePos(fileNo, 0)) + migratedFileSuffix
+	if err = writeFileSync(path, buf); err != nil {
+		return nil, err
+	}
+	migrated.Size = uint64(len(buf))
+	logging.CPrint(logging.DEBUG, fmt.Sprintf("migrated blk%05d.dat", fileNo), logging.LogFormat{
+		"records": migrated.Records,
+		"size":    migrated.Size,
+	})
+	return migrated, nil
+}
+
+// CommitMigratedFile replaces fileNo with the file written by MigrateFile and
+// sets its size, the new offsets must have been saved already.  It may be
+// called again for a file already replaced, to recover from an interrupted
+// migration.
+func (b *BlockFileKeeper) CommitMigratedFile(fileNo uint32, size uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	if fileNo > b.lastBlockFile {
+		return ErrFileOutOfRange
+	}
+	bf := b.blockFiles[fileNo]
+	bf.Close()
+
+	path := b.flatFileSeq.FilePath(NewFlatFilePos(fileNo, 0))
+	if err := os.Rename(path+migratedFileSuffix, path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	bf.mu.Lock()
+	bf.size = size
+	bf.mu.Unlock()
+	b.verified.reset(fileNo)
+	return nil
+}
@@ -0,0 +1,104 @@
+package disk
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/wangxinyu2018/mass-core/logging"
+)
+
+// BlockFileReader reads the records of the block files in a directory in the
+// order they were written, without the metas kept by the block database.  It
+// is meant for rebuilding a lost block database, so corrupt records are
+// skipped rather than failing the read.
+type BlockFileReader struct {
+	flatFileSeq *FlatFileSeq
+
+	fileNo  uint32
+	loaded  bool
+	data    []byte // records of fileNo
+	pos     int
+	skipped int
+}
+
+// NewBlockFileReader returns a reader of the block files in dir, starting at
+// pos, which is the position of a record or the end of a file.
+func NewBlockFileReader(dir string, pos *FlatFilePos) *BlockFileReader {
+	r := &BlockFileReader{flatFileSeq: NewFlatFileSeq(dir, "blk", BlockfileChunkSize)}
+	if pos != nil {
+		r.fileNo, r.pos = pos.FileNo(), int(pos.Pos())
+	}
+	return r
+}
+
+// Next returns the raw block of the next record and the position following
+// it, or io.EOF once the last block file has been read.
+func (r *BlockFileReader) Next() (rawBlk []byte, next *FlatFilePos, err error) {
+	for {
+		if !r.loaded {
+			if err = r.load(); err != nil {
+				return nil, nil, err
+			}
+		}
+		if r.pos >= len(r.data) || isZero(r.data[r.pos:]) {
+			// the rest of a file is zero if it was preallocated
+			r.fileNo++
+			r.loaded, r.data, r.pos = false, nil, 0
+			continue
+		}
+
+		pos := r.pos
+		h, err := parseRecordHeader(r.data[pos:])
+		if err == nil && h.size > uint64(len(r.data)-pos-h.length) {
+			err = ErrReadBrokenData
+		}
+		if err == nil {
+			end := pos + h.length + int(h.size)
+			rawBlk = r.data[pos+h.length : end]
+			if err = h.verify(rawBlk); err == nil {
+				r.pos = end
+				return rawBlk, NewFlatFilePos(r.fileNo, int64(end)), nil
+			}
+		}
+		r.pos = nextRecord(r.data, pos+1)
+		r.skipped++
+		logging.CPrint(logging.WARN, "skip corrupt block record", logging.LogFormat{
+			"file":   r.fileNo,
+			"offset": pos,
+			"size":   r.pos - pos,
+			"err":    err,
+		})
+	}
+}
+
+// Skipped returns the number of corrupt regions skipped.
+func (r *BlockFileReader) Skipped() int {
+	return r.skipped
+}
+
+func (r *BlockFileReader) load() error {
+	path := r.flatFileSeq.FilePath(NewFlatFilePos(r.fileNo, 0))
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	if r.pos > len(data) {
+		return fmt.Errorf("blk%05d.dat: position %d beyond size %d", r.fileNo, r.pos, len(data))
+	}
+	r.loaded, r.data = true, data
+	return nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package disk
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"hash/crc32"
+	"sync"
+)
+
+//  Structure of block records
+//   --------------------------------------------------------
+//  | Version 0 |  magic no  | block size |       raw block  |
+//  |-----------|------------|------------|------------------|
+//  |           |   4 Byte   |   8 Byte   |   block size     |
+//   --------------------------------------------------------
+//  | Version 1 | magic no v1| block size |  CRC32C | raw block  |
+//  |-----------|------------|------------|---------|------------|
+//  |           |   4 Byte   |   8 Byte   |  4 Byte | block size |
+//   --------------------------------------------------------
+// CRC32C covers the block size and the raw block.
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type recordHeader struct {
+	version  int
+	length   int // header length
+	size     uint64
+	checksum uint32
+}
+
+// encodeRecord frames rawBlk into a record of CurrentRecordVersion.
+func encodeRecord(rawBlk []byte) []byte {
+	buf := make([]byte, BlkMessageHeaderLengthV1+len(rawBlk))
+	copy(buf[0:MagicNoLength], MagicNoV1[:])
+	binary.LittleEndian.PutUint64(buf[MagicNoLength:BlkMessageHeaderLength], uint64(len(rawBlk)))
+	copy(buf[BlkMessageHeaderLengthV1:], rawBlk)
+	checksum := recordChecksum(buf[MagicNoLength:BlkMessageHeaderLength], rawBlk)
+	binary.LittleEndian.PutUint32(buf[BlkMessageHeaderLength:BlkMessageHeaderLengthV1], checksum)
+	return buf
+}
+
+func recordChecksum(sizeBuf, rawBlk []byte) uint32 {
+	return crc32.Update(crc32.Checksum(sizeBuf, castagnoli), castagnoli, rawBlk)
+}
+
+// parseRecordHeader parses the record header leading data, which holds at
+// least the header.
+func parseRecordHeader(data []byte) (recordHeader, error) {
+	if len(data) < BlkMessageHeaderLength {
+		return recordHeader{}, ErrReadBrokenData
+	}
+	h := recordHeader{size: binary.LittleEndian.Uint64(data[MagicNoLength:BlkMessageHeaderLength])}
+	switch {
+	case bytes.Equal(data[:MagicNoLength], MagicNo[:]):
+		h.version = RecordVersionLegacy
+		h.length = BlkMessageHeaderLength
+	case bytes.Equal(data[:MagicNoLength], MagicNoV1[:]):
+		if len(data) < BlkMessageHeaderLengthV1 {
+			return recordHeader{}, ErrReadBrokenData
+		}
+		h.version = RecordVersionChecksum
+		h.length = BlkMessageHeaderLengthV1
+		h.checksum = binary.LittleEndian.Uint32(data[BlkMessageHeaderLength:BlkMessageHeaderLengthV1])
+	default:
+		return recordHeader{}, ErrReadBrokenData
+	}
+	return h, nil
+}
+
+// verify checks rawBlk against the checksum of the header, records without
+// checksum always pass.
+func (h recordHeader) verify(rawBlk []byte) error {
+	if h.version == RecordVersionLegacy {
+		return nil
+	}
+	var sizeBuf [8]byte
+	binary.LittleEndian.PutUint64(sizeBuf[:], h.size)
+	if recordChecksum(sizeBuf[:], rawBlk) != h.checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// maxVerifiedRecords bounds the records remembered by BlockFileKeeper as
+// verified.
+const maxVerifiedRecords = 4096
+
+// recordSet is a bounded set of record positions, the least recently used
+// record is evicted once it is full.
+type recordSet struct {
+	mu      sync.Mutex
+	limit   int
+	lru     *list.List
+	records map[FlatFilePos]*list.Element
+}
+
+func newRecordSet(limit int) *recordSet {
+	return &recordSet{limit: limit, lru: list.New(), records: make(map[FlatFilePos]*list.Element)}
+}
+
+func (s *recordSet) add(fileNo uint32, offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pos := *NewFlatFilePos(fileNo, offset)
+	if elem, ok := s.records[pos]; ok {
+		s.lru.MoveToFront(elem)
+		return
+	}
+	if s.lru.Len() >= s.limit {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.records, oldest.Value.(FlatFilePos))
+	}
+	s.records[pos] = s.lru.PushFront(pos)
+}
+
+func (s *recordSet) has(fileNo uint32, offset int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.records[*NewFlatFilePos(fileNo, offset)]
+	if ok {
+		s.lru.MoveToFront(elem)
+	}
+	return ok
+}
+
+// reset forgets the records of fileNo, whose offsets may have changed.
+func (s *recordSet) reset(fileNo uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for pos, elem := range s.records {
+		if pos.FileNo() == fileNo {
+			s.lru.Remove(elem)
+			delete(s.records, pos)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package disk
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeLegacyRecord(rawBlk []byte) []byte {
+	buf := make([]byte, BlkMessageHeaderLength+len(rawBlk))
+	copy(buf, MagicNo[:])
+	binary.LittleEndian.PutUint64(buf[MagicNoLength:], uint64(len(rawBlk)))
+	copy(buf[BlkMessageHeaderLength:], rawBlk)
+	return buf
+}
+
+func TestBlockFileKeeper_Checksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockfilerecord")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// blk00000.dat holds legacy records around a checksummed one
+	raws := [][]byte{[]byte("raw block one"), []byte("raw block two"), []byte("raw block three")}
+	var data []byte
+	offsets := make([]int64, len(raws))
+	for i, raw := range raws {
+		offsets[i] = int64(len(data))
+		if i == 1 {
+			data = append(data, encodeRecord(raw)...)
+		} else {
+			data = append(data, encodeLegacyRecord(raw)...)
+		}
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "blk00000.dat"), data, 0644))
+	bf := NewBlockFile(0, true)
+	for i, raw := range raws {
+		bf.AddBlock(uint64(i+1), uint64(len(encodeRecord(raw))), uint64(i+1000))
+	}
+	bf.size = uint64(len(data))
+	records := [][]byte{bf.Bytes(), NewBlockFile(1, false).Bytes()}
+
+	keeper := NewBlockFileKeeper(dir, records, nil)
+	assert.NotNil(t, keeper)
+	for i, raw := range raws {
+		blk, err := keeper.ReadRawBlock(0, offsets[i], len(raw))
+		assert.Nil(t, err)
+		assert.Equal(t, raw, blk)
+		tx, err := keeper.ReadRawTx(0, offsets[i], 4, 5)
+		assert.Nil(t, err)
+		assert.Equal(t, raw[4:9], tx)
+	}
+	_, err = keeper.ReadRawTx(0, offsets[1], 4, len(raws[1]))
+	assert.Equal(t, ErrReadBrokenData, err)
+	report, err := keeper.Scan(false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Files)
+	assert.Equal(t, 3, report.Records)
+	assert.Equal(t, 2, report.LegacyRecords)
+	assert.Equal(t, 0, len(report.Corrupt))
+
+	// rewrite the legacy records
+	migrated, err := keeper.MigrateFile(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, migrated.Records)
+	assert.Equal(t, len(raws), len(migrated.Offsets))
+	assert.Nil(t, keeper.CommitMigratedFile(0, migrated.Size))
+	assert.Nil(t, keeper.CommitMigratedFile(0, migrated.Size))
+	for i, raw := range raws {
+		offsets[i] = migrated.Offsets[offsets[i]]
+		blk, err := keeper.ReadRawBlock(0, offsets[i], len(raw))
+		assert.Nil(t, err)
+		assert.Equal(t, raw, blk)
+	}
+	migrated, err = keeper.MigrateFile(0)
+	assert.Nil(t, err)
+	assert.Nil(t, migrated)
+	records[0] = keeper.blockFiles[0].Bytes()
+	keeper.Close()
+
+	// flip a bit of the second block
+	f, err := os.OpenFile(filepath.Join(dir, "blk00000.dat"), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{'R'}, offsets[1]+int64(BlkMessageHeaderLengthV1))
+	assert.Nil(t, err)
+	f.Close()
+
+	keeper = NewBlockFileKeeper(dir, records, nil)
+	assert.NotNil(t, keeper)
+	defer keeper.Close()
+	_, err = keeper.ReadRawTx(0, offsets[1], 4, 5)
+	assert.Equal(t, ErrChecksumMismatch, err)
+	_, err = keeper.ReadRawBlock(0, offsets[1], len(raws[1]))
+	assert.Equal(t, ErrChecksumMismatch, err)
+	_, err = keeper.ReadRawBlock(0, offsets[2], len(raws[2]))
+	assert.Nil(t, err)
+
+	recordSize := int64(BlkMessageHeaderLengthV1 + len(raws[1]))
+	for _, quarantine := range []bool{false, true, true} {
+		report, err = keeper.Scan(quarantine)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, report.Records)
+		assert.Equal(t, 0, report.LegacyRecords)
+		if assert.Equal(t, 1, len(report.Corrupt)) {
+			c := report.Corrupt[0]
+			assert.Equal(t, offsets[1], c.Offset)
+			assert.Equal(t, recordSize, c.Size)
+			assert.Equal(t, quarantine, c.Quarantined)
+		}
+	}
+	saved, err := ioutil.ReadFile(filepath.Join(dir, QuarantineDir, "blk00000_"+strconv.FormatInt(offsets[1], 10)+".dat"))
+	assert.Nil(t, err)
+	if assert.Equal(t, int(recordSize), len(saved)) {
+		assert.Equal(t, MagicNoV1[:], saved[:MagicNoLength])
+	}
+	_, err = keeper.ReadRawBlock(0, offsets[1], len(raws[1]))
+	assert.Equal(t, ErrReadBrokenData, err)
+}
+
+func TestBlockFileReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockfilereader")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// blk00000.dat ends with a corrupt record, blk00001.dat is preallocated
+	raws := [][]byte{[]byte("raw block one"), []byte("raw block two"), []byte("raw block three")}
+	file0 := append(encodeLegacyRecord(raws[0]), encodeRecord(raws[1])...)
+	corrupt := encodeRecord([]byte("corrupt"))
+	corrupt[len(corrupt)-1] ^= 0xff
+	file0 = append(file0, corrupt...)
+	file1 := append(encodeRecord(raws[2]), make([]byte, 64)...)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "blk00000.dat"), file0, 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "blk00001.dat"), file1, 0644))
+
+	reader := NewBlockFileReader(dir, nil)
+	var positions []*FlatFilePos
+	for _, raw := range raws {
+		blk, next, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, raw, blk)
+		positions = append(positions, next)
+	}
+	_, _, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 1, reader.Skipped())
+	assert.Equal(t, NewFlatFilePos(1, int64(BlkMessageHeaderLengthV1+len(raws[2]))), positions[2])
+
+	// resume after the first record
+	reader = NewBlockFileReader(dir, positions[0])
+	blk, _, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, raws[1], blk)
+}
+
+func TestRecordSet(t *testing.T) {
+	s := newRecordSet(2)
+	s.add(0, 10)
+	s.add(0, 20)
+	assert.True(t, s.has(0, 10))
+
+	// (0, 20) is the least recently used one
+	s.add(1, 10)
+	assert.True(t, s.has(0, 10))
+	assert.False(t, s.has(0, 20))
+	assert.True(t, s.has(1, 10))
+
+	s.reset(1)
+	assert.False(t, s.has(1, 10))
+	s.add(0, 30)
+	assert.True(t, s.has(0, 10))
+	assert.True(t, s.has(0, 30))
+}
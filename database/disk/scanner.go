@@ -0,0 +1,191 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/wangxinyu2018/mass-core/logging"
+)
+
+// QuarantineDir is the directory under the block file directory holding
+// copies of the corrupt records found by Scan.
+const QuarantineDir = "quarantine"
+
+// CorruptRecord locates a corrupt region of a block file, which starts at a
+// broken record and ends at the next readable record or the end of the file.
+type CorruptRecord struct {
+	FileNo      uint32
+	Offset      int64
+	Size        int64
+	Err         error
+	Quarantined bool
+}
+
+func (c *CorruptRecord) String() string {
+	return fmt.Sprintf("blk%05d.dat offset %d size %d: %v", c.FileNo, c.Offset, c.Size, c.Err)
+}
+
+// ScanReport summarizes a scan of the block files.
+type ScanReport struct {
+	Files         int
+	Records       int
+	LegacyRecords int
+	Corrupt       []*CorruptRecord
+}
+
+// Scan walks the records of all block files which are not pruned, verifying
+// the framing and the checksums of the records.  If quarantine is set, every
+// corrupt region is copied to QuarantineDir and its magic number is cleared
+// so that it is never read as a record again.
+func (b *BlockFileKeeper) Scan(quarantine bool) (*ScanReport, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	report := &ScanReport{}
+	for fileNo := uint32(0); fileNo <= b.lastBlockFile; fileNo++ {
+		bf := b.blockFiles[fileNo]
+		if bf.Pruned() || bf.Size() == 0 {
+			continue
+		}
+		data, err := bf.ReadRawData(b.flatFileSeq, 0, int(bf.Size()))
+		if err != nil {
+			return nil, fmt.Errorf("read blk%05d.dat: %v", fileNo, err)
+		}
+		report.Files++
+
+		corrupt := scanRecords(data, report)
+		for _, c := range corrupt {
+			c.FileNo = fileNo
+			logging.CPrint(logging.ERROR, "corrupt block record found", logging.LogFormat{
+				"file":   fileNo,
+				"offset": c.Offset,
+				"size":   c.Size,
+				"err":    c.Err,
+			})
+			if quarantine {
+				if err = b.quarantine(c, data[c.Offset:c.Offset+c.Size]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		report.Corrupt = append(report.Corrupt, corrupt...)
+	}
+	return report, nil
+}
+
+// scanRecords walks the records in data, counting the good ones in report and
+// returning the corrupt regions.
+func scanRecords(data []byte, report *ScanReport) []*CorruptRecord {
+	var corrupt []*CorruptRecord
+	for pos := 0; pos < len(data); {
+		h, err := parseRecordHeader(data[pos:])
+		end := -1
+		if err == nil {
+			if h.size > uint64(len(data)-pos-h.length) {
+				err = ErrReadBrokenData
+			} else {
+				end = pos + h.length + int(h.size)
+				err = h.verify(data[pos+h.length : end])
+			}
+		}
+		if err == nil {
+			report.Records++
+			if h.version == RecordVersionLegacy {
+				report.LegacyRecords++
+			}
+			pos = end
+			continue
+		}
+
+		// trust the declared size if a record follows it, otherwise resync
+		// at the next magic number
+		next := nextRecord(data, pos+1)
+		if end > pos && (end == len(data) || isRecordStart(data[end:])) {
+			next = end
+		}
+		corrupt = append(corrupt, &CorruptRecord{Offset: int64(pos), Size: int64(next - pos), Err: err})
+		pos = next
+	}
+	return corrupt
+}
+
+func isRecordStart(data []byte) bool {
+	return len(data) >= MagicNoLength &&
+		(bytes.Equal(data[:MagicNoLength], MagicNo[:]) || bytes.Equal(data[:MagicNoLength], MagicNoV1[:]))
+}
+
+// nextRecord returns the offset of the first magic number in data from pos,
+// or the length of data if there is none.
+func nextRecord(data []byte, pos int) int {
+	prefix := MagicNo[:MagicNoLength-1] // shared by all versions
+	for pos < len(data) {
+		i := bytes.Index(data[pos:], prefix)
+		if i < 0 {
+			break
+		}
+		if isRecordStart(data[pos+i:]) {
+			return pos + i
+		}
+		pos += i + 1
+	}
+	return len(data)
+}
+
+// quarantine saves the bytes of c and clears its magic number in place.
+func (b *BlockFileKeeper) quarantine(c *CorruptRecord, data []byte) error {
+	dir := filepath.Join(b.flatFileSeq.dir, QuarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s%05d_%d.dat", b.flatFileSeq.prefix, c.FileNo, c.Offset))
+	// keep the copy of an earlier scan, which still holds the magic number
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err = writeFileSync(path, data); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if isRecordStart(data) {
+		f, err := os.OpenFile(b.flatFileSeq.FilePath(NewFlatFilePos(c.FileNo, 0)), os.O_RDWR, 0755)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteAt(make([]byte, MagicNoLength), c.Offset)
+		if err == nil {
+			err = f.Sync()
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	b.verified.reset(c.FileNo)
+	c.Quarantined = true
+	logging.CPrint(logging.WARN, "corrupt block record quarantined", logging.LogFormat{
+		"file":   c.FileNo,
+		"offset": c.Offset,
+		"path":   path,
+	})
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,531 @@
+package ldb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/txscript"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	addrUtxoSearchKeyLen = 3 + sha256.Size + 1
+	addrUtxoKeyLen       = addrUtxoSearchKeyLen + 8 + sha256.Size + 4
+	addrUtxoValueLen     = 8 + 4 + 4 + 1
+	addrUtxoEntryLen     = addrUtxoKeyLen + addrUtxoValueLen
+	addrBalanceKeyLen    = 3 + sha256.Size
+	addrBalanceValueLen  = database.AddrUtxoKindCount * (8 + 4)
+	addrHistoryKeyLen    = addrUtxoSearchKeyLen + 8 + 4 + 4
+	addrHistoryValueLen  = 8 + 8
+	addrUndoKeyLen       = 3 + 8
+
+	addrUtxoCoinbaseFlag = 0x01
+)
+
+var (
+	//  | key prefix | script hash |  kind  |  height | tx hash  |  index  |      |  value  | tx offset | tx length |  flags |
+	//   --------------------------------------------------------------------- -----> ------------------------------------------
+	//  |  3 bytes   |  32 bytes   | 1 byte | 8 bytes | 32 bytes | 4 bytes |      | 8 bytes |  4 bytes  |  4 bytes  | 1 byte |
+	//
+	//  height and index are BigEndian, so that the outputs are ordered by height.
+	addrUtxoPrefix = []byte("SHU")
+
+	//  | key prefix | script hash |      |  value  | count   |  value  | count   |  value  | count   |
+	//   -------------------------- -----> ----------------------------------------------------------
+	//  |  3 bytes   |  32 bytes   |      | 8 bytes | 4 bytes | 8 bytes | 4 bytes | 8 bytes | 4 bytes |
+	//
+	//  values and counts of the normal, staking and binding outputs.
+	addrBalancePrefix = []byte("SHB")
+
+	//  | key prefix | script hash |  kind  |  height | tx offset | tx length |      | received |  spent  |
+	//   ---------------------------------------------------------------------- -----> --------------------
+	//  |  3 bytes   |  32 bytes   | 1 byte | 8 bytes |  4 bytes  |  4 bytes  |      |  8 bytes | 8 bytes |
+	//
+	//  height, tx offset and tx length are BigEndian.
+	addrHistoryPrefix = []byte("SHH")
+
+	//  | key prefix |  height |      | created | spent   | history | created entries | spent entries | history keys |
+	//   ---------------------- -----> ----------------------------------------------------------------------------
+	//  |  3 bytes   | 8 bytes |      | 4 bytes | 4 bytes | 4 bytes |    97 x N       |    97 x N     |   52 x N     |
+	//
+	//  The undo record of a block reverts its changes to the address UTXO
+	//  index on detach. An entry is the key and value of an output.
+	addrUndoPrefix = []byte("SHD")
+)
+
+// addrOutputOwner returns the script hash an output is paid to and the kind
+// of the output, ok is false if the output is not indexed.  Binding outputs
+// belong to the holder script hash.
+func addrOutputOwner(pkScript []byte) (scriptHash [sha256.Size]byte, kind database.AddrUtxoKind, ok bool) {
+	class, pops := txscript.GetScriptInfo(pkScript)
+	switch class {
+	case txscript.WitnessV0ScriptHashTy, txscript.StakingScriptHashTy:
+		_, rsh, err := txscript.GetParsedOpcode(pops, class)
+		if err != nil {
+			return scriptHash, 0, false
+		}
+		kind = database.AddrUtxoNormal
+		if class == txscript.StakingScriptHashTy {
+			kind = database.AddrUtxoStaking
+		}
+		return rsh, kind, true
+	case txscript.BindingScriptHashTy:
+		holderScriptHash, _, err := txscript.GetParsedBindingOpcode(pops)
+		if err != nil || len(holderScriptHash) != sha256.Size {
+			return scriptHash, 0, false
+		}
+		copy(scriptHash[:], holderScriptHash)
+		return scriptHash, database.AddrUtxoBinding, true
+	default:
+		return scriptHash, 0, false
+	}
+}
+
+func addrUtxoSearchKey(scriptHash [sha256.Size]byte, kind database.AddrUtxoKind) []byte {
+	key := make([]byte, addrUtxoSearchKeyLen)
+	copy(key, addrUtxoPrefix)
+	copy(key[3:35], scriptHash[:])
+	key[35] = byte(kind)
+	return key
+}
+
+func encodeAddrUtxoKey(scriptHash [sha256.Size]byte, kind database.AddrUtxoKind, height uint64, op *wire.OutPoint) []byte {
+	key := make([]byte, addrUtxoKeyLen)
+	copy(key, addrUtxoSearchKey(scriptHash, kind))
+	binary.BigEndian.PutUint64(key[36:44], height)
+	copy(key[44:76], op.Hash[:])
+	binary.BigEndian.PutUint32(key[76:80], op.Index)
+	return key
+}
+
+func decodeAddrUtxoKey(key []byte) (scriptHash [sha256.Size]byte, kind database.AddrUtxoKind, height uint64, op wire.OutPoint) {
+	copy(scriptHash[:], key[3:35])
+	kind = database.AddrUtxoKind(key[35])
+	height = binary.BigEndian.Uint64(key[36:44])
+	copy(op.Hash[:], key[44:76])
+	op.Index = binary.BigEndian.Uint32(key[76:80])
+	return
+}
+
+func encodeAddrUtxoValue(value int64, txLoc *wire.TxLoc, coinbase bool) []byte {
+	buf := make([]byte, addrUtxoValueLen)
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(value))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(txLoc.TxStart))
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(txLoc.TxLen))
+	if coinbase {
+		buf[16] |= addrUtxoCoinbaseFlag
+	}
+	return buf
+}
+
+func decodeAddrUtxoValue(buf []byte) (value int64, txLoc wire.TxLoc, coinbase bool) {
+	value = int64(binary.LittleEndian.Uint64(buf[0:8]))
+	txLoc.TxStart = int(binary.LittleEndian.Uint32(buf[8:12]))
+	txLoc.TxLen = int(binary.LittleEndian.Uint32(buf[12:16]))
+	coinbase = buf[16]&addrUtxoCoinbaseFlag != 0
+	return
+}
+
+func addrBalanceKey(scriptHash [sha256.Size]byte) []byte {
+	key := make([]byte, addrBalanceKeyLen)
+	copy(key, addrBalancePrefix)
+	copy(key[3:35], scriptHash[:])
+	return key
+}
+
+func addrHistorySearchKey(scriptHash [sha256.Size]byte, kind database.AddrUtxoKind) []byte {
+	key := addrUtxoSearchKey(scriptHash, kind)
+	copy(key, addrHistoryPrefix)
+	return key
+}
+
+func encodeAddrHistoryKey(scriptHash [sha256.Size]byte, kind database.AddrUtxoKind, height uint64, txLoc *wire.TxLoc) []byte {
+	key := make([]byte, addrHistoryKeyLen)
+	copy(key, addrHistorySearchKey(scriptHash, kind))
+	binary.BigEndian.PutUint64(key[36:44], height)
+	binary.BigEndian.PutUint32(key[44:48], uint32(txLoc.TxStart))
+	binary.BigEndian.PutUint32(key[48:52], uint32(txLoc.TxLen))
+	return key
+}
+
+func addrUndoKey(height uint64) []byte {
+	key := make([]byte, addrUndoKeyLen)
+	copy(key, addrUndoPrefix)
+	binary.BigEndian.PutUint64(key[3:11], height)
+	return key
+}
+
+// addrBalanceDelta is the change of the balances of a script hash.
+type addrBalanceDelta [database.AddrUtxoKindCount]struct {
+	value int64
+	utxos int64
+}
+
+type addrBalanceDeltas map[[sha256.Size]byte]*addrBalanceDelta
+
+func (d addrBalanceDeltas) add(scriptHash [sha256.Size]byte, kind database.AddrUtxoKind, value, utxos int64) {
+	delta, ok := d[scriptHash]
+	if !ok {
+		delta = &addrBalanceDelta{}
+		d[scriptHash] = delta
+	}
+	delta[kind].value += value
+	delta[kind].utxos += utxos
+}
+
+// submitAddrUtxoIndex puts the outputs created by the block at height into the
+// address UTXO index, deletes the outputs it spends, and records how to revert
+// them in the undo record of the block.
+func (db *ChainDb) submitAddrUtxoIndex(batch storage.Batch, height uint64, outputs, spentOutputs []*database.AddrIndexOutput) error {
+	deltas := make(addrBalanceDeltas)
+	history := make(map[string]*[2]int64)
+	addHistory := func(key []byte, received, spent int64) {
+		h, ok := history[string(key)]
+		if !ok {
+			h = &[2]int64{}
+			history[string(key)] = h
+		}
+		h[0] += received
+		h[1] += spent
+	}
+
+	created := make([]string, 0, len(outputs))
+	createdValues := make(map[string][]byte, len(outputs))
+	for _, out := range outputs {
+		scriptHash, kind, ok := addrOutputOwner(out.TxOut.PkScript)
+		if !ok {
+			continue
+		}
+		key := string(encodeAddrUtxoKey(scriptHash, kind, out.Height, &out.OutPoint))
+		if _, exists := createdValues[key]; exists {
+			continue
+		}
+		created = append(created, key)
+		createdValues[key] = encodeAddrUtxoValue(out.TxOut.Value, out.TxLoc, out.Coinbase)
+		deltas.add(scriptHash, kind, out.TxOut.Value, 1)
+		addHistory(encodeAddrHistoryKey(scriptHash, kind, height, out.TxLoc), out.TxOut.Value, 0)
+	}
+
+	var spent [][]byte
+	for _, out := range spentOutputs {
+		scriptHash, kind, ok := addrOutputOwner(out.TxOut.PkScript)
+		if !ok {
+			continue
+		}
+		key := encodeAddrUtxoKey(scriptHash, kind, out.Height, &out.OutPoint)
+		if _, exists := createdValues[string(key)]; exists {
+			// created and spent by the block
+			delete(createdValues, string(key))
+		} else {
+			entry := make([]byte, addrUtxoEntryLen)
+			copy(entry, key)
+			copy(entry[addrUtxoKeyLen:], encodeAddrUtxoValue(out.TxOut.Value, out.TxLoc, out.Coinbase))
+			spent = append(spent, entry)
+			if err := batch.Delete(key); err != nil {
+				return err
+			}
+		}
+		deltas.add(scriptHash, kind, -out.TxOut.Value, -1)
+		addHistory(encodeAddrHistoryKey(scriptHash, kind, height, out.SpentTxLoc), 0, out.TxOut.Value)
+	}
+
+	undo := make([]byte, 12, 12+addrUtxoEntryLen*(len(created)+len(spent))+addrHistoryKeyLen*len(history))
+	nCreated := 0
+	for _, key := range created {
+		value, ok := createdValues[key]
+		if !ok {
+			continue
+		}
+		if err := batch.Put([]byte(key), value); err != nil {
+			return err
+		}
+		undo = append(undo, key...)
+		undo = append(undo, value...)
+		nCreated++
+	}
+	for _, entry := range spent {
+		undo = append(undo, entry...)
+	}
+
+	historyKeys := make([]string, 0, len(history))
+	for key := range history {
+		historyKeys = append(historyKeys, key)
+	}
+	sort.Strings(historyKeys)
+	for _, key := range historyKeys {
+		h := history[key]
+		value := make([]byte, addrHistoryValueLen)
+		binary.LittleEndian.PutUint64(value[0:8], uint64(h[0]))
+		binary.LittleEndian.PutUint64(value[8:16], uint64(h[1]))
+		if err := batch.Put([]byte(key), value); err != nil {
+			return err
+		}
+		undo = append(undo, key...)
+	}
+
+	if err := db.applyAddrBalanceDeltas(batch, deltas); err != nil {
+		return err
+	}
+	if nCreated == 0 && len(spent) == 0 && len(history) == 0 {
+		return nil
+	}
+	binary.LittleEndian.PutUint32(undo[0:4], uint32(nCreated))
+	binary.LittleEndian.PutUint32(undo[4:8], uint32(len(spent)))
+	binary.LittleEndian.PutUint32(undo[8:12], uint32(len(history)))
+	return batch.Put(addrUndoKey(height), undo)
+}
+
+// deleteAddrUtxoIndex reverts the changes of the block at height to the
+// address UTXO index by its undo record.
+func (db *ChainDb) deleteAddrUtxoIndex(batch storage.Batch, height uint64) error {
+	undoKey := addrUndoKey(height)
+	undo, err := db.stor.Get(undoKey)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			// nothing indexed in the block
+			return nil
+		}
+		return err
+	}
+	if len(undo) < 12 {
+		return ErrIncorrectDbData
+	}
+	nCreated := int(binary.LittleEndian.Uint32(undo[0:4]))
+	nSpent := int(binary.LittleEndian.Uint32(undo[4:8]))
+	nHistory := int(binary.LittleEndian.Uint32(undo[8:12]))
+	if len(undo) != 12+addrUtxoEntryLen*(nCreated+nSpent)+addrHistoryKeyLen*nHistory {
+		logging.CPrint(logging.ERROR, "unexpected address utxo undo length", logging.LogFormat{
+			"height":       height,
+			"created":      nCreated,
+			"spent":        nSpent,
+			"history":      nHistory,
+			"value_length": len(undo),
+		})
+		return ErrIncorrectDbData
+	}
+
+	deltas := make(addrBalanceDeltas)
+	cur := 12
+	for i := 0; i < nCreated+nSpent; i++ {
+		key := undo[cur : cur+addrUtxoKeyLen]
+		value := undo[cur+addrUtxoKeyLen : cur+addrUtxoEntryLen]
+		cur += addrUtxoEntryLen
+
+		scriptHash, kind, _, _ := decodeAddrUtxoKey(key)
+		amount, _, _ := decodeAddrUtxoValue(value)
+		if i < nCreated {
+			err = batch.Delete(key)
+			deltas.add(scriptHash, kind, -amount, -1)
+		} else {
+			err = batch.Put(key, value)
+			deltas.add(scriptHash, kind, amount, 1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < nHistory; i++ {
+		if err = batch.Delete(undo[cur : cur+addrHistoryKeyLen]); err != nil {
+			return err
+		}
+		cur += addrHistoryKeyLen
+	}
+
+	if err = db.applyAddrBalanceDeltas(batch, deltas); err != nil {
+		return err
+	}
+	return batch.Delete(undoKey)
+}
+
+func (db *ChainDb) applyAddrBalanceDeltas(batch storage.Batch, deltas addrBalanceDeltas) error {
+	for scriptHash, delta := range deltas {
+		key := addrBalanceKey(scriptHash)
+		value := make([]byte, addrBalanceValueLen)
+		oldValue, err := db.stor.Get(key)
+		if err != nil {
+			if err != storage.ErrNotFound {
+				return err
+			}
+		} else if len(oldValue) != addrBalanceValueLen {
+			return ErrIncorrectDbData
+		} else {
+			copy(value, oldValue)
+		}
+
+		empty := true
+		for kind := range delta {
+			cur := kind * 12
+			balance := int64(binary.LittleEndian.Uint64(value[cur:cur+8])) + delta[kind].value
+			utxos := int64(binary.LittleEndian.Uint32(value[cur+8:cur+12])) + delta[kind].utxos
+			if balance < 0 || utxos < 0 || (utxos == 0 && balance != 0) {
+				logging.CPrint(logging.ERROR, "unexpected address balance", logging.LogFormat{
+					"kind":    database.AddrUtxoKind(kind),
+					"balance": balance,
+					"utxos":   utxos,
+				})
+				return ErrIncorrectDbData
+			}
+			binary.LittleEndian.PutUint64(value[cur:cur+8], uint64(balance))
+			binary.LittleEndian.PutUint32(value[cur+8:cur+12], uint32(utxos))
+			if utxos != 0 {
+				empty = false
+			}
+		}
+		if empty {
+			err = batch.Delete(key)
+		} else {
+			err = batch.Put(key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toScriptHash(scriptHash []byte) ([sha256.Size]byte, error) {
+	var sh [sha256.Size]byte
+	if len(scriptHash) != sha256.Size {
+		return sh, ErrWrongScriptHashLength
+	}
+	copy(sh[:], scriptHash)
+	return sh, nil
+}
+
+func newAmount(value int64) (massutil.Amount, error) {
+	amount, err := massutil.NewAmountFromInt(value)
+	if err != nil {
+		logging.CPrint(logging.ERROR, "invalid amount in address utxo index", logging.LogFormat{
+			"value": value,
+			"err":   err,
+		})
+		return massutil.ZeroAmount(), ErrIncorrectDbData
+	}
+	return amount, nil
+}
+
+// FetchScriptHashBalance returns the balances of the unspent outputs paid to
+// scriptHash, by kind.
+func (db *ChainDb) FetchScriptHashBalance(scriptHash []byte) (*database.AddrBalances, error) {
+	sh, err := toScriptHash(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	balances := &database.AddrBalances{}
+	for kind := range balances {
+		balances[kind].Value = massutil.ZeroAmount()
+	}
+	value, err := db.stor.Get(addrBalanceKey(sh))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return balances, nil
+		}
+		return nil, err
+	}
+	if len(value) != addrBalanceValueLen {
+		return nil, ErrIncorrectDbData
+	}
+	for kind := range balances {
+		cur := kind * 12
+		if balances[kind].Value, err = newAmount(int64(binary.LittleEndian.Uint64(value[cur : cur+8]))); err != nil {
+			return nil, err
+		}
+		balances[kind].Utxos = binary.LittleEndian.Uint32(value[cur+8 : cur+12])
+	}
+	return balances, nil
+}
+
+// FetchScriptHashUtxos returns a page of the unspent outputs of kind paid to
+// scriptHash, ordered by height.
+func (db *ChainDb) FetchScriptHashUtxos(scriptHash []byte, kind database.AddrUtxoKind, offset, limit int) ([]*database.UtxoReply, error) {
+	var replies []*database.UtxoReply
+	err := db.forEachAddrIndexPage(addrUtxoPrefix, scriptHash, kind, offset, limit, func(key, value []byte) error {
+		if len(key) != addrUtxoKeyLen || len(value) != addrUtxoValueLen {
+			return ErrIncorrectDbData
+		}
+		_, _, height, op := decodeAddrUtxoKey(key)
+		amount, _, coinbase := decodeAddrUtxoValue(value)
+		reply := &database.UtxoReply{
+			TxSha:    &op.Hash,
+			Height:   height,
+			Coinbase: coinbase,
+			Index:    op.Index,
+		}
+		var err error
+		if reply.Value, err = newAmount(amount); err != nil {
+			return err
+		}
+		replies = append(replies, reply)
+		return nil
+	})
+	return replies, err
+}
+
+// FetchScriptHashHistory returns a page of the transactions paying to or
+// spending outputs of kind paid to scriptHash, ordered by height.
+func (db *ChainDb) FetchScriptHashHistory(scriptHash []byte, kind database.AddrUtxoKind, offset, limit int) ([]*database.AddrHistory, error) {
+	var list []*database.AddrHistory
+	err := db.forEachAddrIndexPage(addrHistoryPrefix, scriptHash, kind, offset, limit, func(key, value []byte) error {
+		if len(key) != addrHistoryKeyLen || len(value) != addrHistoryValueLen {
+			return ErrIncorrectDbData
+		}
+		history := &database.AddrHistory{
+			Height: binary.BigEndian.Uint64(key[36:44]),
+			TxLoc: &wire.TxLoc{
+				TxStart: int(binary.BigEndian.Uint32(key[44:48])),
+				TxLen:   int(binary.BigEndian.Uint32(key[48:52])),
+			},
+		}
+		var err error
+		if history.Received, err = newAmount(int64(binary.LittleEndian.Uint64(value[0:8]))); err != nil {
+			return err
+		}
+		if history.Spent, err = newAmount(int64(binary.LittleEndian.Uint64(value[8:16]))); err != nil {
+			return err
+		}
+		list = append(list, history)
+		return nil
+	})
+	return list, err
+}
+
+// forEachAddrIndexPage calls fn with the records of prefix for scriptHash and
+// kind from offset, at most limit records if limit is not 0.  The records are
+// read from a snapshot, so that a page is not torn by a concurrent Commit.
+func (db *ChainDb) forEachAddrIndexPage(prefix, scriptHash []byte, kind database.AddrUtxoKind, offset, limit int, fn func(key, value []byte) error) error {
+	sh, err := toScriptHash(scriptHash)
+	if err != nil {
+		return err
+	}
+	if kind >= database.AddrUtxoKindCount {
+		return ErrWrongAddrUtxoKind
+	}
+	searchKey := addrUtxoSearchKey(sh, kind)
+	copy(searchKey, prefix)
+
+	snap, err := db.stor.NewSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	iter := snap.NewIterator(storage.BytesPrefix(searchKey))
+	defer iter.Release()
+	for n := 0; iter.Next(); n++ {
+		if n < offset {
+			continue
+		}
+		if limit > 0 && n >= offset+limit {
+			break
+		}
+		if err = fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
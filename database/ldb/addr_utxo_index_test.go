@@ -0,0 +1,146 @@
+package ldb_test
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangxinyu2018/mass-core/config"
+	"github.com/wangxinyu2018/mass-core/consensus"
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/ldb"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/txscript"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+func TestLevelDb_AddrUtxoIndex(t *testing.T) {
+	db, tearDown, err := GetDb("DbTest")
+	assert.Nil(t, err)
+	defer tearDown()
+
+	sh := sha256.Sum256([]byte("holder"))
+	normal, err := txscript.PayToWitnessScriptHashScript(sh[:])
+	assert.Nil(t, err)
+	stakingAddr, err := massutil.NewAddressStakingScriptHash(sh[:], &config.ChainParams)
+	assert.Nil(t, err)
+	staking, err := txscript.PayToStakingAddrScript(stakingAddr, consensus.MinFrozenPeriod)
+	assert.Nil(t, err)
+	binding, err := txscript.PayToBindingScriptHashScript(sh[:], make([]byte, 20))
+	assert.Nil(t, err)
+
+	loc := func(start int) *wire.TxLoc {
+		return &wire.TxLoc{TxStart: start, TxLen: 100}
+	}
+	output := func(height uint64, txLoc *wire.TxLoc, index uint32, value int64, pkScript []byte) *database.AddrIndexOutput {
+		return &database.AddrIndexOutput{
+			OutPoint: wire.OutPoint{Hash: wire.DoubleHashH([]byte{byte(height), byte(txLoc.TxStart)}), Index: index},
+			TxOut:    wire.NewTxOut(value, pkScript),
+			Height:   height,
+			TxLoc:    txLoc,
+		}
+	}
+	spend := func(out *database.AddrIndexOutput, txLoc *wire.TxLoc) *database.AddrIndexOutput {
+		spent := *out
+		spent.SpentTxLoc = txLoc
+		return &spent
+	}
+
+	// block 1 pays to each kind, block 2 spends the normal output, and
+	// spends one of its outputs in itself
+	normal1 := output(1, loc(10), 0, 100, normal)
+	normal2 := output(2, loc(20), 0, 40, normal)
+	normal2Spent := output(2, loc(20), 1, 50, normal)
+	data := []*database.AddrIndexData{
+		{
+			Outputs: []*database.AddrIndexOutput{
+				normal1,
+				output(1, loc(10), 1, 200, staking),
+				output(1, loc(110), 0, 300, binding),
+			},
+		},
+		{
+			Outputs:      []*database.AddrIndexOutput{normal2, normal2Spent},
+			SpentOutputs: []*database.AddrIndexOutput{spend(normal1, loc(20)), spend(normal2Spent, loc(120))},
+		},
+	}
+	for i, d := range data {
+		blk := blks200[i+1]
+		assert.Nil(t, db.SubmitBlock(blk))
+		assert.Nil(t, db.SubmitAddrIndex(blk.Hash(), blk.Height(), d))
+		assert.Nil(t, db.Commit(*blk.Hash()))
+	}
+
+	checkBalances := func(values []int64, utxos []uint32) {
+		balances, err := db.FetchScriptHashBalance(sh[:])
+		assert.Nil(t, err)
+		for kind := range balances {
+			assert.Equal(t, values[kind], balances[kind].Value.IntValue())
+			assert.Equal(t, utxos[kind], balances[kind].Utxos)
+		}
+	}
+	checkBalances([]int64{40, 200, 300}, []uint32{1, 1, 1})
+
+	utxos, err := db.FetchScriptHashUtxos(sh[:], database.AddrUtxoNormal, 0, 0)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(utxos)) {
+		assert.Equal(t, normal2.OutPoint.Hash, *utxos[0].TxSha)
+		assert.Equal(t, uint64(2), utxos[0].Height)
+		assert.Equal(t, int64(40), utxos[0].Value.IntValue())
+	}
+	utxos, err = db.FetchScriptHashUtxos(sh[:], database.AddrUtxoBinding, 0, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(utxos)) {
+		assert.Equal(t, int64(300), utxos[0].Value.IntValue())
+	}
+
+	history, err := db.FetchScriptHashHistory(sh[:], database.AddrUtxoNormal, 0, 0)
+	assert.Nil(t, err)
+	expected := []struct {
+		height          uint64
+		txStart         int
+		received, spent int64
+	}{
+		{1, 10, 100, 0},
+		{2, 20, 90, 100},
+		{2, 120, 0, 50},
+	}
+	if assert.Equal(t, len(expected), len(history)) {
+		for i, h := range history {
+			assert.Equal(t, expected[i].height, h.Height)
+			assert.Equal(t, expected[i].txStart, h.TxLoc.TxStart)
+			assert.Equal(t, expected[i].received, h.Received.IntValue())
+			assert.Equal(t, expected[i].spent, h.Spent.IntValue())
+		}
+	}
+	history, err = db.FetchScriptHashHistory(sh[:], database.AddrUtxoNormal, 1, 1)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(history)) {
+		assert.Equal(t, 20, history[0].TxLoc.TxStart)
+	}
+	history, err = db.FetchScriptHashHistory(sh[:], database.AddrUtxoStaking, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history))
+
+	// detach block 2
+	blk := blks200[2]
+	assert.Nil(t, db.DeleteBlock(blk.Hash()))
+	assert.Nil(t, db.DeleteAddrIndex(blk.Hash(), blk.Height()))
+	assert.Nil(t, db.Commit(*blk.Hash()))
+
+	checkBalances([]int64{100, 200, 300}, []uint32{1, 1, 1})
+	utxos, err = db.FetchScriptHashUtxos(sh[:], database.AddrUtxoNormal, 0, 0)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(utxos)) {
+		assert.Equal(t, normal1.OutPoint.Hash, *utxos[0].TxSha)
+		assert.Equal(t, uint64(1), utxos[0].Height)
+	}
+	history, err = db.FetchScriptHashHistory(sh[:], database.AddrUtxoNormal, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history))
+
+	_, err = db.FetchScriptHashUtxos(sh[:], database.AddrUtxoKindCount, 0, 0)
+	assert.Equal(t, ldb.ErrWrongAddrUtxoKind, err)
+	_, err = db.FetchScriptHashBalance(sh[:20])
+	assert.Equal(t, ldb.ErrWrongScriptHashLength, err)
+}
@@ -0,0 +1,173 @@
+package ldb
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/wangxinyu2018/mass-core/database/disk"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// backupBatchSize is the size of keys and values written to the copied
+// storage in one batch.
+const backupBatchSize = 4 * storage.MiB
+
+// Backup copies the database along with its version file and block files into
+// dir, laid out as in the chain store directory.  Commits are paused during the
+// copy, fn is called before they resume to copy the stores which are written
+// along with the database.  It returns the hash and height of the copied tip.
+func (db *ChainDb) Backup(dir string, fn func() error) (*wire.Hash, uint64, error) {
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	db.dbLock.Lock()
+	meta := db.dbStorageMeta
+	snapshot, err := db.stor.NewSnapshot()
+	db.dbLock.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer snapshot.Release()
+
+	logging.CPrint(logging.INFO, "backing up chain database", logging.LogFormat{
+		"dir":    dir,
+		"height": meta.currentHeight,
+		"hash":   meta.currentHash,
+	})
+	if err = backupStorage(snapshot, db.dbPath, filepath.Join(dir, filepath.Base(db.dbPath))); err != nil {
+		return nil, 0, err
+	}
+	srcBlkDir := filepath.Join(filepath.Dir(db.dbPath), blockFileDir)
+	if err = backupBlockFiles(snapshot, srcBlkDir, filepath.Join(dir, blockFileDir)); err != nil {
+		return nil, 0, err
+	}
+	if fn != nil {
+		if err = fn(); err != nil {
+			return nil, 0, err
+		}
+	}
+	hash := meta.currentHash
+	return &hash, meta.currentHeight, nil
+}
+
+// backupStorage writes the records of snapshot to a new storage in dst, of the
+// type recorded in the version file of the storage in srcPath, which is copied
+// too.
+func backupStorage(snapshot storage.Snapshot, srcPath, dst string) error {
+	verData, err := ioutil.ReadFile(filepath.Join(srcPath, storage.VersionFile))
+	if err != nil {
+		return err
+	}
+	dbtype, _, err := storage.ReadVersion(filepath.Join(srcPath, storage.VersionFile))
+	if err != nil {
+		return err
+	}
+	stor, err := storage.CreateStorage(dbtype, dst)
+	if err != nil {
+		return err
+	}
+	defer stor.Close()
+
+	batch := stor.NewBatch()
+	defer batch.Release()
+	size := 0
+	iter := snapshot.NewIterator(nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err = batch.Put(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+		size += len(iter.Key()) + len(iter.Value())
+		if size >= backupBatchSize {
+			if err = stor.Write(batch); err != nil {
+				return err
+			}
+			batch.Reset()
+			size = 0
+		}
+	}
+	if err = iter.Error(); err != nil {
+		return err
+	}
+	if err = stor.Write(batch); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dst, storage.VersionFile), verData, 0644)
+}
+
+// backupBlockFiles copies the block files recorded in snapshot from srcDir to
+// dstDir, up to the size of their committed blocks.  Pruned files are skipped.
+func backupBlockFiles(snapshot storage.Snapshot, srcDir, dstDir string) error {
+	metas, err := readAllBlockFileMeta(snapshot)
+	if err != nil {
+		return err
+	}
+	pruned, err := readPrunedBlockFiles(snapshot)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dstDir, 0755); err != nil {
+		return err
+	}
+
+	src := disk.NewFlatFileSeq(srcDir, "blk", disk.BlockfileChunkSize)
+	dst := disk.NewFlatFileSeq(dstDir, "blk", disk.BlockfileChunkSize)
+	for _, meta := range metas {
+		fileNo := binary.LittleEndian.Uint32(meta[0:4])
+		size := int64(binary.LittleEndian.Uint64(meta[8:16]))
+		if containsUint32(pruned, fileNo) {
+			continue
+		}
+		pos := disk.NewFlatFilePos(fileNo, 0)
+		if size == 0 {
+			// the latest file may not have been created yet
+			exist, err := src.ExistFile(pos)
+			if err != nil {
+				return err
+			}
+			if !exist {
+				continue
+			}
+		}
+		if err = copyFilePrefix(dst.FilePath(pos), src.FilePath(pos), size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFilePrefix copies the first size bytes of file src to a new file dst.
+func copyFilePrefix(dst, src string, size int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(out, in, size); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func containsUint32(list []uint32, n uint32) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package ldb_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangxinyu2018/mass-core/database"
+)
+
+func TestLevelDb_Backup(t *testing.T) {
+	db, tearDown, err := GetDb("DbTest")
+	assert.Nil(t, err)
+	defer tearDown()
+
+	err = initBlocks(db, 10)
+	assert.Nil(t, err)
+	tipSha, tipHeight, err := db.NewestSha()
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "backup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	called := false
+	sha, height, err := db.Backup(dir, func() error {
+		called = true
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.True(t, called)
+	assert.Equal(t, tipSha, sha)
+	assert.Equal(t, tipHeight, height)
+
+	copied, err := database.OpenDB(dbtype, filepath.Join(dir, "DbTest"), true)
+	assert.Nil(t, err)
+	defer copied.Close()
+	sha, height, err = copied.NewestSha()
+	assert.Nil(t, err)
+	assert.Equal(t, tipSha, sha)
+	assert.Equal(t, tipHeight, height)
+	for _, blk := range blks200[:10] {
+		copiedBlk, err := copied.FetchBlockBySha(blk.Hash())
+		assert.Nil(t, err)
+		assert.Equal(t, blk.Hash(), copiedBlk.Hash())
+	}
+}
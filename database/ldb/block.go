@@ -7,6 +7,7 @@ import (
 
 	"github.com/golang/protobuf/proto"
 	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/disk"
 	"github.com/wangxinyu2018/mass-core/database/storage"
 	"github.com/wangxinyu2018/mass-core/debug"
 	"github.com/wangxinyu2018/mass-core/errors"
@@ -498,6 +499,9 @@ func (db *ChainDb) getBlkByHeight(blkHeight uint64) (rsha *wire.Hash, rbuf []byt
 
 	rbuf, err = db.blkFileKeeper.ReadRawBlock(fileNo, offset, int(blkSize))
 	if err != nil {
+		if err == disk.ErrFilePruned {
+			return nil, nil, database.ErrBlockPruned
+		}
 		logging.CPrint(logging.ERROR, "failed to read raw block", logging.LogFormat{"height": blkHeight, "err": err})
 		return nil, nil, err
 	}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/wangxinyu2018/mass-core/database/disk"
 	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/logging"
 	"github.com/wangxinyu2018/mass-core/massutil"
 )
 
@@ -30,6 +31,23 @@ var (
 	//		[32:40] - timestamp of lowest block
 	//		[40:48] - timestamp of highest block
 	blockFilePrefix = []byte("fb")
+
+	// marks a deleted blkXXXXX.dat in pruned mode, value is empty
+	//
+	// key is 6 bytes:
+	//      [0:2]   - prunedBlockFilePrefix
+	//		[2:6] 	- number of file
+	prunedBlockFilePrefix = []byte("fp")
+
+	// value is 2-bytes record version of blkXXXXX.dat, see disk.RecordVersionLegacy
+	// and disk.RecordVersionChecksum, a missing key means the legacy version
+	// LittleEndian
+	blockFileVersionKey = []byte("fversion")
+
+	// value is 4-bytes number of the blkXXXXX.dat being replaced by the block
+	// file record upgrade, the key is only present during the replacement
+	// LittleEndian
+	blockFileMigrateKey = []byte("fmigrate")
 )
 
 func putRawBlockIndex(batch storage.Batch, blk *massutil.Block, blkFile *disk.BlockFile, offset, blkSize int64) error {
@@ -85,17 +103,28 @@ func (db *ChainDb) initBlockFileMeta() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	var b2 [2]byte
+	binary.LittleEndian.PutUint16(b2[:], disk.CurrentRecordVersion)
+	if err = db.stor.Put(blockFileVersionKey, b2[:]); err != nil {
+		return nil, err
+	}
 	var file0 [48]byte
 	key := append(blockFilePrefix, file0[0:4]...)
 	return file0[:], db.stor.Put(key, file0[:])
 }
 
 func (db *ChainDb) getAllBlockFileMeta() ([][]byte, error) {
+	return readAllBlockFileMeta(db.stor)
+}
+
+// readAllBlockFileMeta returns the metas of all block files recorded in r,
+// which may be a snapshot of the storage.
+func readAllBlockFileMeta(r storage.Reader) ([][]byte, error) {
 
 	metas := make([][]byte, 0)
 
 	total := uint32(0)
-	iter := db.stor.NewIterator(storage.BytesPrefix(blockFilePrefix))
+	iter := r.NewIterator(storage.BytesPrefix(blockFilePrefix))
 	defer iter.Release()
 	for iter.Next() {
 		key := iter.Key()
@@ -131,3 +160,85 @@ func (db *ChainDb) getAllBlockFileMeta() ([][]byte, error) {
 	}
 	return metas, nil
 }
+
+func makePrunedBlockFileKey(fileNo uint32) []byte {
+	key := make([]byte, len(prunedBlockFilePrefix)+4)
+	copy(key, prunedBlockFilePrefix)
+	binary.LittleEndian.PutUint32(key[len(prunedBlockFilePrefix):], fileNo)
+	return key
+}
+
+// getPrunedBlockFiles returns numbers of all deleted block files
+func (db *ChainDb) getPrunedBlockFiles() ([]uint32, error) {
+	return readPrunedBlockFiles(db.stor)
+}
+
+func readPrunedBlockFiles(r storage.Reader) ([]uint32, error) {
+	pruned := make([]uint32, 0)
+	iter := r.NewIterator(storage.BytesPrefix(prunedBlockFilePrefix))
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != len(prunedBlockFilePrefix)+4 {
+			return nil, fmt.Errorf("unknown pruned block file key found: %v", key)
+		}
+		pruned = append(pruned, binary.LittleEndian.Uint32(key[len(prunedBlockFilePrefix):]))
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return pruned, nil
+}
+
+// getBlockFileVersion returns the record version of the block files.
+func (db *ChainDb) getBlockFileVersion() (uint16, error) {
+	value, err := db.stor.Get(blockFileVersionKey)
+	if err == storage.ErrNotFound {
+		return disk.RecordVersionLegacy, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 2 {
+		return 0, ErrIncorrectValueLength
+	}
+	return binary.LittleEndian.Uint16(value), nil
+}
+
+func putBlockFileVersion(batch storage.Batch, version uint16) error {
+	var b2 [2]byte
+	binary.LittleEndian.PutUint16(b2[:], version)
+	return batch.Put(blockFileVersionKey, b2[:])
+}
+
+// recoverBlockFileMigration finishes the replacement of a block file which was
+// interrupted after its new offsets had been saved.
+func (db *ChainDb) recoverBlockFileMigration() error {
+	value, err := db.stor.Get(blockFileMigrateKey)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(value) != 4 {
+		return ErrIncorrectValueLength
+	}
+	fileNo := binary.LittleEndian.Uint32(value)
+	meta, err := db.stor.Get(append(blockFilePrefix, value...))
+	if err != nil {
+		return err
+	}
+	if len(meta) != 48 {
+		return ErrInvalidBlockFileMeta
+	}
+	if err = db.blkFileKeeper.CommitMigratedFile(fileNo, binary.LittleEndian.Uint64(meta[8:16])); err != nil {
+		return err
+	}
+	logging.CPrint(logging.INFO, fmt.Sprintf("recovered migration of blk%05d.dat", fileNo), logging.LogFormat{})
+	if err = db.stor.Delete(blockFileMigrateKey); err != nil {
+		// harmless, the replacement is repeated on next open
+		logging.CPrint(logging.WARN, "delete block file migration marker error", logging.LogFormat{"err": err})
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package ldb
+
+import (
+	"encoding/binary"
+
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	cfIndexKeyLength      = 3 + 8
+	cfIndexTipValueLength = 32 + 8
+	minCFIndexValueLength = 32 + 32
+)
+
+var (
+	// compact filter of each main chain block
+	//
+	// key is 11 bytes:
+	//      [0:3]   - cfIndexPrefix
+	//      [3:11]  - block height, BigEndian
+	// value's structure is:
+	//      [0:32]  - block hash
+	//      [32:64] - filter header
+	//      [64:]   - filter prefixed with item count
+	cfIndexPrefix = []byte("CFI")
+
+	// value is 40 bytes:
+	//      [0:32]  - hash of the latest indexed block
+	//      [32:40] - height of the latest indexed block, LittleEndian
+	cfIndexTipKey = []byte("CFTIP")
+)
+
+func makeCFIndexKey(height uint64) []byte {
+	key := make([]byte, cfIndexKeyLength)
+	copy(key, cfIndexPrefix)
+	binary.BigEndian.PutUint64(key[3:], height)
+	return key
+}
+
+func encodeCFIndexTip(hash *wire.Hash, height uint64) []byte {
+	value := make([]byte, cfIndexTipValueLength)
+	copy(value, hash[:])
+	binary.LittleEndian.PutUint64(value[32:], height)
+	return value
+}
+
+// indexBatch returns the batch of the block being submitted or deleted if it
+// is the given block, so that an optional index is committed along with the
+// block. Otherwise a standalone batch is returned for indexing committed blocks.
+func (db *ChainDb) indexBatch(hash *wire.Hash) (batch storage.Batch, standalone bool) {
+	blkBatch := db.Batch(blockBatch)
+	if blkBatch.done && hash.IsEqual(&blkBatch.block) {
+		return blkBatch.Batch(), false
+	}
+	return db.stor.NewBatch(), true
+}
+
+// FetchCFilterTip returns the hash and height of the latest block which has
+// had its compact filter indexed. It returns ErrCFilterIndexDoesNotExist if
+// no filter has been indexed.
+func (db *ChainDb) FetchCFilterTip() (*wire.Hash, uint64, error) {
+	value, err := db.stor.Get(cfIndexTipKey)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return &wire.Hash{}, UnknownHeight, database.ErrCFilterIndexDoesNotExist
+		}
+		return nil, 0, err
+	}
+	if len(value) != cfIndexTipValueLength {
+		return nil, 0, ErrIncorrectValueLength
+	}
+	var hash wire.Hash
+	copy(hash[:], value[:32])
+	return &hash, binary.LittleEndian.Uint64(value[32:]), nil
+}
+
+// SubmitCFilter puts the compact filter and filter header of block, and moves
+// the tip of compact filter index to the block.
+func (db *ChainDb) SubmitCFilter(hash *wire.Hash, height uint64, header *wire.Hash, filter []byte) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	batch, standalone := db.indexBatch(hash)
+	if standalone {
+		defer batch.Release()
+	}
+
+	value := make([]byte, minCFIndexValueLength+len(filter))
+	copy(value, hash[:])
+	copy(value[32:], header[:])
+	copy(value[64:], filter)
+	if err := batch.Put(makeCFIndexKey(height), value); err != nil {
+		return err
+	}
+	if err := batch.Put(cfIndexTipKey, encodeCFIndexTip(hash, height)); err != nil {
+		return err
+	}
+
+	if standalone {
+		return db.stor.Write(batch)
+	}
+	return nil
+}
+
+// DeleteCFilter removes the compact filter of block, which must be the tip of
+// compact filter index, and moves the tip to its parent.
+func (db *ChainDb) DeleteCFilter(hash *wire.Hash, height uint64) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	batch, standalone := db.indexBatch(hash)
+	if standalone {
+		defer batch.Release()
+	}
+
+	if err := batch.Delete(makeCFIndexKey(height)); err != nil {
+		return err
+	}
+	if height == 0 {
+		if err := batch.Delete(cfIndexTipKey); err != nil {
+			return err
+		}
+	} else {
+		prevHash, _, _, err := db.fetchCFilterByHeight(height - 1)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(cfIndexTipKey, encodeCFIndexTip(prevHash, height-1)); err != nil {
+			return err
+		}
+	}
+
+	if standalone {
+		return db.stor.Write(batch)
+	}
+	return nil
+}
+
+// FetchCFilterByHeight returns the block hash, filter header and compact
+// filter of main chain block at height.
+func (db *ChainDb) FetchCFilterByHeight(height uint64) (*wire.Hash, *wire.Hash, []byte, error) {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+	return db.fetchCFilterByHeight(height)
+}
+
+func (db *ChainDb) fetchCFilterByHeight(height uint64) (*wire.Hash, *wire.Hash, []byte, error) {
+	value, err := db.stor.Get(makeCFIndexKey(height))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, nil, nil, database.ErrCFilterMissing
+		}
+		return nil, nil, nil, err
+	}
+	if len(value) < minCFIndexValueLength {
+		return nil, nil, nil, ErrIncorrectValueLength
+	}
+	var hash, header wire.Hash
+	copy(hash[:], value[:32])
+	copy(header[:], value[32:64])
+	filter := make([]byte, len(value)-minCFIndexValueLength)
+	copy(filter, value[64:])
+	return &hash, &header, filter, nil
+}
@@ -0,0 +1,65 @@
+package ldb_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+func TestLevelDb_CFilterIndex(t *testing.T) {
+	db, tearDown, err := GetDb("DbTest")
+	assert.Nil(t, err)
+	defer tearDown()
+
+	err = initBlocks(db, 3)
+	assert.Nil(t, err)
+
+	_, _, err = db.FetchCFilterTip()
+	assert.Equal(t, database.ErrCFilterIndexDoesNotExist, err)
+
+	headers := make([]wire.Hash, 0, 3)
+	for height := uint64(0); height < 3; height++ {
+		sha, err := db.FetchBlockShaByHeight(height)
+		assert.Nil(t, err)
+		header := wire.DoubleHashH(sha[:])
+		headers = append(headers, header)
+		err = db.SubmitCFilter(sha, height, &header, []byte{byte(height), 0xff})
+		assert.Nil(t, err)
+
+		tipSha, tipHeight, err := db.FetchCFilterTip()
+		assert.Nil(t, err)
+		assert.Equal(t, sha, tipSha)
+		assert.Equal(t, height, tipHeight)
+	}
+
+	sha1, err := db.FetchBlockShaByHeight(1)
+	assert.Nil(t, err)
+	rSha, rHeader, filter, err := db.FetchCFilterByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, sha1, rSha)
+	assert.Equal(t, headers[1], *rHeader)
+	assert.Equal(t, []byte{1, 0xff}, filter)
+
+	// delete tip, the tip moves back to parent
+	sha2, err := db.FetchBlockShaByHeight(2)
+	assert.Nil(t, err)
+	err = db.DeleteCFilter(sha2, 2)
+	assert.Nil(t, err)
+	tipSha, tipHeight, err := db.FetchCFilterTip()
+	assert.Nil(t, err)
+	assert.Equal(t, sha1, tipSha)
+	assert.Equal(t, uint64(1), tipHeight)
+	_, _, _, err = db.FetchCFilterByHeight(2)
+	assert.Equal(t, database.ErrCFilterMissing, err)
+
+	err = db.DeleteCFilter(sha1, 1)
+	assert.Nil(t, err)
+	sha0, err := db.FetchBlockShaByHeight(0)
+	assert.Nil(t, err)
+	err = db.DeleteCFilter(sha0, 0)
+	assert.Nil(t, err)
+	_, _, err = db.FetchCFilterTip()
+	assert.Equal(t, database.ErrCFilterIndexDoesNotExist, err)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/wangxinyu2018/mass-core/database"
 	"github.com/wangxinyu2018/mass-core/database/disk"
 	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/database/storage/instrumented"
 	_ "github.com/wangxinyu2018/mass-core/database/storage/ldbstorage"
 	_ "github.com/wangxinyu2018/mass-core/database/storage/rdbstorage"
 	"github.com/wangxinyu2018/mass-core/errors"
@@ -24,6 +25,9 @@ const (
 	addrIndexBatch = 1
 
 	blockStorageMetaDataLength = 40
+
+	// blockFileDir is the directory of block files, next to the database
+	blockFileDir = "blocks"
 )
 
 var (
@@ -57,6 +61,10 @@ type ChainDb struct {
 	// lock preventing multiple entry
 	dbLock sync.Mutex
 
+	// commitLock is held by Commit, and by Backup to pause commits
+	commitLock sync.Mutex
+
+	dbPath        string
 	stor          storage.Storage
 	blkFileKeeper *disk.BlockFileKeeper
 
@@ -71,7 +79,49 @@ type ChainDb struct {
 	expiredStakingTxMap map[stakingTxMapKey]*stakingTx
 }
 
+// KeyPrefixes names the key prefixes of the ChainDb records, the operations on
+// them are counted separately by the instrumented storage drivers.
+var KeyPrefixes = []instrumented.Prefix{
+	{Name: "meta", Bytes: dbStorageMetaDataKey},
+	{Name: "block_height", Bytes: blockHeightKeyPrefix},
+	{Name: "block_sha", Bytes: blockShaKeyPrefix},
+	{Name: "block_file", Bytes: blockFilePrefix},
+	{Name: "block_file_latest", Bytes: latestBlockFileNumKey},
+	{Name: "pruned_block_file", Bytes: prunedBlockFilePrefix},
+	{Name: "block_file_version", Bytes: blockFileVersionKey},
+	{Name: "block_file_migrate", Bytes: blockFileMigrateKey},
+	{Name: "tx", Bytes: recordSuffixTx},
+	{Name: "spent", Bytes: recordSuffixSpentTx},
+	{Name: "staking", Bytes: recordStakingTx},
+	{Name: "expired_staking", Bytes: recordExpiredStakingTx},
+	{Name: "addr_index_version", Bytes: addrIndexVersionKey},
+	{Name: "tx_index", Bytes: txIndexPrefix},
+	{Name: "sh_index", Bytes: shIndexPrefix},
+	{Name: "binding_tx_index", Bytes: bindingTxIndexPrefix},
+	{Name: "binding_sh_index", Bytes: bindingShIndexPrefix},
+	{Name: "binding_spent_index", Bytes: bindingTxSpentIndexPrefix},
+	{Name: "addr_utxo", Bytes: addrUtxoPrefix},
+	{Name: "addr_balance", Bytes: addrBalancePrefix},
+	{Name: "addr_history", Bytes: addrHistoryPrefix},
+	{Name: "addr_undo", Bytes: addrUndoPrefix},
+	{Name: "fault_pk", Bytes: faultPkShaDataPrefix},
+	{Name: "fault_pk_height", Bytes: faultPkHeightShaPrefix},
+	{Name: "punishment", Bytes: punishmentPrefix},
+	{Name: "mined_block", Bytes: minedBlockIndexPrefix},
+	{Name: "cfilter", Bytes: cfIndexPrefix},
+	{Name: "cfilter_tip", Bytes: cfIndexTipKey},
+	{Name: "spent_by", Bytes: spentIndexPrefix},
+	{Name: "spent_by_block", Bytes: spentIndexBlockPrefix},
+	{Name: "spent_by_tip", Bytes: spentIndexTipKey},
+	{Name: "migration", Bytes: storage.MigrationStateKey},
+}
+
 func init() {
+	// Wrap the storage drivers before registering the database drivers,
+	// so that the instrumented types are available to database.OpenDB too.
+	for _, dbtype := range storage.RegisteredDbTypes() {
+		storage.RegisterDriver(instrumented.NewDriver(dbtype, KeyPrefixes))
+	}
 	for _, dbtype := range storage.RegisteredDbTypes() {
 		tp := dbtype
 		database.AddDBDriver(database.DriverDB{
@@ -89,6 +139,11 @@ func init() {
 				if err != nil {
 					return nil, err
 				}
+				err = storage.WriteVersion(filepath.Join(dbpath, storage.VersionFile), tp, storage.CurrentStorageVersion)
+				if err != nil {
+					stor.Close()
+					return nil, err
+				}
 				return NewChainDb(dbpath, stor)
 			},
 			OpenDB: func(path string, readonly bool, args ...interface{}) (database.Db, error) {
@@ -96,14 +151,40 @@ func init() {
 				if err != nil {
 					return nil, err
 				}
-				return NewChainDb(path, stor)
+				db, err := NewChainDb(path, stor)
+				if err != nil {
+					return nil, err
+				}
+				if err = upgradeChainDb(db, path, tp, readonly); err != nil {
+					db.Close()
+					return nil, err
+				}
+				return db, nil
 			},
 		})
 	}
 }
 
+// upgradeChainDb runs the storage migrations pending on db, a read-only db is
+// refused if there are any.
+func upgradeChainDb(db *ChainDb, path, dbtype string, readonly bool) error {
+	plans, err := storage.Upgrade(db, db.stor, path, dbtype, &storage.MigrationOptions{DryRun: readonly})
+	if err != nil {
+		return err
+	}
+	if readonly && len(plans) > 0 {
+		logging.CPrint(logging.ERROR, "storage migration required", logging.LogFormat{
+			"path":    path,
+			"version": plans[len(plans)-1].Version,
+		})
+		return storage.ErrIncompatibleStorage
+	}
+	return nil
+}
+
 func NewChainDb(dbpath string, stor storage.Storage) (*ChainDb, error) {
 	cdb := &ChainDb{
+		dbPath:              dbpath,
 		stor:                stor,
 		dbBatch:             stor.NewBatch(),
 		txUpdateMap:         make(map[wire.Hash]*txUpdateObj),
@@ -141,7 +222,7 @@ func NewChainDb(dbpath string, stor storage.Storage) (*ChainDb, error) {
 	}
 
 	// init or load blkXXXXX.dat
-	blkDir := filepath.Join(filepath.Dir(dbpath), "blocks")
+	blkDir := filepath.Join(filepath.Dir(dbpath), blockFileDir)
 	fi, err := os.Stat(blkDir)
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -166,7 +247,18 @@ func NewChainDb(dbpath string, stor storage.Storage) (*ChainDb, error) {
 		}
 		records = append(records, file0)
 	}
-	cdb.blkFileKeeper = disk.NewBlockFileKeeper(blkDir, records)
+	pruned, err := cdb.getPrunedBlockFiles()
+	if err != nil {
+		return nil, err
+	}
+	cdb.blkFileKeeper = disk.NewBlockFileKeeper(blkDir, records, pruned)
+	if cdb.blkFileKeeper == nil {
+		return nil, ErrInvalidBlockFileMeta
+	}
+	if err = cdb.recoverBlockFileMigration(); err != nil {
+		cdb.blkFileKeeper.Close()
+		return nil, err
+	}
 	return cdb, nil
 }
 
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"sort"
 
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/disk"
 	"github.com/wangxinyu2018/mass-core/database/storage"
 	"github.com/wangxinyu2018/mass-core/errors"
 	"github.com/wangxinyu2018/mass-core/logging"
@@ -497,3 +500,374 @@ func buildTxIndex(db *ChainDb, progStage string) error {
 	}
 	return writeTxIndex(db, currentStlMap)
 }
+
+// UpgradeBlockFileRecords rewrites the legacy records of all block files which
+// are not pruned with checksums.  Files are replaced one by one, an interrupted
+// upgrade is resumed by calling it again.
+func (db *ChainDb) UpgradeBlockFileRecords() error {
+	return db.upgradeBlockFileRecords(nil)
+}
+
+// upgradeBlockFileRecords rewrites the block files in the order of their
+// numbers, saving the number of the next file to ctx after each file, so that
+// a resumed migration does not read the files done again.
+func (db *ChainDb) upgradeBlockFileRecords(ctx *storage.MigrationContext) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	version, err := db.getBlockFileVersion()
+	if err != nil {
+		return err
+	}
+	if version >= disk.CurrentRecordVersion {
+		return nil
+	}
+	fbs, err := db.getAllBlockFileMeta()
+	if err != nil {
+		return err
+	}
+	sort.Slice(fbs, func(i, j int) bool {
+		return binary.LittleEndian.Uint32(fbs[i][0:4]) < binary.LittleEndian.Uint32(fbs[j][0:4])
+	})
+	next, resumed := migrationCheckpoint(ctx)
+
+	progStage := "[1/1]"
+	logging.CPrint(logging.INFO, fmt.Sprintf("%s checksum block records", progStage), logging.LogFormat{
+		"files":       len(fbs),
+		"resume_file": next,
+	})
+	prog := 0
+	for i, fb := range fbs {
+		fileNo := binary.LittleEndian.Uint32(fb[0:4])
+		if resumed && uint64(fileNo) < next {
+			continue
+		}
+		if err = migrateBlockFile(db, fb); err != nil {
+			logging.CPrint(logging.ERROR, "migrate block file error", logging.LogFormat{
+				"fileNo": fileNo,
+				"err":    err,
+			})
+			return err
+		}
+		if err = saveMigrationCheckpoint(ctx, nil, uint64(fileNo)+1); err != nil {
+			return err
+		}
+		newProg := (i + 1) * 100 / len(fbs)
+		if newProg >= prog+5 {
+			prog = newProg
+			logging.CPrint(logging.INFO, fmt.Sprintf("%s %d%%", progStage, prog), logging.LogFormat{})
+		}
+	}
+
+	batch := db.stor.NewBatch()
+	if err = putBlockFileVersion(batch, disk.CurrentRecordVersion); err != nil {
+		return err
+	}
+	if err = db.stor.Write(batch); err != nil {
+		return err
+	}
+	logging.CPrint(logging.INFO, fmt.Sprintf("%s done", progStage), logging.LogFormat{})
+	return nil
+}
+
+// migrateBlockFile rewrites the block file of meta fb, then saves the new
+// offsets, the new file size and the migration marker in one batch before
+// replacing the file, so that recoverBlockFileMigration completes it.
+func migrateBlockFile(db *ChainDb, fb []byte) error {
+	fileNo := binary.LittleEndian.Uint32(fb[0:4])
+	migrated, err := db.blkFileKeeper.MigrateFile(fileNo)
+	if err != nil || migrated == nil {
+		return err
+	}
+
+	batch := db.stor.NewBatch()
+	heightFirst := binary.LittleEndian.Uint64(fb[16:24])
+	heightLast := binary.LittleEndian.Uint64(fb[24:32])
+	for height := heightFirst; height <= heightLast; height++ {
+		key := makeBlockHeightKey(height)
+		value, err := db.stor.Get(key)
+		if err == storage.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(value) != 52 || binary.LittleEndian.Uint32(value[32:36]) != fileNo {
+			continue
+		}
+		offset, ok := migrated.Offsets[int64(binary.LittleEndian.Uint64(value[36:44]))]
+		if !ok {
+			logging.CPrint(logging.ERROR, "block record not found in file", logging.LogFormat{
+				"height": height,
+				"fileNo": fileNo,
+				"offset": binary.LittleEndian.Uint64(value[36:44]),
+			})
+			return ErrUpgradeFileNumber
+		}
+		newValue := make([]byte, 52)
+		copy(newValue, value)
+		binary.LittleEndian.PutUint64(newValue[36:44], uint64(offset))
+		if err = batch.Put(key, newValue); err != nil {
+			return err
+		}
+	}
+
+	meta := make([]byte, 48)
+	copy(meta, fb)
+	binary.LittleEndian.PutUint64(meta[8:16], migrated.Size)
+	if err = batch.Put(append(blockFilePrefix, meta[0:4]...), meta); err != nil {
+		return err
+	}
+	if err = batch.Put(blockFileMigrateKey, meta[0:4]); err != nil {
+		return err
+	}
+	if err = db.stor.Write(batch); err != nil {
+		return err
+	}
+
+	if err = db.blkFileKeeper.CommitMigratedFile(fileNo, migrated.Size); err != nil {
+		return err
+	}
+	return db.stor.Delete(blockFileMigrateKey)
+}
+
+// buildAddrUtxoIndex rebuilds the address UTXO index by replaying the blocks
+// of the main chain.  If blocks have been pruned, only the unspent outputs and
+// the balances are built, without the history and undo records of the blocks.
+// The height of the next block is saved to ctx along with the records of each
+// block, a resumed build reloads the unspent outputs from the index.
+func buildAddrUtxoIndex(db *ChainDb, ctx *storage.MigrationContext, progStage string) error {
+	unspent := make(map[wire.OutPoint]*database.AddrIndexOutput)
+	start, resumed := migrationCheckpoint(ctx)
+	if resumed {
+		err := loadAddrUtxos(db, unspent)
+		if err == database.ErrBlockPruned {
+			return buildAddrUtxoIndexFromUtxos(db, progStage)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, prefix := range [][]byte{addrUtxoPrefix, addrBalancePrefix, addrHistoryPrefix, addrUndoPrefix} {
+			if err := removeByPrefix(db, prefix); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, bestHeight, _ := db.NewestSha()
+	if bestHeight == UnknownHeight {
+		return nil
+	}
+	logging.CPrint(logging.INFO, fmt.Sprintf("%s build address utxo index start", progStage), logging.LogFormat{
+		"height":        bestHeight,
+		"resume_height": start,
+	})
+
+	prog := 0
+	for height := start; height <= bestHeight; height++ {
+		sha, buf, err := db.getBlkByHeight(height)
+		if err == database.ErrBlockPruned {
+			logging.CPrint(logging.WARN, fmt.Sprintf("%s blocks pruned, build address utxo index without history", progStage),
+				logging.LogFormat{"height": height})
+			return buildAddrUtxoIndexFromUtxos(db, progStage)
+		}
+		if err != nil {
+			return err
+		}
+		block, err := massutil.NewBlockFromBytes(buf, wire.DB)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(sha[:], block.Hash()[:]) {
+			return ErrUpgradeBlockHash
+		}
+		txLocs, err := block.TxLoc()
+		if err != nil {
+			return err
+		}
+
+		var outputs, spentOutputs []*database.AddrIndexOutput
+		for txIdx, tx := range block.MsgBlock().Transactions {
+			loc := &txLocs[txIdx]
+			if txIdx != 0 { // skip coinbase
+				for _, txIn := range tx.TxIn {
+					out, ok := unspent[txIn.PreviousOutPoint]
+					if !ok {
+						continue
+					}
+					delete(unspent, txIn.PreviousOutPoint)
+					spent := *out
+					spent.SpentTxLoc = loc
+					spentOutputs = append(spentOutputs, &spent)
+				}
+			}
+			txHash := tx.TxHash()
+			for vout, txOut := range tx.TxOut {
+				if _, _, ok := addrOutputOwner(txOut.PkScript); !ok {
+					continue
+				}
+				out := &database.AddrIndexOutput{
+					OutPoint: wire.OutPoint{Hash: txHash, Index: uint32(vout)},
+					// copy the output, not to keep the block in memory
+					TxOut:    wire.NewTxOut(txOut.Value, append([]byte(nil), txOut.PkScript...)),
+					Coinbase: txIdx == 0,
+					Height:   height,
+					TxLoc:    loc,
+				}
+				outputs = append(outputs, out)
+				unspent[out.OutPoint] = out
+			}
+		}
+
+		batch := db.stor.NewBatch()
+		err = db.submitAddrUtxoIndex(batch, height, outputs, spentOutputs)
+		if err == nil {
+			err = saveMigrationCheckpoint(ctx, batch, height+1)
+		}
+		if err == nil {
+			err = db.stor.Write(batch)
+		}
+		batch.Release()
+		if err != nil {
+			return err
+		}
+
+		if bestHeight > 0 && int(height*100/bestHeight) >= prog+5 {
+			prog = int(height * 100 / bestHeight)
+			logging.CPrint(logging.INFO, fmt.Sprintf("%s %d%%", progStage, prog), logging.LogFormat{})
+		}
+	}
+	logging.CPrint(logging.INFO, fmt.Sprintf("%s build address utxo index done", progStage), logging.LogFormat{
+		"utxos": len(unspent),
+	})
+	return nil
+}
+
+// loadAddrUtxos loads the outputs in the address UTXO index into unspent, with
+// the output scripts read from the block files.
+func loadAddrUtxos(db *ChainDb, unspent map[wire.OutPoint]*database.AddrIndexOutput) error {
+	iter := db.stor.NewIterator(storage.BytesPrefix(addrUtxoPrefix))
+	defer iter.Release()
+
+	var (
+		lastLoc wire.TxLoc
+		lastTx  *wire.MsgTx
+	)
+	for iter.Next() {
+		_, _, height, op := decodeAddrUtxoKey(iter.Key())
+		_, txLoc, coinbase := decodeAddrUtxoValue(iter.Value())
+		// outputs of a tx are often paid to the same script hash
+		if lastTx == nil || lastTx.TxHash() != op.Hash || lastLoc != txLoc {
+			tx, _, err := db.fetchTxDataByLoc(height, txLoc.TxStart, txLoc.TxLen)
+			if err != nil {
+				return err
+			}
+			if tx == nil || tx.TxHash() != op.Hash || int(op.Index) >= len(tx.TxOut) {
+				return ErrIncorrectValue
+			}
+			lastTx, lastLoc = tx, txLoc
+		}
+		loc := txLoc
+		unspent[op] = &database.AddrIndexOutput{
+			OutPoint: op,
+			TxOut:    lastTx.TxOut[op.Index],
+			Coinbase: coinbase,
+			Height:   height,
+			TxLoc:    &loc,
+		}
+	}
+	return iter.Error()
+}
+
+// buildAddrUtxoIndexFromUtxos builds the unspent outputs and the balances of
+// the address UTXO index from the unspent transactions, whose blocks are never
+// pruned.  The index is rebuilt as a whole, so that it is not mixed with the
+// records written by buildAddrUtxoIndex before finding pruned blocks.
+func buildAddrUtxoIndexFromUtxos(db *ChainDb, progStage string) error {
+	for _, prefix := range [][]byte{addrUtxoPrefix, addrBalancePrefix, addrHistoryPrefix, addrUndoPrefix} {
+		if err := removeByPrefix(db, prefix); err != nil {
+			return err
+		}
+	}
+
+	var outputs []*database.AddrIndexOutput
+	err := db.ForEachUnspentTx(func(reply *database.TxReply) error {
+		_, txOff, txLen, err := db.GetUnspentTxData(reply.Sha)
+		if err != nil {
+			return err
+		}
+		coinbase := false
+		if len(reply.Tx.TxIn) == 1 {
+			prevOut := &reply.Tx.TxIn[0].PreviousOutPoint
+			coinbase = prevOut.Index == math.MaxUint32 && prevOut.Hash == (wire.Hash{})
+		}
+		for vout, txOut := range reply.Tx.TxOut {
+			if reply.TxSpent[vout] {
+				continue
+			}
+			if _, _, ok := addrOutputOwner(txOut.PkScript); !ok {
+				continue
+			}
+			outputs = append(outputs, &database.AddrIndexOutput{
+				OutPoint: wire.OutPoint{Hash: *reply.Sha, Index: uint32(vout)},
+				TxOut:    txOut,
+				Coinbase: coinbase,
+				Height:   reply.Height,
+				TxLoc:    &wire.TxLoc{TxStart: txOff, TxLen: txLen},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// no block to detach to the outputs, write them without undo and history
+	deltas := make(addrBalanceDeltas)
+	batch := db.stor.NewBatch()
+	defer batch.Release()
+	for _, out := range outputs {
+		scriptHash, kind, _ := addrOutputOwner(out.TxOut.PkScript)
+		key := encodeAddrUtxoKey(scriptHash, kind, out.Height, &out.OutPoint)
+		if err = batch.Put(key, encodeAddrUtxoValue(out.TxOut.Value, out.TxLoc, out.Coinbase)); err != nil {
+			return err
+		}
+		deltas.add(scriptHash, kind, out.TxOut.Value, 1)
+	}
+	if err = db.applyAddrBalanceDeltas(batch, deltas); err != nil {
+		return err
+	}
+	if err = db.stor.Write(batch); err != nil {
+		return err
+	}
+	logging.CPrint(logging.INFO, fmt.Sprintf("%s build address utxo index done", progStage), logging.LogFormat{
+		"utxos":  len(outputs),
+		"pruned": true,
+	})
+	return nil
+}
+
+// removeByPrefix deletes all the records of prefix.
+func removeByPrefix(db *ChainDb, prefix []byte) error {
+	batch := db.stor.NewBatch()
+	defer batch.Release()
+
+	iter := db.stor.NewIterator(storage.BytesPrefix(prefix))
+	defer iter.Release()
+	for count := 1; iter.Next(); count++ {
+		if err := batch.Delete(iter.Key()); err != nil {
+			return err
+		}
+		if count%5000 == 0 {
+			if err := db.stor.Write(batch); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return db.stor.Write(batch)
+}
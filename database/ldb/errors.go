@@ -22,6 +22,14 @@ var (
 	ErrWrongBindingTxSpentIndexLen    = errors.New("length of binding tx spent index is invalid")
 	ErrWrongBindingTxSpentIndexPrefix = errors.New("prefix of binding tx spent index is invalid")
 
+	// errors for address utxo index
+	ErrWrongAddrUtxoKind = errors.New("kind of address utxo is invalid")
+
+	// errors for utxo snapshot
+	ErrDbNotEmpty           = errors.New("database is not empty")
+	ErrInvalidUtxoSnapshot  = errors.New("invalid utxo snapshot data")
+	ErrUtxoSnapshotNotFound = errors.New("database is not initialized by utxo snapshot")
+
 	// for disk file
 	ErrInvalidBlockFileMeta = errors.New("invalid blockfile meta")
 	ErrIncorrectValueLength = errors.New("incorrect value length")
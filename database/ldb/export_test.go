@@ -0,0 +1,5 @@
+package ldb
+
+// SpentBitmapChunkSize exposes the number of records checked in one pass over
+// the blocks by the spent_bitmap check.
+var SpentBitmapChunkSize = &spentBitmapChunkSize
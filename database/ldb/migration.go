@@ -0,0 +1,130 @@
+package ldb
+
+import (
+	"encoding/binary"
+
+	"github.com/wangxinyu2018/mass-core/database/disk"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/errors"
+)
+
+var ErrMigrationUnsupportedDb = errors.New("storage migration needs a ChainDb")
+
+func init() {
+	for _, m := range []storage.Migration{
+		{
+			Version:     storage.StorageV3,
+			Description: "save blocks to block files",
+			Steps: []storage.MigrationStep{
+				{
+					Name: "mv blocks to disk",
+					Run: chainDbMigration(func(db *ChainDb, ctx *storage.MigrationContext) error {
+						return moveBlockToDisk(db, "[v3 1/3]")
+					}),
+				},
+				{
+					Name: "rm empty BANHGT",
+					Run: chainDbMigration(func(db *ChainDb, ctx *storage.MigrationContext) error {
+						return removeEmptyBan(db, "[v3 2/3]")
+					}),
+				},
+				{
+					Name: "build STL/HTS index",
+					Run: chainDbMigration(func(db *ChainDb, ctx *storage.MigrationContext) error {
+						return buildTxIndex(db, "[v3 3/3]")
+					}),
+				},
+			},
+			Estimate: estimateBlocks,
+			Unit:     "blocks",
+		},
+		{
+			Version:     storage.StorageV4,
+			Description: "checksum block records in block files",
+			Steps: []storage.MigrationStep{
+				{Name: "checksum block records", Run: chainDbMigration((*ChainDb).upgradeBlockFileRecords)},
+			},
+			Estimate: estimateLegacyBlockFiles,
+			Unit:     "block files",
+		},
+		{
+			Version:     storage.StorageV5,
+			Description: "index utxos and balances by script hash",
+			Steps: []storage.MigrationStep{
+				{
+					Name: "build address utxo index",
+					Run: chainDbMigration(func(db *ChainDb, ctx *storage.MigrationContext) error {
+						return buildAddrUtxoIndex(db, ctx, "[v5 1/1]")
+					}),
+				},
+			},
+			Estimate: estimateBlocks,
+			Unit:     "blocks",
+		},
+	} {
+		if err := storage.RegisterMigration(m); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// chainDbMigration adapts a migration step of ChainDb.  The steps replaying
+// blocks or block files save checkpoints, the v3 steps resume from the records
+// they have written or start over.
+func chainDbMigration(run func(db *ChainDb, ctx *storage.MigrationContext) error) func(ctx *storage.MigrationContext) error {
+	return func(ctx *storage.MigrationContext) error {
+		db, ok := ctx.DB.(*ChainDb)
+		if !ok {
+			return ErrMigrationUnsupportedDb
+		}
+		return run(db, ctx)
+	}
+}
+
+// migrationCheckpoint returns the height or file number a step saved with
+// saveMigrationCheckpoint to resume from, ok is false if the step starts over
+// or runs out of a migration.
+func migrationCheckpoint(ctx *storage.MigrationContext) (next uint64, ok bool) {
+	if ctx == nil || len(ctx.Checkpoint()) != 8 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(ctx.Checkpoint()), true
+}
+
+// saveMigrationCheckpoint saves next in batch as the height or file number to
+// resume the step from, nothing is saved out of a migration.
+func saveMigrationCheckpoint(ctx *storage.MigrationContext, batch storage.Batch, next uint64) error {
+	if ctx == nil {
+		return nil
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], next)
+	return ctx.SaveCheckpoint(batch, buf[:])
+}
+
+func estimateBlocks(ctx *storage.MigrationContext) (uint64, error) {
+	db, ok := ctx.DB.(*ChainDb)
+	if !ok {
+		return 0, ErrMigrationUnsupportedDb
+	}
+	if db.dbStorageMeta.currentHeight == UnknownHeight {
+		return 0, nil
+	}
+	return db.dbStorageMeta.currentHeight + 1, nil
+}
+
+func estimateLegacyBlockFiles(ctx *storage.MigrationContext) (uint64, error) {
+	db, ok := ctx.DB.(*ChainDb)
+	if !ok {
+		return 0, ErrMigrationUnsupportedDb
+	}
+	version, err := db.getBlockFileVersion()
+	if err != nil || version >= disk.CurrentRecordVersion {
+		return 0, err
+	}
+	fbs, err := db.getAllBlockFileMeta()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(fbs)), nil
+}
@@ -0,0 +1,116 @@
+package ldb
+
+import (
+	"github.com/wangxinyu2018/mass-core/database/disk"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+// PruneBlockFiles deletes finalized block files which only hold blocks lower
+// than height.  Transactions of a file still recorded in "TXD" are copied to
+// "SNPTX" before the file is deleted, and are read from there as those of a
+// utxo snapshot.  The db lock is held for one file at a time, so that blocks
+// may be connected between two files.
+func (db *ChainDb) PruneBlockFiles(height uint64) (int, error) {
+	candidates := db.blkFileKeeper.PruneCandidates(height)
+	for i, bf := range candidates {
+		if err := db.pruneBlockFile(bf); err != nil {
+			logging.CPrint(logging.ERROR, "failed to prune block file", logging.LogFormat{"fileNo": bf.Number(), "err": err})
+			return i, err
+		}
+	}
+	return len(candidates), nil
+}
+
+// pruneBlockFile copies the unspent transactions of the main chain blocks in
+// bf to "SNPTX" and deletes bf.
+func (db *ChainDb) pruneBlockFile(bf *disk.BlockFile) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	batch := db.stor.NewBatch()
+	defer batch.Release()
+	for height := bf.HeightFirst(); height <= bf.HeightLast(); height++ {
+		blkSha, fileNo, offset, size, err := db.GetBlkLocByHeight(height)
+		if err != nil {
+			// below the blocks of a utxo snapshot
+			if err == storage.ErrNotFound {
+				continue
+			}
+			return err
+		}
+		// the main chain block may be in a later file after a reorganization
+		if fileNo != bf.Number() {
+			continue
+		}
+		if err = db.copyUnspentTxs(batch, height, blkSha, fileNo, offset, size); err != nil {
+			return err
+		}
+	}
+	if err := batch.Put(makePrunedBlockFileKey(bf.Number()), []byte{}); err != nil {
+		return err
+	}
+
+	// records go first, a file left behind by a crash is removed on next open
+	if err := db.stor.Write(batch); err != nil {
+		return err
+	}
+	return db.blkFileKeeper.PruneFile(bf.Number())
+}
+
+// copyUnspentTxs puts the transactions of the block at height which are still
+// recorded in "TXD" into "SNPTX" records of batch.
+func (db *ChainDb) copyUnspentTxs(batch storage.Batch, height uint64, blkSha *wire.Hash, fileNo uint32, offset, size int64) error {
+	buf, err := db.blkFileKeeper.ReadRawBlock(fileNo, offset, int(size))
+	if err != nil {
+		return err
+	}
+	blk, err := massutil.NewBlockFromBytes(buf, wire.DB)
+	if err != nil {
+		return err
+	}
+	txLocs, err := blk.TxLoc()
+	if err != nil {
+		return err
+	}
+
+	for i, tx := range blk.Transactions() {
+		txHeight, txOff, txLen, _, err := db.getTxData(tx.Hash())
+		if err != nil {
+			// fully spent
+			if err == storage.ErrNotFound {
+				continue
+			}
+			return err
+		}
+		// a duplicated tx hash is recorded for its latest block only
+		if txHeight != height || txOff != txLocs[i].TxStart || txLen != txLocs[i].TxLen {
+			continue
+		}
+		value := make([]byte, wire.HashSize+txLen)
+		copy(value, blkSha[:])
+		copy(value[wire.HashSize:], buf[txOff:txOff+txLen])
+		if err = batch.Put(makeSnapshotTxKey(height, txOff), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasPrunedBlocks returns whether any block file has been pruned, or the
+// database has been initialized by a utxo snapshot without the blocks below.
+func (db *ChainDb) HasPrunedBlocks() (bool, error) {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	if snapshot, err := db.stor.Has(utxoSnapshotBaseKey); err != nil || snapshot {
+		return snapshot, err
+	}
+	pruned, err := db.getPrunedBlockFiles()
+	if err != nil {
+		return false, err
+	}
+	return len(pruned) > 0, nil
+}
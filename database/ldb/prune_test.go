@@ -0,0 +1,86 @@
+package ldb_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/disk"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+func fetchUnspentTxs(t *testing.T, db database.Db) map[wire.Hash]*database.TxReply {
+	replies := make(map[wire.Hash]*database.TxReply)
+	err := db.ForEachUnspentTx(func(reply *database.TxReply) error {
+		replies[*reply.Sha] = reply
+		return nil
+	})
+	assert.Nil(t, err)
+	return replies
+}
+
+func TestLevelDb_PruneBlockFiles(t *testing.T) {
+	// spread the blocks over files of a few blocks
+	defer func(size uint64) { disk.MaxBlockfileSize = size }(disk.MaxBlockfileSize)
+	disk.MaxBlockfileSize = 16 * 1024
+
+	db, tearDown, err := GetDb("DbTest")
+	assert.Nil(t, err)
+	defer tearDown()
+
+	numBlks, pruneHeight := 60, uint64(40)
+	err = initBlocks(db, numBlks)
+	assert.Nil(t, err)
+
+	unspent := fetchUnspentTxs(t, db)
+	pruned, err := db.HasPrunedBlocks()
+	assert.Nil(t, err)
+	assert.False(t, pruned)
+
+	n, err := db.PruneBlockFiles(pruneHeight)
+	assert.Nil(t, err)
+	if !assert.NotEqual(t, 0, n) {
+		return
+	}
+	pruned, err = db.HasPrunedBlocks()
+	assert.Nil(t, err)
+	assert.True(t, pruned)
+	n, err = db.PruneBlockFiles(pruneHeight)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// blocks of pruned files are gone, their unspent txs are kept
+	_, err = db.FetchBlockBySha(blks200[1].Hash())
+	assert.Equal(t, database.ErrBlockPruned, err)
+	_, err = db.FetchBlockBySha(blks200[numBlks-1].Hash())
+	assert.Nil(t, err)
+	kept := 0
+	for sha, reply := range fetchUnspentTxs(t, db) {
+		expected := unspent[sha]
+		if !assert.NotNil(t, expected, sha.String()) {
+			continue
+		}
+		assert.Equal(t, expected.Tx.TxHash(), reply.Tx.TxHash())
+		assert.Equal(t, expected.BlkSha, reply.BlkSha)
+		assert.Equal(t, expected.Height, reply.Height)
+		assert.Equal(t, expected.TxSpent, reply.TxSpent)
+		if reply.Height < pruneHeight {
+			kept++
+		}
+	}
+	assert.NotEqual(t, 0, kept)
+	assert.Equal(t, len(unspent), len(fetchUnspentTxs(t, db)))
+
+	// blocks spending txs of pruned files are still connected
+	for _, blk := range blks200[numBlks:100] {
+		err = insertBlock(db, blk)
+		assert.Nil(t, err)
+	}
+	for _, reply := range db.FetchUnSpentTxByShaList([]*wire.Hash{blks200[1].Transactions()[0].Hash()}) {
+		if reply.Err == nil {
+			assert.Equal(t, blks200[1].Hash(), reply.BlkSha)
+		} else {
+			assert.Equal(t, database.ErrTxShaMissing, reply.Err)
+		}
+	}
+}
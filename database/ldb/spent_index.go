@@ -0,0 +1,216 @@
+package ldb
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	spentIndexKeyLength         = 3 + 32 + 4
+	spentIndexValueLength       = 32 + 8
+	spentIndexTipValueLength    = 32 + 8
+	spentIndexBlockKeyLength    = 3 + 8
+	minSpentIndexBlockValLength = 32 + 32
+	spentIndexOutPointLength    = 32 + 4
+)
+
+var (
+	// spending tx of each outpoint spent in main chain
+	//
+	// key is 39 bytes:
+	//      [0:3]   - spentIndexPrefix
+	//      [3:35]  - hash of the tx holding the output
+	//      [35:39] - output index, BigEndian
+	// value is 40 bytes:
+	//      [0:32]  - hash of the spending tx
+	//      [32:40] - height of the spending block, LittleEndian
+	spentIndexPrefix = []byte("SPB")
+
+	// outpoints spent by each indexed block, to remove the records of the
+	// block without loading it
+	//
+	// key is 11 bytes:
+	//      [0:3]   - spentIndexBlockPrefix
+	//      [3:11]  - block height, BigEndian
+	// value's structure is:
+	//      [0:32]  - block hash
+	//      [32:64] - parent block hash
+	//      [64:]   - spent outpoints, 36 bytes each
+	spentIndexBlockPrefix = []byte("SPH")
+
+	// value is 40 bytes:
+	//      [0:32]  - hash of the latest indexed block
+	//      [32:40] - height of the latest indexed block, LittleEndian
+	spentIndexTipKey = []byte("SPTIP")
+)
+
+func makeSpentIndexKey(op *wire.OutPoint) []byte {
+	key := make([]byte, spentIndexKeyLength)
+	copy(key, spentIndexPrefix)
+	copy(key[3:35], op.Hash[:])
+	binary.BigEndian.PutUint32(key[35:], op.Index)
+	return key
+}
+
+func makeSpentIndexBlockKey(height uint64) []byte {
+	key := make([]byte, spentIndexBlockKeyLength)
+	copy(key, spentIndexBlockPrefix)
+	binary.BigEndian.PutUint64(key[3:], height)
+	return key
+}
+
+func encodeSpentIndexTip(hash *wire.Hash, height uint64) []byte {
+	value := make([]byte, spentIndexTipValueLength)
+	copy(value, hash[:])
+	binary.LittleEndian.PutUint64(value[32:], height)
+	return value
+}
+
+// FetchSpentIndexTip returns the hash and height of the latest block which has
+// had its spent outpoints indexed. It returns ErrSpentIndexDoesNotExist if no
+// block has been indexed.
+func (db *ChainDb) FetchSpentIndexTip() (*wire.Hash, uint64, error) {
+	value, err := db.stor.Get(spentIndexTipKey)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return &wire.Hash{}, UnknownHeight, database.ErrSpentIndexDoesNotExist
+		}
+		return nil, 0, err
+	}
+	if len(value) != spentIndexTipValueLength {
+		return nil, 0, ErrIncorrectValueLength
+	}
+	var hash wire.Hash
+	copy(hash[:], value[:32])
+	return &hash, binary.LittleEndian.Uint64(value[32:]), nil
+}
+
+// SubmitSpentIndex records the spending tx of each outpoint spent by block,
+// and moves the tip of spent index to the block.
+func (db *ChainDb) SubmitSpentIndex(block *massutil.Block) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	batch, standalone := db.indexBatch(block.Hash())
+	if standalone {
+		defer batch.Release()
+	}
+
+	height := block.Height()
+	blockValue := make([]byte, minSpentIndexBlockValLength, minSpentIndexBlockValLength+spentIndexOutPointLength*len(block.Transactions()))
+	copy(blockValue, block.Hash()[:])
+	copy(blockValue[32:], block.MsgBlock().Header.Previous[:])
+	for _, tx := range block.Transactions() {
+		// coinbase spends nothing, see doSpend
+		if isCoinBaseTx(tx.MsgTx()) {
+			continue
+		}
+		value := make([]byte, spentIndexValueLength)
+		copy(value, tx.Hash()[:])
+		binary.LittleEndian.PutUint64(value[32:], height)
+		for _, txIn := range tx.MsgTx().TxIn {
+			op := &txIn.PreviousOutPoint
+			if op.Index == ^uint32(0) {
+				continue
+			}
+			key := makeSpentIndexKey(op)
+			if err := batch.Put(key, value); err != nil {
+				return err
+			}
+			blockValue = append(blockValue, key[3:]...)
+		}
+	}
+	if err := batch.Put(makeSpentIndexBlockKey(height), blockValue); err != nil {
+		return err
+	}
+	if err := batch.Put(spentIndexTipKey, encodeSpentIndexTip(block.Hash(), height)); err != nil {
+		return err
+	}
+
+	if standalone {
+		return db.stor.Write(batch)
+	}
+	return nil
+}
+
+// DeleteSpentIndex removes the records of block, which must be the tip of spent
+// index, and moves the tip to its parent.
+func (db *ChainDb) DeleteSpentIndex(hash *wire.Hash, height uint64) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	blockKey := makeSpentIndexBlockKey(height)
+	blockValue, err := db.stor.Get(blockKey)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return database.ErrSpentIndexDoesNotExist
+		}
+		return err
+	}
+	if len(blockValue) < minSpentIndexBlockValLength ||
+		(len(blockValue)-minSpentIndexBlockValLength)%spentIndexOutPointLength != 0 {
+		return ErrIncorrectValueLength
+	}
+	if !bytes.Equal(hash[:], blockValue[:32]) {
+		return ErrIncorrectDbData
+	}
+
+	batch, standalone := db.indexBatch(hash)
+	if standalone {
+		defer batch.Release()
+	}
+
+	for ops := blockValue[minSpentIndexBlockValLength:]; len(ops) > 0; ops = ops[spentIndexOutPointLength:] {
+		key := make([]byte, spentIndexKeyLength)
+		copy(key, spentIndexPrefix)
+		copy(key[3:], ops[:spentIndexOutPointLength])
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := batch.Delete(blockKey); err != nil {
+		return err
+	}
+	if height == 0 {
+		if err := batch.Delete(spentIndexTipKey); err != nil {
+			return err
+		}
+	} else {
+		var parent wire.Hash
+		copy(parent[:], blockValue[32:64])
+		if err := batch.Put(spentIndexTipKey, encodeSpentIndexTip(&parent, height-1)); err != nil {
+			return err
+		}
+	}
+
+	if standalone {
+		return db.stor.Write(batch)
+	}
+	return nil
+}
+
+// FetchSpendingTx returns the hash of the main chain tx spending outPoint and
+// the height of its block.
+func (db *ChainDb) FetchSpendingTx(outPoint *wire.OutPoint) (*wire.Hash, uint64, error) {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	value, err := db.stor.Get(makeSpentIndexKey(outPoint))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, 0, database.ErrSpendingTxMissing
+		}
+		return nil, 0, err
+	}
+	if len(value) != spentIndexValueLength {
+		return nil, 0, ErrIncorrectValueLength
+	}
+	var hash wire.Hash
+	copy(hash[:], value[:32])
+	return &hash, binary.LittleEndian.Uint64(value[32:]), nil
+}
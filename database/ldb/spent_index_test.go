@@ -0,0 +1,77 @@
+package ldb_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/ldb"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+func TestLevelDb_SpentIndex(t *testing.T) {
+	db, tearDown, err := GetDb("DbTest")
+	assert.Nil(t, err)
+	defer tearDown()
+
+	numBlks := 100
+	err = initBlocks(db, numBlks)
+	assert.Nil(t, err)
+
+	_, _, err = db.FetchSpentIndexTip()
+	assert.Equal(t, database.ErrSpentIndexDoesNotExist, err)
+
+	type spend struct {
+		outPoint wire.OutPoint
+		txHash   *wire.Hash
+		height   uint64
+	}
+	var spends []spend
+	for _, blk := range blks200[:numBlks] {
+		err = db.SubmitSpentIndex(blk)
+		assert.Nil(t, err)
+		tipSha, tipHeight, err := db.FetchSpentIndexTip()
+		assert.Nil(t, err)
+		assert.Equal(t, blk.Hash(), tipSha)
+		assert.Equal(t, blk.Height(), tipHeight)
+
+		for _, tx := range blk.Transactions()[1:] {
+			for _, txIn := range tx.MsgTx().TxIn {
+				spends = append(spends, spend{txIn.PreviousOutPoint, tx.Hash(), blk.Height()})
+			}
+		}
+	}
+	if !assert.NotEqual(t, 0, len(spends)) {
+		return
+	}
+
+	for _, s := range spends {
+		txHash, height, err := db.FetchSpendingTx(&s.outPoint)
+		assert.Nil(t, err)
+		assert.Equal(t, s.txHash, txHash)
+		assert.Equal(t, s.height, height)
+	}
+	// outputs of coinbase in the last block are unspent
+	last := blks200[numBlks-1]
+	_, _, err = db.FetchSpendingTx(wire.NewOutPoint(last.Transactions()[0].Hash(), 0))
+	assert.Equal(t, database.ErrSpendingTxMissing, err)
+
+	// only the tip can be deleted
+	err = db.DeleteSpentIndex(blks200[0].Hash(), last.Height())
+	assert.Equal(t, ldb.ErrIncorrectDbData, err)
+
+	// delete down to the block of the first spend, the tip moves back
+	first := spends[0]
+	for height := last.Height(); height >= first.height; height-- {
+		err = db.DeleteSpentIndex(blks200[height].Hash(), height)
+		assert.Nil(t, err)
+	}
+	tipSha, tipHeight, err := db.FetchSpentIndexTip()
+	assert.Nil(t, err)
+	assert.Equal(t, blks200[first.height-1].Hash(), tipSha)
+	assert.Equal(t, first.height-1, tipHeight)
+	for _, s := range spends {
+		_, _, err = db.FetchSpendingTx(&s.outPoint)
+		assert.Equal(t, database.ErrSpendingTxMissing, err)
+	}
+}
@@ -265,11 +265,11 @@ func (db *ChainDb) FetchStakingTxMap() (database.StakingNodes, error) {
 }
 
 // fetchActiveStakingTxFromUnexpired returns currently unexpired staking at 'height'
-func (db *ChainDb) fetchActiveStakingTxFromUnexpired(height uint64) (map[[sha256.Size]byte][]database.StakingTxInfo, error) {
+func fetchActiveStakingTxFromUnexpired(r storage.Reader, height uint64) (map[[sha256.Size]byte][]database.StakingTxInfo, error) {
 
 	stakingTxInfos := make(map[[sha256.Size]byte][]database.StakingTxInfo)
 
-	iter := db.stor.NewIterator(storage.BytesPrefix(recordStakingTx))
+	iter := r.NewIterator(storage.BytesPrefix(recordStakingTx))
 	defer iter.Release()
 
 	for iter.Next() {
@@ -300,7 +300,7 @@ func (db *ChainDb) fetchActiveStakingTxFromUnexpired(height uint64) (map[[sha256
 // FetchUnexpiredStakingRank returns only currently unexpired staking rank at
 // target height. This function is for mining and validating block.
 func (db *ChainDb) FetchUnexpiredStakingRank(height uint64, onlyOnList bool) ([]database.Rank, error) {
-	stakingTxInfos, err := db.fetchActiveStakingTxFromUnexpired(height)
+	stakingTxInfos, err := fetchActiveStakingTxFromUnexpired(db.stor, height)
 	if err != nil {
 		return nil, err
 	}
@@ -321,10 +321,10 @@ func (db *ChainDb) FetchUnexpiredStakingRank(height uint64, onlyOnList bool) ([]
 }
 
 // fetchActiveStakingTxFromExpired returns once unexpired staking at 'height'
-func (db *ChainDb) fetchActiveStakingTxFromExpired(height uint64) (map[[sha256.Size]byte][]database.StakingTxInfo, error) {
+func fetchActiveStakingTxFromExpired(r storage.Reader, height uint64) (map[[sha256.Size]byte][]database.StakingTxInfo, error) {
 	stakingTxInfos := make(map[[sha256.Size]byte][]database.StakingTxInfo)
 
-	iter := db.stor.NewIterator(storage.BytesPrefix(recordExpiredStakingTx))
+	iter := r.NewIterator(storage.BytesPrefix(recordExpiredStakingTx))
 	defer iter.Release()
 
 	for iter.Next() {
@@ -356,12 +356,20 @@ func (db *ChainDb) fetchActiveStakingTxFromExpired(height uint64) (map[[sha256.S
 // FetchStakingRank returns staking rank at any height. This
 // function may be slow.
 func (db *ChainDb) FetchStakingRank(height uint64, onlyOnList bool) ([]database.Rank, error) {
-	stakingTxInfos, err := db.fetchActiveStakingTxFromUnexpired(height)
+	// Read both staking records from a snapshot, a block commit moves
+	// records from unexpired to expired.
+	snap, err := db.stor.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	stakingTxInfos, err := fetchActiveStakingTxFromUnexpired(snap, height)
 	if err != nil {
 		return nil, err
 	}
 
-	expired, err := db.fetchActiveStakingTxFromExpired(height)
+	expired, err := fetchActiveStakingTxFromExpired(snap, height)
 	if err != nil {
 		return nil, err
 	}
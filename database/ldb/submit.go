@@ -109,6 +109,8 @@ func (db *ChainDb) RollbackClose() error {
 }
 
 func (db *ChainDb) Commit(hash wire.Hash) error {
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
 	db.dbLock.Lock()
 	defer db.dbLock.Unlock()
 
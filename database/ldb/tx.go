@@ -7,6 +7,7 @@ import (
 	"math"
 
 	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/disk"
 	"github.com/wangxinyu2018/mass-core/database/storage"
 	"github.com/wangxinyu2018/mass-core/debug"
 	"github.com/wangxinyu2018/mass-core/logging"
@@ -261,6 +262,43 @@ func (db *ChainDb) FetchUnSpentTxByShaList(txShaList []*wire.Hash) []*database.T
 	return replies
 }
 
+// ForEachUnspentTx calls fn with every transaction recorded in "TXD", which
+// are those still having unspent outputs.
+func (db *ChainDb) ForEachUnspentTx(fn func(reply *database.TxReply) error) error {
+	iter := db.stor.NewIterator(storage.BytesPrefix(recordSuffixTx))
+	defer iter.Release()
+
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if len(key) != len(recordSuffixTx)+wire.HashSize || len(value) < 16 {
+			return ErrIncorrectValueLength
+		}
+		var sha wire.Hash
+		copy(sha[:], key[len(recordSuffixTx):])
+		height := binary.LittleEndian.Uint64(value[0:8])
+		txOff := binary.LittleEndian.Uint32(value[8:12])
+		txLen := binary.LittleEndian.Uint32(value[12:16])
+		spentBuf := value[16:]
+
+		tx, blkSha, err := db.fetchTxDataByLoc(height, int(txOff), int(txLen))
+		if err != nil {
+			return err
+		}
+		if len(spentBuf) < (len(tx.TxOut)+7)/8 {
+			return ErrIncorrectValueLength
+		}
+		txSpent := make([]bool, len(tx.TxOut))
+		for idx := range tx.TxOut {
+			txSpent[idx] = (spentBuf[idx/8] & (byte(1) << uint(idx%8))) != 0
+		}
+		err = fn(&database.TxReply{Sha: &sha, Tx: tx, BlkSha: blkSha, Height: height, TxSpent: txSpent})
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
 // fetchTxDataBySha returns several pieces of data regarding the given sha.
 func (db *ChainDb) fetchTxDataBySha(txsha *wire.Hash) (rtx *wire.MsgTx, rblksha *wire.Hash, rheight uint64, rtxspent []byte, err error) {
 	var txOff, txLen int
@@ -291,11 +329,22 @@ func (db *ChainDb) fetchTxDataByLoc(blkHeight uint64, txOff int, txLen int) (rtx
 
 	blksha, fileNo, blkOffset, _, err := db.GetBlkLocByHeight(blkHeight)
 	if err != nil {
+		if err == storage.ErrNotFound {
+			// below the blocks of a utxo snapshot
+			return db.fetchSnapshotTx(blkHeight, txOff, txLen)
+		}
 		return nil, nil, err
 	}
 	buf, err := db.blkFileKeeper.ReadRawTx(fileNo, blkOffset, int64(txOff), txLen)
 	if err != nil {
-		return nil, nil, err
+		if err == disk.ErrFilePruned {
+			// unspent when the block file was pruned
+			rtx, rblksha, err = db.fetchSnapshotTx(blkHeight, txOff, txLen)
+			if err == storage.ErrNotFound {
+				err = database.ErrBlockPruned
+			}
+		}
+		return rtx, rblksha, err
 	}
 
 	var tx wire.MsgTx
@@ -320,6 +369,9 @@ func (db *ChainDb) FetchTxByLoc(blkHeight uint64, txOff int, txLen int) (*wire.M
 func (db *ChainDb) FetchTxByFileLoc(blkLoc *database.BlockLoc, txLoc *wire.TxLoc) (*wire.MsgTx, error) {
 	buf, err := db.blkFileKeeper.ReadRawTx(blkLoc.File, int64(blkLoc.Offset), int64(txLoc.TxStart), txLoc.TxLen)
 	if err != nil {
+		if err == disk.ErrFilePruned {
+			err = database.ErrBlockPruned
+		}
 		return nil, err
 	}
 
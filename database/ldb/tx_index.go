@@ -380,6 +380,10 @@ func (db *ChainDb) submitAddrIndex(height uint64, addrIndexData *database.AddrIn
 		}
 	}
 
+	if err := db.submitAddrUtxoIndex(batch, height, addrIndexData.Outputs, addrIndexData.SpentOutputs); err != nil {
+		return err
+	}
+
 	// Ensure we're writing an address index version
 	newIndexVersion := make([]byte, 2)
 	binary.LittleEndian.PutUint16(newIndexVersion[0:2],
@@ -553,7 +557,8 @@ func (db *ChainDb) deleteAddrIndex(height uint64) error {
 		return err
 	}
 
-	return nil
+	// revert the address utxo index
+	return db.deleteAddrUtxoIndex(batch, height)
 }
 
 // from start to stop-1
@@ -564,13 +569,21 @@ func (db *ChainDb) FetchScriptHashRelatedTx(
 
 	lowBoundary, upBoundary := calcSTLLowBoundary(startBlock), calcSTLLowBoundary(stopBlock-1)
 
+	// Read all boundaries from a snapshot, so that the history does not
+	// mix indexes from before and after a block commit.
+	snap, err := db.stor.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
 	dedup := make(map[uint64]map[wire.TxLoc]struct{})
 	for _, v := range scriptHashes {
 		var scriptHash [sha256.Size]byte
 		copy(scriptHash[:], v)
 		for curBoundary := lowBoundary; curBoundary <= upBoundary; curBoundary += stlBoundaryDistance {
 			key, _ := encodeSTLKey(curBoundary, scriptHash)
-			value, err := db.stor.Get(key)
+			value, err := snap.Get(key)
 			if err != nil {
 				if err == storage.ErrNotFound {
 					continue
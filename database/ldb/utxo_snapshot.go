@@ -0,0 +1,257 @@
+package ldb
+
+import (
+	"encoding/binary"
+
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+var (
+	// |  "SNAPSHOT"  |      |  height  |  block hash  |  content hash  |  lowest height  |  state  |
+	// |    8-bytes   |  ->  |  8-bytes |   32-bytes   |    32-bytes    |     8-bytes     |  1-byte |
+	utxoSnapshotBaseKey = []byte("SNAPSHOT")
+
+	// transactions of a utxo snapshot whose blocks are not stored, and those
+	// unspent when their block file was pruned, "TXD" records of them locate
+	// them by height and offset as usual
+	//
+	// |  "SNPTX"  |  block height  |  tx offset  |      |  block hash  |  raw tx  |
+	// |  5-bytes  |     8-bytes    |   4-bytes   |  ->  |   32-bytes   |          |
+	snapshotTxPrefix = []byte("SNPTX")
+)
+
+const (
+	utxoSnapshotBaseLength = 81
+	snapshotTxKeyLength    = 5 + 8 + 4
+)
+
+func makeSnapshotTxKey(height uint64, txOff int) []byte {
+	key := make([]byte, snapshotTxKeyLength)
+	copy(key, snapshotTxPrefix)
+	binary.LittleEndian.PutUint64(key[len(snapshotTxPrefix):], height)
+	binary.LittleEndian.PutUint32(key[len(snapshotTxPrefix)+8:], uint32(txOff))
+	return key
+}
+
+func encodeUtxoSnapshotBase(base *database.UtxoSnapshotBase) []byte {
+	buf := make([]byte, utxoSnapshotBaseLength)
+	binary.LittleEndian.PutUint64(buf[0:8], base.Height)
+	copy(buf[8:40], base.BlockHash[:])
+	copy(buf[40:72], base.ContentHash[:])
+	binary.LittleEndian.PutUint64(buf[72:80], base.LowestHeight)
+	buf[80] = byte(base.State)
+	return buf
+}
+
+func decodeUtxoSnapshotBase(buf []byte) (*database.UtxoSnapshotBase, error) {
+	if len(buf) != utxoSnapshotBaseLength {
+		return nil, ErrIncorrectValueLength
+	}
+	base := &database.UtxoSnapshotBase{
+		Height:       binary.LittleEndian.Uint64(buf[0:8]),
+		LowestHeight: binary.LittleEndian.Uint64(buf[72:80]),
+		State:        database.UtxoSnapshotState(buf[80]),
+	}
+	copy(base.BlockHash[:], buf[8:40])
+	copy(base.ContentHash[:], buf[40:72])
+	return base, nil
+}
+
+// InitByUtxoSnapshot inits an empty database by the chain state of a utxo
+// snapshot.  The records of transactions, stakings and fault pks are written
+// before the blocks and the storage meta, so that a database left behind by an
+// interruption has no block and can be initialized again.
+func (db *ChainDb) InitByUtxoSnapshot(base *database.UtxoSnapshotBase, genesis *massutil.Block, blocks []*massutil.Block,
+	txs []*database.UtxoSnapshotTx, stakings []*database.UtxoSnapshotStakingTx, faultPks []*database.UtxoSnapshotFaultPk) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	if db.dbStorageMeta.currentHeight != UnknownHeight {
+		return ErrDbNotEmpty
+	}
+	if len(blocks) == 0 || genesis.Height() != 0 || blocks[0].Height() != base.LowestHeight ||
+		blocks[len(blocks)-1].Height() != base.Height || !blocks[len(blocks)-1].Hash().IsEqual(&base.BlockHash) {
+		return ErrInvalidUtxoSnapshot
+	}
+
+	batch := db.stor.NewBatch()
+	defer batch.Release()
+	count := 0
+	flush := func() error {
+		if count++; count%5000 != 0 {
+			return nil
+		}
+		if err := db.stor.Write(batch); err != nil {
+			return err
+		}
+		batch.Reset()
+		return nil
+	}
+
+	for _, stx := range txs {
+		if len(stx.Spent) != len(stx.Tx.TxOut) {
+			return ErrInvalidUtxoSnapshot
+		}
+		spentbuflen := (len(stx.Spent) + 7) / 8
+		spentbuf := make([]byte, spentbuflen)
+		for i, spent := range stx.Spent {
+			if spent {
+				spentbuf[i/8] |= byte(1) << uint(i%8)
+			}
+		}
+		// same as submitBlock, bits beyond outputs are set except for genesis
+		if stx.Height != 0 && len(stx.Spent)%8 != 0 {
+			for i := uint(len(stx.Spent) % 8); i < 8; i++ {
+				spentbuf[spentbuflen-1] |= byte(1) << i
+			}
+		}
+		txSha := stx.Tx.TxHash()
+		txU := &txUpdateObj{
+			blkHeight: stx.Height,
+			txoff:     stx.TxLoc.TxStart,
+			txlen:     stx.TxLoc.TxLen,
+			spentData: spentbuf,
+		}
+		if err := batch.Put(shaTxToKey(&txSha), db.formatTx(txU)); err != nil {
+			return err
+		}
+
+		// blocks of the snapshot and genesis are stored
+		if stx.Height < base.LowestHeight && stx.Height != 0 {
+			raw, err := stx.Tx.Bytes(wire.DB)
+			if err != nil {
+				return err
+			}
+			if len(raw) != stx.TxLoc.TxLen {
+				return ErrInvalidUtxoSnapshot
+			}
+			value := make([]byte, wire.HashSize+len(raw))
+			copy(value, stx.BlkSha[:])
+			copy(value[wire.HashSize:], raw)
+			if err = batch.Put(makeSnapshotTxKey(stx.Height, stx.TxLoc.TxStart), value); err != nil {
+				return err
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	for _, stk := range stakings {
+		mapKey := stakingTxMapKey{
+			blockHeight: stk.Info.BlkHeight,
+			txID:        stk.OutPoint.Hash,
+			index:       stk.OutPoint.Index,
+		}
+		stx := &stakingTx{rsh: stk.ScriptHash, value: stk.Info.Value}
+		if err := batch.Put(heightStakingTxToKey(stk.Info.BlkHeight+stk.Info.FrozenPeriod, mapKey), db.formatSTx(stx)); err != nil {
+			return err
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	fpksByHeight := make(map[uint64][]*wire.FaultPubKey)
+	for _, fpk := range faultPks {
+		fpksByHeight[fpk.Height] = append(fpksByHeight[fpk.Height], fpk.FaultPk)
+	}
+	for height, fpks := range fpksByHeight {
+		if err := insertFaultPks(batch, height, fpks); err != nil {
+			return err
+		}
+	}
+	if err := db.stor.Write(batch); err != nil {
+		return err
+	}
+	batch.Reset()
+
+	for _, blk := range append([]*massutil.Block{genesis}, blocks...) {
+		rawMsg, err := blk.MsgBlock().Bytes(wire.DB)
+		if err != nil {
+			return err
+		}
+		blkFile, offset, err := db.blkFileKeeper.SaveRawBlockToDisk(rawMsg, blk.Height(), blk.MsgBlock().Header.Timestamp.Unix())
+		if err != nil {
+			logging.CPrint(logging.ERROR, "save block to disk failed", logging.LogFormat{"block": blk.Hash(), "err": err})
+			return err
+		}
+		if err = putRawBlockIndex(batch, blk, blkFile, offset, int64(len(rawMsg))); err != nil {
+			return err
+		}
+	}
+	meta := dbStorageMeta{
+		currentHeight: base.Height,
+		currentHash:   base.BlockHash,
+	}
+	if err := batch.Put(dbStorageMetaDataKey, encodeDBStorageMetaData(meta)); err != nil {
+		return err
+	}
+	if err := batch.Put(utxoSnapshotBaseKey, encodeUtxoSnapshotBase(base)); err != nil {
+		return err
+	}
+	if err := db.stor.Write(batch); err != nil {
+		return err
+	}
+	db.blkFileKeeper.CommitRecentChange()
+	db.dbStorageMeta = meta
+
+	// no history to index, the address utxo index starts from the utxos
+	return buildAddrUtxoIndexFromUtxos(db, "utxo snapshot")
+}
+
+// FetchUtxoSnapshotBase returns the utxo snapshot the database has been
+// initialized by, nil if it has been initialized by the genesis block.
+func (db *ChainDb) FetchUtxoSnapshotBase() (*database.UtxoSnapshotBase, error) {
+	buf, err := db.stor.Get(utxoSnapshotBaseKey)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeUtxoSnapshotBase(buf)
+}
+
+// SetUtxoSnapshotState records the result of validating the history up to the
+// utxo snapshot the database has been initialized by.
+func (db *ChainDb) SetUtxoSnapshotState(state database.UtxoSnapshotState) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	base, err := db.FetchUtxoSnapshotBase()
+	if err != nil {
+		return err
+	}
+	if base == nil {
+		return ErrUtxoSnapshotNotFound
+	}
+	base.State = state
+	return db.stor.Put(utxoSnapshotBaseKey, encodeUtxoSnapshotBase(base))
+}
+
+// fetchSnapshotTx returns a transaction of the utxo snapshot or of a pruned
+// block file, whose block is not readable.
+func (db *ChainDb) fetchSnapshotTx(blkHeight uint64, txOff int, txLen int) (*wire.MsgTx, *wire.Hash, error) {
+	value, err := db.stor.Get(makeSnapshotTxKey(blkHeight, txOff))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(value) != wire.HashSize+txLen {
+		return nil, nil, ErrIncorrectValueLength
+	}
+	var blkSha wire.Hash
+	copy(blkSha[:], value[:wire.HashSize])
+
+	var tx wire.MsgTx
+	if err = tx.SetBytes(value[wire.HashSize:], wire.DB); err != nil {
+		logging.CPrint(logging.WARN, "unable to decode snapshot tx",
+			logging.LogFormat{"blockHash": blkSha, "blockHeight": blkHeight, "txoff": txOff, "txlen": txLen})
+		return nil, nil, err
+	}
+	return &tx, &blkSha, nil
+}
@@ -0,0 +1,865 @@
+package ldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/database/verifydb"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/txscript"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+func init() {
+	for _, check := range []verifydb.Check{
+		{
+			Name:        "block_index",
+			Description: "block height and block hash records map to each other",
+			Repairable:  true,
+			Run:         chainDbCheck((*ChainDb).verifyBlockIndex),
+		},
+		{
+			Name:        "block_file_records",
+			Description: "records of the block files are framed and match their checksums",
+			Repairable:  true,
+			Run:         chainDbCheck((*ChainDb).verifyBlockFileRecords),
+		},
+		{
+			Name:        "tx_index",
+			Description: "transactions of the block files are recorded at their locations",
+			Run:         chainDbCheck((*ChainDb).verifyTxIndex),
+		},
+		{
+			Name:        "spent_bitmap",
+			Description: "spent bitmaps of the transaction records match the spending inputs",
+			Repairable:  true,
+			Run:         chainDbCheck((*ChainDb).verifySpentBitmaps),
+		},
+		{
+			Name:        "mined_block_index",
+			Description: "every block is indexed by the public key mining it",
+			Repairable:  true,
+			Run:         chainDbCheck((*ChainDb).verifyMinedBlockIndex),
+		},
+		{
+			Name:        "fault_pk",
+			Description: "fault public keys and punishments match the punishments of the blocks",
+			Repairable:  true,
+			Run:         chainDbCheck((*ChainDb).verifyFaultPks),
+		},
+		{
+			Name:        "addr_index_tip",
+			Description: "address index is built up to and not beyond the best block",
+			Repairable:  true,
+			Run:         chainDbCheck((*ChainDb).verifyAddrIndexTip),
+		},
+		{
+			Name:        "staking_index",
+			Description: "staking records match the staking outputs of the blocks",
+			Repairable:  true,
+			Run:         chainDbCheck((*ChainDb).verifyStakingIndex),
+		},
+		{
+			Name:        "binding_index",
+			Description: "binding records match the binding outputs of the blocks",
+			Repairable:  true,
+			Run:         chainDbCheck((*ChainDb).verifyBindingIndex),
+		},
+	} {
+		if err := verifydb.Register(check); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// chainDbCheck adapts a check of ChainDb to verifydb.  The check runs with the
+// db lock held, and is skipped on an empty database.
+func chainDbCheck(run func(db *ChainDb, ctx *verifydb.Context) error) func(ctx *verifydb.Context) error {
+	return func(ctx *verifydb.Context) error {
+		db, ok := ctx.DB.(*ChainDb)
+		if !ok {
+			return verifydb.ErrUnsupportedDb
+		}
+		db.dbLock.Lock()
+		defer db.dbLock.Unlock()
+
+		if db.dbStorageMeta.currentHeight == UnknownHeight {
+			return nil
+		}
+		return run(db, ctx)
+	}
+}
+
+// repairBatch collects the repairs of a check, which are written once the
+// check is done.
+type repairBatch struct {
+	db    *ChainDb
+	ctx   *verifydb.Context
+	batch storage.Batch
+	count int
+}
+
+func (db *ChainDb) newRepairBatch(ctx *verifydb.Context) *repairBatch {
+	return &repairBatch{db: db, ctx: ctx, batch: db.stor.NewBatch()}
+}
+
+// apply queues the repair of fn in repair mode, returning whether it is
+// queued.
+func (b *repairBatch) apply(fn func(batch storage.Batch) error) bool {
+	if !b.ctx.Repair || fn(b.batch) != nil {
+		return false
+	}
+	b.count++
+	return true
+}
+
+func (b *repairBatch) put(key, value []byte) bool {
+	return b.apply(func(batch storage.Batch) error { return batch.Put(key, value) })
+}
+
+func (b *repairBatch) delete(key []byte) bool {
+	return b.apply(func(batch storage.Batch) error { return batch.Delete(key) })
+}
+
+func (b *repairBatch) write() error {
+	defer b.batch.Release()
+	if b.count == 0 {
+		return nil
+	}
+	return b.db.stor.Write(b.batch)
+}
+
+// forEachMainBlock calls fn with every main chain block, reporting the blocks
+// unable to be read as issues.  Pruned blocks are skipped, or returned as
+// database.ErrBlockPruned if allowPruned is false.
+func (db *ChainDb) forEachMainBlock(ctx *verifydb.Context, allowPruned bool, fn func(hash *wire.Hash, block *massutil.Block) error) error {
+	bestHeight := db.dbStorageMeta.currentHeight
+	for height := uint64(0); height <= bestHeight; height++ {
+		ctx.Progress(height, bestHeight)
+		hash, buf, err := db.getBlkByHeight(height)
+		if err != nil {
+			if err == database.ErrBlockPruned {
+				if allowPruned {
+					continue
+				}
+				return err
+			}
+			ctx.Issue(height, false, "unable to read block: %v", err)
+			continue
+		}
+		block, err := massutil.NewBlockFromBytes(buf, wire.DB)
+		if err != nil {
+			ctx.Issue(height, false, "unable to decode block %s: %v", hash, err)
+			continue
+		}
+		block.SetHeight(height)
+		if err = fn(hash, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyBlockIndex checks that the "BLKHGT" record of every height is mapped
+// back by the "BLKSHA" record of its block, and that no other "BLKSHA" record
+// exists.
+func (db *ChainDb) verifyBlockIndex(ctx *verifydb.Context) error {
+	repairs := db.newRepairBatch(ctx)
+	bestHeight := db.dbStorageMeta.currentHeight
+
+	for height := uint64(0); height <= bestHeight; height++ {
+		ctx.Progress(height, bestHeight)
+		ctx.Checked(1)
+		hash, _, _, _, err := db.GetBlkLocByHeight(height)
+		if err != nil {
+			ctx.Issue(height, false, "invalid block height record: %v", err)
+			continue
+		}
+		if height == bestHeight && !hash.IsEqual(&db.dbStorageMeta.currentHash) {
+			ctx.Issue(height, false, "best block %s mismatches block height record %s", &db.dbStorageMeta.currentHash, hash)
+		}
+
+		shaHeight, err := db.getBlkHeight(hash)
+		if err != nil && err != database.ErrBlockShaMissing {
+			return err
+		}
+		if err == nil && shaHeight == height {
+			continue
+		}
+		hgtKey := makeBlockHeightKey(height)
+		repaired := repairs.put(makeBlockShaKey(hash), hgtKey[blockHeightKeyPrefixLength:])
+		if err != nil {
+			ctx.Issue(height, repaired, "missing block hash record of %s", hash)
+		} else {
+			ctx.Issue(height, repaired, "block hash record of %s at height %d", hash, shaHeight)
+		}
+	}
+
+	iter := db.stor.NewIterator(storage.BytesPrefix(blockShaKeyPrefix))
+	defer iter.Release()
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		ctx.Checked(1)
+		if len(key) != blockShaKeyLength || len(value) != 8 {
+			repaired := repairs.delete(append([]byte(nil), key...))
+			ctx.Issue(0, repaired, "malformed block hash record %x", key)
+			continue
+		}
+		var hash wire.Hash
+		copy(hash[:], key[blockShaKeyPrefixLength:])
+		height := binary.LittleEndian.Uint64(value)
+		if height <= bestHeight {
+			mainHash, err := db.fetchBlockShaByHeight(height)
+			if err != nil || mainHash.IsEqual(&hash) {
+				// missing height records are reported above
+				continue
+			}
+		}
+		repaired := repairs.delete(append([]byte(nil), key...))
+		ctx.Issue(height, repaired, "block hash record of block %s not on the main chain", &hash)
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return repairs.write()
+}
+
+// verifyBlockFileRecords scans the block files for corrupt records, which are
+// quarantined in repair mode.  Corrupt records are reported at the height of
+// the main chain block stored there, or at 0 if there is none.
+func (db *ChainDb) verifyBlockFileRecords(ctx *verifydb.Context) error {
+	report, err := db.blkFileKeeper.Scan(ctx.Repair)
+	if err != nil {
+		return err
+	}
+	ctx.Checked(uint64(report.Records + len(report.Corrupt)))
+	ctx.Progress(1, 1)
+	if len(report.Corrupt) == 0 {
+		return nil
+	}
+
+	heights := make(map[[12]byte]uint64)
+	iter := db.stor.NewIterator(storage.BytesPrefix(blockHeightKeyPrefix))
+	defer iter.Release()
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if len(key) != blockHeightKeyLength || len(value) != 52 {
+			continue
+		}
+		var loc [12]byte
+		copy(loc[:], value[32:44])
+		heights[loc] = binary.LittleEndian.Uint64(key[blockHeightKeyPrefixLength:])
+	}
+	if err = iter.Error(); err != nil {
+		return err
+	}
+	for _, c := range report.Corrupt {
+		var loc [12]byte
+		binary.LittleEndian.PutUint32(loc[0:4], c.FileNo)
+		binary.LittleEndian.PutUint64(loc[4:12], uint64(c.Offset))
+		ctx.Issue(heights[loc], c.Quarantined, "corrupt block record %v", c)
+	}
+	return nil
+}
+
+// verifyTxIndex checks that every transaction of the block files is recorded,
+// either in "TXD" or fully spent in "TXS", at its location in the block.
+func (db *ChainDb) verifyTxIndex(ctx *verifydb.Context) error {
+	return db.forEachMainBlock(ctx, true, func(hash *wire.Hash, block *massutil.Block) error {
+		height := block.Height()
+		if !block.Hash().IsEqual(hash) {
+			ctx.Issue(height, false, "block file holds block %s instead of %s", block.Hash(), hash)
+			return nil
+		}
+		txLocs, err := block.TxLoc()
+		if err != nil {
+			return err
+		}
+		for i, tx := range block.Transactions() {
+			ctx.Checked(1)
+			loc := txLocs[i]
+			txHeight, txOff, txLen, spentBuf, err := db.getTxData(tx.Hash())
+			if err != nil && err != storage.ErrNotFound {
+				return err
+			}
+			if err == nil && txHeight == height {
+				if txOff != loc.TxStart || txLen != loc.TxLen {
+					ctx.Issue(height, false, "tx %s recorded at %d+%d, located at %d+%d",
+						tx.Hash(), txOff, txLen, loc.TxStart, loc.TxLen)
+				} else if len(spentBuf) != (len(tx.MsgTx().TxOut)+7)/8 {
+					ctx.Issue(height, false, "tx %s has spent bitmap of %d bytes for %d outputs",
+						tx.Hash(), len(spentBuf), len(tx.MsgTx().TxOut))
+				}
+				continue
+			}
+
+			spentTxs, err := db.getTxFullySpent(tx.Hash())
+			if err != nil && err != storage.ErrNotFound {
+				return err
+			}
+			found := false
+			for _, stx := range spentTxs {
+				if stx.blkHeight != height {
+					continue
+				}
+				found = true
+				if stx.txoff != loc.TxStart || stx.txlen != loc.TxLen {
+					ctx.Issue(height, false, "fully spent tx %s recorded at %d+%d, located at %d+%d",
+						tx.Hash(), stx.txoff, stx.txlen, loc.TxStart, loc.TxLen)
+				}
+				break
+			}
+			if !found {
+				ctx.Issue(height, false, "tx %s is not recorded", tx.Hash())
+			}
+		}
+		return nil
+	})
+}
+
+// spentBitmapChunkSize is the number of "TXD" records checked in one pass over
+// the blocks, when the spent index is not built up to the best block.
+var spentBitmapChunkSize = 1 << 16
+
+// txRecord is a "TXD" record of a transaction found at its location.
+type txRecord struct {
+	key      []byte
+	hash     wire.Hash
+	height   uint64
+	txOff    int
+	txLen    int
+	numTxOut int
+	spentBuf []byte
+}
+
+// verifySpentBitmaps checks the spent bitmap of every "TXD" record against
+// the inputs of the main chain, along with the padding bits beyond the
+// outputs.  Outputs are looked up in the spent index if it is built up to the
+// best block, or else in the blocks, a chunk of records at a time to bound the
+// memory used.  Records of transactions not found at their location are
+// removed by repair, and those of fully spent transactions are moved to "TXS".
+func (db *ChainDb) verifySpentBitmaps(ctx *verifydb.Context) error {
+	bestHeight := db.dbStorageMeta.currentHeight
+	tipHash, tipHeight, err := db.FetchSpentIndexTip()
+	useIndex := err == nil && tipHeight == bestHeight && tipHash.IsEqual(&db.dbStorageMeta.currentHash)
+
+	repairs := db.newRepairBatch(ctx)
+	chunk := make([]*txRecord, 0, spentBitmapChunkSize)
+	checkChunk := func() error {
+		spent, err := db.fetchSpentOutputs(ctx, chunk, useIndex)
+		if err != nil {
+			return err
+		}
+		for _, r := range chunk {
+			db.checkSpentBitmap(ctx, repairs, r, spent)
+		}
+		chunk = chunk[:0]
+		return nil
+	}
+
+	iter := db.stor.NewIterator(storage.BytesPrefix(recordSuffixTx))
+	defer iter.Release()
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		ctx.Checked(1)
+		if len(key) != len(recordSuffixTx)+wire.HashSize || len(value) < 16 {
+			ctx.Issue(0, false, "malformed tx record %x", key)
+			continue
+		}
+		r := &txRecord{
+			key:      append([]byte(nil), key...),
+			height:   binary.LittleEndian.Uint64(value[0:8]),
+			txOff:    int(binary.LittleEndian.Uint32(value[8:12])),
+			txLen:    int(binary.LittleEndian.Uint32(value[12:16])),
+			spentBuf: append([]byte(nil), value[16:]...),
+		}
+		copy(r.hash[:], key[len(recordSuffixTx):])
+
+		var tx *wire.MsgTx
+		if r.height <= bestHeight {
+			if tx, _, err = db.fetchTxDataByLoc(r.height, r.txOff, r.txLen); err == database.ErrBlockPruned {
+				continue
+			}
+		}
+		if tx == nil || tx.TxHash() != r.hash {
+			repaired := repairs.delete(r.key)
+			ctx.Issue(r.height, repaired, "tx %s not found at its location %d+%d", &r.hash, r.txOff, r.txLen)
+			continue
+		}
+		r.numTxOut = len(tx.TxOut)
+
+		if chunk = append(chunk, r); len(chunk) == spentBitmapChunkSize {
+			if err = checkChunk(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if len(chunk) > 0 {
+		if err := checkChunk(); err != nil {
+			return err
+		}
+	}
+	return repairs.write()
+}
+
+// fetchSpentOutputs returns the outputs of records spent by the main chain.
+func (db *ChainDb) fetchSpentOutputs(ctx *verifydb.Context, records []*txRecord, useIndex bool) (map[wire.OutPoint]struct{}, error) {
+	spent := make(map[wire.OutPoint]struct{})
+	if useIndex {
+		for _, r := range records {
+			for vout := 0; vout < r.numTxOut; vout++ {
+				op := wire.OutPoint{Hash: r.hash, Index: uint32(vout)}
+				ok, err := db.stor.Has(makeSpentIndexKey(&op))
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					spent[op] = struct{}{}
+				}
+			}
+		}
+		return spent, nil
+	}
+
+	// outputs are spent at or above the height of their tx
+	byHash := make(map[wire.Hash]struct{}, len(records))
+	minHeight := db.dbStorageMeta.currentHeight
+	for _, r := range records {
+		byHash[r.hash] = struct{}{}
+		if r.height < minHeight {
+			minHeight = r.height
+		}
+	}
+	bestHeight := db.dbStorageMeta.currentHeight
+	for height := minHeight; height <= bestHeight; height++ {
+		ctx.Progress(height, bestHeight)
+		hash, buf, err := db.getBlkByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		block, err := massutil.NewBlockFromBytes(buf, wire.DB)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode block %s at height %d: %v", hash, height, err)
+		}
+		for _, tx := range block.MsgBlock().Transactions[1:] {
+			for _, txIn := range tx.TxIn {
+				if _, ok := byHash[txIn.PreviousOutPoint.Hash]; ok {
+					spent[txIn.PreviousOutPoint] = struct{}{}
+				}
+			}
+		}
+	}
+	return spent, nil
+}
+
+// checkSpentBitmap checks the spent bitmap of a record against its spent
+// outputs.
+func (db *ChainDb) checkSpentBitmap(ctx *verifydb.Context, repairs *repairBatch, r *txRecord, spent map[wire.OutPoint]struct{}) {
+	expected := make([]byte, (r.numTxOut+7)/8)
+	allSpent := true
+	for i := range expected {
+		for bit := 0; bit < 8; bit++ {
+			vout := i*8 + bit
+			if vout >= r.numTxOut {
+				if r.height > 0 {
+					expected[i] |= byte(1) << uint(bit)
+				}
+				continue
+			}
+			if _, ok := spent[wire.OutPoint{Hash: r.hash, Index: uint32(vout)}]; ok {
+				expected[i] |= byte(1) << uint(bit)
+			} else {
+				allSpent = false
+			}
+		}
+	}
+	if allSpent && r.height > 0 {
+		repaired := repairs.apply(func(batch storage.Batch) error {
+			return db.moveToFullySpent(batch, r, len(expected))
+		})
+		ctx.Issue(r.height, repaired, "fully spent tx %s is recorded unspent", &r.hash)
+		return
+	}
+	if !bytes.Equal(r.spentBuf, expected) {
+		txu := &txUpdateObj{blkHeight: r.height, txoff: r.txOff, txlen: r.txLen, spentData: expected}
+		repaired := repairs.put(r.key, db.formatTx(txu))
+		ctx.Issue(r.height, repaired, "spent bitmap of tx %s is %x, expected %x", &r.hash, r.spentBuf, expected)
+	}
+}
+
+// moveToFullySpent replaces the "TXD" record of a fully spent transaction by
+// its entry in the "TXS" list of the hash, as connecting the spending block
+// does.
+func (db *ChainDb) moveToFullySpent(batch storage.Batch, r *txRecord, bitmapLen int) error {
+	spentTxs, err := db.getTxFullySpent(&r.hash)
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	listed := false
+	for _, stx := range spentTxs {
+		if stx.blkHeight == r.height {
+			listed = true
+			break
+		}
+	}
+	if !listed {
+		spentTxs = append(spentTxs, &spentTx{
+			blkHeight: r.height,
+			txoff:     r.txOff,
+			txlen:     r.txLen,
+			numTxO:    8 * bitmapLen,
+		})
+		if err = batch.Put(shaSpentTxToKey(&r.hash), db.formatTxFullySpent(spentTxs)); err != nil {
+			return err
+		}
+	}
+	return batch.Delete(r.key)
+}
+
+// verifyMinedBlockIndex checks that every block is in the "MBP" index under
+// the public key of its header, and that no other "MBP" record exists.
+func (db *ChainDb) verifyMinedBlockIndex(ctx *verifydb.Context) error {
+	repairs := db.newRepairBatch(ctx)
+	bestHeight := db.dbStorageMeta.currentHeight
+
+	err := db.forEachMainBlock(ctx, true, func(hash *wire.Hash, block *massutil.Block) error {
+		ctx.Checked(1)
+		key, err := minedBlockIndexToKey(block.MsgBlock().Header.PublicKey(), block.Height())
+		if err != nil {
+			ctx.Issue(block.Height(), false, "unable to index block %s: %v", hash, err)
+			return nil
+		}
+		exists, err := db.stor.Has(key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			repaired := repairs.put(key, blankData)
+			ctx.Issue(block.Height(), repaired, "block %s is missing from the mined block index", hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	iter := db.stor.NewIterator(storage.BytesPrefix(minedBlockIndexPrefix))
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		ctx.Checked(1)
+		pkLen := len(key) - len(minedBlockIndexPrefix) - 8
+		if pkLen != PUBLICKEYLENGTH_MASS && pkLen != PUBLICKEYLENGTH_CHIA {
+			repaired := repairs.delete(append([]byte(nil), key...))
+			ctx.Issue(0, repaired, "malformed mined block record %x", key)
+			continue
+		}
+		height := binary.LittleEndian.Uint64(key[len(key)-8:])
+		if height <= bestHeight {
+			hash, err := db.fetchBlockShaByHeight(height)
+			if err != nil {
+				continue
+			}
+			header, err := db.FetchBlockHeaderBySha(hash)
+			if err == database.ErrBlockPruned {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			pk := key[len(minedBlockIndexPrefix) : len(key)-8]
+			if bytes.Equal(header.PublicKey().SerializeCompressed(), pk) {
+				continue
+			}
+		}
+		repaired := repairs.delete(append([]byte(nil), key...))
+		ctx.Issue(height, repaired, "mined block record %x of a block not on the main chain", key)
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return repairs.write()
+}
+
+// verifyFaultPks checks the "BANPUB" and "BANHGT" records against the
+// punishments of the blocks, and that the public keys already banned are no
+// longer waiting for punishment in "PUNISH".
+func (db *ChainDb) verifyFaultPks(ctx *verifydb.Context) error {
+	repairs := db.newRepairBatch(ctx)
+	bestHeight := db.dbStorageMeta.currentHeight
+
+	err := db.forEachMainBlock(ctx, true, func(hash *wire.Hash, block *massutil.Block) error {
+		height := block.Height()
+		faultPks := block.MsgBlock().Proposals.PunishmentArea
+		expected := make([]*wire.Hash, 0, len(faultPks))
+		for _, fpk := range faultPks {
+			ctx.Checked(1)
+			sha := wire.DoubleHashH(fpk.PubKey.SerializeUncompressed())
+			expected = append(expected, &sha)
+			fpkHeight, _, err := db.getFaultPkData(&sha)
+			if err != nil && err != storage.ErrNotFound {
+				ctx.Issue(height, false, "unable to read fault pk %s: %v", &sha, err)
+			} else if err != nil || fpkHeight != height {
+				repaired := repairs.apply(func(batch storage.Batch) error {
+					return insertFaultPk(batch, height, fpk, &sha)
+				})
+				ctx.Issue(height, repaired, "fault pk %s punished by block %s is not recorded", &sha, hash)
+			}
+
+			key, err := punishmentPubKeyToKey(fpk.PubKey)
+			if err != nil {
+				return err
+			}
+			exists, err := db.stor.Has(key)
+			if err != nil {
+				return err
+			}
+			if exists {
+				repaired := repairs.delete(key)
+				ctx.Issue(height, repaired, "fault pk %s punished by block %s is still waiting for punishment", &sha, hash)
+			}
+		}
+
+		shas, err := db.getFaultPkShasByHeight(height)
+		if err != nil {
+			return err
+		}
+		if equalHashes(shas, expected) {
+			return nil
+		}
+		if len(expected) == 0 {
+			repaired := repairs.delete(faultPkHeightToKey(height))
+			ctx.Issue(height, repaired, "fault pk height record of block %s punishing none", hash)
+			return nil
+		}
+		var value bytes.Buffer
+		var b2 [2]byte
+		binary.LittleEndian.PutUint16(b2[:], uint16(len(expected)))
+		value.Write(b2[:])
+		for _, sha := range expected {
+			value.Write(sha[:])
+		}
+		repaired := repairs.put(faultPkHeightToKey(height), value.Bytes())
+		ctx.Issue(height, repaired, "fault pk height record of block %s lists %d fault pks, expected %d", hash, len(shas), len(expected))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	iter := db.stor.NewIterator(storage.BytesPrefix(faultPkShaDataPrefix))
+	defer iter.Release()
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		ctx.Checked(1)
+		if len(key) != faultPkShaDataPrefixLen+wire.HashSize || len(value) < 8 {
+			repaired := repairs.delete(append([]byte(nil), key...))
+			ctx.Issue(0, repaired, "malformed fault pk record %x", key)
+			continue
+		}
+		var sha wire.Hash
+		copy(sha[:], key[faultPkShaDataPrefixLen:])
+		height := binary.LittleEndian.Uint64(value[:8])
+		if height <= bestHeight {
+			shas, err := db.getFaultPkShasByHeight(height)
+			if err != nil {
+				return err
+			}
+			if containsHash(shas, &sha) {
+				continue
+			}
+		}
+		repaired := repairs.delete(append([]byte(nil), key...))
+		ctx.Issue(height, repaired, "fault pk %s not punished by the main chain", &sha)
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	hgtIter := db.stor.NewIterator(storage.BytesPrefix(faultPkHeightShaPrefix))
+	defer hgtIter.Release()
+	for hgtIter.Next() {
+		key := hgtIter.Key()
+		if len(key) != len(faultPkHeightShaPrefix)+8 {
+			continue
+		}
+		if height := binary.LittleEndian.Uint64(key[len(faultPkHeightShaPrefix):]); height > bestHeight {
+			repaired := repairs.delete(append([]byte(nil), key...))
+			ctx.Issue(height, repaired, "fault pk height record above the best height %d", bestHeight)
+		}
+	}
+	if err := hgtIter.Error(); err != nil {
+		return err
+	}
+
+	punishIter := db.stor.NewIterator(storage.BytesPrefix(punishmentPrefix))
+	defer punishIter.Release()
+	for punishIter.Next() {
+		ctx.Checked(1)
+		if _, err := wire.NewFaultPubKeyFromBytes(punishIter.Value(), wire.DB); err != nil {
+			repaired := repairs.delete(append([]byte(nil), punishIter.Key()...))
+			ctx.Issue(0, repaired, "malformed punishment record %x: %v", punishIter.Key(), err)
+		}
+	}
+	if err := punishIter.Error(); err != nil {
+		return err
+	}
+	return repairs.write()
+}
+
+func equalHashes(a, b []*wire.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].IsEqual(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsHash(list []*wire.Hash, hash *wire.Hash) bool {
+	for _, h := range list {
+		if h.IsEqual(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAddrIndexTip checks the address index version, that the outputs of
+// the best block are indexed, and that no block above the best block is.
+func (db *ChainDb) verifyAddrIndexTip(ctx *verifydb.Context) error {
+	ctx.Checked(1)
+	if err := db.checkAddrIndexVersion(); err != nil {
+		ctx.Issue(0, false, "address index version: %v", err)
+	}
+
+	tipHash, tipHeight, err := db.FetchAddrIndexTip()
+	if err != nil {
+		return err
+	}
+	hash, buf, err := db.getBlkByHeight(tipHeight)
+	if err == database.ErrBlockPruned {
+		return nil
+	}
+	if err != nil {
+		ctx.Issue(tipHeight, false, "unable to read address index tip: %v", err)
+		return nil
+	}
+	if !hash.IsEqual(tipHash) {
+		ctx.Issue(tipHeight, false, "address index tip %s mismatches block %s", tipHash, hash)
+	}
+	block, err := massutil.NewBlockFromBytes(buf, wire.DB)
+	if err != nil {
+		ctx.Issue(tipHeight, false, "unable to decode block %s: %v", hash, err)
+		return nil
+	}
+	for _, tx := range block.MsgBlock().Transactions {
+		for _, txOut := range tx.TxOut {
+			class, pops := txscript.GetScriptInfo(txOut.PkScript)
+			_, scriptHash, err := txscript.GetParsedOpcode(pops, class)
+			if err != nil {
+				continue
+			}
+			ctx.Checked(1)
+			htsKey, htsBoundary := encodeHTSKey(tipHeight, scriptHash)
+			if !db.hasIndexBit(htsKey, tipHeight-htsBoundary) {
+				ctx.Issue(tipHeight, false, "output of tx %s at the tip is missing from the address index", tx.TxHash())
+				continue
+			}
+			stlKey, stlBoundary := encodeSTLKey(tipHeight, scriptHash)
+			if !db.hasIndexBit(stlKey, tipHeight-stlBoundary) {
+				ctx.Issue(tipHeight, false, "output of tx %s at the tip is missing from the tx index", tx.TxHash())
+			}
+		}
+	}
+
+	// "HTS" records beyond the tip, which are left by an interrupted
+	// disconnection of blocks
+	repairs := db.newRepairBatch(ctx)
+	start, boundary := encodeHTSSearchKeyPrefix(tipHeight)
+	iter := db.stor.NewIterator(&storage.Range{Start: start, Limit: storage.BytesPrefix(shIndexPrefix).Limit})
+	defer iter.Release()
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		ctx.Checked(1)
+		if len(key) != shIndexKeyLen || len(value) != 4 {
+			repaired := repairs.delete(append([]byte(nil), key...))
+			ctx.Issue(tipHeight, repaired, "malformed address index record %x", key)
+			continue
+		}
+		keyBoundary := binary.BigEndian.Uint64(key[3:11])
+		bitmap := binary.LittleEndian.Uint32(value)
+		var kept uint32
+		if keyBoundary == boundary {
+			kept = bitmap & (uint32(0x01)<<(tipHeight-boundary+1) - 1)
+		}
+		if kept == bitmap {
+			continue
+		}
+		var repaired bool
+		if kept == 0 {
+			repaired = repairs.delete(append([]byte(nil), key...))
+		} else {
+			newValue := make([]byte, 4)
+			binary.LittleEndian.PutUint32(newValue, kept)
+			repaired = repairs.put(append([]byte(nil), key...), newValue)
+		}
+		ctx.Issue(keyBoundary, repaired, "address index record %x has blocks above the tip %d", key, tipHeight)
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return repairs.write()
+}
+
+// hasIndexBit returns whether the bitmap leading the value of key has the
+// bit set.
+func (db *ChainDb) hasIndexBit(key []byte, bit uint64) bool {
+	value, err := db.stor.Get(key)
+	if err != nil || len(value) < 4 {
+		return false
+	}
+	return binary.LittleEndian.Uint32(value[0:4])&(uint32(0x01)<<bit) != 0
+}
+
+// verifyStakingIndex checks the staking records by CheckStakingTxIndex, which
+// stops at the first issue found, and rebuilds them in repair mode.
+func (db *ChainDb) verifyStakingIndex(ctx *verifydb.Context) error {
+	return verifyRebuildable(ctx, db.CheckStakingTxIndex)
+}
+
+// verifyBindingIndex checks the binding records by CheckBindingIndex, which
+// stops at the first issue found, and rebuilds them in repair mode.
+func (db *ChainDb) verifyBindingIndex(ctx *verifydb.Context) error {
+	return verifyRebuildable(ctx, db.CheckBindingIndex)
+}
+
+func verifyRebuildable(ctx *verifydb.Context, check func(rebuild bool) error) error {
+	ctx.Progress(0, 1)
+	defer ctx.Progress(1, 1)
+
+	ctx.Checked(1)
+	err := check(false)
+	if err == nil {
+		return nil
+	}
+	if !ctx.Repair {
+		ctx.Issue(0, false, "%v", err)
+		return nil
+	}
+	if rebuildErr := check(true); rebuildErr != nil {
+		ctx.Issue(0, false, "%v, rebuilding: %v", err, rebuildErr)
+		return nil
+	}
+	ctx.Issue(0, true, "%v", err)
+	return nil
+}
@@ -0,0 +1,233 @@
+package ldb_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/database/ldb"
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/database/verifydb"
+)
+
+func TestVerifyDB(t *testing.T) {
+	db, tearDown, err := GetDb("TestVerifyDB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = initBlocks(db, 20); err != nil {
+		tearDown()
+		t.Fatal(err)
+	}
+	hash, err := db.FetchBlockShaByHeight(10)
+	if err != nil {
+		tearDown()
+		t.Fatal(err)
+	}
+	db.Close()
+	defer os.RemoveAll(testDbRoot)
+
+	// drop the hash record of block 10 and the mined block records
+	dbPath := filepath.Join(testDbRoot, "TestVerifyDB")
+	stor, err := storage.OpenStorage(dbtype, dbPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stor.Delete(append([]byte("BLKSHA"), hash[:]...)); err != nil {
+		t.Fatal(err)
+	}
+	iter := stor.NewIterator(storage.BytesPrefix([]byte("MBP")))
+	var mined int
+	for iter.Next() {
+		if err = stor.Delete(append([]byte(nil), iter.Key()...)); err != nil {
+			t.Fatal(err)
+		}
+		mined++
+	}
+	iter.Release()
+	stor.Close()
+	if mined != 20 {
+		t.Fatalf("mined block records, got = %d, want = 20", mined)
+	}
+
+	db, err = database.OpenDB(dbtype, dbPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	checks := []string{"block_index", "mined_block_index"}
+	var progressed int
+	opts := &verifydb.Options{
+		Checks:   checks,
+		Progress: func(verifydb.Progress) { progressed++ },
+	}
+	reports, err := verifydb.Run(db, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != len(checks) {
+		t.Fatalf("reports, got = %d, want = %d", len(reports), len(checks))
+	}
+	for i, want := range []int{1, 20} {
+		if r := reports[i]; r.Err != nil || r.NumIssues != want || r.Repaired != 0 || r.OK() {
+			t.Errorf("%s, got = %+v, want %d issues", r.Check, r, want)
+		}
+	}
+	if progressed == 0 {
+		t.Error("no progress reported")
+	}
+
+	opts.Repair = true
+	if reports, err = verifydb.Run(db, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range reports {
+		if !r.OK() || r.Repaired == 0 {
+			t.Errorf("%s not repaired, got = %+v", r.Check, r)
+		}
+	}
+
+	opts.Repair = false
+	if reports, err = verifydb.Run(db, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range reports {
+		if r.NumIssues != 0 || !r.OK() {
+			t.Errorf("%s after repair, got = %+v", r.Check, r)
+		}
+	}
+
+	if _, err = verifydb.Run(db, nil, &verifydb.Options{Checks: []string{"unknown"}}); err == nil {
+		t.Error("unknown check should fail")
+	}
+}
+
+// corruptSpentBitmaps moves a fully spent tx back to "TXD" with an empty spent
+// bitmap, and flips a spent bit of another tx.  The records as they were are
+// returned, nil for the records not existing.
+func corruptSpentBitmaps(t *testing.T, dbPath string) map[string][]byte {
+	stor, err := storage.OpenStorage(dbtype, dbPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stor.Close()
+
+	var spentKey, spentValue, unspentKey, unspentValue []byte
+	iter := stor.NewIterator(storage.BytesPrefix([]byte("TXS")))
+	for iter.Next() {
+		if len(iter.Value()) == 20 {
+			spentKey = append([]byte(nil), iter.Key()...)
+			spentValue = append([]byte(nil), iter.Value()...)
+			break
+		}
+	}
+	iter.Release()
+	iter = stor.NewIterator(storage.BytesPrefix([]byte("TXD")))
+	for iter.Next() {
+		if binary.LittleEndian.Uint64(iter.Value()[0:8]) > 0 {
+			unspentKey = append([]byte(nil), iter.Key()...)
+			unspentValue = append([]byte(nil), iter.Value()...)
+			break
+		}
+	}
+	iter.Release()
+	if spentKey == nil || unspentKey == nil {
+		t.Fatal("no tx records to corrupt")
+	}
+
+	txdKey := append([]byte("TXD"), spentKey[3:]...)
+	numTxO := binary.LittleEndian.Uint32(spentValue[16:20])
+	txdValue := append(append([]byte(nil), spentValue[:16]...), make([]byte, numTxO/8)...)
+	flipped := append([]byte(nil), unspentValue...)
+	flipped[16] ^= 1
+	if err = stor.Delete(spentKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = stor.Put(txdKey, txdValue); err != nil {
+		t.Fatal(err)
+	}
+	if err = stor.Put(unspentKey, flipped); err != nil {
+		t.Fatal(err)
+	}
+	return map[string][]byte{
+		string(spentKey):   spentValue,
+		string(txdKey):     nil,
+		string(unspentKey): unspentValue,
+	}
+}
+
+func TestVerifySpentBitmaps(t *testing.T) {
+	defer func(size int) { *ldb.SpentBitmapChunkSize = size }(*ldb.SpentBitmapChunkSize)
+	*ldb.SpentBitmapChunkSize = 3
+
+	db, tearDown, err := GetDb("TestVerifySpentBitmaps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = initBlocks(db, 40); err != nil {
+		tearDown()
+		t.Fatal(err)
+	}
+	db.Close()
+	defer os.RemoveAll(testDbRoot)
+	dbPath := filepath.Join(testDbRoot, "TestVerifySpentBitmaps")
+
+	// records are checked against the blocks a chunk at a time, then
+	// against the spent index once it is built up to the best block
+	for _, indexed := range []bool{false, true} {
+		records := corruptSpentBitmaps(t, dbPath)
+		db, err = database.OpenDB(dbtype, dbPath, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if indexed {
+			blks, err := loadTopNBlk(40)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, blk := range blks {
+				if err = db.SubmitSpentIndex(blk); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		opts := &verifydb.Options{Checks: []string{"spent_bitmap"}}
+		for _, repair := range []bool{false, true} {
+			opts.Repair = repair
+			reports, err := verifydb.Run(db, nil, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r := reports[0]; r.Err != nil || r.NumIssues != 2 || r.Repaired != r.NumIssues && repair {
+				t.Errorf("indexed %v, repair %v, got = %+v, want 2 issues", indexed, repair, r)
+			}
+		}
+		opts.Repair = false
+		reports, err := verifydb.Run(db, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := reports[0]; r.NumIssues != 0 || !r.OK() {
+			t.Errorf("indexed %v, after repair, got = %+v", indexed, r)
+		}
+		db.Close()
+
+		// the fully spent tx is back in "TXS"
+		stor, err := storage.OpenStorage(dbtype, dbPath, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, want := range records {
+			got, err := stor.Get([]byte(key))
+			if want == nil && err != storage.ErrNotFound || want != nil && !bytes.Equal(got, want) {
+				t.Errorf("indexed %v, record %x, got = %x (%v), want = %x", indexed, key, got, err, want)
+			}
+		}
+		stor.Close()
+	}
+}
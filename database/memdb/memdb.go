@@ -24,6 +24,10 @@ type levelIterator struct {
 	slice *dbstorage.Range
 }
 
+type levelSnapshot struct {
+	snap *leveldb.Snapshot
+}
+
 func NewMemDb() (database.Db, error) {
 	stor, err := newMemStorage()
 	if err != nil {
@@ -92,6 +96,18 @@ func (l *memLevelDB) Write(batch dbstorage.Batch) error {
 }
 
 func (l *memLevelDB) NewIterator(slice *dbstorage.Range) dbstorage.Iterator {
+	return newLevelIterator(l.db.NewIterator, slice)
+}
+
+func (l *memLevelDB) NewSnapshot() (dbstorage.Snapshot, error) {
+	snap, err := l.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &levelSnapshot{snap: snap}, nil
+}
+
+func newLevelIterator(newIter func(*util.Range, *opt.ReadOptions) iterator.Iterator, slice *dbstorage.Range) dbstorage.Iterator {
 	if slice == nil {
 		slice = &dbstorage.Range{}
 	} else {
@@ -104,13 +120,38 @@ func (l *memLevelDB) NewIterator(slice *dbstorage.Range) dbstorage.Iterator {
 	}
 	return &levelIterator{
 		slice: slice,
-		iter: l.db.NewIterator(&util.Range{
+		iter: newIter(&util.Range{
 			Start: slice.Start,
 			Limit: slice.Limit,
 		}, nil),
 	}
 }
 
+// -------------levelSnapshot-------------
+
+func (s *levelSnapshot) Get(key []byte) ([]byte, error) {
+	value, err := s.snap.Get(key, nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil, dbstorage.ErrNotFound
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *levelSnapshot) Has(key []byte) (bool, error) {
+	return s.snap.Has(key, nil)
+}
+
+func (s *levelSnapshot) NewIterator(slice *dbstorage.Range) dbstorage.Iterator {
+	return newLevelIterator(s.snap.NewIterator, slice)
+}
+
+func (s *levelSnapshot) Release() {
+	s.snap.Release()
+}
+
 // -------------levelBatch-------------
 
 func (b *levelBatch) Put(key, value []byte) error {
@@ -0,0 +1,294 @@
+// Package instrumented provides a storage driver decorator which collects
+// operation counts per key prefix, latency histograms and bytes read and
+// written of the storages it opens.
+package instrumented
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wangxinyu2018/mass-core/database/storage"
+)
+
+// DbTypePrefix is prepended to the type of the decorated driver to make the
+// type of the instrumented driver.
+const DbTypePrefix = storage.InstrumentedPrefix
+
+// DbType returns the type of the instrumented driver around dbType.
+func DbType(dbType string) string {
+	return storage.DbType(dbType, true)
+}
+
+var (
+	registryMtx sync.Mutex
+	registry    = make(map[*instrumentedStorage]struct{})
+)
+
+// NewDriver returns a driver opening storages of dbType, counting their
+// operations separately for each of the prefixes.  It is registered with
+// storage.RegisterDriver, under the type returned by DbType(dbType).
+func NewDriver(dbType string, prefixes []Prefix) storage.StorageDriver {
+	return storage.StorageDriver{
+		DbType: DbType(dbType),
+		CreateStorage: func(storPath string, args ...interface{}) (storage.Storage, error) {
+			s, err := storage.CreateStorage(dbType, storPath, args...)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(s, dbType, storPath, prefixes), nil
+		},
+		OpenStorage: func(storPath string, readonly bool, args ...interface{}) (storage.Storage, error) {
+			s, err := storage.OpenStorage(dbType, storPath, readonly, args...)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(s, dbType, storPath, prefixes), nil
+		},
+	}
+}
+
+// AllStats returns the stats of all open instrumented storages, ordered by
+// path.
+func AllStats() []Stats {
+	registryMtx.Lock()
+	storages := make([]*instrumentedStorage, 0, len(registry))
+	for s := range registry {
+		storages = append(storages, s)
+	}
+	registryMtx.Unlock()
+
+	stats := make([]Stats, 0, len(storages))
+	for _, s := range storages {
+		stats = append(stats, s.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Path < stats[j].Path })
+	return stats
+}
+
+// StatsOf returns the stats of s, or false if s is not an instrumented storage.
+func StatsOf(s storage.Storage) (Stats, bool) {
+	is, ok := s.(*instrumentedStorage)
+	if !ok {
+		return Stats{}, false
+	}
+	return is.Stats(), true
+}
+
+type instrumentedStorage struct {
+	inner  storage.Storage
+	dbType string
+	path   string
+	c      *collector
+}
+
+type instrumentedSnapshot struct {
+	inner storage.Snapshot
+	c     *collector
+}
+
+type instrumentedBatch struct {
+	inner   storage.Batch
+	c       *collector
+	entries []batchEntry
+}
+
+// batchEntry is a pending batch operation, counted once the batch is written.
+type batchEntry struct {
+	p       *prefixCounters
+	op      Op
+	written int
+}
+
+// instrumentedIterator counts the bytes it reads for the prefix of the start
+// of its range.
+type instrumentedIterator struct {
+	inner storage.Iterator
+	c     *collector
+	p     *prefixCounters
+}
+
+func wrap(s storage.Storage, dbType, path string, prefixes []Prefix) *instrumentedStorage {
+	is := &instrumentedStorage{
+		inner:  s,
+		dbType: dbType,
+		path:   path,
+		c:      newCollector(prefixes),
+	}
+	registryMtx.Lock()
+	registry[is] = struct{}{}
+	registryMtx.Unlock()
+	return is
+}
+
+// Stats returns a snapshot of the stats of the storage.
+func (s *instrumentedStorage) Stats() Stats {
+	prefixes, latency := s.c.snapshot()
+	return Stats{
+		DbType:   s.dbType,
+		Path:     s.path,
+		Prefixes: prefixes,
+		Latency:  latency,
+	}
+}
+
+func (s *instrumentedStorage) Close() error {
+	registryMtx.Lock()
+	delete(registry, s)
+	registryMtx.Unlock()
+	return s.inner.Close()
+}
+
+func (s *instrumentedStorage) Get(key []byte) ([]byte, error) {
+	return get(s.inner, s.c, key)
+}
+
+func (s *instrumentedStorage) Put(key, value []byte) error {
+	defer s.c.observe(OpPut, time.Now())
+	s.c.count(OpPut, key, 0, len(key)+len(value))
+	return s.inner.Put(key, value)
+}
+
+func (s *instrumentedStorage) Has(key []byte) (bool, error) {
+	return has(s.inner, s.c, key)
+}
+
+func (s *instrumentedStorage) Delete(key []byte) error {
+	defer s.c.observe(OpDelete, time.Now())
+	s.c.count(OpDelete, key, 0, len(key))
+	return s.inner.Delete(key)
+}
+
+func (s *instrumentedStorage) Write(batch storage.Batch) error {
+	ib, ok := batch.(*instrumentedBatch)
+	if !ok {
+		return storage.ErrInvalidBatch
+	}
+	start := time.Now()
+	if err := s.inner.Write(ib.inner); err != nil {
+		return err
+	}
+	s.c.observe(OpWrite, start)
+	for _, e := range ib.entries {
+		e.p.countOp(e.op)
+		e.p.addBytes(0, e.written)
+	}
+	return nil
+}
+
+func (s *instrumentedStorage) NewBatch() storage.Batch {
+	return &instrumentedBatch{inner: s.inner.NewBatch(), c: s.c}
+}
+
+func (s *instrumentedStorage) NewIterator(slice *storage.Range) storage.Iterator {
+	return newIterator(s.inner, s.c, slice)
+}
+
+func (s *instrumentedStorage) NewSnapshot() (storage.Snapshot, error) {
+	snap, err := s.inner.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedSnapshot{inner: snap, c: s.c}, nil
+}
+
+func get(r storage.Reader, c *collector, key []byte) ([]byte, error) {
+	defer c.observe(OpGet, time.Now())
+	value, err := r.Get(key)
+	c.count(OpGet, key, len(value), 0)
+	return value, err
+}
+
+func has(r storage.Reader, c *collector, key []byte) (bool, error) {
+	defer c.observe(OpHas, time.Now())
+	c.count(OpHas, key, 0, 0)
+	return r.Has(key)
+}
+
+func newIterator(r storage.Reader, c *collector, slice *storage.Range) storage.Iterator {
+	var start []byte
+	if slice != nil {
+		start = slice.Start
+	}
+	p := c.match(start)
+	p.countOp(OpIterate)
+	return &instrumentedIterator{inner: r.NewIterator(slice), c: c, p: p}
+}
+
+// -------------instrumentedSnapshot-------------
+
+func (s *instrumentedSnapshot) Get(key []byte) ([]byte, error) {
+	return get(s.inner, s.c, key)
+}
+
+func (s *instrumentedSnapshot) Has(key []byte) (bool, error) {
+	return has(s.inner, s.c, key)
+}
+
+func (s *instrumentedSnapshot) NewIterator(slice *storage.Range) storage.Iterator {
+	return newIterator(s.inner, s.c, slice)
+}
+
+func (s *instrumentedSnapshot) Release() {
+	s.inner.Release()
+}
+
+// -------------instrumentedBatch-------------
+
+func (b *instrumentedBatch) Put(key, value []byte) error {
+	if err := b.inner.Put(key, value); err != nil {
+		return err
+	}
+	b.entries = append(b.entries, batchEntry{p: b.c.match(key), op: OpPut, written: len(key) + len(value)})
+	return nil
+}
+
+func (b *instrumentedBatch) Delete(key []byte) error {
+	if err := b.inner.Delete(key); err != nil {
+		return err
+	}
+	b.entries = append(b.entries, batchEntry{p: b.c.match(key), op: OpDelete, written: len(key)})
+	return nil
+}
+
+func (b *instrumentedBatch) Reset() {
+	b.inner.Reset()
+	b.entries = b.entries[:0]
+}
+
+func (b *instrumentedBatch) Release() {
+	b.inner.Release()
+	b.entries = nil
+}
+
+// -----------------instrumentedIterator-----------------
+
+func (it *instrumentedIterator) Seek(key []byte) bool {
+	defer it.c.observe(OpIterate, time.Now())
+	return it.inner.Seek(key)
+}
+
+func (it *instrumentedIterator) Next() bool {
+	defer it.c.observe(OpIterate, time.Now())
+	return it.inner.Next()
+}
+
+func (it *instrumentedIterator) Key() []byte {
+	k := it.inner.Key()
+	it.p.addBytes(len(k), 0)
+	return k
+}
+
+func (it *instrumentedIterator) Value() []byte {
+	v := it.inner.Value()
+	it.p.addBytes(len(v), 0)
+	return v
+}
+
+func (it *instrumentedIterator) Release() {
+	it.inner.Release()
+}
+
+func (it *instrumentedIterator) Error() error {
+	return it.inner.Error()
+}
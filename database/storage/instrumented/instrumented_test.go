@@ -0,0 +1,105 @@
+package instrumented_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wangxinyu2018/mass-core/database/storage"
+	"github.com/wangxinyu2018/mass-core/database/storage/instrumented"
+	_ "github.com/wangxinyu2018/mass-core/database/storage/ldbstorage"
+)
+
+func TestInstrumentedStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "instrumented")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage.RegisterDriver(instrumented.NewDriver("leveldb", []instrumented.Prefix{
+		{Name: "tx", Bytes: []byte("TX")},
+		{Name: "txd", Bytes: []byte("TXD")},
+	}))
+	stor, err := storage.CreateStorage(instrumented.DbType("leveldb"), filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stor.Close()
+
+	if err = stor.Put([]byte("TXD1"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stor.Get([]byte("TXD1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stor.Get([]byte("TXS1")); err != storage.ErrNotFound {
+		t.Fatalf("got = %v, want = %v", err, storage.ErrNotFound)
+	}
+	if _, err = stor.Has([]byte("ABC")); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := stor.NewBatch()
+	batch.Put([]byte("TXD2"), []byte("v2"))
+	batch.Delete([]byte("TXD1"))
+	if err = stor.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := stor.NewSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter := snap.NewIterator(storage.BytesPrefix([]byte("TXD")))
+	var n int
+	for iter.Next() {
+		iter.Key()
+		iter.Value()
+		n++
+	}
+	iter.Release()
+	snap.Release()
+	if n != 1 {
+		t.Errorf("iterated, got = %d, want = 1", n)
+	}
+
+	stats, ok := instrumented.StatsOf(stor)
+	if !ok {
+		t.Fatal("storage is not instrumented")
+	}
+	byName := make(map[string]instrumented.PrefixStats)
+	for _, ps := range stats.Prefixes {
+		byName[ps.Name] = ps
+	}
+	txd := byName["txd"]
+	for op, want := range map[string]uint64{"put": 2, "get": 1, "delete": 1, "iterate": 1} {
+		if got := txd.Ops[op]; got != want {
+			t.Errorf("txd %s, got = %d, want = %d", op, got, want)
+		}
+	}
+	// "TXD1" + "value", "TXD2" + "v2", "TXD1"
+	if txd.BytesWritten != 9+6+4 {
+		t.Errorf("txd bytes written, got = %d, want = 19", txd.BytesWritten)
+	}
+	// "value", then "TXD2" + "v2" by the iterator
+	if txd.BytesRead != 5+6 {
+		t.Errorf("txd bytes read, got = %d, want = 11", txd.BytesRead)
+	}
+	if got := byName["tx"].Ops["get"]; got != 1 {
+		t.Errorf("tx get, got = %d, want = 1", got)
+	}
+	if got := byName[instrumented.OtherPrefix].Ops["has"]; got != 1 {
+		t.Errorf("other has, got = %d, want = 1", got)
+	}
+	if got := stats.Latency["write"].Count(); got != 1 {
+		t.Errorf("write latency count, got = %d, want = 1", got)
+	}
+	if got := stats.Latency["get"].Count(); got != 2 {
+		t.Errorf("get latency count, got = %d, want = 2", got)
+	}
+	if len(instrumented.AllStats()) != 1 {
+		t.Errorf("open storages, got = %d, want = 1", len(instrumented.AllStats()))
+	}
+}
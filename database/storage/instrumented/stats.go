@@ -0,0 +1,202 @@
+package instrumented
+
+import (
+	"bytes"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Op is a kind of storage operation.
+type Op int
+
+const (
+	OpGet Op = iota
+	OpPut
+	OpHas
+	OpDelete
+	OpWrite
+	OpIterate
+
+	numOps
+)
+
+var opStrings = [numOps]string{
+	OpGet:     "get",
+	OpPut:     "put",
+	OpHas:     "has",
+	OpDelete:  "delete",
+	OpWrite:   "write",
+	OpIterate: "iterate",
+}
+
+func (op Op) String() string {
+	if op >= 0 && op < numOps {
+		return opStrings[op]
+	}
+	return "unknown"
+}
+
+// OtherPrefix names the keys matching none of the registered prefixes.
+const OtherPrefix = "other"
+
+// Prefix names a key prefix whose operations are counted separately.
+type Prefix struct {
+	Name  string
+	Bytes []byte
+}
+
+// latencyBounds are the upper bounds of the latency histogram buckets, the
+// last bucket counts everything slower.
+var latencyBounds = []time.Duration{
+	time.Microsecond,
+	4 * time.Microsecond,
+	16 * time.Microsecond,
+	64 * time.Microsecond,
+	256 * time.Microsecond,
+	time.Millisecond,
+	4 * time.Millisecond,
+	16 * time.Millisecond,
+	64 * time.Millisecond,
+	256 * time.Millisecond,
+	time.Second,
+}
+
+// prefixCounters houses the counters of a key prefix, updated atomically.  The
+// counters come first to keep them 64-bit aligned.
+type prefixCounters struct {
+	ops          [numOps]uint64
+	bytesRead    uint64
+	bytesWritten uint64
+	name         string
+	bytes        []byte
+}
+
+// histogram is a latency histogram, updated atomically.
+type histogram struct {
+	counts [12]uint64 // len(latencyBounds) + 1
+	sum    int64      // nanoseconds
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(latencyBounds), func(i int) bool { return d <= latencyBounds[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// collector collects the stats of one storage.
+type collector struct {
+	latency  [numOps]histogram
+	prefixes []*prefixCounters // longest prefix first, OtherPrefix last
+}
+
+func newCollector(prefixes []Prefix) *collector {
+	c := &collector{prefixes: make([]*prefixCounters, 0, len(prefixes)+1)}
+	for _, p := range prefixes {
+		c.prefixes = append(c.prefixes, &prefixCounters{name: p.Name, bytes: p.Bytes})
+	}
+	// match longer prefixes first, such as "fblatest" before "fb"
+	sort.SliceStable(c.prefixes, func(i, j int) bool {
+		return len(c.prefixes[i].bytes) > len(c.prefixes[j].bytes)
+	})
+	c.prefixes = append(c.prefixes, &prefixCounters{name: OtherPrefix})
+	return c
+}
+
+func (c *collector) match(key []byte) *prefixCounters {
+	for _, p := range c.prefixes[:len(c.prefixes)-1] {
+		if bytes.HasPrefix(key, p.bytes) {
+			return p
+		}
+	}
+	return c.prefixes[len(c.prefixes)-1]
+}
+
+// count counts an operation on key along with the bytes read and written.
+func (c *collector) count(op Op, key []byte, read, written int) {
+	p := c.match(key)
+	p.countOp(op)
+	p.addBytes(read, written)
+}
+
+func (p *prefixCounters) countOp(op Op) {
+	atomic.AddUint64(&p.ops[op], 1)
+}
+
+func (p *prefixCounters) addBytes(read, written int) {
+	if read > 0 {
+		atomic.AddUint64(&p.bytesRead, uint64(read))
+	}
+	if written > 0 {
+		atomic.AddUint64(&p.bytesWritten, uint64(written))
+	}
+}
+
+func (c *collector) observe(op Op, start time.Time) {
+	c.latency[op].observe(time.Since(start))
+}
+
+// PrefixStats is a snapshot of the counters of a key prefix.
+type PrefixStats struct {
+	Name         string
+	Ops          map[string]uint64
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// Histogram is a snapshot of a latency histogram.  Counts[i] is the number of
+// operations no slower than Bounds[i], the last count is for the slower ones.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Sum    time.Duration
+}
+
+// Count returns the total number of operations of the histogram.
+func (h Histogram) Count() uint64 {
+	var n uint64
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+// Stats is a snapshot of the stats of an instrumented storage.
+type Stats struct {
+	DbType   string
+	Path     string
+	Prefixes []PrefixStats
+	Latency  map[string]Histogram
+}
+
+func (c *collector) snapshot() ([]PrefixStats, map[string]Histogram) {
+	prefixes := make([]PrefixStats, 0, len(c.prefixes))
+	for _, p := range c.prefixes {
+		ps := PrefixStats{
+			Name:         p.name,
+			Ops:          make(map[string]uint64, numOps),
+			BytesRead:    atomic.LoadUint64(&p.bytesRead),
+			BytesWritten: atomic.LoadUint64(&p.bytesWritten),
+		}
+		for op := Op(0); op < numOps; op++ {
+			ps.Ops[op.String()] = atomic.LoadUint64(&p.ops[op])
+		}
+		prefixes = append(prefixes, ps)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i].Name < prefixes[j].Name })
+
+	latency := make(map[string]Histogram, numOps)
+	for op := Op(0); op < numOps; op++ {
+		h := &c.latency[op]
+		counts := make([]uint64, len(h.counts))
+		for i := range h.counts {
+			counts[i] = atomic.LoadUint64(&h.counts[i])
+		}
+		latency[op.String()] = Histogram{
+			Bounds: latencyBounds,
+			Counts: counts,
+			Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+		}
+	}
+	return prefixes, latency
+}
@@ -23,6 +23,16 @@ type levelIterator struct {
 	slice *storage.Range
 }
 
+type levelSnapshot struct {
+	snap *leveldb.Snapshot
+}
+
+// levelReader is implemented by both leveldb.DB and leveldb.Snapshot.
+type levelReader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
 func init() {
 	storage.RegisterDriver(storage.StorageDriver{
 		DbType:        "leveldb",
@@ -79,14 +89,7 @@ func (l *levelDB) Close() error {
 }
 
 func (l *levelDB) Get(key []byte) ([]byte, error) {
-	value, err := l.db.Get(key, nil)
-	if err != nil {
-		if err == leveldb.ErrNotFound {
-			return nil, storage.ErrNotFound
-		}
-		return nil, err
-	}
-	return value, nil
+	return get(l.db, key)
 }
 
 func (l *levelDB) Put(key, value []byte) error {
@@ -97,14 +100,7 @@ func (l *levelDB) Put(key, value []byte) error {
 }
 
 func (l *levelDB) Has(key []byte) (bool, error) {
-	_, err := l.Get(key)
-	if err != nil {
-		if err == storage.ErrNotFound {
-			return false, nil
-		}
-		return false, err
-	}
-	return true, nil
+	return has(l.db, key)
 }
 
 func (l *levelDB) Delete(key []byte) error {
@@ -126,6 +122,40 @@ func (l *levelDB) Write(batch storage.Batch) error {
 }
 
 func (l *levelDB) NewIterator(slice *storage.Range) storage.Iterator {
+	return newIterator(l.db, slice)
+}
+
+func (l *levelDB) NewSnapshot() (storage.Snapshot, error) {
+	snap, err := l.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &levelSnapshot{snap: snap}, nil
+}
+
+func get(r levelReader, key []byte) ([]byte, error) {
+	value, err := r.Get(key, nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+func has(r levelReader, key []byte) (bool, error) {
+	_, err := get(r, key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func newIterator(r levelReader, slice *storage.Range) storage.Iterator {
 	if slice == nil {
 		slice = &storage.Range{}
 	} else {
@@ -138,13 +168,31 @@ func (l *levelDB) NewIterator(slice *storage.Range) storage.Iterator {
 	}
 	return &levelIterator{
 		slice: slice,
-		iter: l.db.NewIterator(&util.Range{
+		iter: r.NewIterator(&util.Range{
 			Start: slice.Start,
 			Limit: slice.Limit,
 		}, nil),
 	}
 }
 
+// -------------levelSnapshot-------------
+
+func (s *levelSnapshot) Get(key []byte) ([]byte, error) {
+	return get(s.snap, key)
+}
+
+func (s *levelSnapshot) Has(key []byte) (bool, error) {
+	return has(s.snap, key)
+}
+
+func (s *levelSnapshot) NewIterator(slice *storage.Range) storage.Iterator {
+	return newIterator(s.snap, slice)
+}
+
+func (s *levelSnapshot) Release() {
+	s.snap.Release()
+}
+
 // -------------levelBatch-------------
 
 func (b *levelBatch) Put(key, value []byte) error {
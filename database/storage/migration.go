@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/wangxinyu2018/mass-core/logging"
+)
+
+// VersionFile is the name of the file in the storage directory recording the
+// storage version.
+const VersionFile = ".ver"
+
+var (
+	ErrDuplicateMigration = errors.New("duplicate storage migration")
+	ErrInvalidMigration   = errors.New("invalid storage migration")
+	ErrStorageDowngrade   = errors.New("storage version is newer than supported")
+)
+
+var (
+	// MigrationStateKey is the key of the state of the migration in
+	// progress, it is removed once the migration is done.
+	// LittleEndian
+	//
+	// value is:
+	//		[0:4]	- version being migrated to
+	//		[4:8]	- number of steps done
+	//		[8:]	- checkpoint of the step in progress
+	MigrationStateKey = []byte("MIGRATIONSTATE")
+)
+
+// Migration upgrades a storage of version Version-1 to Version.
+type Migration struct {
+	Version     int32
+	Description string
+
+	// Steps are run in order, each step is resumed from its checkpoint if
+	// interrupted, so a step must be idempotent up to its last checkpoint.
+	Steps []MigrationStep
+
+	// Estimate returns the amount of work of the migration in Unit, for
+	// the plans of a dry run.  It is optional.
+	Estimate func(ctx *MigrationContext) (uint64, error)
+	Unit     string
+}
+
+// MigrationStep is a step of a Migration.
+type MigrationStep struct {
+	Name string
+	Run  func(ctx *MigrationContext) error
+}
+
+var (
+	migrationsMtx sync.Mutex
+	migrations    = make(map[int32]Migration)
+)
+
+// RegisterMigration adds the migration to the version of m, there may be one
+// migration to each version.
+func RegisterMigration(m Migration) error {
+	if m.Version <= StorageV1 || m.Version > CurrentStorageVersion || len(m.Steps) == 0 {
+		return ErrInvalidMigration
+	}
+	migrationsMtx.Lock()
+	defer migrationsMtx.Unlock()
+
+	if _, exists := migrations[m.Version]; exists {
+		return ErrDuplicateMigration
+	}
+	migrations[m.Version] = m
+	return nil
+}
+
+// Migrations returns the registered migrations ordered by version.
+func Migrations() []Migration {
+	migrationsMtx.Lock()
+	defer migrationsMtx.Unlock()
+
+	list := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// migrationPath returns the migrations upgrading version to
+// CurrentStorageVersion.
+func migrationPath(version int32) ([]Migration, error) {
+	if version > CurrentStorageVersion {
+		return nil, fmt.Errorf("%w: version %d, supported %d", ErrStorageDowngrade, version, CurrentStorageVersion)
+	}
+	migrationsMtx.Lock()
+	defer migrationsMtx.Unlock()
+
+	path := make([]Migration, 0, CurrentStorageVersion-version)
+	for v := version + 1; v <= CurrentStorageVersion; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("%w: no migration from version %d to %d", ErrIncompatibleStorage, v-1, v)
+		}
+		path = append(path, m)
+	}
+	return path, nil
+}
+
+// MigrationOptions are the options of Upgrade.
+type MigrationOptions struct {
+	// DryRun returns the plans of the migrations to run without running
+	// them, estimating their work.
+	DryRun bool
+
+	// Progress receives the progress of the running steps, at most once for
+	// each percent done.  The progress is logged if it is nil.
+	Progress func(MigrationProgress)
+}
+
+// MigrationProgress is the progress of a running migration step.
+type MigrationProgress struct {
+	Version int32
+	Step    string
+	Done    uint64
+	Total   uint64
+}
+
+// Percent returns the progress in percent.
+func (p MigrationProgress) Percent() int {
+	if p.Total == 0 {
+		return 100
+	}
+	return int(p.Done * 100 / p.Total)
+}
+
+// MigrationPlan describes a migration run by Upgrade.
+type MigrationPlan struct {
+	Version     int32
+	Description string
+	Steps       []string
+	FirstStep   int    // index of the step to resume from
+	Work        uint64 // estimated work in Unit, only set by a dry run
+	Unit        string
+}
+
+// MigrationContext is passed to a running migration step.
+type MigrationContext struct {
+	// DB is the database opened on Storage, as passed to Upgrade.
+	DB      interface{}
+	Storage Storage
+
+	version    int32
+	step       int
+	checkpoint []byte
+	progress   func(MigrationProgress)
+	stepName   string
+	lastPct    int
+}
+
+// Checkpoint returns the checkpoint of the step saved before an interruption,
+// nil if the step starts over.
+func (ctx *MigrationContext) Checkpoint() []byte {
+	return ctx.checkpoint
+}
+
+// SaveCheckpoint saves the checkpoint of the step in batch, which is to be
+// written along with the work done up to the checkpoint.  It is written at
+// once if batch is nil.
+func (ctx *MigrationContext) SaveCheckpoint(batch Batch, checkpoint []byte) error {
+	value := encodeMigrationState(ctx.version, ctx.step, checkpoint)
+	var err error
+	if batch == nil {
+		err = ctx.Storage.Put(MigrationStateKey, value)
+	} else {
+		err = batch.Put(MigrationStateKey, value)
+	}
+	if err == nil {
+		ctx.checkpoint = checkpoint
+	}
+	return err
+}
+
+// Progress reports done of total work of the step done.
+func (ctx *MigrationContext) Progress(done, total uint64) {
+	p := MigrationProgress{Version: ctx.version, Step: ctx.stepName, Done: done, Total: total}
+	if pct := p.Percent(); pct > ctx.lastPct {
+		ctx.lastPct = pct
+		ctx.progress(p)
+	}
+}
+
+func logMigrationProgress(p MigrationProgress) {
+	logging.CPrint(logging.INFO, fmt.Sprintf("migrate to v%d %s %d%%", p.Version, p.Step, p.Percent()), logging.LogFormat{})
+}
+
+func encodeMigrationState(version int32, step int, checkpoint []byte) []byte {
+	value := make([]byte, 8+len(checkpoint))
+	binary.LittleEndian.PutUint32(value[0:4], uint32(version))
+	binary.LittleEndian.PutUint32(value[4:8], uint32(step))
+	copy(value[8:], checkpoint)
+	return value
+}
+
+type migrationState struct {
+	version    int32
+	step       int
+	checkpoint []byte
+}
+
+// loadMigrationState returns the state of the migration in progress, nil if
+// there is none.
+func loadMigrationState(stor Storage) (*migrationState, error) {
+	value, err := stor.Get(MigrationStateKey)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < 8 {
+		return nil, ErrInvalidValue
+	}
+	state := &migrationState{
+		version: int32(binary.LittleEndian.Uint32(value[0:4])),
+		step:    int(binary.LittleEndian.Uint32(value[4:8])),
+	}
+	if len(value) > 8 {
+		state.checkpoint = append([]byte(nil), value[8:]...)
+	}
+	return state, nil
+}
+
+// Upgrade migrates stor in directory storPath to CurrentStorageVersion step
+// by step, passing db to the migrations.  A storage without version file is
+// taken as current.  The version file is set to the version being migrated
+// to before a migration starts, so that a partly migrated storage is refused
+// by older versions, and an interrupted migration is resumed from its last
+// checkpoint.  It returns the plans of the migrations run, or to be run in a
+// dry run.
+func Upgrade(db interface{}, stor Storage, storPath, dbtype string, opts *MigrationOptions) ([]*MigrationPlan, error) {
+	if opts == nil {
+		opts = &MigrationOptions{}
+	}
+	progress := opts.Progress
+	if progress == nil {
+		progress = logMigrationProgress
+	}
+
+	verPath := filepath.Join(storPath, VersionFile)
+	verType, version, err := ReadVersion(verPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		verType, version = BaseDbType(dbtype), CurrentStorageVersion
+		if !opts.DryRun {
+			if err = WriteVersion(verPath, verType, version); err != nil {
+				return nil, err
+			}
+		}
+	}
+	state, err := loadMigrationState(stor)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		// the version file may be written or not
+		version = state.version - 1
+	}
+	path, err := migrationPath(version)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := make([]*MigrationPlan, 0, len(path))
+	for _, m := range path {
+		plan := &MigrationPlan{Version: m.Version, Description: m.Description, Unit: m.Unit}
+		for _, step := range m.Steps {
+			plan.Steps = append(plan.Steps, step.Name)
+		}
+		if state != nil && state.version == m.Version {
+			plan.FirstStep = state.step
+		}
+		if opts.DryRun && m.Estimate != nil {
+			ctx := &MigrationContext{DB: db, Storage: stor, version: m.Version}
+			if plan.Work, err = m.Estimate(ctx); err != nil {
+				return nil, err
+			}
+		}
+		plans = append(plans, plan)
+	}
+	if opts.DryRun {
+		return plans, nil
+	}
+
+	for i, m := range path {
+		var checkpoint []byte
+		if state != nil && state.version == m.Version {
+			checkpoint = state.checkpoint
+		} else if err = stor.Put(MigrationStateKey, encodeMigrationState(m.Version, 0, nil)); err != nil {
+			return nil, err
+		}
+		if err = WriteVersion(verPath, verType, m.Version); err != nil {
+			return nil, err
+		}
+		if err = runMigration(db, stor, m, plans[i].FirstStep, checkpoint, progress); err != nil {
+			return nil, err
+		}
+		if err = stor.Delete(MigrationStateKey); err != nil {
+			return nil, err
+		}
+	}
+	return plans, nil
+}
+
+func runMigration(db interface{}, stor Storage, m Migration, firstStep int, checkpoint []byte, progress func(MigrationProgress)) error {
+	logging.CPrint(logging.INFO, fmt.Sprintf("migrate storage to v%d", m.Version), logging.LogFormat{
+		"description": m.Description,
+		"resume_step": firstStep,
+	})
+	for i := firstStep; i < len(m.Steps); i++ {
+		step := m.Steps[i]
+		ctx := &MigrationContext{
+			DB:         db,
+			Storage:    stor,
+			version:    m.Version,
+			step:       i,
+			checkpoint: checkpoint,
+			progress:   progress,
+			stepName:   step.Name,
+			lastPct:    -1,
+		}
+		checkpoint = nil
+
+		logging.CPrint(logging.INFO, fmt.Sprintf("[%d/%d] %s", i+1, len(m.Steps), step.Name), logging.LogFormat{
+			"version": m.Version,
+			"resumed": ctx.checkpoint != nil,
+		})
+		if err := step.Run(ctx); err != nil {
+			logging.CPrint(logging.ERROR, "storage migration step failed", logging.LogFormat{
+				"version": m.Version,
+				"step":    step.Name,
+				"err":     err,
+			})
+			return err
+		}
+		if err := stor.Put(MigrationStateKey, encodeMigrationState(m.Version, i+1, nil)); err != nil {
+			return err
+		}
+	}
+	logging.CPrint(logging.INFO, fmt.Sprintf("migrated storage to v%d", m.Version), logging.LogFormat{})
+	return nil
+}
@@ -28,6 +28,12 @@ type rocksIterator struct {
 	iter    *gorocksdb.Iterator
 }
 
+type rocksSnapshot struct {
+	db   *gorocksdb.DB
+	snap *gorocksdb.Snapshot
+	ro   *gorocksdb.ReadOptions
+}
+
 func init() {
 	storage.RegisterDriver(storage.StorageDriver{
 		DbType:        "rocksdb",
@@ -91,7 +97,11 @@ func (r *rocksDB) Close() error {
 }
 
 func (r *rocksDB) Get(key []byte) ([]byte, error) {
-	value, err := r.db.Get(r.ro, key)
+	return get(r.db, r.ro, key)
+}
+
+func get(db *gorocksdb.DB, ro *gorocksdb.ReadOptions, key []byte) ([]byte, error) {
+	value, err := db.Get(ro, key)
 	if err != nil {
 		return nil, err
 	}
@@ -112,7 +122,11 @@ func (r *rocksDB) Put(key, value []byte) error {
 }
 
 func (r *rocksDB) Has(key []byte) (bool, error) {
-	_, err := r.Get(key)
+	return has(r.db, r.ro, key)
+}
+
+func has(db *gorocksdb.DB, ro *gorocksdb.ReadOptions, key []byte) (bool, error) {
+	_, err := get(db, ro, key)
 	if err != nil {
 		if err == storage.ErrNotFound {
 			return false, nil
@@ -141,6 +155,19 @@ func (r *rocksDB) Write(batch storage.Batch) error {
 }
 
 func (r *rocksDB) NewIterator(slice *storage.Range) storage.Iterator {
+	return newIterator(r.db, nil, slice)
+}
+
+func (r *rocksDB) NewSnapshot() (storage.Snapshot, error) {
+	snap := r.db.NewSnapshot()
+	ro := gorocksdb.NewDefaultReadOptions()
+	ro.SetSnapshot(snap)
+	return &rocksSnapshot{db: r.db, snap: snap, ro: ro}, nil
+}
+
+// newIterator returns an iterator reading from snap, or from the latest state
+// of db if snap is nil.
+func newIterator(db *gorocksdb.DB, snap *gorocksdb.Snapshot, slice *storage.Range) storage.Iterator {
 	if slice == nil {
 		slice = &storage.Range{}
 	} else {
@@ -153,13 +180,35 @@ func (r *rocksDB) NewIterator(slice *storage.Range) storage.Iterator {
 	}
 	ro := gorocksdb.NewDefaultReadOptions()
 	ro.SetIterateUpperBound(slice.Limit)
+	if snap != nil {
+		ro.SetSnapshot(snap)
+	}
 	return &rocksIterator{
 		started: false,
 		slice:   slice,
-		iter:    r.db.NewIterator(ro),
+		iter:    db.NewIterator(ro),
 	}
 }
 
+// -------------rocksSnapshot-------------
+
+func (s *rocksSnapshot) Get(key []byte) ([]byte, error) {
+	return get(s.db, s.ro, key)
+}
+
+func (s *rocksSnapshot) Has(key []byte) (bool, error) {
+	return has(s.db, s.ro, key)
+}
+
+func (s *rocksSnapshot) NewIterator(slice *storage.Range) storage.Iterator {
+	return newIterator(s.db, s.snap, slice)
+}
+
+func (s *rocksSnapshot) Release() {
+	s.ro.Destroy()
+	s.db.ReleaseSnapshot(s.snap)
+}
+
 // -------------rocksBatch-------------
 
 func (b *rocksBatch) Put(key, value []byte) error {
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
@@ -21,10 +22,36 @@ const (
 	//		- since 1.1.0
 	//		- save blocks to disk
 	StorageV3
+	// StorageV4
+	//		- checksum block records in block files
+	StorageV4
+	// StorageV5
+	//		- index utxos and balances by script hash
+	StorageV5
 
-	CurrentStorageVersion int32 = StorageV3
+	CurrentStorageVersion int32 = StorageV5
 )
 
+// InstrumentedPrefix is prepended to the type of a storage driver to make the
+// type of the instrumented driver decorating it.
+const InstrumentedPrefix = "instrumented-"
+
+// DbType returns the type of the driver to open a datastore of dbtype with,
+// which is the instrumented driver around dbtype if instrumented is set.
+func DbType(dbtype string, instrumented bool) string {
+	if instrumented {
+		return InstrumentedPrefix + dbtype
+	}
+	return dbtype
+}
+
+// BaseDbType returns the type of the driver decorated by dbtype, or dbtype
+// itself if it is not a decorator.  Version files record the base type, so a
+// datastore can be opened with or without decorators.
+func BaseDbType(dbtype string) string {
+	return strings.TrimPrefix(dbtype, InstrumentedPrefix)
+}
+
 const (
 	KiB = 1024
 	MiB = KiB * 1024
@@ -95,6 +122,22 @@ type Batch interface {
 	Reset()
 }
 
+// Reader is the read-only part of Storage, which is also implemented by
+// Snapshot.
+type Reader interface {
+	// Get returns ErrNotFound if key not exist
+	Get(key []byte) ([]byte, error)
+	Has(key []byte) (bool, error)
+	NewIterator(slice *Range) Iterator
+}
+
+// Snapshot is a point-in-time read view of a Storage, writes made after it is
+// taken are not visible.  It must be released once no longer used.
+type Snapshot interface {
+	Reader
+	Release()
+}
+
 type Storage interface {
 	Close() error
 	// Get returns ErrNotFound if key not exist
@@ -105,6 +148,7 @@ type Storage interface {
 	Write(batch Batch) error
 	NewBatch() Batch
 	NewIterator(slice *Range) Iterator
+	NewSnapshot() (Snapshot, error)
 }
 
 type StorageDriver struct {
@@ -169,7 +213,7 @@ func WriteVersion(path, dbtype string, version int32) error {
 	defer fo.Close()
 
 	b, err := json.Marshal(storageVersion{
-		Dbtype:  dbtype,
+		Dbtype:  BaseDbType(dbtype),
 		Version: version,
 	})
 	if err != nil {
@@ -203,8 +247,12 @@ func ReadVersion(path string) (string, int32, error) {
 	return ver.Dbtype, ver.Version, nil
 }
 
+// CheckCompatibility checks the version file of the storage in storPath,
+// creating it if missing.  The storage is compatible if it is of the base type
+// of dbtype and either of CurrentStorageVersion or an older version with
+// registered migrations to CurrentStorageVersion.
 func CheckCompatibility(dbtype, storPath string) error {
-	verFile := filepath.Join(storPath, ".ver")
+	verFile := filepath.Join(storPath, VersionFile)
 	fs, err := os.Stat(verFile)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -215,7 +263,7 @@ func CheckCompatibility(dbtype, storPath string) error {
 			defer file.Close()
 
 			data, err := json.Marshal(storageVersion{
-				Dbtype:  dbtype,
+				Dbtype:  BaseDbType(dbtype),
 				Version: CurrentStorageVersion,
 			})
 			if err != nil {
@@ -248,8 +296,12 @@ func CheckCompatibility(dbtype, storPath string) error {
 		return fmt.Errorf("unmarshal failed: %v", err)
 	}
 
-	if ver.Version == CurrentStorageVersion && ver.Dbtype == dbtype {
-		return nil
+	if ver.Dbtype != BaseDbType(dbtype) {
+		return ErrIncompatibleStorage
+	}
+	// older versions are upgraded by Upgrade if there are migrations
+	if _, err = migrationPath(ver.Version); err != nil {
+		return ErrIncompatibleStorage
 	}
-	return ErrIncompatibleStorage
+	return nil
 }
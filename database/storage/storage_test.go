@@ -1,6 +1,7 @@
 package storage_test
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -26,6 +27,7 @@ func TestAll(t *testing.T) {
 		testIterator(t)
 		testOverwrite(t)
 		testSeek(t)
+		testSnapshot(t)
 	}
 }
 
@@ -587,6 +589,22 @@ func TestCheckCompatibility(t *testing.T) {
 			"mysql",
 			storage.ErrIncompatibleStorage,
 		},
+		{
+			"instrumented dbtype",
+			storage.CurrentStorageVersion,
+			"testDb",
+			"leveldb",
+			storage.DbType("leveldb", true),
+			nil,
+		},
+		{
+			"written by instrumented dbtype",
+			storage.CurrentStorageVersion,
+			"testDb",
+			storage.DbType("leveldb", true),
+			"leveldb",
+			nil,
+		},
 	}
 
 	for _, test := range tests {
@@ -748,3 +766,149 @@ func testSeek(t *testing.T) {
 		})
 	}
 }
+
+func testSnapshot(t *testing.T) {
+	store, tearDown, err := GetStorage("Tst_Snapshot")
+	if err != nil {
+		t.Errorf("init db error:%v", err)
+		t.FailNow()
+	}
+	defer tearDown()
+
+	assert.Nil(t, store.Put([]byte("a1"), []byte("old")))
+	assert.Nil(t, store.Put([]byte("a2"), []byte("old")))
+
+	snap, err := store.NewSnapshot()
+	assert.Nil(t, err)
+	defer snap.Release()
+
+	batch := store.NewBatch()
+	assert.Nil(t, batch.Put([]byte("a1"), []byte("new")))
+	assert.Nil(t, batch.Delete([]byte("a2")))
+	assert.Nil(t, batch.Put([]byte("a3"), []byte("new")))
+	assert.Nil(t, store.Write(batch))
+
+	v, err := snap.Get([]byte("a1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), v)
+	has, err := snap.Has([]byte("a2"))
+	assert.Nil(t, err)
+	assert.True(t, has)
+	_, err = snap.Get([]byte("a3"))
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	mp := make(map[string]string)
+	iter := snap.NewIterator(storage.BytesPrefix([]byte("a")))
+	for iter.Next() {
+		mp[string(iter.Key())] = string(iter.Value())
+	}
+	assert.Nil(t, iter.Error())
+	iter.Release()
+	assert.Equal(t, map[string]string{"a1": "old", "a2": "old"}, mp)
+
+	v, err = store.Get([]byte("a1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), v)
+}
+
+// TestUpgrade registers migrations, so it runs after TestCheckCompatibility.
+func TestUpgrade(t *testing.T) {
+	dbtype = "leveldb"
+	store, tearDown, err := GetStorage("upgrade")
+	assert.Nil(t, err)
+	defer tearDown()
+	dbPath := filepath.Join(testDbRoot, "upgrade")
+	verPath := filepath.Join(dbPath, storage.VersionFile)
+	version := func() int32 {
+		_, v, err := storage.ReadVersion(verPath)
+		assert.Nil(t, err)
+		return v
+	}
+
+	// the first step of v3 is interrupted once after a checkpoint
+	errInterrupted := errors.New("interrupted")
+	var checkpoints [][]byte
+	var v4Runs int
+	assert.Nil(t, storage.RegisterMigration(storage.Migration{
+		Version: storage.StorageV3,
+		Steps: []storage.MigrationStep{
+			{Name: "step1", Run: func(ctx *storage.MigrationContext) error {
+				checkpoints = append(checkpoints, ctx.Checkpoint())
+				if ctx.Checkpoint() == nil {
+					assert.Nil(t, ctx.SaveCheckpoint(nil, []byte("half")))
+					return errInterrupted
+				}
+				return nil
+			}},
+			{Name: "step2", Run: func(ctx *storage.MigrationContext) error {
+				batch := ctx.Storage.NewBatch()
+				defer batch.Release()
+				assert.Nil(t, batch.Put([]byte("v3"), []byte("done")))
+				assert.Nil(t, ctx.SaveCheckpoint(batch, []byte("written")))
+				return ctx.Storage.Write(batch)
+			}},
+		},
+	}))
+	assert.Nil(t, storage.RegisterMigration(storage.Migration{
+		Version: storage.StorageV4,
+		Steps: []storage.MigrationStep{
+			{Name: "step1", Run: func(ctx *storage.MigrationContext) error {
+				v4Runs++
+				return nil
+			}},
+		},
+		Estimate: func(ctx *storage.MigrationContext) (uint64, error) { return 7, nil },
+		Unit:     "records",
+	}))
+	assert.Nil(t, storage.RegisterMigration(storage.Migration{
+		Version: storage.StorageV5,
+		Steps: []storage.MigrationStep{
+			{Name: "step1", Run: func(ctx *storage.MigrationContext) error { return nil }},
+		},
+	}))
+	assert.Equal(t, storage.ErrDuplicateMigration, storage.RegisterMigration(storage.Migration{
+		Version: storage.StorageV4,
+		Steps:   []storage.MigrationStep{{Name: "dup"}},
+	}))
+	assert.Equal(t, storage.ErrInvalidMigration, storage.RegisterMigration(storage.Migration{Version: storage.StorageV2}))
+
+	assert.Nil(t, storage.WriteVersion(verPath, dbtype, storage.StorageV1))
+	_, err = storage.Upgrade(nil, store, dbPath, dbtype, nil)
+	assert.True(t, errors.Is(err, storage.ErrIncompatibleStorage))
+	assert.Equal(t, storage.ErrIncompatibleStorage, storage.CheckCompatibility(dbtype, dbPath))
+
+	assert.Nil(t, storage.WriteVersion(verPath, dbtype, storage.StorageV2))
+	assert.Nil(t, storage.CheckCompatibility(dbtype, dbPath))
+	plans, err := storage.Upgrade(nil, store, dbPath, dbtype, &storage.MigrationOptions{DryRun: true})
+	assert.Nil(t, err)
+	if assert.Equal(t, 3, len(plans)) {
+		assert.Equal(t, []string{"step1", "step2"}, plans[0].Steps)
+		assert.Equal(t, uint64(7), plans[1].Work)
+	}
+	assert.Equal(t, storage.StorageV2, version())
+
+	// interrupted, older versions refuse the storage
+	_, err = storage.Upgrade(nil, store, dbPath, dbtype, nil)
+	assert.Equal(t, errInterrupted, err)
+	assert.Equal(t, storage.StorageV3, version())
+	assert.Equal(t, 0, v4Runs)
+
+	// resumed from the checkpoint
+	plans, err = storage.Upgrade(nil, store, dbPath, dbtype, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(plans))
+	assert.Equal(t, [][]byte{nil, []byte("half")}, checkpoints)
+	assert.Equal(t, 1, v4Runs)
+	assert.Equal(t, storage.CurrentStorageVersion, version())
+	has, err := store.Has(storage.MigrationStateKey)
+	assert.Nil(t, err)
+	assert.False(t, has)
+
+	plans, err = storage.Upgrade(nil, store, dbPath, dbtype, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(plans))
+
+	assert.Nil(t, storage.WriteVersion(verPath, dbtype, storage.CurrentStorageVersion+1))
+	_, err = storage.Upgrade(nil, store, dbPath, dbtype, nil)
+	assert.True(t, errors.Is(err, storage.ErrStorageDowngrade))
+}
@@ -0,0 +1,64 @@
+package verifydb
+
+import (
+	"github.com/wangxinyu2018/mass-core/consensus/forks"
+	"github.com/wangxinyu2018/mass-core/trie/rawdb"
+)
+
+func init() {
+	Register(Check{
+		Name:        "binding_state_root",
+		Description: "binding state tries exist for the binding roots of the main chain blocks",
+		Run:         checkBindingStateRoots,
+	})
+}
+
+// checkBindingStateRoots opens the binding trie of every main chain block
+// committing to a binding root, and walks the whole trie of the best block,
+// which is the one the next block is connected on.  Tries below the height the
+// binding state has been pruned below may be missing.
+func checkBindingStateRoots(ctx *Context) error {
+	if ctx.BindingDb == nil {
+		return ErrNoBindingDb
+	}
+	prunedHeight, _ := rawdb.ReadPrunedHeight(ctx.BindingDb.TrieDB().DiskDB())
+	_, bestHeight, err := ctx.DB.NewestSha()
+	if err != nil {
+		return err
+	}
+
+	for height := uint64(0); height <= bestHeight; height++ {
+		ctx.Progress(height, bestHeight)
+		if !forks.EnforceMASSIP0002WarmUp(height) {
+			continue
+		}
+		hash, err := ctx.DB.FetchBlockShaByHeight(height)
+		if err != nil {
+			return err
+		}
+		header, err := ctx.DB.FetchBlockHeaderBySha(hash)
+		if err != nil {
+			return err
+		}
+		ctx.Checked(1)
+
+		tr, err := ctx.BindingDb.OpenBindingTrie(header.BindingRoot)
+		if err != nil && height < prunedHeight {
+			continue
+		}
+		if err != nil {
+			ctx.Issue(height, false, "binding root %s of block %s: %v", header.BindingRoot, hash, err)
+			continue
+		}
+		if height != bestHeight {
+			continue
+		}
+		it := tr.NodeIterator(nil)
+		for it.Next(true) {
+		}
+		if err = it.Error(); err != nil {
+			ctx.Issue(height, false, "binding trie %s of best block %s: %v", header.BindingRoot, hash, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Package verifydb checks the integrity of the chain database.  The checks are
+// pluggable, the database drivers register the checks of their own records
+// with Register, and each check reports its issues separately.  Checks that
+// are able to do so repair the issues found when run in repair mode.
+package verifydb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wangxinyu2018/mass-core/blockchain/state"
+	"github.com/wangxinyu2018/mass-core/database"
+	"github.com/wangxinyu2018/mass-core/logging"
+)
+
+// DefaultMaxIssues is the number of issues kept in a report by default, the
+// issues found beyond are counted only.
+const DefaultMaxIssues = 1000
+
+var (
+	ErrUnknownCheck      = errors.New("unknown verifydb check")
+	ErrDuplicateCheck    = errors.New("duplicate verifydb check")
+	ErrUnsupportedDb     = errors.New("database type is not supported by the check")
+	ErrNoBindingDb       = errors.New("binding state database is not available")
+	ErrRepairUnsupported = errors.New("check is unable to repair the issues found")
+)
+
+// Check is an integrity check of the chain database.
+type Check struct {
+	Name        string
+	Description string
+
+	// Repairable tells whether the check repairs the issues it finds when
+	// the context is in repair mode.
+	Repairable bool
+
+	// Run runs the check, reporting the issues found to ctx.  An error is
+	// returned only when the check could not complete.
+	Run func(ctx *Context) error
+}
+
+var (
+	checksMtx sync.Mutex
+	checks    []Check
+)
+
+// Register adds a check, which is run after the checks registered before.
+func Register(check Check) error {
+	checksMtx.Lock()
+	defer checksMtx.Unlock()
+
+	for _, c := range checks {
+		if c.Name == check.Name {
+			return ErrDuplicateCheck
+		}
+	}
+	checks = append(checks, check)
+	return nil
+}
+
+// Checks returns the registered checks, in the order they are run.
+func Checks() []Check {
+	checksMtx.Lock()
+	defer checksMtx.Unlock()
+
+	return append([]Check(nil), checks...)
+}
+
+// Options are the options of Run.
+type Options struct {
+	// Checks names the checks to run, all the registered checks are run if
+	// it is empty.
+	Checks []string
+
+	// Repair tells the checks to repair the issues they find, the database
+	// must be opened writable.
+	Repair bool
+
+	// MaxIssues is the number of issues kept in each report, zero for
+	// DefaultMaxIssues.
+	MaxIssues int
+
+	// Progress receives the progress of the checks, at most once for each
+	// percent done.  The progress is logged if it is nil.
+	Progress func(Progress)
+}
+
+// Progress is the progress of a running check.
+type Progress struct {
+	Check string
+	Done  uint64
+	Total uint64
+}
+
+// Percent returns the progress in percent.
+func (p Progress) Percent() int {
+	if p.Total == 0 {
+		return 100
+	}
+	return int(p.Done * 100 / p.Total)
+}
+
+// Issue is an inconsistency found by a check.
+type Issue struct {
+	Height   uint64
+	Message  string
+	Repaired bool
+}
+
+// Report is the result of a check.
+type Report struct {
+	Check     string
+	Checked   uint64 // number of records checked
+	NumIssues int    // including the issues beyond Issues
+	Repaired  int
+	Issues    []Issue
+	Err       error // the error aborting the check
+	Elapsed   time.Duration
+}
+
+// OK returns whether the check completed with all the issues found repaired.
+func (r *Report) OK() bool {
+	return r.Err == nil && r.NumIssues == r.Repaired
+}
+
+// Context is passed to a running check.
+type Context struct {
+	DB        database.Db
+	BindingDb state.Database // nil if unavailable
+	Repair    bool
+
+	report    *Report
+	maxIssues int
+	progress  func(Progress)
+	lastPct   int
+}
+
+// Checked counts n records checked.
+func (ctx *Context) Checked(n uint64) {
+	ctx.report.Checked += n
+}
+
+// Issue reports an issue found at height, along with whether it has been
+// repaired.
+func (ctx *Context) Issue(height uint64, repaired bool, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logging.CPrint(logging.WARN, "verifydb found issue", logging.LogFormat{
+		"check":    ctx.report.Check,
+		"height":   height,
+		"issue":    msg,
+		"repaired": repaired,
+	})
+	ctx.report.NumIssues++
+	if repaired {
+		ctx.report.Repaired++
+	}
+	if len(ctx.report.Issues) < ctx.maxIssues {
+		ctx.report.Issues = append(ctx.report.Issues, Issue{Height: height, Message: msg, Repaired: repaired})
+	}
+}
+
+// Progress reports done of total steps of the check done.
+func (ctx *Context) Progress(done, total uint64) {
+	p := Progress{Check: ctx.report.Check, Done: done, Total: total}
+	if pct := p.Percent(); pct > ctx.lastPct {
+		ctx.lastPct = pct
+		ctx.progress(p)
+	}
+}
+
+func logProgress(p Progress) {
+	logging.CPrint(logging.INFO, fmt.Sprintf("check %s %d%%", p.Check, p.Percent()), logging.LogFormat{})
+}
+
+// Run runs the checks selected by opts against db and bindingDb, which may be
+// nil, and returns the report of each check.  A failing check doesn't stop
+// the checks following it, its error is found in its report.
+func Run(db database.Db, bindingDb state.Database, opts *Options) ([]*Report, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	selected, err := selectChecks(opts.Checks)
+	if err != nil {
+		return nil, err
+	}
+	maxIssues := opts.MaxIssues
+	if maxIssues <= 0 {
+		maxIssues = DefaultMaxIssues
+	}
+	progress := opts.Progress
+	if progress == nil {
+		progress = logProgress
+	}
+
+	reports := make([]*Report, 0, len(selected))
+	for _, check := range selected {
+		report := &Report{Check: check.Name}
+		ctx := &Context{
+			DB:        db,
+			BindingDb: bindingDb,
+			Repair:    opts.Repair && check.Repairable,
+			report:    report,
+			maxIssues: maxIssues,
+			progress:  progress,
+			lastPct:   -1,
+		}
+
+		logging.CPrint(logging.INFO, "verifydb check start", logging.LogFormat{"check": check.Name, "repair": ctx.Repair})
+		start := time.Now()
+		report.Err = check.Run(ctx)
+		report.Elapsed = time.Since(start)
+		if report.Err == nil && opts.Repair && !check.Repairable && report.NumIssues > 0 {
+			report.Err = ErrRepairUnsupported
+		}
+		logging.CPrint(logging.INFO, "verifydb check done", logging.LogFormat{
+			"check":    check.Name,
+			"checked":  report.Checked,
+			"issues":   report.NumIssues,
+			"repaired": report.Repaired,
+			"elapsed":  report.Elapsed,
+			"err":      report.Err,
+		})
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func selectChecks(names []string) ([]Check, error) {
+	all := Checks()
+	if len(names) == 0 {
+		return all, nil
+	}
+	selected := make([]Check, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, check := range all {
+			if check.Name == name {
+				selected = append(selected, check)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCheck, name)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,83 @@
+package verifydb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	errAbort := errors.New("abort")
+	for _, check := range []Check{
+		{
+			Name:       "test_repairable",
+			Repairable: true,
+			Run: func(ctx *Context) error {
+				for i := uint64(0); i < 10; i++ {
+					ctx.Progress(i, 9)
+					ctx.Checked(1)
+					if i%2 == 0 {
+						ctx.Issue(i, ctx.Repair, "issue %d", i)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name: "test_readonly",
+			Run: func(ctx *Context) error {
+				ctx.Issue(0, false, "unrepairable")
+				return nil
+			},
+		},
+		{
+			Name: "test_abort",
+			Run:  func(ctx *Context) error { return errAbort },
+		},
+	} {
+		if err := Register(check); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Register(Check{Name: "test_abort"}); err != ErrDuplicateCheck {
+		t.Errorf("duplicate, got = %v, want = %v", err, ErrDuplicateCheck)
+	}
+
+	var progress []Progress
+	opts := &Options{
+		Checks:    []string{"test_repairable", "test_readonly", "test_abort"},
+		MaxIssues: 3,
+		Progress:  func(p Progress) { progress = append(progress, p) },
+	}
+	reports, err := Run(nil, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reports[0]
+	if r.Checked != 10 || r.NumIssues != 5 || len(r.Issues) != 3 || r.Repaired != 0 || r.OK() {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if len(progress) != 10 || progress[9].Percent() != 100 {
+		t.Errorf("progress, got = %v", progress)
+	}
+	if r := reports[1]; r.Err != nil || r.OK() {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if r := reports[2]; r.Err != errAbort {
+		t.Errorf("aborted, got = %v, want = %v", r.Err, errAbort)
+	}
+
+	opts.Repair = true
+	if reports, err = Run(nil, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if r := reports[0]; r.Repaired != 5 || !r.OK() {
+		t.Errorf("unexpected repaired report %+v", r)
+	}
+	if r := reports[1]; r.Err != ErrRepairUnsupported {
+		t.Errorf("repair unsupported, got = %v, want = %v", r.Err, ErrRepairUnsupported)
+	}
+
+	if _, err = Run(nil, nil, &Options{Checks: []string{"test_unknown"}}); !errors.Is(err, ErrUnknownCheck) {
+		t.Errorf("unknown check, got = %v, want = %v", err, ErrUnknownCheck)
+	}
+}
@@ -0,0 +1,63 @@
+package gcs
+
+import (
+	"io"
+)
+
+// bitWriter appends bits to a byte slice, most significant bit first.
+type bitWriter struct {
+	data   []byte
+	offset uint8 // number of bits used in the last byte
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.offset == 0 {
+		w.data = append(w.data, 0)
+	}
+	if bit {
+		w.data[len(w.data)-1] |= 1 << (7 - w.offset)
+	}
+	w.offset = (w.offset + 1) % 8
+}
+
+// writeBits writes the n lowest bits of value.
+func (w *bitWriter) writeBits(value uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.writeBit(value&(1<<(i-1)) != 0)
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.data
+}
+
+// bitReader reads bits from a byte slice, most significant bit first.
+type bitReader struct {
+	data   []byte
+	offset uint64 // number of bits read
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.offset >= uint64(len(r.data))*8 {
+		return false, io.EOF
+	}
+	bit := r.data[r.offset/8]&(1<<(7-r.offset%8)) != 0
+	r.offset++
+	return bit, nil
+}
+
+// readBits reads n bits as the lowest bits of the returned value.
+func (r *bitReader) readBits(n uint) (uint64, error) {
+	var value uint64
+	for i := uint(0); i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value <<= 1
+		if bit {
+			value |= 1
+		}
+	}
+	return value, nil
+}
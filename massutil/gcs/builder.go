@@ -0,0 +1,81 @@
+package gcs
+
+import (
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	// DefaultP is the Golomb-Rice parameter of basic block filters.
+	DefaultP = 19
+
+	// DefaultM is the inverse false-positive rate of basic block filters.
+	DefaultM uint64 = 784931
+
+	// opReturn is the first opcode of null data scripts, which are never
+	// spent and therefore not put into filters.
+	opReturn = 0x6a
+)
+
+// DeriveKey returns the siphash key of the filter for a block, which is the
+// first KeySize bytes of the block hash.
+func DeriveKey(blockHash *wire.Hash) [KeySize]byte {
+	var key [KeySize]byte
+	copy(key[:], blockHash[:KeySize])
+	return key
+}
+
+// BuildBasicFilter builds the basic filter of block, which contains the
+// pkScripts created by the block and prevOutScripts spent by the block.
+func BuildBasicFilter(block *wire.MsgBlock, prevOutScripts [][]byte) (*Filter, error) {
+	seen := make(map[string]struct{})
+	data := make([][]byte, 0)
+	add := func(script []byte) {
+		if len(script) == 0 || script[0] == opReturn {
+			return
+		}
+		if _, ok := seen[string(script)]; ok {
+			return
+		}
+		seen[string(script)] = struct{}{}
+		data = append(data, script)
+	}
+
+	for _, tx := range block.Transactions {
+		for _, txOut := range tx.TxOut {
+			add(txOut.PkScript)
+		}
+	}
+	for _, script := range prevOutScripts {
+		add(script)
+	}
+
+	blockHash := block.BlockHash()
+	return BuildGCSFilter(DefaultP, DefaultM, DeriveKey(&blockHash), data)
+}
+
+// FromBasicFilterBytes deserializes a basic filter from bytes produced by
+// NBytes.
+func FromBasicFilterBytes(d []byte) (*Filter, error) {
+	return FromNBytes(DefaultP, DefaultM, d)
+}
+
+// FilterHash returns the hash of the serialized filter.
+func FilterHash(filter *Filter) wire.Hash {
+	return wire.DoubleHashH(filter.NBytes())
+}
+
+// MakeHeaderForFilter returns the filter header, which commits to the filter
+// and the header of the previous block filter.  The previous header of the
+// genesis block is zero.
+func MakeHeaderForFilter(filter *Filter, prevHeader *wire.Hash) wire.Hash {
+	return MakeHeaderForFilterHash(FilterHash(filter), prevHeader)
+}
+
+// MakeHeaderForFilterHash returns the filter header from the filter hash and
+// the previous filter header.
+func MakeHeaderForFilterHash(filterHash wire.Hash, prevHeader *wire.Hash) wire.Hash {
+	buf := make([]byte, 2*wire.HashSize)
+	copy(buf, filterHash[:])
+	copy(buf[wire.HashSize:], prevHeader[:])
+	return wire.DoubleHashH(buf)
+}
@@ -0,0 +1,12 @@
+/*
+Package gcs provides an implementation of Golomb-coded sets as specified in
+BIP 158, together with the basic block filter and filter header built on it.
+
+A Golomb-coded set is a probabilistic structure similar to a bloom filter but
+more compact, which is used by light clients to check whether a block may
+contain scripts they are interested in without revealing those scripts to
+full nodes.
+
+More info: https://github.com/bitcoin/bips/blob/master/bip-0158.mediawiki
+*/
+package gcs
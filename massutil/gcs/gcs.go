@@ -0,0 +1,200 @@
+package gcs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// KeySize is the size of the siphash key used to hash items into a filter.
+const KeySize = 16
+
+var (
+	// ErrNTooBig is returned when too many items are put into a filter.
+	ErrNTooBig = errors.New("N is too big to fit in uint32")
+
+	// ErrPTooBig is returned when the Golomb-Rice parameter is too big.
+	ErrPTooBig = errors.New("P is too big to fit in uint64")
+
+	// ErrMisserialized is returned when a serialized filter is malformed.
+	ErrMisserialized = errors.New("misserialized filter")
+)
+
+// Filter describes an immutable Golomb-coded set.
+type Filter struct {
+	n          uint32
+	p          uint8
+	modulusNP  uint64
+	filterData []byte
+}
+
+// BuildGCSFilter builds a filter of data with the Golomb-Rice parameter P,
+// the inverse false-positive rate M and the siphash key.
+func BuildGCSFilter(P uint8, M uint64, key [KeySize]byte, data [][]byte) (*Filter, error) {
+	if uint64(len(data)) > math.MaxUint32 {
+		return nil, ErrNTooBig
+	}
+	if P > 32 {
+		return nil, ErrPTooBig
+	}
+
+	f := &Filter{
+		n: uint32(len(data)),
+		p: P,
+	}
+	f.modulusNP = uint64(f.n) * M
+	if f.n == 0 {
+		return f, nil
+	}
+
+	values := hashValues(key, f.modulusNP, data)
+
+	// Golomb-Rice code the sorted differences
+	var w bitWriter
+	var last uint64
+	for _, v := range values {
+		delta := v - last
+		last = v
+		for q := delta >> P; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, uint(P))
+	}
+	f.filterData = w.bytes()
+	return f, nil
+}
+
+// FromBytes deserializes a filter built with the parameters P and M from the
+// known item count n and the raw filter data.
+func FromBytes(n uint32, P uint8, M uint64, d []byte) (*Filter, error) {
+	if P > 32 {
+		return nil, ErrPTooBig
+	}
+	data := make([]byte, len(d))
+	copy(data, d)
+	return &Filter{
+		n:          n,
+		p:          P,
+		modulusNP:  uint64(n) * M,
+		filterData: data,
+	}, nil
+}
+
+// FromNBytes deserializes a filter built with the parameters P and M from
+// bytes produced by NBytes.
+func FromNBytes(P uint8, M uint64, d []byte) (*Filter, error) {
+	n, size := binary.Uvarint(d)
+	if size <= 0 || n > math.MaxUint32 {
+		return nil, ErrMisserialized
+	}
+	return FromBytes(uint32(n), P, M, d[size:])
+}
+
+// N returns the number of items in the filter.
+func (f *Filter) N() uint32 {
+	return f.n
+}
+
+// P returns the Golomb-Rice parameter of the filter.
+func (f *Filter) P() uint8 {
+	return f.p
+}
+
+// Bytes returns the raw filter data.
+func (f *Filter) Bytes() []byte {
+	data := make([]byte, len(f.filterData))
+	copy(data, f.filterData)
+	return data
+}
+
+// NBytes returns the filter data prefixed with the varint encoded item count.
+func (f *Filter) NBytes() []byte {
+	buf := make([]byte, binary.MaxVarintLen64+len(f.filterData))
+	size := binary.PutUvarint(buf, uint64(f.n))
+	copy(buf[size:], f.filterData)
+	return buf[:size+len(f.filterData)]
+}
+
+// Match checks whether data is likely (within the false-positive rate) to be
+// a member of the filter.
+func (f *Filter) Match(key [KeySize]byte, data []byte) (bool, error) {
+	return f.MatchAny(key, [][]byte{data})
+}
+
+// MatchAny checks whether any of data is likely (within the false-positive
+// rate) to be a member of the filter.
+func (f *Filter) MatchAny(key [KeySize]byte, data [][]byte) (bool, error) {
+	if f.n == 0 || len(data) == 0 {
+		return false, nil
+	}
+
+	values := hashValues(key, f.modulusNP, data)
+
+	// walk the sorted filter and query values at the same time
+	r := bitReader{data: f.filterData}
+	var value uint64
+	for i := uint32(0); i < f.n; i++ {
+		delta, err := f.readFullUint64(&r)
+		if err != nil {
+			if err == io.EOF {
+				return false, ErrMisserialized
+			}
+			return false, err
+		}
+		value += delta
+
+		for len(values) > 0 && values[0] < value {
+			values = values[1:]
+		}
+		if len(values) == 0 {
+			return false, nil
+		}
+		if values[0] == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readFullUint64 reads a Golomb-Rice coded difference.
+func (f *Filter) readFullUint64(r *bitReader) (uint64, error) {
+	var quotient uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		quotient++
+	}
+	remainder, err := r.readBits(uint(f.p))
+	if err != nil {
+		return 0, err
+	}
+	return quotient<<f.p | remainder, nil
+}
+
+// hashValues hashes data into the range [0, modulusNP) and returns them in
+// ascending order.
+func hashValues(key [KeySize]byte, modulusNP uint64, data [][]byte) []uint64 {
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+
+	values := make([]uint64, 0, len(data))
+	for _, d := range data {
+		// (hash * modulusNP) >> 64 maps the hash uniformly into the range
+		// without a division
+		v, _ := bits.Mul64(sipHash(k0, k1, d), modulusNP)
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i] < values[j]
+	})
+	return values
+}
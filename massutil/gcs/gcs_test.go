@@ -0,0 +1,116 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+func TestSipHash(t *testing.T) {
+	var key [KeySize]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+
+	tests := []struct {
+		msgLen int
+		want   uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{15, 0xa129ca6149be45e5},
+	}
+	for _, test := range tests {
+		msg := make([]byte, test.msgLen)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		if got := sipHash(k0, k1, msg); got != test.want {
+			t.Errorf("sipHash of %d bytes, got = %x, want = %x", test.msgLen, got, test.want)
+		}
+	}
+}
+
+func TestGCSFilter(t *testing.T) {
+	var key [KeySize]byte
+	rand.Read(key[:])
+
+	contents := make([][]byte, 0, 200)
+	for i := 0; i < 200; i++ {
+		item := make([]byte, 32)
+		rand.Read(item)
+		contents = append(contents, item)
+	}
+
+	filter, err := BuildGCSFilter(DefaultP, DefaultM, key, contents)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter error: %v", err)
+	}
+	if filter.N() != uint32(len(contents)) {
+		t.Fatalf("filter N, got = %d, want = %d", filter.N(), len(contents))
+	}
+
+	for i, item := range contents {
+		match, err := filter.Match(key, item)
+		if err != nil {
+			t.Fatalf("%d, Match error: %v", i, err)
+		}
+		if !match {
+			t.Errorf("%d, filter should match member", i)
+		}
+	}
+
+	absent := [][]byte{[]byte("absent-1"), []byte("absent-2")}
+	match, err := filter.MatchAny(key, absent)
+	if err != nil {
+		t.Fatalf("MatchAny error: %v", err)
+	}
+	if match {
+		t.Errorf("filter should not match absent items")
+	}
+	match, err = filter.MatchAny(key, append(absent, contents[100]))
+	if err != nil {
+		t.Fatalf("MatchAny error: %v", err)
+	}
+	if !match {
+		t.Errorf("filter should match when any item is a member")
+	}
+
+	// serialization round trip
+	restored, err := FromNBytes(DefaultP, DefaultM, filter.NBytes())
+	if err != nil {
+		t.Fatalf("FromNBytes error: %v", err)
+	}
+	if restored.N() != filter.N() || !bytes.Equal(restored.Bytes(), filter.Bytes()) {
+		t.Errorf("restored filter not equal")
+	}
+	match, err = restored.Match(key, contents[0])
+	if err != nil || !match {
+		t.Errorf("restored filter should match member, err = %v", err)
+	}
+
+	// truncated filter data
+	truncated, _ := FromBytes(filter.N(), DefaultP, DefaultM, filter.Bytes()[:10])
+	if _, err := truncated.Match(key, contents[199]); err != ErrMisserialized {
+		t.Errorf("truncated filter, got = %v, want = %v", err, ErrMisserialized)
+	}
+}
+
+func TestGCSFilterEmpty(t *testing.T) {
+	var key [KeySize]byte
+	filter, err := BuildGCSFilter(DefaultP, DefaultM, key, nil)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter error: %v", err)
+	}
+	if !bytes.Equal(filter.NBytes(), []byte{0}) {
+		t.Errorf("empty filter bytes, got = %x", filter.NBytes())
+	}
+	if match, _ := filter.Match(key, []byte("item")); match {
+		t.Errorf("empty filter should not match")
+	}
+	if _, err := FromNBytes(DefaultP, DefaultM, nil); err != ErrMisserialized {
+		t.Errorf("no bytes, got = %v, want = %v", err, ErrMisserialized)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"container/list"
 	"time"
 
+	"github.com/wangxinyu2018/mass-core/blockchain"
 	"github.com/wangxinyu2018/mass-core/config"
 	"github.com/wangxinyu2018/mass-core/consensus"
 	"github.com/wangxinyu2018/mass-core/errors"
@@ -93,7 +94,12 @@ func (bk *blockKeeper) appendHeaderList(headers []*wire.BlockHeader) error {
 }
 
 func (bk *blockKeeper) blockLocator() []*wire.Hash {
-	header := bk.chain.BestBlockHeader()
+	return makeBlockLocator(bk.chain.BestBlockHeader(), bk.chain.GetHeaderByHeight)
+}
+
+// makeBlockLocator returns the locator of the chain ending at header, whose
+// ancestors are looked up by getHeader.
+func makeBlockLocator(header *wire.BlockHeader, getHeader func(uint64) (*wire.BlockHeader, error)) []*wire.Hash {
 	locator := []*wire.Hash{}
 
 	step := uint64(1)
@@ -106,9 +112,9 @@ func (bk *blockKeeper) blockLocator() []*wire.Hash {
 
 		var err error
 		if header.Height < step {
-			header, err = bk.chain.GetHeaderByHeight(0)
+			header, err = getHeader(0)
 		} else {
-			header, err = bk.chain.GetHeaderByHeight(header.Height - step)
+			header, err = getHeader(header.Height - step)
 		}
 		if err != nil {
 			logging.CPrint(logging.ERROR, "blockKeeper fail on get blockLocator", logging.LogFormat{"err": err})
@@ -549,6 +555,7 @@ func (bk *blockKeeper) startSync() bool {
 
 	localHeight := bk.chain.BestBlockHeight()
 	if peer.Height() <= localHeight {
+		bk.historyBlockSync(peer)
 		return false
 	}
 
@@ -579,6 +586,35 @@ func (bk *blockKeeper) startSync() bool {
 	return true
 }
 
+// historyBlockSync passes a round of blocks up to the utxo snapshot the chain
+// started from to its validator, once the chain itself has nothing to sync.
+func (bk *blockKeeper) historyBlockSync(peer *peer) {
+	validator := bk.chain.SnapshotValidator()
+	if validator == nil || validator.Done() || peer.Height() < validator.TargetHeight() {
+		return
+	}
+
+	bk.syncPeer = peer
+	locator := makeBlockLocator(validator.BestBlockHeader(), validator.GetHeaderByHeight)
+	blocks, err := bk.requireBlocks(locator, validator.TargetHash())
+	if err == nil && len(blocks) == 0 {
+		err = errors.Wrap(errPeerMisbehave, "requireBlocks return empty list")
+	}
+	for i := 0; err == nil && i < len(blocks); i++ {
+		_, err = validator.ProcessBlock(blocks[i])
+	}
+	if err == nil {
+		return
+	}
+	// the history not matching the snapshot is not the fault of the peer
+	if err == blockchain.ErrUtxoSnapshotMismatch {
+		logging.CPrint(logging.ERROR, "utxo snapshot found invalid by history", logging.LogFormat{"err": err})
+		return
+	}
+	logging.CPrint(logging.WARN, "fail on historyBlockSync", logging.LogFormat{"err": err, "peer": peer.Addr()})
+	bk.peers.errorHandler(peer.ID(), err)
+}
+
 func (bk *blockKeeper) syncWorker() {
 	genesisBlock, err := bk.chain.GetBlockByHeight(0)
 	if err != nil {
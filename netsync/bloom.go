@@ -0,0 +1,301 @@
+package netsync
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/txscript"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const (
+	// maxFilterLoadHashFuncs is the maximum number of hash functions to
+	// load into a bloom filter.
+	maxFilterLoadHashFuncs = 50
+
+	// maxFilterLoadFilterSize is the maximum size in bytes a filter may be.
+	maxFilterLoadFilterSize = 36000
+
+	// maxFilterAddDataSize is the maximum byte size of a data element to add
+	// to a bloom filter.
+	maxFilterAddDataSize = 520
+
+	// ln2Squared is simply the square of the natural log of 2.
+	ln2Squared = math.Ln2 * math.Ln2
+)
+
+// BloomUpdateType specifies how the filter is updated when a match is found.
+type BloomUpdateType uint8
+
+const (
+	// BloomUpdateNone indicates the filter is not adjusted when a match is
+	// found.
+	BloomUpdateNone BloomUpdateType = 0
+
+	// BloomUpdateAll indicates if the filter matches any data element in a
+	// pkScript, the outpoint is serialized and inserted into the filter.
+	BloomUpdateAll BloomUpdateType = 1
+
+	// BloomUpdateMultiSigOnly indicates if the filter matches a data element
+	// in a multisig pkScript, the outpoint is serialized and inserted into
+	// the filter.
+	BloomUpdateMultiSigOnly BloomUpdateType = 2
+)
+
+// BloomFilter defines a BIP37 bloom filter that provides easy manipulation
+// of raw filter data.
+type BloomFilter struct {
+	mtx       sync.Mutex
+	filter    []byte
+	hashFuncs uint32
+	tweak     uint32
+	flags     BloomUpdateType
+}
+
+// NewBloomFilter creates a new bloom filter instance, mainly to be used by SPV
+// clients.  The tweak parameter is a random value added to the seed value.
+// The false positive rate is the probability of a false positive where 1.0 is
+// "match everything" and zero is unachievable.  Thus, providing any false
+// positive rates less than 0 or greater than 1 will be adjusted to the valid
+// range.
+func NewBloomFilter(elements, tweak uint32, fprate float64, flags BloomUpdateType) *BloomFilter {
+	// Massage the false positive rate to sane values.
+	if fprate > 1.0 {
+		fprate = 1.0
+	}
+	if fprate < 1e-9 {
+		fprate = 1e-9
+	}
+
+	// Calculate the size of the filter in bytes for the given number of
+	// elements and false positive rate.
+	//
+	// Equivalent to m = -(n*ln(p) / ln(2)^2), where m is in bits.
+	// Then clamp it to the maximum filter size and convert to bytes.
+	dataLen := uint32(-1 * float64(elements) * math.Log(fprate) / ln2Squared)
+	dataLen = minUint32(dataLen, maxFilterLoadFilterSize*8) / 8
+	if dataLen == 0 {
+		dataLen = 1
+	}
+
+	// Calculate the number of hash functions based on the size of the
+	// filter calculated above and the number of elements.
+	//
+	// Equivalent to k = (m/n) * ln(2)
+	// Then clamp it to the maximum allowed hash funcs.
+	hashFuncs := uint32(1)
+	if elements > 0 {
+		hashFuncs = uint32(float64(dataLen*8) / float64(elements) * math.Ln2)
+	}
+	hashFuncs = minUint32(hashFuncs, maxFilterLoadHashFuncs)
+	if hashFuncs == 0 {
+		hashFuncs = 1
+	}
+
+	return &BloomFilter{
+		filter:    make([]byte, dataLen),
+		hashFuncs: hashFuncs,
+		tweak:     tweak,
+		flags:     flags,
+	}
+}
+
+// LoadBloomFilter creates a new bloom filter instance with the given
+// FilterLoadMessage.  The message must be checked by validFilterLoad first.
+func LoadBloomFilter(msg *FilterLoadMessage) *BloomFilter {
+	filter := make([]byte, len(msg.Filter))
+	copy(filter, msg.Filter)
+	return &BloomFilter{
+		filter:    filter,
+		hashFuncs: msg.HashFuncs,
+		tweak:     msg.Tweak,
+		flags:     BloomUpdateType(msg.Flags),
+	}
+}
+
+// validFilterLoad returns whether the filter of msg is within the size and
+// hash-function limits.
+func validFilterLoad(msg *FilterLoadMessage) bool {
+	return len(msg.Filter) > 0 && len(msg.Filter) <= maxFilterLoadFilterSize &&
+		msg.HashFuncs > 0 && msg.HashFuncs <= maxFilterLoadHashFuncs
+}
+
+// hash returns the bit offset in the bloom filter which corresponds to the
+// passed data for the given independent hash function number.
+func (bf *BloomFilter) hash(hashNum uint32, data []byte) uint32 {
+	// bitcoind: 0xfba4c795 chosen as it guarantees a reasonable bit
+	// difference between hashNum values.
+	//
+	// Note that << 3 is equivalent to multiplying by 8, but is faster.
+	// Thus the returned hash is brought into range of the number of bits
+	// the filter has and returned.
+	mm := murmurHash3(hashNum*0xfba4c795+bf.tweak, data)
+	return mm % (uint32(len(bf.filter)) << 3)
+}
+
+// matches returns true if the bloom filter might contain the passed data and
+// false if it definitely does not.
+//
+// This function MUST be called with the filter lock held.
+func (bf *BloomFilter) matches(data []byte) bool {
+	for i := uint32(0); i < bf.hashFuncs; i++ {
+		idx := bf.hash(i, data)
+		if bf.filter[idx>>3]&(1<<(idx&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches returns true if the bloom filter might contain the passed data and
+// false if it definitely does not.
+func (bf *BloomFilter) Matches(data []byte) bool {
+	bf.mtx.Lock()
+	defer bf.mtx.Unlock()
+	return bf.matches(data)
+}
+
+// matchesOutPoint returns true if the bloom filter might contain the passed
+// outpoint and false if it definitely does not.
+//
+// This function MUST be called with the filter lock held.
+func (bf *BloomFilter) matchesOutPoint(outpoint *wire.OutPoint) bool {
+	return bf.matches(serializeOutPoint(outpoint))
+}
+
+// add adds the passed byte slice to the bloom filter.
+//
+// This function MUST be called with the filter lock held.
+func (bf *BloomFilter) add(data []byte) {
+	for i := uint32(0); i < bf.hashFuncs; i++ {
+		idx := bf.hash(i, data)
+		bf.filter[idx>>3] |= 1 << (idx & 7)
+	}
+}
+
+// Add adds the passed byte slice to the bloom filter.
+func (bf *BloomFilter) Add(data []byte) {
+	bf.mtx.Lock()
+	defer bf.mtx.Unlock()
+	bf.add(data)
+}
+
+// AddOutPoint adds the passed transaction outpoint to the bloom filter.
+func (bf *BloomFilter) AddOutPoint(outpoint *wire.OutPoint) {
+	bf.mtx.Lock()
+	defer bf.mtx.Unlock()
+	bf.add(serializeOutPoint(outpoint))
+}
+
+// maybeAddOutpoint potentially adds the passed outpoint to the bloom filter
+// depending on the bloom update flags and the type of the passed public key
+// script.
+//
+// This function MUST be called with the filter lock held.
+func (bf *BloomFilter) maybeAddOutpoint(pkScript []byte, outHash *wire.Hash, outIdx uint32) {
+	switch bf.flags {
+	case BloomUpdateAll:
+		bf.add(serializeOutPoint(wire.NewOutPoint(outHash, outIdx)))
+	case BloomUpdateMultiSigOnly:
+		if txscript.GetScriptClass(pkScript) == txscript.MultiSigTy {
+			bf.add(serializeOutPoint(wire.NewOutPoint(outHash, outIdx)))
+		}
+	}
+}
+
+// matchTxAndUpdate returns true if the bloom filter matches data within the
+// passed transaction, otherwise false is returned.  If the filter does match
+// the passed transaction, it will also update the filter depending on the
+// bloom update flags set via the loaded filter if needed.
+//
+// This function MUST be called with the filter lock held.
+func (bf *BloomFilter) matchTxAndUpdate(tx *massutil.Tx) bool {
+	// Check if the filter matches the hash of the transaction.
+	matched := bf.matches(tx.Hash()[:])
+
+	// Check if the filter matches any data elements in the public key
+	// scripts of any of the outputs.  When it does, add the outpoint that
+	// matched so transactions which spend from the matched transaction are
+	// also included in the filter.  This removes the burden of updating the
+	// filter for this scenario from the client.
+	for i, txOut := range tx.MsgTx().TxOut {
+		pushes, err := txscript.PushedData(txOut.PkScript)
+		if err != nil {
+			continue
+		}
+		for _, data := range pushes {
+			if len(data) == 0 || !bf.matches(data) {
+				continue
+			}
+			matched = true
+			bf.maybeAddOutpoint(txOut.PkScript, tx.Hash(), uint32(i))
+			break
+		}
+	}
+
+	// Nothing more to do if a match has already been made.
+	if matched {
+		return true
+	}
+
+	// At this point, the transaction and none of the data elements in the
+	// public key scripts of its outputs matched.
+
+	// Check if the filter matches any outpoints this transaction spends or
+	// any data elements in the witnesses of any of the inputs.
+	for _, txIn := range tx.MsgTx().TxIn {
+		if bf.matchesOutPoint(&txIn.PreviousOutPoint) {
+			return true
+		}
+		for _, data := range txIn.Witness {
+			if len(data) != 0 && bf.matches(data) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchTxAndUpdate returns true if the bloom filter matches data within the
+// passed transaction, otherwise false is returned.  If the filter does match
+// the passed transaction, it will also update the filter depending on the
+// bloom update flags set via the loaded filter if needed.
+func (bf *BloomFilter) MatchTxAndUpdate(tx *massutil.Tx) bool {
+	bf.mtx.Lock()
+	defer bf.mtx.Unlock()
+	return bf.matchTxAndUpdate(tx)
+}
+
+// MsgFilterLoad returns the underlying FilterLoadMessage for the bloom filter.
+func (bf *BloomFilter) MsgFilterLoad() *FilterLoadMessage {
+	bf.mtx.Lock()
+	defer bf.mtx.Unlock()
+	filter := make([]byte, len(bf.filter))
+	copy(filter, bf.filter)
+	return &FilterLoadMessage{
+		Filter:    filter,
+		HashFuncs: bf.hashFuncs,
+		Tweak:     bf.tweak,
+		Flags:     uint8(bf.flags),
+	}
+}
+
+// serializeOutPoint serializes the passed outpoint as hash followed by the
+// little-endian index.
+func serializeOutPoint(outpoint *wire.OutPoint) []byte {
+	buf := make([]byte, wire.HashSize+4)
+	copy(buf, outpoint.Hash[:])
+	binary.LittleEndian.PutUint32(buf[wire.HashSize:], outpoint.Index)
+	return buf
+}
+
+// minUint32 is a convenience function to return the minimum value of the two
+// passed uint32 values.
+func minUint32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,121 @@
+package netsync
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+func TestMurmurHash3(t *testing.T) {
+	tests := []struct {
+		seed uint32
+		data []byte
+		out  uint32
+	}{
+		{0x00000000, []byte{}, 0x00000000},
+		{0xfba4c795, []byte{}, 0x6a396f08},
+		{0xffffffff, []byte{}, 0x81f16f39},
+		{0x00000000, []byte{0x00}, 0x514e28b7},
+		{0xfba4c795, []byte{0x00}, 0xea3f0b17},
+		{0x00000000, []byte{0xff}, 0xfd6cf10d},
+		{0x00000000, []byte{0x00, 0x11}, 0x16c6b7ab},
+		{0x00000000, []byte{0x00, 0x11, 0x22}, 0x8eb51c3d},
+		{0x00000000, []byte{0x00, 0x11, 0x22, 0x33}, 0xb4471bf8},
+		{0x00000000, []byte{0x00, 0x11, 0x22, 0x33, 0x44}, 0xe2301fa8},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.out, murmurHash3(test.seed, test.data), "test %d", i)
+	}
+}
+
+func TestBloomFilterInsert(t *testing.T) {
+	tests := []struct {
+		tweak     uint32
+		filter    string
+		hashFuncs uint32
+	}{
+		{0, "614e9b", 5},
+		{2147483649, "ce4299", 5},
+	}
+	elements := []string{
+		"99108ad8ed9bb6274d3980bab5a85c048f0950c8",
+		"b5a2c786d9ef4658287ced5914b37a1b4aa32eee",
+		"b9300670b4c5366e95b2699e8b18bc75e5f729c5",
+	}
+
+	for i, test := range tests {
+		bf := NewBloomFilter(3, test.tweak, 0.01, BloomUpdateAll)
+		for _, element := range elements {
+			data, _ := hex.DecodeString(element)
+			bf.Add(data)
+			assert.True(t, bf.Matches(data), "test %d", i)
+		}
+		absent, _ := hex.DecodeString("19108ad8ed9bb6274d3980bab5a85c048f0950c8")
+		assert.False(t, bf.Matches(absent), "test %d", i)
+
+		msg := bf.MsgFilterLoad()
+		assert.Equal(t, test.filter, hex.EncodeToString(msg.Filter), "test %d", i)
+		assert.Equal(t, test.hashFuncs, msg.HashFuncs, "test %d", i)
+		assert.Equal(t, test.tweak, msg.Tweak, "test %d", i)
+		assert.Equal(t, uint8(BloomUpdateAll), msg.Flags, "test %d", i)
+	}
+}
+
+func TestBloomFilterLimits(t *testing.T) {
+	bf := NewBloomFilter(1000000, 0, 1e-12, BloomUpdateNone)
+	msg := bf.MsgFilterLoad()
+	assert.Equal(t, maxFilterLoadFilterSize, len(msg.Filter))
+	assert.True(t, msg.HashFuncs <= maxFilterLoadHashFuncs)
+	assert.True(t, validFilterLoad(msg))
+
+	assert.False(t, validFilterLoad(&FilterLoadMessage{Filter: make([]byte, maxFilterLoadFilterSize+1), HashFuncs: 1}))
+	assert.False(t, validFilterLoad(&FilterLoadMessage{Filter: make([]byte, 1), HashFuncs: maxFilterLoadHashFuncs + 1}))
+	assert.False(t, validFilterLoad(&FilterLoadMessage{Filter: []byte{}, HashFuncs: 1}))
+}
+
+func TestBloomFilterMatchTxAndUpdate(t *testing.T) {
+	scriptHash, _ := hex.DecodeString("ba60494593fe65bea35fe9e118c129e5478ce660cec07c8ea8a7e2ec841fccd2")
+	pkScript := append([]byte{0x00, 0x20}, scriptHash...)
+	fundTx := massutil.NewTx(&wire.MsgTx{
+		Version: 1,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+			Sequence:         wire.MaxTxInSequenceNum,
+		}},
+		TxOut: []*wire.TxOut{{Value: 100, PkScript: []byte{0x51}}, {Value: 200, PkScript: pkScript}},
+	})
+	witness := []byte{0x01, 0x02, 0x03, 0x04}
+	spendTx := massutil.NewTx(&wire.MsgTx{
+		Version: 1,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: *wire.NewOutPoint(fundTx.Hash(), 1),
+			Sequence:         wire.MaxTxInSequenceNum,
+			Witness:          wire.TxWitness{witness},
+		}},
+		TxOut: []*wire.TxOut{{Value: 150, PkScript: []byte{0x51}}},
+	})
+
+	// matching pkScript inserts the outpoint, so the spending tx is matched
+	bf := NewBloomFilter(10, 0, 0.0001, BloomUpdateAll)
+	bf.Add(scriptHash)
+	assert.True(t, bf.MatchTxAndUpdate(fundTx))
+	assert.True(t, bf.MatchTxAndUpdate(spendTx))
+
+	bf = NewBloomFilter(10, 0, 0.0001, BloomUpdateNone)
+	bf.Add(scriptHash)
+	assert.True(t, bf.MatchTxAndUpdate(fundTx))
+	assert.False(t, bf.MatchTxAndUpdate(spendTx))
+
+	bf = NewBloomFilter(10, 0, 0.0001, BloomUpdateNone)
+	bf.Add(witness)
+	assert.False(t, bf.MatchTxAndUpdate(fundTx))
+	assert.True(t, bf.MatchTxAndUpdate(spendTx))
+
+	bf = NewBloomFilter(10, 0, 0.0001, BloomUpdateNone)
+	bf.Add(spendTx.Hash()[:])
+	assert.False(t, bf.MatchTxAndUpdate(fundTx))
+	assert.True(t, bf.MatchTxAndUpdate(spendTx))
+}
@@ -4,4 +4,8 @@ import "errors"
 
 var (
 	errPeerIDNotExists = errors.New("peerID not exist")
+
+	errMerkleBlockMatchedCount = errors.New("matched flags mismatch transaction count")
+	errMerkleBlockRootMismatch = errors.New("merkle root mismatch transaction root of header")
+	errMerkleBlockTxMismatch   = errors.New("transactions mismatch matched hashes of merkle block")
 )
@@ -1,6 +1,7 @@
 package netsync
 
 import (
+	"math"
 	"reflect"
 
 	"github.com/wangxinyu2018/mass-core/blockchain"
@@ -10,14 +11,34 @@ import (
 	"github.com/wangxinyu2018/mass-core/logging"
 	"github.com/wangxinyu2018/mass-core/massutil"
 	"github.com/wangxinyu2018/mass-core/p2p"
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb"
 	"github.com/wangxinyu2018/mass-core/wire"
 	cmn "github.com/massnetorg/tendermint/tmlibs/common"
 )
 
 const (
-	maxTxChanSize         = 10000
-	maxFilterAddressSize  = 50
-	maxFilterAddressCount = 1000
+	maxTxChanSize = 10000
+
+	// maxGetCFiltersReqRange is the maximum number of filters served for
+	// one GetCFiltersMessage.
+	maxGetCFiltersReqRange = 1000
+	// maxCFHeadersPerMsg is the maximum number of filter hashes in one
+	// CFHeadersMessage.
+	maxCFHeadersPerMsg = 2000
+	// CFCheckptInterval is the gap between heights of filter headers in
+	// CFCheckptMessage.
+	CFCheckptInterval = 1000
+
+	// maxTrieNodesPerMsg is the maximum number of trie nodes requested by
+	// one GetTrieNodesMessage.
+	maxTrieNodesPerMsg = 1024
+	// maxBindingRangeLeaves is the maximum number of leaves served for one
+	// GetBindingRangeMessage.
+	maxBindingRangeLeaves = 4096
+	// stateResponseSoftSize is the size of trie nodes or leaves after which
+	// a state sync response is cut short.
+	stateResponseSoftSize = 2 * 1024 * 1024
 )
 
 type Chain interface {
@@ -28,10 +49,15 @@ type Chain interface {
 	GetHeaderByHash(*wire.Hash) (*wire.BlockHeader, error)
 	GetHeaderByHeight(uint64) (*wire.BlockHeader, error)
 	InMainChain(wire.Hash) bool
+	GetCFilterByHeight(uint64) (*wire.Hash, *wire.Hash, []byte, error)
 	ProcessBlock(*massutil.Block) (bool, error)
 	ProcessTx(*massutil.Tx) (bool, error)
 	ChainID() *wire.Hash
 	Checkpoints() []config.Checkpoint
+	GetBindingTrieNodes([]common.Hash, int) [][]byte
+	GetBindingStateRange(common.Hash, []byte, int, int) ([][]byte, [][]byte, [][]byte, error)
+	BindingStateDB() massdb.KeyValueStore
+	SnapshotValidator() *blockchain.SnapshotValidator
 }
 
 type TxPool interface {
@@ -49,6 +75,7 @@ type SyncManager struct {
 	txPool       TxPool
 	blockFetcher *blockFetcher
 	blockKeeper  *blockKeeper
+	stateKeeper  *stateKeeper
 	peers        *peerSet
 
 	newTxCh    chan *massutil.Tx
@@ -78,6 +105,7 @@ func NewSyncManager(config *config.Config, chain Chain, txPool *blockchain.TxPoo
 		// privKey:      crypto.GenPrivKeyEd25519(),
 		blockFetcher: newBlockFetcher(chain, peers),
 		blockKeeper:  newBlockKeeper(chain, peers),
+		stateKeeper:  newStateKeeper(chain, peers),
 		peers:        peers,
 		newTxCh:      make(chan *massutil.Tx, maxTxChanSize),
 		newBlockCh:   newBlockCh,
@@ -183,15 +211,33 @@ func (sm *SyncManager) handleBlocksMsg(peer *peer, msg *BlocksMessage) {
 }
 
 func (sm *SyncManager) handleFilterAddMsg(peer *peer, msg *FilterAddMessage) {
-	peer.addFilterAddress(msg.Address)
+	if len(msg.Data) > maxFilterAddDataSize {
+		logging.CPrint(logging.WARN, "the size of filter data is greater than limit",
+			logging.LogFormat{"size": len(msg.Data), "limit": maxFilterAddDataSize})
+		sm.peers.addBanScore(peer.ID(), 100, 0, "oversized filter data")
+		return
+	}
+	if !peer.addFilterData(msg.Data) {
+		sm.peers.addBanScore(peer.ID(), 100, 0, "add filter data without filter loaded")
+	}
 }
 
 func (sm *SyncManager) handleFilterClearMsg(peer *peer) {
-	peer.filterAdds.Clear()
+	peer.clearFilter()
 }
 
 func (sm *SyncManager) handleFilterLoadMsg(peer *peer, msg *FilterLoadMessage) {
-	peer.addFilterAddresses(msg.Addresses)
+	if !validFilterLoad(msg) {
+		logging.CPrint(logging.WARN, "the filter is beyond limits", logging.LogFormat{
+			"size":            len(msg.Filter),
+			"size_limit":      maxFilterLoadFilterSize,
+			"hash_funcs":      msg.HashFuncs,
+			"hash_func_limit": maxFilterLoadHashFuncs,
+		})
+		sm.peers.addBanScore(peer.ID(), 100, 0, "oversized filter")
+		return
+	}
+	peer.loadFilter(LoadBloomFilter(msg))
 }
 
 func (sm *SyncManager) handleGetBlockMsg(peer *peer, msg *GetBlockMessage) {
@@ -216,6 +262,35 @@ func (sm *SyncManager) handleGetBlockMsg(peer *peer, msg *GetBlockMessage) {
 	}
 }
 
+func (sm *SyncManager) handleGetMerkleBlockMsg(peer *peer, msg *GetMerkleBlockMessage) {
+	var block *massutil.Block
+	var err error
+	if msg.Height != 0 {
+		block, err = sm.chain.GetBlockByHeight(msg.Height)
+	} else {
+		block, err = sm.chain.GetBlockByHash(msg.GetHash())
+	}
+	if err != nil {
+		logging.CPrint(logging.WARN, "fail on handleGetMerkleBlockMsg get block from chain", logging.LogFormat{"err": err})
+		return
+	}
+
+	ok, err := peer.sendMerkleBlock(block)
+	if !ok {
+		sm.peers.removePeer(peer.ID())
+	}
+	if err != nil {
+		logging.CPrint(logging.ERROR, "fail on handleGetMerkleBlockMsg sendMerkleBlock", logging.LogFormat{"err": err})
+	}
+}
+
+func (sm *SyncManager) handleMerkleBlockMsg(peer *peer, msg *MerkleBlockMessage) {
+	// full nodes never request merkle blocks, only make sure the proof is valid
+	if _, _, err := msg.GetMerkleBlock(); err != nil {
+		sm.peers.addBanScore(peer.ID(), 20, 0, "received invalid merkle block")
+	}
+}
+
 func (sm *SyncManager) handleGetBlocksMsg(peer *peer, msg *GetBlocksMessage) {
 	//blocks, err := sm.blockKeeper.locateBlocks(msg.GetBlockLocator(), msg.GetStopHash())
 	//if err != nil || len(blocks) == 0 {
@@ -266,6 +341,135 @@ func (sm *SyncManager) handleGetBlocksMsg(peer *peer, msg *GetBlocksMessage) {
 	}
 }
 
+// cfStopHeight returns the height of stopHash if it is in main chain and not
+// lower than startHeight, and no more than maxRange blocks are requested.
+func (sm *SyncManager) cfStopHeight(startHeight uint64, stopHash *wire.Hash, maxRange uint64) (uint64, bool) {
+	if !sm.chain.InMainChain(*stopHash) {
+		return 0, false
+	}
+	header, err := sm.chain.GetHeaderByHash(stopHash)
+	if err != nil {
+		return 0, false
+	}
+	if header.Height < startHeight || header.Height-startHeight >= maxRange {
+		return 0, false
+	}
+	return header.Height, true
+}
+
+func (sm *SyncManager) handleGetCFiltersMsg(peer *peer, msg *GetCFiltersMessage) {
+	stopHeight, ok := sm.cfStopHeight(msg.StartHeight, msg.GetStopHash(), maxGetCFiltersReqRange)
+	if !ok {
+		logging.CPrint(logging.DEBUG, "invalid GetCFiltersMessage range", logging.LogFormat{"msg": msg.String()})
+		return
+	}
+
+	for height := msg.StartHeight; height <= stopHeight; height++ {
+		blockHash, _, filter, err := sm.chain.GetCFilterByHeight(height)
+		if err != nil {
+			logging.CPrint(logging.WARN, "fail on handleGetCFiltersMsg get filter from chain", logging.LogFormat{"height": height, "err": err})
+			return
+		}
+		if ok := peer.TrySend(BlockchainChannel, struct{ BlockchainMessage }{NewCFilterMessage(blockHash, filter)}); !ok {
+			sm.peers.removePeer(peer.ID())
+			return
+		}
+	}
+}
+
+func (sm *SyncManager) handleGetCFHeadersMsg(peer *peer, msg *GetCFHeadersMessage) {
+	stopHash := msg.GetStopHash()
+	stopHeight, ok := sm.cfStopHeight(msg.StartHeight, stopHash, maxCFHeadersPerMsg)
+	if !ok {
+		logging.CPrint(logging.DEBUG, "invalid GetCFHeadersMessage range", logging.LogFormat{"msg": msg.String()})
+		return
+	}
+
+	prevFilterHeader := &wire.Hash{}
+	if msg.StartHeight > 0 {
+		_, header, _, err := sm.chain.GetCFilterByHeight(msg.StartHeight - 1)
+		if err != nil {
+			logging.CPrint(logging.WARN, "fail on handleGetCFHeadersMsg get filter from chain", logging.LogFormat{"height": msg.StartHeight - 1, "err": err})
+			return
+		}
+		prevFilterHeader = header
+	}
+	filterHashes := make([]*wire.Hash, 0, stopHeight-msg.StartHeight+1)
+	for height := msg.StartHeight; height <= stopHeight; height++ {
+		_, _, filter, err := sm.chain.GetCFilterByHeight(height)
+		if err != nil {
+			logging.CPrint(logging.WARN, "fail on handleGetCFHeadersMsg get filter from chain", logging.LogFormat{"height": height, "err": err})
+			return
+		}
+		filterHash := wire.DoubleHashH(filter)
+		filterHashes = append(filterHashes, &filterHash)
+	}
+
+	msgHeaders := NewCFHeadersMessage(stopHash, prevFilterHeader, filterHashes)
+	if ok := peer.TrySend(BlockchainChannel, struct{ BlockchainMessage }{msgHeaders}); !ok {
+		sm.peers.removePeer(peer.ID())
+	}
+}
+
+func (sm *SyncManager) handleGetCFCheckptMsg(peer *peer, msg *GetCFCheckptMessage) {
+	stopHash := msg.GetStopHash()
+	stopHeight, ok := sm.cfStopHeight(0, stopHash, math.MaxUint64)
+	if !ok {
+		logging.CPrint(logging.DEBUG, "invalid GetCFCheckptMessage stop hash", logging.LogFormat{"msg": msg.String()})
+		return
+	}
+
+	filterHeaders := make([]*wire.Hash, 0, stopHeight/CFCheckptInterval)
+	for height := uint64(CFCheckptInterval); height <= stopHeight; height += CFCheckptInterval {
+		_, header, _, err := sm.chain.GetCFilterByHeight(height)
+		if err != nil {
+			logging.CPrint(logging.WARN, "fail on handleGetCFCheckptMsg get filter from chain", logging.LogFormat{"height": height, "err": err})
+			return
+		}
+		filterHeaders = append(filterHeaders, header)
+	}
+
+	msgCheckpt := NewCFCheckptMessage(stopHash, filterHeaders)
+	if ok := peer.TrySend(BlockchainChannel, struct{ BlockchainMessage }{msgCheckpt}); !ok {
+		sm.peers.removePeer(peer.ID())
+	}
+}
+
+func (sm *SyncManager) handleGetTrieNodesMsg(peer *peer, msg *GetTrieNodesMessage) {
+	hashes := msg.GetHashes()
+	if len(hashes) > maxTrieNodesPerMsg {
+		hashes = hashes[:maxTrieNodesPerMsg]
+	}
+	nodes := sm.chain.GetBindingTrieNodes(hashes, stateResponseSoftSize)
+	if ok := peer.TrySend(BlockchainChannel, struct{ BlockchainMessage }{&TrieNodesMessage{Nodes: nodes}}); !ok {
+		sm.peers.removePeer(peer.ID())
+	}
+}
+
+func (sm *SyncManager) handleTrieNodesMsg(peer *peer, msg *TrieNodesMessage) {
+	sm.stateKeeper.processTrieNodes(peer.ID(), msg.Nodes)
+}
+
+func (sm *SyncManager) handleGetBindingRangeMsg(peer *peer, msg *GetBindingRangeMessage) {
+	limit := int(msg.Limit)
+	if limit == 0 || limit > maxBindingRangeLeaves {
+		limit = maxBindingRangeLeaves
+	}
+	root := msg.GetRoot()
+	keys, values, proof, err := sm.chain.GetBindingStateRange(root, msg.Origin, limit, stateResponseSoftSize)
+	if err != nil {
+		logging.CPrint(logging.DEBUG, "fail on handleGetBindingRangeMsg get binding range", logging.LogFormat{"msg": msg.String(), "err": err})
+		return
+	}
+	if ok := peer.TrySend(BlockchainChannel, struct{ BlockchainMessage }{NewBindingRangeMessage(root, keys, values, proof)}); !ok {
+		sm.peers.removePeer(peer.ID())
+	}
+}
+
+func (sm *SyncManager) handleBindingRangeMsg(peer *peer, msg *BindingRangeMessage) {
+	sm.stateKeeper.processBindingRange(peer.ID(), msg)
+}
+
 func (sm *SyncManager) handleGetHeaderMsg(peer *peer, msg *GetHeaderMessage) {
 	var header *wire.BlockHeader
 	var err error
@@ -442,6 +646,36 @@ func (sm *SyncManager) processMsg(basePeer BasePeer, msgType byte, msg Blockchai
 	case *FilterClearMessage:
 		sm.handleFilterClearMsg(peer)
 
+	case *GetMerkleBlockMessage:
+		sm.handleGetMerkleBlockMsg(peer, msg)
+
+	case *MerkleBlockMessage:
+		sm.handleMerkleBlockMsg(peer, msg)
+
+	case *GetCFiltersMessage:
+		sm.handleGetCFiltersMsg(peer, msg)
+
+	case *GetCFHeadersMessage:
+		sm.handleGetCFHeadersMsg(peer, msg)
+
+	case *GetCFCheckptMessage:
+		sm.handleGetCFCheckptMsg(peer, msg)
+
+	case *GetTrieNodesMessage:
+		sm.handleGetTrieNodesMsg(peer, msg)
+
+	case *TrieNodesMessage:
+		sm.handleTrieNodesMsg(peer, msg)
+
+	case *GetBindingRangeMessage:
+		sm.handleGetBindingRangeMsg(peer, msg)
+
+	case *BindingRangeMessage:
+		sm.handleBindingRangeMsg(peer, msg)
+
+	case *CFilterMessage, *CFHeadersMessage, *CFCheckptMessage:
+		// responses for light clients, never requested by full nodes
+
 	default:
 		logging.CPrint(logging.ERROR, "unknown message type", logging.LogFormat{"typ": reflect.TypeOf(msg)})
 	}
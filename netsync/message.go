@@ -6,6 +6,8 @@ import (
 	"fmt"
 
 	"github.com/wangxinyu2018/mass-core/massutil"
+	"github.com/wangxinyu2018/mass-core/massutil/gcs"
+	"github.com/wangxinyu2018/mass-core/trie/common"
 	"github.com/wangxinyu2018/mass-core/wire"
 	gowire "github.com/massnetorg/tendermint/go-wire"
 )
@@ -32,6 +34,18 @@ const (
 	MerkleRequestByte   = byte(0x60)
 	MerkleResponseByte  = byte(0x61)
 
+	CFiltersRequestByte   = byte(0x70)
+	CFilterResponseByte   = byte(0x71)
+	CFHeadersRequestByte  = byte(0x72)
+	CFHeadersResponseByte = byte(0x73)
+	CFCheckptRequestByte  = byte(0x74)
+	CFCheckptResponseByte = byte(0x75)
+
+	TrieNodesRequestByte     = byte(0x80)
+	TrieNodesResponseByte    = byte(0x81)
+	BindingRangeRequestByte  = byte(0x82)
+	BindingRangeResponseByte = byte(0x83)
+
 	maxBlockchainResponseSize = 4000000
 )
 
@@ -55,8 +69,18 @@ var _ = gowire.RegisterInterface(
 	gowire.ConcreteType{&FilterLoadMessage{}, FilterLoadByte},
 	gowire.ConcreteType{&FilterAddMessage{}, FilterAddByte},
 	gowire.ConcreteType{&FilterClearMessage{}, FilterClearByte},
-	//gowire.ConcreteType{&GetMerkleBlockMessage{}, MerkleRequestByte},
-	//gowire.ConcreteType{&MerkleBlockMessage{}, MerkleResponseByte},
+	gowire.ConcreteType{&GetMerkleBlockMessage{}, MerkleRequestByte},
+	gowire.ConcreteType{&MerkleBlockMessage{}, MerkleResponseByte},
+	gowire.ConcreteType{&GetCFiltersMessage{}, CFiltersRequestByte},
+	gowire.ConcreteType{&CFilterMessage{}, CFilterResponseByte},
+	gowire.ConcreteType{&GetCFHeadersMessage{}, CFHeadersRequestByte},
+	gowire.ConcreteType{&CFHeadersMessage{}, CFHeadersResponseByte},
+	gowire.ConcreteType{&GetCFCheckptMessage{}, CFCheckptRequestByte},
+	gowire.ConcreteType{&CFCheckptMessage{}, CFCheckptResponseByte},
+	gowire.ConcreteType{&GetTrieNodesMessage{}, TrieNodesRequestByte},
+	gowire.ConcreteType{&TrieNodesMessage{}, TrieNodesResponseByte},
+	gowire.ConcreteType{&GetBindingRangeMessage{}, BindingRangeRequestByte},
+	gowire.ConcreteType{&BindingRangeMessage{}, BindingRangeResponseByte},
 )
 
 //DecodeMessage decode msg
@@ -399,15 +423,367 @@ func (m *MineBlockMessage) String() string {
 	return fmt.Sprintf("NewMineBlockMessage{Size: %d}", len(m.RawBlock))
 }
 
-//FilterLoadMessage tells the receiving peer to filter the transactions according to address.
+//FilterLoadMessage tells the receiving peer to filter the transactions according to bloom filter.
 type FilterLoadMessage struct {
-	Addresses [][]byte
+	Filter    []byte
+	HashFuncs uint32
+	Tweak     uint32
+	Flags     uint8
+}
+
+//String convert msg to string
+func (m *FilterLoadMessage) String() string {
+	return fmt.Sprintf("FilterLoadMessage{Size: %d, HashFuncs: %d}", len(m.Filter), m.HashFuncs)
 }
 
-// FilterAddMessage tells the receiving peer to add address to the filter.
+// FilterAddMessage tells the receiving peer to add data to the loaded filter.
 type FilterAddMessage struct {
-	Address []byte
+	Data []byte
 }
 
 //FilterClearMessage tells the receiving peer to remove a previously-set filter.
 type FilterClearMessage struct{}
+
+//GetMerkleBlockMessage request merkle block from remote peers by height/hash
+type GetMerkleBlockMessage struct {
+	Height  uint64
+	RawHash [32]byte
+}
+
+//GetHash reutrn the hash of the request
+func (m *GetMerkleBlockMessage) GetHash() *wire.Hash {
+	hash, _ := wire.NewHash(m.RawHash[:])
+	return hash
+}
+
+//String convert msg to string
+func (m *GetMerkleBlockMessage) String() string {
+	if m.Height > 0 {
+		return fmt.Sprintf("GetMerkleBlockMessage{Height: %d}", m.Height)
+	}
+	hash := m.GetHash()
+	return fmt.Sprintf("GetMerkleBlockMessage{Hash: %s}", hash.String())
+}
+
+//MerkleBlockMessage response get merkle block msg, it carries the block header,
+//a partial merkle tree of transactions and the transactions matched by filter.
+type MerkleBlockMessage struct {
+	RawHeader []byte
+	TxCount   uint32
+	TxHashes  [][32]byte
+	Flags     []byte
+	RawTxs    [][]byte
+}
+
+//NewMerkleBlockMessage construct merkle block response msg, matched marks
+//transactions to be sent and must have the same length as block transactions.
+func NewMerkleBlockMessage(block *massutil.Block, matched []bool) (*MerkleBlockMessage, error) {
+	msgBlock := block.MsgBlock()
+	if len(matched) != len(msgBlock.Transactions) {
+		return nil, errMerkleBlockMatchedCount
+	}
+	rawHeader, err := msgBlock.Header.Bytes(wire.Packet)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := wire.NewPartialMerkleTree(msgBlock.Transactions, matched)
+	msg := &MerkleBlockMessage{
+		RawHeader: rawHeader,
+		TxCount:   tree.NumTx,
+		TxHashes:  make([][32]byte, len(tree.Hashes)),
+		Flags:     tree.Flags,
+		RawTxs:    make([][]byte, 0),
+	}
+	for i, hash := range tree.Hashes {
+		copy(msg.TxHashes[i][:], hash[:])
+	}
+	for i, tx := range msgBlock.Transactions {
+		if !matched[i] {
+			continue
+		}
+		rawTx, err := tx.Bytes(wire.Packet)
+		if err != nil {
+			return nil, err
+		}
+		msg.RawTxs = append(msg.RawTxs, rawTx)
+	}
+	return msg, nil
+}
+
+//GetMerkleBlock verifies the partial merkle tree against TransactionRoot of
+//the header, and returns the header with the matched transactions.
+func (m *MerkleBlockMessage) GetMerkleBlock() (*wire.BlockHeader, []*massutil.Tx, error) {
+	header, err := wire.NewBlockHeaderFromBytes(m.RawHeader, wire.Packet)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tree := &wire.PartialMerkleTree{
+		NumTx:  m.TxCount,
+		Hashes: make([]*wire.Hash, len(m.TxHashes)),
+		Flags:  m.Flags,
+	}
+	for i := range m.TxHashes {
+		hash := wire.Hash(m.TxHashes[i])
+		tree.Hashes[i] = &hash
+	}
+	root, matches, err := tree.ExtractMatches()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !header.TransactionRoot.IsEqual(&root) {
+		return nil, nil, errMerkleBlockRootMismatch
+	}
+
+	if len(m.RawTxs) != len(matches) {
+		return nil, nil, errMerkleBlockTxMismatch
+	}
+	txs := make([]*massutil.Tx, len(m.RawTxs))
+	for i, rawTx := range m.RawTxs {
+		tx, err := massutil.NewTxFromBytes(rawTx, wire.Packet)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !tx.Hash().IsEqual(&matches[i]) {
+			return nil, nil, errMerkleBlockTxMismatch
+		}
+		txs[i] = tx
+	}
+	return header, txs, nil
+}
+
+//String convert msg to string
+func (m *MerkleBlockMessage) String() string {
+	return fmt.Sprintf("MerkleBlockMessage{TxCount: %d, Matched: %d}", m.TxCount, len(m.RawTxs))
+}
+
+//GetCFiltersMessage request compact filters of main chain blocks from start height to stop hash
+type GetCFiltersMessage struct {
+	StartHeight uint64
+	RawStopHash [32]byte
+}
+
+//GetStopHash return the stop hash of the request
+func (m *GetCFiltersMessage) GetStopHash() *wire.Hash {
+	hash, _ := wire.NewHash(m.RawStopHash[:])
+	return hash
+}
+
+//String convert msg to string
+func (m *GetCFiltersMessage) String() string {
+	return fmt.Sprintf("GetCFiltersMessage{StartHeight: %d, StopHash: %s}", m.StartHeight, m.GetStopHash())
+}
+
+//CFilterMessage response compact filter of one block
+type CFilterMessage struct {
+	RawBlockHash [32]byte
+	Filter       []byte
+}
+
+//NewCFilterMessage construct compact filter response msg
+func NewCFilterMessage(blockHash *wire.Hash, filter []byte) *CFilterMessage {
+	msg := &CFilterMessage{Filter: filter}
+	copy(msg.RawBlockHash[:], blockHash[:])
+	return msg
+}
+
+//GetBlockHash return the block hash of the filter
+func (m *CFilterMessage) GetBlockHash() *wire.Hash {
+	hash, _ := wire.NewHash(m.RawBlockHash[:])
+	return hash
+}
+
+//GetFilter get compact filter from msg
+func (m *CFilterMessage) GetFilter() (*gcs.Filter, error) {
+	return gcs.FromBasicFilterBytes(m.Filter)
+}
+
+//String convert msg to string
+func (m *CFilterMessage) String() string {
+	return fmt.Sprintf("CFilterMessage{BlockHash: %s, Size: %d}", m.GetBlockHash(), len(m.Filter))
+}
+
+//GetCFHeadersMessage request compact filter hashes of main chain blocks from start height to stop hash
+type GetCFHeadersMessage struct {
+	StartHeight uint64
+	RawStopHash [32]byte
+}
+
+//GetStopHash return the stop hash of the request
+func (m *GetCFHeadersMessage) GetStopHash() *wire.Hash {
+	hash, _ := wire.NewHash(m.RawStopHash[:])
+	return hash
+}
+
+//String convert msg to string
+func (m *GetCFHeadersMessage) String() string {
+	return fmt.Sprintf("GetCFHeadersMessage{StartHeight: %d, StopHash: %s}", m.StartHeight, m.GetStopHash())
+}
+
+//CFHeadersMessage response the filter header before start height and the following filter hashes
+type CFHeadersMessage struct {
+	RawStopHash         [32]byte
+	RawPrevFilterHeader [32]byte
+	RawFilterHashes     [][32]byte
+}
+
+//NewCFHeadersMessage construct compact filter headers response msg
+func NewCFHeadersMessage(stopHash, prevFilterHeader *wire.Hash, filterHashes []*wire.Hash) *CFHeadersMessage {
+	msg := &CFHeadersMessage{RawFilterHashes: make([][32]byte, len(filterHashes))}
+	copy(msg.RawStopHash[:], stopHash[:])
+	copy(msg.RawPrevFilterHeader[:], prevFilterHeader[:])
+	for i, hash := range filterHashes {
+		copy(msg.RawFilterHashes[i][:], hash[:])
+	}
+	return msg
+}
+
+//GetStopHash return the stop hash of the response
+func (m *CFHeadersMessage) GetStopHash() *wire.Hash {
+	hash, _ := wire.NewHash(m.RawStopHash[:])
+	return hash
+}
+
+//GetFilterHeaders rebuilds filter headers by chaining filter hashes to the previous filter header
+func (m *CFHeadersMessage) GetFilterHeaders() []*wire.Hash {
+	headers := make([]*wire.Hash, len(m.RawFilterHashes))
+	prevHeader := wire.Hash(m.RawPrevFilterHeader)
+	for i := range m.RawFilterHashes {
+		header := gcs.MakeHeaderForFilterHash(wire.Hash(m.RawFilterHashes[i]), &prevHeader)
+		headers[i] = &header
+		prevHeader = header
+	}
+	return headers
+}
+
+//String convert msg to string
+func (m *CFHeadersMessage) String() string {
+	return fmt.Sprintf("CFHeadersMessage{StopHash: %s, Count: %d}", m.GetStopHash(), len(m.RawFilterHashes))
+}
+
+//GetCFCheckptMessage request filter headers at every checkpoint interval up to stop hash
+type GetCFCheckptMessage struct {
+	RawStopHash [32]byte
+}
+
+//GetStopHash return the stop hash of the request
+func (m *GetCFCheckptMessage) GetStopHash() *wire.Hash {
+	hash, _ := wire.NewHash(m.RawStopHash[:])
+	return hash
+}
+
+//String convert msg to string
+func (m *GetCFCheckptMessage) String() string {
+	return fmt.Sprintf("GetCFCheckptMessage{StopHash: %s}", m.GetStopHash())
+}
+
+//CFCheckptMessage response filter headers at heights CFCheckptInterval, 2*CFCheckptInterval...
+type CFCheckptMessage struct {
+	RawStopHash      [32]byte
+	RawFilterHeaders [][32]byte
+}
+
+//NewCFCheckptMessage construct compact filter checkpoints response msg
+func NewCFCheckptMessage(stopHash *wire.Hash, filterHeaders []*wire.Hash) *CFCheckptMessage {
+	msg := &CFCheckptMessage{RawFilterHeaders: make([][32]byte, len(filterHeaders))}
+	copy(msg.RawStopHash[:], stopHash[:])
+	for i, hash := range filterHeaders {
+		copy(msg.RawFilterHeaders[i][:], hash[:])
+	}
+	return msg
+}
+
+//GetStopHash return the stop hash of the response
+func (m *CFCheckptMessage) GetStopHash() *wire.Hash {
+	hash, _ := wire.NewHash(m.RawStopHash[:])
+	return hash
+}
+
+//String convert msg to string
+func (m *CFCheckptMessage) String() string {
+	return fmt.Sprintf("CFCheckptMessage{StopHash: %s, Count: %d}", m.GetStopHash(), len(m.RawFilterHeaders))
+}
+
+//GetTrieNodesMessage request binding trie nodes by hash
+type GetTrieNodesMessage struct {
+	RawHashes [][32]byte
+}
+
+//NewGetTrieNodesMessage construct trie nodes request msg
+func NewGetTrieNodesMessage(hashes []common.Hash) *GetTrieNodesMessage {
+	msg := &GetTrieNodesMessage{RawHashes: make([][32]byte, len(hashes))}
+	for i, hash := range hashes {
+		msg.RawHashes[i] = hash
+	}
+	return msg
+}
+
+//GetHashes return the node hashes of the request
+func (m *GetTrieNodesMessage) GetHashes() []common.Hash {
+	hashes := make([]common.Hash, len(m.RawHashes))
+	for i, hash := range m.RawHashes {
+		hashes[i] = hash
+	}
+	return hashes
+}
+
+//String convert msg to string
+func (m *GetTrieNodesMessage) String() string {
+	return fmt.Sprintf("GetTrieNodesMessage{Count: %d}", len(m.RawHashes))
+}
+
+//TrieNodesMessage response encoded trie nodes, those not found are left out
+type TrieNodesMessage struct {
+	Nodes [][]byte
+}
+
+//String convert msg to string
+func (m *TrieNodesMessage) String() string {
+	return fmt.Sprintf("TrieNodesMessage{Count: %d}", len(m.Nodes))
+}
+
+//GetBindingRangeMessage request binding trie leaves of root from origin on
+type GetBindingRangeMessage struct {
+	RawRoot [32]byte
+	Origin  []byte
+	Limit   uint32
+}
+
+//NewGetBindingRangeMessage construct binding range request msg
+func NewGetBindingRangeMessage(root common.Hash, origin []byte, limit uint32) *GetBindingRangeMessage {
+	return &GetBindingRangeMessage{RawRoot: root, Origin: origin, Limit: limit}
+}
+
+//GetRoot return the trie root of the request
+func (m *GetBindingRangeMessage) GetRoot() common.Hash {
+	return m.RawRoot
+}
+
+//String convert msg to string
+func (m *GetBindingRangeMessage) String() string {
+	return fmt.Sprintf("GetBindingRangeMessage{Root: %x, Origin: %x, Limit: %d}", m.RawRoot, m.Origin, m.Limit)
+}
+
+//BindingRangeMessage response binding trie leaves with the proofs of the origin and last keys
+type BindingRangeMessage struct {
+	RawRoot [32]byte
+	Keys    [][]byte
+	Values  [][]byte
+	Proof   [][]byte
+}
+
+//NewBindingRangeMessage construct binding range response msg
+func NewBindingRangeMessage(root common.Hash, keys, values, proof [][]byte) *BindingRangeMessage {
+	return &BindingRangeMessage{RawRoot: root, Keys: keys, Values: values, Proof: proof}
+}
+
+//GetRoot return the trie root of the response
+func (m *BindingRangeMessage) GetRoot() common.Hash {
+	return m.RawRoot
+}
+
+//String convert msg to string
+func (m *BindingRangeMessage) String() string {
+	return fmt.Sprintf("BindingRangeMessage{Root: %x, Count: %d}", m.RawRoot, len(m.Keys))
+}
@@ -1,7 +1,6 @@
 package netsync
 
 import (
-	"encoding/hex"
 	"net"
 	"sync"
 
@@ -54,9 +53,9 @@ type peer struct {
 	height      uint64
 	hash        *wire.Hash
 	banScore    trust.DynamicBanScore
-	knownTxs    *set.Set // Set of transaction hashes known to be known by this peer
-	knownBlocks *set.Set // Set of block hashes known to be known by this peer
-	filterAdds  *set.Set // Set of addresses that the spv node cares about.
+	knownTxs    *set.Set     // Set of transaction hashes known to be known by this peer
+	knownBlocks *set.Set     // Set of block hashes known to be known by this peer
+	filter      *BloomFilter // Bloom filter loaded by the spv node, nil if not loaded.
 }
 
 func newPeer(height uint64, hash *wire.Hash, basePeer BasePeer) *peer {
@@ -67,7 +66,6 @@ func newPeer(height uint64, hash *wire.Hash, basePeer BasePeer) *peer {
 		hash:        hash,
 		knownTxs:    set.New(set.ThreadSafe).(*set.Set),
 		knownBlocks: set.New(set.ThreadSafe).(*set.Set),
-		filterAdds:  set.New(set.ThreadSafe).(*set.Set),
 	}
 }
 
@@ -98,30 +96,27 @@ func (p *peer) addBanScore(persistent, transient uint64, reason string) bool {
 	return false
 }
 
-func (p *peer) addFilterAddress(address []byte) {
-	p.mtx.Lock()
-	defer p.mtx.Unlock()
+func (p *peer) addFilterData(data []byte) bool {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
 
-	if size := p.filterAdds.Size(); size >= maxFilterAddressCount {
-		logging.CPrint(logging.WARN, "the count of filter addresses is greater than limit",
-			logging.LogFormat{"size": size, "limit": maxFilterAddressCount})
-		return
+	if p.filter == nil {
+		return false
 	}
-	if size := len(address); size > maxFilterAddressSize {
-		logging.CPrint(logging.WARN, "the size of filter address is greater than limit",
-			logging.LogFormat{"size": size, "limit": maxFilterAddressSize})
-		return
-	}
-	p.filterAdds.Add(hex.EncodeToString(address))
+	p.filter.Add(data)
+	return true
 }
 
-func (p *peer) addFilterAddresses(addresses [][]byte) {
-	if !p.filterAdds.IsEmpty() {
-		p.filterAdds.Clear()
-	}
-	for _, address := range addresses {
-		p.addFilterAddress(address)
-	}
+func (p *peer) clearFilter() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.filter = nil
+}
+
+func (p *peer) loadFilter(filter *BloomFilter) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.filter = filter
 }
 
 func (p *peer) getBlockByHeight(height uint64) bool {
@@ -156,17 +151,13 @@ func (p *peer) getPeerInfo() *PeerInfo {
 }
 
 func (p *peer) isRelatedTx(tx *massutil.Tx) bool {
-	/*for _, input := range tx.MsgTx().TxIn {
-			if p.filterAdds.Has(hex.EncodeToString(input.PreviousOutPoint.Hash[:])) {
-				return true
-			}
-		}
-	for _, output := range tx.MsgTx().TxOut {
-		if p.filterAdds.Has(hex.EncodeToString(output.PkScript)) {
-			return true
-		}
-	}*/
-	return false
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	if p.filter == nil {
+		return false
+	}
+	return p.filter.MatchTxAndUpdate(tx)
 }
 
 func (p *peer) isSPVNode() bool {
@@ -244,6 +235,28 @@ func (p *peer) sendHeaders(headers []*wire.BlockHeader) (bool, error) {
 	return ok, nil
 }
 
+func (p *peer) sendMerkleBlock(block *massutil.Block) (bool, error) {
+	txs := block.Transactions()
+	matched := make([]bool, len(txs))
+	for i, tx := range txs {
+		matched[i] = p.isRelatedTx(tx)
+	}
+	msg, err := NewMerkleBlockMessage(block, matched)
+	if err != nil {
+		return false, errors.Wrap(err, "fail on NewMerkleBlockMessage")
+	}
+
+	ok := p.TrySend(BlockchainChannel, struct{ BlockchainMessage }{msg})
+	if ok {
+		for i, tx := range txs {
+			if matched[i] {
+				p.knownTxs.Add(tx.Hash().String())
+			}
+		}
+	}
+	return ok, nil
+}
+
 func (p *peer) sendTransactions(txs []*massutil.Tx) (bool, error) {
 	for _, tx := range txs {
 		if p.isSPVNode() && !p.isRelatedTx(tx) {
@@ -0,0 +1,261 @@
+package netsync
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangxinyu2018/mass-core/consensus"
+	"github.com/wangxinyu2018/mass-core/errors"
+	"github.com/wangxinyu2018/mass-core/logging"
+	"github.com/wangxinyu2018/mass-core/trie"
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb"
+)
+
+const (
+	trieNodesProcessChSize    = 16
+	bindingRangeProcessChSize = 16
+)
+
+var (
+	errStateSyncing     = errors.New("binding state sync in progress")
+	errNoStatePeer      = errors.New("no peer to sync binding state from")
+	errStateUnavailable = errors.New("binding state not served by peer")
+)
+
+type trieNodesMsg struct {
+	nodes  [][]byte
+	peerID string
+}
+
+type bindingRangeMsg struct {
+	msg    *BindingRangeMessage
+	peerID string
+}
+
+// stateKeeper downloads binding tries from peers into the binding state
+// database.  Into an empty database the leaves of a trie are fetched range by
+// range and the trie is rebuilt from them.  Otherwise only the nodes missing
+// are fetched by hash, the subtries shared with the tries on disk, or written
+// by an interrupted sync, are not downloaded again.
+type stateKeeper struct {
+	chain Chain
+	peers *peerSet
+
+	syncing               int32
+	syncPeer              *peer
+	trieNodesProcessCh    chan *trieNodesMsg
+	bindingRangeProcessCh chan *bindingRangeMsg
+}
+
+func newStateKeeper(chain Chain, peers *peerSet) *stateKeeper {
+	return &stateKeeper{
+		chain:                 chain,
+		peers:                 peers,
+		trieNodesProcessCh:    make(chan *trieNodesMsg, trieNodesProcessChSize),
+		bindingRangeProcessCh: make(chan *bindingRangeMsg, bindingRangeProcessChSize),
+	}
+}
+
+// processTrieNodes passes a response to the running sync, responses nobody
+// waits for are dropped.
+func (sk *stateKeeper) processTrieNodes(peerID string, nodes [][]byte) {
+	select {
+	case sk.trieNodesProcessCh <- &trieNodesMsg{nodes: nodes, peerID: peerID}:
+	default:
+	}
+}
+
+// processBindingRange passes a response to the running sync, responses
+// nobody waits for are dropped.
+func (sk *stateKeeper) processBindingRange(peerID string, msg *BindingRangeMessage) {
+	select {
+	case sk.bindingRangeProcessCh <- &bindingRangeMsg{msg: msg, peerID: peerID}:
+	default:
+	}
+}
+
+// SyncBindingState downloads the binding trie of root from the best full node
+// peer into the binding state database, verifying every leaf range and node
+// against root.  It returns once the whole trie is in the database, nothing is
+// downloaded if it is already.
+func (sm *SyncManager) SyncBindingState(root common.Hash) error {
+	return sm.stateKeeper.syncBindingState(root)
+}
+
+func (sk *stateKeeper) syncBindingState(root common.Hash) error {
+	if !atomic.CompareAndSwapInt32(&sk.syncing, 0, 1) {
+		return errStateSyncing
+	}
+	defer atomic.StoreInt32(&sk.syncing, 0)
+
+	db := sk.chain.BindingStateDB()
+	if trie.NewSync(root, db).Pending() == 0 {
+		return nil
+	}
+	peer := sk.peers.bestPeer(consensus.SFFullNode)
+	if peer == nil {
+		return errNoStatePeer
+	}
+	sk.syncPeer = peer
+	sk.dropResponses()
+
+	logging.CPrint(logging.INFO, "start binding state sync", logging.LogFormat{"root": root, "peer": peer.Addr()})
+	var err error
+	if isEmptyDB(db) {
+		err = sk.syncRanges(root, db)
+	}
+	if err == nil {
+		err = sk.syncNodes(root, db)
+	}
+	if err != nil {
+		logging.CPrint(logging.WARN, "fail on binding state sync", logging.LogFormat{"root": root, "err": err, "peer": peer.Addr()})
+		if errors.Root(err) != errStateUnavailable {
+			sk.peers.errorHandler(peer.ID(), err)
+		}
+		return err
+	}
+	logging.CPrint(logging.INFO, "binding state synced", logging.LogFormat{"root": root})
+	return nil
+}
+
+// syncRanges rebuilds the trie of root from its leaves with a StackTrie,
+// which writes every node once its subtrie is complete.  The first range is
+// proven to start at the first leaf, and each following one at the last key of
+// the previous one, so the ranges are proven contiguous.
+func (sk *stateKeeper) syncRanges(root common.Hash, db massdb.KeyValueStore) error {
+	batch := db.NewBatch()
+	st := trie.NewStackTrie(batch)
+	var origin []byte
+	for {
+		keys, values, proof, err := sk.requireBindingRange(root, origin)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return errors.Wrap(errStateUnavailable, "syncRanges")
+		}
+		more, err := trie.VerifyRangeProof(root, origin, keys[len(keys)-1], keys, values, proof)
+		if err != nil {
+			return errors.Wrap(errPeerMisbehave, err)
+		}
+		if origin != nil {
+			if !bytes.Equal(keys[0], origin) {
+				return errors.Wrap(errPeerMisbehave, "binding range not starting at origin")
+			}
+			keys, values = keys[1:], values[1:]
+			if more && len(keys) == 0 {
+				return errors.Wrap(errPeerMisbehave, "binding range without progress")
+			}
+		}
+		for i, key := range keys {
+			if err := st.TryUpdate(key, values[i]); err != nil {
+				return err
+			}
+		}
+		if batch.ValueSize() >= massdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		if !more {
+			break
+		}
+		origin = keys[len(keys)-1]
+	}
+
+	hash, err := st.Commit()
+	if err != nil {
+		return err
+	}
+	if hash != root {
+		return errors.Wrap(errPeerMisbehave, "binding ranges not matching root")
+	}
+	return batch.Write()
+}
+
+// syncNodes fetches the nodes of the trie of root missing in db by hash, a
+// trie rebuilt by syncRanges has none.
+func (sk *stateKeeper) syncNodes(root common.Hash, db massdb.KeyValueStore) error {
+	sched := trie.NewSync(root, db)
+	for sched.Pending() > 0 {
+		nodes, err := sk.requireTrieNodes(sched.Missing(maxTrieNodesPerMsg))
+		if err != nil {
+			return err
+		}
+		if len(nodes) == 0 {
+			return errors.Wrap(errStateUnavailable, "syncNodes")
+		}
+		for _, enc := range nodes {
+			if err := sched.Process(enc); err != nil {
+				return errors.Wrap(errPeerMisbehave, err)
+			}
+		}
+		batch := db.NewBatch()
+		sched.Commit(batch)
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isEmptyDB(db massdb.KeyValueStore) bool {
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	return !it.Next()
+}
+
+// dropResponses drops the responses left from requests timed out before.
+func (sk *stateKeeper) dropResponses() {
+	for {
+		select {
+		case <-sk.trieNodesProcessCh:
+		case <-sk.bindingRangeProcessCh:
+		default:
+			return
+		}
+	}
+}
+
+func (sk *stateKeeper) requireBindingRange(root common.Hash, origin []byte) (keys, values, proof [][]byte, err error) {
+	msg := struct{ BlockchainMessage }{NewGetBindingRangeMessage(root, origin, maxBindingRangeLeaves)}
+	if ok := sk.syncPeer.TrySend(BlockchainChannel, msg); !ok {
+		return nil, nil, nil, errPeerDropped
+	}
+
+	waitTicker := time.NewTimer(syncTimeout)
+	for {
+		select {
+		case msg := <-sk.bindingRangeProcessCh:
+			if msg.peerID != sk.syncPeer.ID() || msg.msg.GetRoot() != root {
+				continue
+			}
+			return msg.msg.Keys, msg.msg.Values, msg.msg.Proof, nil
+		case <-waitTicker.C:
+			return nil, nil, nil, errors.Wrap(errRequestTimeout, "requireBindingRange")
+		}
+	}
+}
+
+func (sk *stateKeeper) requireTrieNodes(hashes []common.Hash) ([][]byte, error) {
+	msg := struct{ BlockchainMessage }{NewGetTrieNodesMessage(hashes)}
+	if ok := sk.syncPeer.TrySend(BlockchainChannel, msg); !ok {
+		return nil, errPeerDropped
+	}
+
+	waitTicker := time.NewTimer(syncTimeout)
+	for {
+		select {
+		case msg := <-sk.trieNodesProcessCh:
+			if msg.peerID != sk.syncPeer.ID() {
+				continue
+			}
+			return msg.nodes, nil
+		case <-waitTicker.C:
+			return nil, errors.Wrap(errRequestTimeout, "requireTrieNodes")
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package netsync
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wangxinyu2018/mass-core/consensus"
+	"github.com/wangxinyu2018/mass-core/errors"
+	"github.com/wangxinyu2018/mass-core/trie"
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb"
+	"github.com/wangxinyu2018/mass-core/trie/massdb/memorydb"
+	"github.com/wangxinyu2018/mass-core/wire"
+)
+
+const testStatePeerID = "state-peer"
+
+// testStatePeer answers the requests sent to it with respond, nil ignores
+// them.
+type testStatePeer struct {
+	respond  func(BlockchainMessage)
+	requests int
+}
+
+func (p *testStatePeer) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 43453}
+}
+func (p *testStatePeer) ID() string                         { return testStatePeerID }
+func (p *testStatePeer) ServiceFlag() consensus.ServiceFlag { return consensus.SFFullNode }
+func (p *testStatePeer) IsOutbound() bool                   { return true }
+func (p *testStatePeer) IsTrustworthy() bool                { return false }
+
+func (p *testStatePeer) TrySend(_ byte, msg interface{}) bool {
+	p.requests++
+	if p.respond != nil {
+		p.respond(msg.(struct{ BlockchainMessage }).BlockchainMessage)
+	}
+	return true
+}
+
+type testPeerSet struct{}
+
+func (ps *testPeerSet) AddBannedPeer(string, string) error { return nil }
+func (ps *testPeerSet) StopPeerGracefully(string)          {}
+
+type testStateChain struct {
+	Chain
+	db massdb.KeyValueStore
+}
+
+func (c *testStateChain) BindingStateDB() massdb.KeyValueStore {
+	return c.db
+}
+
+func newTestStateKeeper(db massdb.KeyValueStore) (*stateKeeper, *testStatePeer) {
+	peers := newPeerSet(&testPeerSet{})
+	p := &testStatePeer{}
+	peers.addPeer(p, 1, &wire.Hash{})
+	return newStateKeeper(&testStateChain{db: db}, peers), p
+}
+
+func newTestBindingTrie(t *testing.T, n int) (*trie.Trie, *trie.Database, map[string][]byte) {
+	triedb := trie.NewDatabase(memorydb.New())
+	tr, err := trie.New(common.Hash{}, triedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals := make(map[string][]byte)
+	for i := 0; i < n; i++ {
+		k, v := make([]byte, 32), make([]byte, 20)
+		rand.Read(k)
+		rand.Read(v)
+		tr.Update(k, v)
+		vals[string(k)] = v
+	}
+	if _, err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return tr, triedb, vals
+}
+
+// serveBindingRange serves limit leaves of tr from origin on the way
+// Blockchain.GetBindingStateRange does.
+func serveBindingRange(t *testing.T, tr *trie.Trie, origin []byte, limit int) (keys, values, proof [][]byte) {
+	it := trie.NewIterator(tr.NodeIterator(origin))
+	for len(keys) < limit && it.Next() {
+		keys = append(keys, common.CopyBytes(it.Key))
+		values = append(values, common.CopyBytes(it.Value))
+	}
+	if len(origin) > 0 || len(keys) == 0 {
+		p, err := tr.Prove(origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof = p
+	}
+	if len(keys) > 0 && !bytes.Equal(keys[len(keys)-1], origin) {
+		p, err := tr.Prove(keys[len(keys)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof = append(proof, p...)
+	}
+	return keys, values, proof
+}
+
+func checkSyncedBindingTrie(t *testing.T, root common.Hash, db massdb.KeyValueStore, vals map[string][]byte) {
+	if pending := trie.NewSync(root, db).Pending(); pending != 0 {
+		t.Fatalf("%d nodes pending after sync", pending)
+	}
+	tr, err := trie.New(root, trie.NewDatabase(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range vals {
+		if have := tr.Get([]byte(k)); !bytes.Equal(have, v) {
+			t.Fatalf("key %x: have %x, want %x", k, have, v)
+		}
+	}
+}
+
+func TestSyncBindingStateRanges(t *testing.T) {
+	src, _, vals := newTestBindingTrie(t, 200)
+	root := src.Hash()
+
+	db := memorydb.New()
+	sk, p := newTestStateKeeper(db)
+	p.respond = func(msg BlockchainMessage) {
+		req, ok := msg.(*GetBindingRangeMessage)
+		if !ok {
+			t.Fatalf("unexpected request %T", msg)
+		}
+		if req.GetRoot() != root {
+			t.Fatalf("range requested for root %x, want %x", req.GetRoot(), root)
+		}
+		keys, values, proof := serveBindingRange(t, src, req.Origin, 16)
+		sk.processBindingRange(testStatePeerID, NewBindingRangeMessage(root, keys, values, proof))
+	}
+	if err := sk.syncBindingState(root); err != nil {
+		t.Fatalf("sync binding state: %v", err)
+	}
+	checkSyncedBindingTrie(t, root, db, vals)
+	if p.requests < len(vals)/16 {
+		t.Fatalf("%d ranges requested for %d leaves", p.requests, len(vals))
+	}
+
+	// a synced trie is not requested again
+	p.requests = 0
+	if err := sk.syncBindingState(root); err != nil || p.requests != 0 {
+		t.Fatalf("sync synced binding state: err %v, %d requests", err, p.requests)
+	}
+}
+
+func TestSyncBindingStateNodes(t *testing.T) {
+	src, srcdb, vals := newTestBindingTrie(t, 200)
+	root := src.Hash()
+
+	// nodes are fetched by hash into a database not empty
+	db := memorydb.New()
+	db.Put([]byte("unrelated"), []byte{1})
+	sk, p := newTestStateKeeper(db)
+	p.respond = func(msg BlockchainMessage) {
+		req, ok := msg.(*GetTrieNodesMessage)
+		if !ok {
+			t.Fatalf("unexpected request %T", msg)
+		}
+		var nodes [][]byte
+		for _, hash := range req.GetHashes() {
+			enc, err := srcdb.Node(hash)
+			if err != nil {
+				t.Fatalf("node %x: %v", hash, err)
+			}
+			nodes = append(nodes, enc)
+		}
+		sk.processTrieNodes(testStatePeerID, nodes)
+	}
+	if err := sk.syncBindingState(root); err != nil {
+		t.Fatalf("sync binding state: %v", err)
+	}
+	checkSyncedBindingTrie(t, root, db, vals)
+}
+
+func TestSyncBindingStateTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { syncTimeout = timeout }(syncTimeout)
+	syncTimeout = 50 * time.Millisecond
+
+	src, _, _ := newTestBindingTrie(t, 50)
+	root := src.Hash()
+
+	// responses from other peers are dropped
+	sk, p := newTestStateKeeper(memorydb.New())
+	p.respond = func(msg BlockchainMessage) {
+		keys, values, proof := serveBindingRange(t, src, msg.(*GetBindingRangeMessage).Origin, 16)
+		sk.processBindingRange("other-peer", NewBindingRangeMessage(root, keys, values, proof))
+	}
+	err := sk.syncBindingState(root)
+	if errors.Root(err) != errRequestTimeout {
+		t.Fatalf("sync binding state: have err %v, want %v", err, errRequestTimeout)
+	}
+	if sk.peers.getPeer(testStatePeerID) != nil {
+		t.Fatal("timed out peer not removed")
+	}
+	if err := sk.syncBindingState(root); err != errNoStatePeer {
+		t.Fatalf("sync without peers: have err %v, want %v", err, errNoStatePeer)
+	}
+}
+
+func TestSyncBindingStateMisbehave(t *testing.T) {
+	src, _, _ := newTestBindingTrie(t, 200)
+	root := src.Hash()
+
+	tests := []struct {
+		name    string
+		db      func() massdb.KeyValueStore
+		respond func(sk *stateKeeper, msg BlockchainMessage)
+	}{
+		{
+			name: "leading leaf omitted",
+			db:   func() massdb.KeyValueStore { return memorydb.New() },
+			respond: func(sk *stateKeeper, msg BlockchainMessage) {
+				origin := msg.(*GetBindingRangeMessage).Origin
+				keys, values, proof := serveBindingRange(t, src, origin, 16)
+				if origin == nil {
+					keys, values = keys[1:], values[1:]
+				}
+				sk.processBindingRange(testStatePeerID, NewBindingRangeMessage(root, keys, values, proof))
+			},
+		},
+		{
+			name: "origin leaf omitted",
+			db:   func() massdb.KeyValueStore { return memorydb.New() },
+			respond: func(sk *stateKeeper, msg BlockchainMessage) {
+				origin := msg.(*GetBindingRangeMessage).Origin
+				keys, values, proof := serveBindingRange(t, src, origin, 16)
+				if origin != nil {
+					keys, values = keys[1:], values[1:]
+				}
+				sk.processBindingRange(testStatePeerID, NewBindingRangeMessage(root, keys, values, proof))
+			},
+		},
+		{
+			name: "trie node not requested",
+			db: func() massdb.KeyValueStore {
+				db := memorydb.New()
+				db.Put([]byte("unrelated"), []byte{1})
+				return db
+			},
+			respond: func(sk *stateKeeper, msg BlockchainMessage) {
+				sk.processTrieNodes(testStatePeerID, [][]byte{{0xc1, 0x80}})
+			},
+		},
+	}
+	for _, test := range tests {
+		sk, p := newTestStateKeeper(test.db())
+		p.respond = func(msg BlockchainMessage) { test.respond(sk, msg) }
+		err := sk.syncBindingState(root)
+		if errors.Root(err) != errPeerMisbehave {
+			t.Fatalf("%s: have err %v, want %v", test.name, err, errPeerMisbehave)
+		}
+		peer := sk.peers.getPeer(testStatePeerID)
+		if peer == nil || peer.banScore.Int() == 0 {
+			t.Fatalf("%s: misbehaving peer not scored", test.name)
+		}
+	}
+}
+
+func TestSyncBindingStateUnavailable(t *testing.T) {
+	src, _, _ := newTestBindingTrie(t, 50)
+	root := src.Hash()
+
+	// a peer without the trie is not punished
+	sk, p := newTestStateKeeper(memorydb.New())
+	p.respond = func(msg BlockchainMessage) {
+		sk.processBindingRange(testStatePeerID, NewBindingRangeMessage(root, nil, nil, nil))
+	}
+	err := sk.syncBindingState(root)
+	if errors.Root(err) != errStateUnavailable {
+		t.Fatalf("sync binding state: have err %v, want %v", err, errStateUnavailable)
+	}
+	peer := sk.peers.getPeer(testStatePeerID)
+	if peer == nil || peer.banScore.Int() != 0 {
+		t.Fatal("peer without the binding state punished")
+	}
+}
@@ -143,12 +143,19 @@ func NewSwitch(conf *config.Config) (*Switch, error) {
 	}
 
 	// init node info
+	services := consensus.DefaultServices
+	if conf.Chain != nil && conf.Chain.PruneDepth > 0 {
+		services = consensus.PrunedServices
+	}
+	if conf.Chain != nil && conf.Chain.CFIndex {
+		services |= consensus.SFCompactFilters
+	}
 	sw.nodeInfo = &NodeInfo{
 		PubKey:  pubKey,
 		Moniker: config.Moniker,
 		Network: config.ChainTag,
 		Version: version.GetVersion(),
-		Other:   []string{strconv.FormatUint(uint64(consensus.DefaultServices), 10)},
+		Other:   []string{strconv.FormatUint(uint64(services), 10)},
 	}
 
 	if sw.IsListening() {
@@ -0,0 +1,84 @@
+package trie
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/wangxinyu2018/mass-core/trie/common"
+)
+
+// cleanCache is a least recently used cache of encoded trie nodes, bounded by
+// the size of the hashes and encodings it holds.
+//
+// cleanCache is safe for concurrent use.
+type cleanCache struct {
+	mu      sync.Mutex
+	limit   int
+	size    int
+	entries map[common.Hash]*list.Element
+	lru     *list.List // of *cleanEntry, most recently used first
+}
+
+type cleanEntry struct {
+	hash common.Hash
+	enc  []byte
+}
+
+func newCleanCache(limit int) *cleanCache {
+	return &cleanCache{
+		limit:   limit,
+		entries: make(map[common.Hash]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns the encoded node of hash, and false if it is not cached.
+func (c *cleanCache) get(hash common.Hash) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[hash]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cleanEntry).enc, true
+}
+
+// add caches the encoded node of hash, evicting the least recently used nodes
+// beyond the limit.
+func (c *cleanCache) add(hash common.Hash, enc []byte) {
+	size := common.HashLength + len(enc)
+	if size > c.limit {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[hash]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[hash] = c.lru.PushFront(&cleanEntry{hash: hash, enc: enc})
+	c.size += size
+	for c.size > c.limit {
+		oldest := c.lru.Back()
+		entry := c.lru.Remove(oldest).(*cleanEntry)
+		delete(c.entries, entry.hash)
+		c.size -= common.HashLength + len(entry.enc)
+	}
+}
+
+// reset drops all cached nodes.
+func (c *cleanCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[common.Hash]*list.Element)
+	c.lru.Init()
+	c.size = 0
+}
+
+// stats returns the number and size of the cached nodes.
+func (c *cleanCache) stats() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
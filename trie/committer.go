@@ -6,8 +6,6 @@ import (
 
 	"github.com/wangxinyu2018/mass-core/logging"
 	"github.com/wangxinyu2018/mass-core/trie/common"
-	"github.com/wangxinyu2018/mass-core/trie/massdb"
-	"github.com/wangxinyu2018/mass-core/trie/rawdb"
 )
 
 // committer is a type used for the trie Commit operation. A committer has some
@@ -48,14 +46,14 @@ func (c *committer) Commit(n node, db *Database) (hashNode, error) {
 		return nil, errors.New("no db provided")
 	}
 
-	batch := db.diskdb.NewBatch()
+	var nodes []committedNode
 
-	h, err := c.commit(n, batch)
+	h, err := c.commit(n, &nodes)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := batch.Write(); err != nil {
+	if err := db.commitNodes(common.BytesToHash(h.(hashNode)), nodes); err != nil {
 		return nil, err
 	}
 
@@ -63,7 +61,7 @@ func (c *committer) Commit(n node, db *Database) (hashNode, error) {
 }
 
 // commit collapses a node down into a hash node and inserts it into the database
-func (c *committer) commit(n node, batch massdb.Batch) (node, error) {
+func (c *committer) commit(n node, nodes *[]committedNode) (node, error) {
 	// if this path is clean, use available cached data
 	hash, dirty := n.cache()
 	if hash != nil && !dirty {
@@ -78,7 +76,7 @@ func (c *committer) commit(n node, batch massdb.Batch) (node, error) {
 		// If the child is fullnode, recursively commit.
 		// Otherwise it can only be hashNode or valueNode.
 		if _, ok := cn.Val.(*fullNode); ok {
-			childV, err := c.commit(cn.Val, batch)
+			childV, err := c.commit(cn.Val, nodes)
 			if err != nil {
 				return nil, err
 			}
@@ -86,20 +84,20 @@ func (c *committer) commit(n node, batch massdb.Batch) (node, error) {
 		}
 		// The key needs to be copied, since we're delivering it to database
 		collapsed.Key = hexToCompact(cn.Key)
-		hashedNode := c.store(collapsed, batch)
+		hashedNode := c.store(collapsed, nodes)
 		if hn, ok := hashedNode.(hashNode); ok {
 			return hn, nil
 		}
 		return collapsed, nil
 	case *fullNode:
-		hashedKids, err := c.commitChildren(cn, batch)
+		hashedKids, err := c.commitChildren(cn, nodes)
 		if err != nil {
 			return nil, err
 		}
 		collapsed := cn.copy()
 		collapsed.Children = hashedKids
 
-		hashedNode := c.store(collapsed, batch)
+		hashedNode := c.store(collapsed, nodes)
 		if hn, ok := hashedNode.(hashNode); ok {
 			return hn, nil
 		}
@@ -113,7 +111,7 @@ func (c *committer) commit(n node, batch massdb.Batch) (node, error) {
 }
 
 // commitChildren commits the children of the given fullnode
-func (c *committer) commitChildren(n *fullNode, batch massdb.Batch) ([17]node, error) {
+func (c *committer) commitChildren(n *fullNode, nodes *[]committedNode) ([17]node, error) {
 	var children [17]node
 	for i := 0; i < 16; i++ {
 		child := n.Children[i]
@@ -130,7 +128,7 @@ func (c *committer) commitChildren(n *fullNode, batch massdb.Batch) ([17]node, e
 		// Commit the child recursively and store the "hashed" value.
 		// Note the returned node can be some embedded nodes, so it's
 		// possible the type is not hashnode.
-		hashed, err := c.commit(child, batch)
+		hashed, err := c.commit(child, nodes)
 		if err != nil {
 			return children, err
 		}
@@ -204,7 +202,7 @@ func (c *committer) commitChildren(n *fullNode, batch massdb.Batch) ([17]node, e
 // }
 
 //===============
-func (c *committer) store(n node, batch massdb.Batch) node {
+func (c *committer) store(n node, nodes *[]committedNode) node {
 	hash, _ := n.cache()
 
 	if hash == nil {
@@ -220,6 +218,6 @@ func (c *committer) store(n node, batch massdb.Batch) node {
 		logging.CPrint(logging.PANIC, "failed to encode node", logging.LogFormat{"err": err})
 	}
 
-	rawdb.WriteTrieNode(batch, common.BytesToHash(hash), enc)
+	*nodes = append(*nodes, committedNode{common.BytesToHash(hash), enc})
 	return hash
 }
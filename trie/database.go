@@ -2,7 +2,11 @@ package trie
 
 import (
 	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/wangxinyu2018/mass-core/logging"
 	"github.com/wangxinyu2018/mass-core/trie/common"
 	"github.com/wangxinyu2018/mass-core/trie/massdb"
 	"github.com/wangxinyu2018/mass-core/trie/rawdb"
@@ -17,25 +21,51 @@ import (
 // behind this split design is to provide read access to RPC handlers and sync
 // servers even while the trie is executing expensive garbage collection.
 type Database struct {
-	diskdb massdb.KeyValueStore // Persistent storage for matured trie nodes
-
-	// dirties map[common.Hash]*cachedNode // Data and references relationships of dirty trie nodes
-	// oldest  common.Hash                 // Oldest tracked node, flush-list head
-	// newest  common.Hash                 // Newest tracked node, flush-list tail
+	// Stats of node lookups and flushes, accessed atomically.
+	cleanHits    uint64
+	cleanMisses  uint64
+	dirtyHits    uint64
+	diskReads    uint64
+	flushes      uint64
+	flushedNodes uint64
 
-	// gctime  time.Duration      // Time spent on garbage collection since last commit
-	// gcnodes uint64             // Nodes garbage collected since last commit
-	// gcsize  common.StorageSize // Data storage garbage collected since last commit
+	diskdb massdb.KeyValueStore // Persistent storage for matured trie nodes
 
-	// flushtime  time.Duration      // Time spent on data flushing since last commit
-	// flushnodes uint64             // Nodes flushed since last commit
-	// flushsize  common.StorageSize // Data storage flushed since last commit
+	cleans *cleanCache // Clean node cache, nil if disabled
+
+	lock          sync.RWMutex
+	dirties       map[common.Hash][]byte // Encoded nodes committed but not yet flushed to disk
+	dirtyOrder    []common.Hash          // Hashes of the dirty nodes in commit order, children before parents
+	lastRoot      common.Hash            // Root of the last trie commit, recorded on disk by the next flush
+	dirtiesSize   common.StorageSize     // Storage size of the dirty node cache
+	dirtyLimit    common.StorageSize     // Storage size the dirty node cache is flushed at, 0 to write through
+	flushInterval time.Duration          // Time after which the dirty node cache is flushed, 0 for no limit
+	lastFlush     time.Time              // Time of the last flush
+	flushedSize   common.StorageSize     // Data storage flushed
+	flushTime     time.Duration          // Time spent on flushing
+}
 
-	// dirtiesSize   common.StorageSize // Storage size of the dirty node cache (exc. metadata)
-	// childrenSize  common.StorageSize // Storage size of the external children tracking
-	// preimagesSize common.StorageSize // Storage size of the preimages cache
+// Config defines the caching of a Database.
+type Config struct {
+	Cache         int           // Memory allowance (MB) of the clean node cache, 0 to disable
+	DirtyCache    int           // Memory allowance (MB) of committed nodes not yet flushed, 0 to write through
+	FlushInterval time.Duration // Max time committed nodes stay unflushed, 0 for no limit
+}
 
-	// lock sync.RWMutex
+// DatabaseStats reports the node lookups and flushes of a Database.
+type DatabaseStats struct {
+	CleanHits    uint64 // lookups found in the clean node cache
+	CleanMisses  uint64 // lookups not found in the clean node cache
+	CleanNodes   int
+	CleanSize    common.StorageSize
+	DirtyHits    uint64 // lookups found in the dirty node cache
+	DirtyNodes   int
+	DirtySize    common.StorageSize
+	DiskReads    uint64 // lookups read from disk
+	Flushes      uint64
+	FlushedNodes uint64
+	FlushedSize  common.StorageSize
+	FlushTime    time.Duration
 }
 
 // // rawNode is a simple binary blob used to differentiate between collapsed trie
@@ -189,34 +219,43 @@ type Database struct {
 // 	}
 // }
 
+// NewDatabase creates a new trie database writing committed nodes straight to
+// diskdb, without caching nodes in memory.
 func NewDatabase(diskdb massdb.KeyValueStore) *Database {
+	return NewDatabaseWithConfig(diskdb, nil)
+}
+
+// NewDatabaseWithConfig creates a new trie database storing its nodes in
+// diskdb, caching them in memory as configured.
+func NewDatabaseWithConfig(diskdb massdb.KeyValueStore, config *Config) *Database {
 	db := &Database{
-		diskdb: diskdb,
-		// dirties: map[common.Hash]*cachedNode{{}: {
-		// 	children: make(map[common.Hash]uint16),
-		// }},
+		diskdb:    diskdb,
+		dirties:   make(map[common.Hash][]byte),
+		lastFlush: time.Now(),
+	}
+	if config != nil {
+		if config.Cache > 0 {
+			db.cleans = newCleanCache(config.Cache * 1024 * 1024)
+		}
+		db.dirtyLimit = common.StorageSize(config.DirtyCache * 1024 * 1024)
+		db.flushInterval = config.FlushInterval
 	}
 	return db
 }
 
-// node retrieves a cached trie node from memory, or returns nil if none can be
-// found in the memory cache.
-func (db *Database) node(hash common.Hash) node {
-	// // Retrieve the node from the dirty cache if available
-	// db.lock.RLock()
-	// dirty := db.dirties[hash]
-	// db.lock.RUnlock()
-
-	// if dirty != nil {
-	// 	return dirty.obj(hash)
-	// }
+// DiskDB retrieves the persistent storage backing the trie database, which
+// misses the nodes not yet flushed.
+func (db *Database) DiskDB() massdb.KeyValueStore {
+	return db.diskdb
+}
 
-	// Content unavailable in memory, attempt to retrieve from disk
-	enc, err := db.diskdb.Get(hash[:])
-	if err != nil || enc == nil {
+// node retrieves a trie node from the caches or the disk, or returns nil if
+// none can be found.
+func (db *Database) node(hash common.Hash) node {
+	enc := db.nodeBlob(hash)
+	if enc == nil {
 		return nil
 	}
-
 	n, err := decodeNode(hash[:], enc)
 	if err != nil {
 		return nil
@@ -230,6 +269,37 @@ func (db *Database) node(hash common.Hash) node {
 	return n
 }
 
+// nodeBlob retrieves the encoded trie node of hash from the clean cache, the
+// dirty cache or the disk, and returns nil if it is found nowhere.
+func (db *Database) nodeBlob(hash common.Hash) []byte {
+	if db.cleans != nil {
+		if enc, ok := db.cleans.get(hash); ok {
+			atomic.AddUint64(&db.cleanHits, 1)
+			return enc
+		}
+		atomic.AddUint64(&db.cleanMisses, 1)
+	}
+
+	db.lock.RLock()
+	enc := db.dirties[hash]
+	db.lock.RUnlock()
+	if enc != nil {
+		atomic.AddUint64(&db.dirtyHits, 1)
+		return enc
+	}
+
+	// Content unavailable in memory, attempt to retrieve from disk
+	enc = rawdb.ReadTrieNode(db.diskdb, hash)
+	if len(enc) == 0 {
+		return nil
+	}
+	atomic.AddUint64(&db.diskReads, 1)
+	if db.cleans != nil {
+		db.cleans.add(hash, enc)
+	}
+	return enc
+}
+
 // Node retrieves an encoded cached trie node from memory. If it cannot be found
 // cached, the method queries the persistent database for the content.
 func (db *Database) Node(hash common.Hash) ([]byte, error) {
@@ -238,14 +308,142 @@ func (db *Database) Node(hash common.Hash) ([]byte, error) {
 		return nil, errors.New("not found")
 	}
 
-	// Content unavailable in memory, attempt to retrieve from disk
-	enc := rawdb.ReadTrieNode(db.diskdb, hash)
-	if len(enc) != 0 {
+	if enc := db.nodeBlob(hash); enc != nil {
 		return enc, nil
 	}
 	return nil, errors.New("not found")
 }
 
+// committedNode is an encoded node of a trie commit.
+type committedNode struct {
+	hash common.Hash
+	enc  []byte
+}
+
+// commitNodes stores the encoded nodes of the trie commit of root, in commit
+// order.  They are written to disk right away if the dirty node cache is
+// disabled, otherwise they are buffered until the dirty node cache is full or
+// the flush interval elapses.  Either way root is recorded by the next flush.
+func (db *Database) commitNodes(root common.Hash, nodes []committedNode) error {
+	if db.dirtyLimit == 0 {
+		batch := db.diskdb.NewBatch()
+		for _, n := range nodes {
+			rawdb.WriteTrieNode(batch, n.hash, n.enc)
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		db.lock.Lock()
+		db.lastRoot = root
+		db.lock.Unlock()
+		if db.cleans != nil {
+			for _, n := range nodes {
+				db.cleans.add(n.hash, n.enc)
+			}
+		}
+		return nil
+	}
+
+	db.lock.Lock()
+	for _, n := range nodes {
+		if _, ok := db.dirties[n.hash]; ok {
+			continue
+		}
+		db.dirties[n.hash] = n.enc
+		db.dirtyOrder = append(db.dirtyOrder, n.hash)
+		db.dirtiesSize += common.StorageSize(common.HashLength + len(n.enc))
+	}
+	db.lastRoot = root
+	flush := db.dirtiesSize >= db.dirtyLimit ||
+		(db.flushInterval > 0 && time.Since(db.lastFlush) >= db.flushInterval)
+	db.lock.Unlock()
+
+	if flush {
+		return db.Flush()
+	}
+	return nil
+}
+
+// Flush writes all nodes of the dirty node cache to disk, and moves them to the
+// clean node cache.  The nodes are written in commit order, so that a node on
+// disk always has its subtrie on disk too, even if a flush is interrupted
+// between two batches.  The root of the last commit is recorded in the last
+// batch, once all nodes of its trie are on disk, see rawdb.ReadFlushedRoot.
+func (db *Database) Flush() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.lastFlush = time.Now()
+	if len(db.dirties) == 0 {
+		if db.lastRoot == (common.Hash{}) {
+			return nil
+		}
+		return rawdb.WriteFlushedRoot(db.diskdb, db.lastRoot)
+	}
+	start := time.Now()
+	batch := db.diskdb.NewBatch()
+	for _, hash := range db.dirtyOrder {
+		rawdb.WriteTrieNode(batch, hash, db.dirties[hash])
+		if batch.ValueSize() >= massdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := rawdb.WriteFlushedRoot(batch, db.lastRoot); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if db.cleans != nil {
+		for hash, enc := range db.dirties {
+			db.cleans.add(hash, enc)
+		}
+	}
+
+	nodes, size := len(db.dirties), db.dirtiesSize
+	atomic.AddUint64(&db.flushes, 1)
+	atomic.AddUint64(&db.flushedNodes, uint64(nodes))
+	db.flushedSize += size
+	db.flushTime += time.Since(start)
+	db.dirties = make(map[common.Hash][]byte)
+	db.dirtyOrder = nil
+	db.dirtiesSize = 0
+
+	logging.CPrint(logging.DEBUG, "flushed trie nodes", logging.LogFormat{
+		"nodes":   nodes,
+		"size":    size,
+		"elapsed": time.Since(start),
+	})
+	return nil
+}
+
+// Stats returns the stats of node lookups and flushes.
+func (db *Database) Stats() DatabaseStats {
+	stats := DatabaseStats{
+		CleanHits:    atomic.LoadUint64(&db.cleanHits),
+		CleanMisses:  atomic.LoadUint64(&db.cleanMisses),
+		DirtyHits:    atomic.LoadUint64(&db.dirtyHits),
+		DiskReads:    atomic.LoadUint64(&db.diskReads),
+		Flushes:      atomic.LoadUint64(&db.flushes),
+		FlushedNodes: atomic.LoadUint64(&db.flushedNodes),
+	}
+	if db.cleans != nil {
+		var size int
+		stats.CleanNodes, size = db.cleans.stats()
+		stats.CleanSize = common.StorageSize(size)
+	}
+	db.lock.RLock()
+	stats.DirtyNodes = len(db.dirties)
+	stats.DirtySize = db.dirtiesSize
+	stats.FlushedSize = db.flushedSize
+	stats.FlushTime = db.flushTime
+	db.lock.RUnlock()
+	return stats
+}
+
 // // insert inserts a collapsed trie node into the memory database.
 // // The blob size must be specified to allow proper size tracking.
 // // All nodes inserted by this function will be reference tracked
@@ -5,6 +5,7 @@ import (
 
 	"github.com/wangxinyu2018/mass-core/trie/common"
 	"github.com/wangxinyu2018/mass-core/trie/massdb/memorydb"
+	"github.com/wangxinyu2018/mass-core/trie/rawdb"
 )
 
 // Tests that the trie database returns a missing trie node error if attempting
@@ -15,3 +16,185 @@ func TestDatabaseMetarootFetch(t *testing.T) {
 		t.Fatalf("metaroot retrieval succeeded")
 	}
 }
+
+// Tests that committed nodes are buffered in the dirty node cache until it is
+// flushed, and are readable meanwhile.
+func TestDatabaseDirtyCache(t *testing.T) {
+	diskdb := memorydb.New()
+	db := NewDatabaseWithConfig(diskdb, &Config{Cache: 1, DirtyCache: 1})
+
+	trie, _ := New(common.Hash{}, db)
+	for i := 0; i < 100; i++ {
+		trie.Update(randBytes(32), randBytes(20))
+	}
+	root, err := trie.Commit()
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if diskdb.Len() != 0 {
+		t.Fatalf("%d nodes written to disk before flush", diskdb.Len())
+	}
+	stats := db.Stats()
+	if stats.DirtyNodes == 0 || stats.Flushes != 0 {
+		t.Fatalf("unexpected stats before flush %+v", stats)
+	}
+	if _, err := New(root, db); err != nil {
+		t.Fatalf("open dirty root: %v", err)
+	}
+	if db.Stats().DirtyHits == 0 {
+		t.Fatalf("dirty node lookup not counted")
+	}
+
+	if err := db.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	stats = db.Stats()
+	// the flushed root is recorded along with the nodes
+	nodes := diskdb.Len() - 1
+	if nodes != stats.CleanNodes || stats.DirtyNodes != 0 || stats.Flushes != 1 || int(stats.FlushedNodes) != nodes {
+		t.Fatalf("unexpected stats after flush %+v, %d nodes on disk", stats, nodes)
+	}
+	if flushed, ok := rawdb.ReadFlushedRoot(diskdb); !ok || flushed != root {
+		t.Fatalf("flushed root %x recorded %v, want %x", flushed, ok, root)
+	}
+	// flushed nodes are served by the clean node cache
+	if _, err := New(root, NewDatabase(diskdb)); err != nil {
+		t.Fatalf("open flushed root: %v", err)
+	}
+	if _, err := New(root, db); err != nil {
+		t.Fatalf("open cached root: %v", err)
+	}
+	if db.Stats().CleanHits == 0 {
+		t.Fatalf("clean node lookup not counted")
+	}
+}
+
+// Tests that the root of the last commit is recorded by a flush only, even if
+// the nodes are written through.
+func TestDatabaseFlushedRoot(t *testing.T) {
+	diskdb := memorydb.New()
+	db := NewDatabase(diskdb)
+
+	trie, _ := New(common.Hash{}, db)
+	var root common.Hash
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			trie.Update(randBytes(32), randBytes(20))
+		}
+		var err error
+		if root, err = trie.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		if _, ok := rawdb.ReadFlushedRoot(diskdb); ok {
+			t.Fatalf("flushed root recorded before flush")
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if flushed, ok := rawdb.ReadFlushedRoot(diskdb); !ok || flushed != root {
+		t.Fatalf("flushed root %x recorded %v, want %x", flushed, ok, root)
+	}
+}
+
+// Tests that the dirty node cache is flushed once it exceeds its limit.
+func TestDatabaseFlushOnSize(t *testing.T) {
+	diskdb := memorydb.New()
+	db := NewDatabaseWithConfig(diskdb, &Config{DirtyCache: 1})
+
+	trie, _ := New(common.Hash{}, db)
+	for round := 0; db.Stats().Flushes == 0; round++ {
+		if round == 100 {
+			t.Fatalf("dirty node cache not flushed, %+v", db.Stats())
+		}
+		for i := 0; i < 1000; i++ {
+			trie.Update(randBytes(32), randBytes(100))
+		}
+		if _, err := trie.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+	}
+	if diskdb.Len() == 0 || db.Stats().DirtyNodes != 0 {
+		t.Fatalf("unexpected stats after flush %+v, %d nodes on disk", db.Stats(), diskdb.Len())
+	}
+}
+
+// Tests that dirty nodes are kept in commit order, so that a flush writes the
+// children of a node before the node.
+func TestDatabaseFlushOrder(t *testing.T) {
+	db := NewDatabaseWithConfig(memorydb.New(), &Config{DirtyCache: 64})
+
+	trie, _ := New(common.Hash{}, db)
+	keys := make([][]byte, 0, 500)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 500; i++ {
+			if round == 0 {
+				keys = append(keys, randBytes(32))
+			}
+			if round == 0 || i%3 == 0 {
+				trie.Update(keys[i], randBytes(20))
+			}
+		}
+		if _, err := trie.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+	}
+
+	var walk func(n node, onChild func(common.Hash))
+	walk = func(n node, onChild func(common.Hash)) {
+		switch n := n.(type) {
+		case *shortNode:
+			walk(n.Val, onChild)
+		case *fullNode:
+			for i := 0; i < 16; i++ {
+				walk(n.Children[i], onChild)
+			}
+		case hashNode:
+			onChild(common.BytesToHash(n))
+		}
+	}
+	seen := make(map[common.Hash]bool)
+	for _, hash := range db.dirtyOrder {
+		n, err := decodeNode(hash[:], db.dirties[hash])
+		if err != nil {
+			t.Fatalf("decode node %x: %v", hash, err)
+		}
+		walk(n, func(child common.Hash) {
+			if !seen[child] {
+				t.Fatalf("node %x ordered before its child %x", hash, child)
+			}
+		})
+		seen[hash] = true
+	}
+	if len(db.dirtyOrder) != len(db.dirties) {
+		t.Fatalf("%d nodes ordered, %d dirty", len(db.dirtyOrder), len(db.dirties))
+	}
+}
+
+func TestCleanCacheEviction(t *testing.T) {
+	entrySize := common.HashLength + 10
+	c := newCleanCache(3 * entrySize)
+	hashes := make([]common.Hash, 4)
+	for i := range hashes {
+		hashes[i] = common.BytesToHash([]byte{byte(i + 1)})
+	}
+	c.add(hashes[0], make([]byte, 10))
+	c.add(hashes[1], make([]byte, 10))
+	c.add(hashes[2], make([]byte, 10))
+	// use the first, making the second the least recently used
+	if _, ok := c.get(hashes[0]); !ok {
+		t.Fatalf("cached node missing")
+	}
+	c.add(hashes[3], make([]byte, 10))
+	if _, ok := c.get(hashes[1]); ok {
+		t.Fatalf("least recently used node not evicted")
+	}
+	for _, i := range []int{0, 2, 3} {
+		if _, ok := c.get(hashes[i]); !ok {
+			t.Fatalf("node %d evicted", i)
+		}
+	}
+	if n, size := c.stats(); n != 3 || size != 3*entrySize {
+		t.Fatalf("unexpected cache stats %d nodes, %d bytes", n, size)
+	}
+}
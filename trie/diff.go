@@ -0,0 +1,138 @@
+package trie
+
+import (
+	"bytes"
+
+	"github.com/wangxinyu2018/mass-core/trie/common"
+)
+
+// DiffKind tells how a key differs between two tries.
+type DiffKind uint8
+
+const (
+	DiffAdded   DiffKind = iota // key only in the second trie
+	DiffRemoved                 // key only in the first trie
+	DiffChanged                 // key in both tries with different values
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	default:
+		return "unknown"
+	}
+}
+
+// DiffIterator is a key-value iterator over the differences of two tries, in
+// the order of NodeIterator, which is key order except that a key comes after
+// the longer keys it is a prefix of.  The node iterators of both tries are
+// walked side by side, and subtries with the same hash at the same path are
+// skipped, so only the nodes on the paths to the differing keys are resolved.
+type DiffIterator struct {
+	a, b     NodeIterator
+	aOk, bOk bool
+	started  bool
+
+	Kind DiffKind
+	Key  []byte // Key that differs
+	From []byte // Value of Key in the first trie, nil if added
+	To   []byte // Value of Key in the second trie, nil if removed
+	Err  error
+}
+
+// NewDiffIterator creates an iterator over the keys added, removed and changed
+// from the trie of a to the trie of b.  Both node iterators must start at the
+// same key.
+func NewDiffIterator(a, b NodeIterator) *DiffIterator {
+	return &DiffIterator{a: a, b: b}
+}
+
+// Next moves the iterator forward to the next differing key.
+func (it *DiffIterator) Next() bool {
+	if !it.started {
+		it.started = true
+		it.aOk, it.bOk = it.a.Next(true), it.b.Next(true)
+	}
+	for it.aOk || it.bOk {
+		var cmp int
+		switch {
+		case !it.aOk:
+			cmp = 1
+		case !it.bOk:
+			cmp = -1
+		default:
+			cmp = bytes.Compare(it.a.Path(), it.b.Path())
+		}
+
+		switch {
+		case cmp < 0:
+			// node only in the first trie
+			found := it.a.Leaf()
+			if found {
+				it.set(DiffRemoved, it.a.LeafKey(), it.a.LeafBlob(), nil)
+			}
+			it.aOk = it.a.Next(true)
+			if found {
+				return true
+			}
+		case cmp > 0:
+			// node only in the second trie
+			found := it.b.Leaf()
+			if found {
+				it.set(DiffAdded, it.b.LeafKey(), nil, it.b.LeafBlob())
+			}
+			it.bOk = it.b.Next(true)
+			if found {
+				return true
+			}
+		case it.a.Leaf():
+			// same key in both tries, leaf paths end with the terminator so
+			// the other node is a leaf too
+			found := !bytes.Equal(it.a.LeafBlob(), it.b.LeafBlob())
+			if found {
+				it.set(DiffChanged, it.a.LeafKey(), it.a.LeafBlob(), it.b.LeafBlob())
+			}
+			it.aOk, it.bOk = it.a.Next(true), it.b.Next(true)
+			if found {
+				return true
+			}
+		default:
+			// same subtrie in both tries unless the node hashes differ, nodes
+			// embedded in their parents have no hash and are descended
+			hash := it.a.Hash()
+			descend := hash == (common.Hash{}) || hash != it.b.Hash()
+			it.aOk, it.bOk = it.a.Next(descend), it.b.Next(descend)
+		}
+	}
+	it.Key, it.From, it.To = nil, nil, nil
+	if it.Err = it.a.Error(); it.Err == nil {
+		it.Err = it.b.Error()
+	}
+	return false
+}
+
+func (it *DiffIterator) set(kind DiffKind, key, from, to []byte) {
+	it.Kind = kind
+	it.Key = common.CopyBytes(key)
+	it.From = common.CopyBytes(from)
+	it.To = common.CopyBytes(to)
+}
+
+// Diff returns an iterator over the keys added, removed and changed from the
+// trie of root a to the trie of root b, both stored in db.
+func Diff(a, b common.Hash, db *Database) (*DiffIterator, error) {
+	ta, err := New(a, db)
+	if err != nil {
+		return nil, err
+	}
+	tb, err := New(b, db)
+	if err != nil {
+		return nil, err
+	}
+	return NewDiffIterator(ta.NodeIterator(nil), tb.NodeIterator(nil)), nil
+}
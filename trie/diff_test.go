@@ -0,0 +1,137 @@
+package trie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb/memorydb"
+)
+
+func TestDiffIterator(t *testing.T) {
+	diskdb := memorydb.New()
+	triedb := NewDatabase(diskdb)
+
+	trie, _ := New(common.Hash{}, triedb)
+	from := make(map[string][]byte)
+	for i := 0; i < 500; i++ {
+		k, v := randBytes(32), randBytes(20)
+		trie.Update(k, v)
+		from[string(k)] = v
+	}
+	// short keys and values give nodes embedded in their parents
+	for _, kv := range []struct{ k, v string }{{"do", "verb"}, {"dog", "puppy"}, {"doge", "coin"}} {
+		trie.Update([]byte(kv.k), []byte(kv.v))
+		from[kv.k] = []byte(kv.v)
+	}
+	fromRoot, _ := trie.Commit()
+
+	to := make(map[string][]byte, len(from))
+	for k, v := range from {
+		to[k] = v
+	}
+	want := make(map[string]DiffKind)
+	n := 0
+	for k := range from {
+		switch n % 50 {
+		case 0:
+			trie.Delete([]byte(k))
+			delete(to, k)
+			want[k] = DiffRemoved
+		case 1:
+			v := randBytes(20)
+			trie.Update([]byte(k), v)
+			to[k] = v
+			want[k] = DiffChanged
+		}
+		n++
+	}
+	for i := 0; i < 10; i++ {
+		k, v := randBytes(32), randBytes(20)
+		trie.Update(k, v)
+		to[string(k)] = v
+		want[string(k)] = DiffAdded
+	}
+	trie.Update([]byte("dog"), []byte("hound"))
+	to["dog"] = []byte("hound")
+	want["dog"] = DiffChanged
+	toRoot, _ := trie.Commit()
+
+	it, err := Diff(fromRoot, toRoot, NewDatabase(diskdb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prev []byte
+	found := 0
+	for it.Next() {
+		// hex paths end with the terminator, which sorts a key after the
+		// longer keys it is a prefix of, as NodeIterator visits them
+		if prev != nil && bytes.Compare(keybytesToHex(prev), keybytesToHex(it.Key)) >= 0 {
+			t.Fatalf("keys out of order: %x after %x", it.Key, prev)
+		}
+		prev = it.Key
+		k := string(it.Key)
+		kind, ok := want[k]
+		if !ok || kind != it.Kind {
+			t.Fatalf("unexpected %v key %x, want %v %v", it.Kind, it.Key, kind, ok)
+		}
+		if !bytes.Equal(it.From, from[k]) || !bytes.Equal(it.To, to[k]) {
+			t.Fatalf("%v key %x: have %x -> %x, want %x -> %x", it.Kind, it.Key, it.From, it.To, from[k], to[k])
+		}
+		found++
+	}
+	if it.Err != nil {
+		t.Fatal(it.Err)
+	}
+	if found != len(want) {
+		t.Fatalf("found %d differences, want %d", found, len(want))
+	}
+
+	// the reverse diff swaps added and removed keys
+	it, _ = Diff(toRoot, fromRoot, triedb)
+	for it.Next() {
+		switch kind := want[string(it.Key)]; {
+		case kind == DiffAdded && it.Kind != DiffRemoved,
+			kind == DiffRemoved && it.Kind != DiffAdded,
+			kind == DiffChanged && it.Kind != DiffChanged:
+			t.Fatalf("reverse diff: key %x %v, forward %v", it.Key, it.Kind, kind)
+		}
+	}
+	if it.Err != nil {
+		t.Fatal(it.Err)
+	}
+}
+
+func TestDiffIteratorSkipsShared(t *testing.T) {
+	diskdb := memorydb.New()
+	trie, _ := New(common.Hash{}, NewDatabase(diskdb))
+	for i := 0; i < 2000; i++ {
+		trie.Update(randBytes(32), randBytes(20))
+	}
+	fromRoot, _ := trie.Commit()
+	key := randBytes(32)
+	trie.Update(key, []byte("value"))
+	toRoot, _ := trie.Commit()
+
+	triedb := NewDatabase(diskdb)
+	it, _ := Diff(fromRoot, toRoot, triedb)
+	found := 0
+	for it.Next() {
+		if it.Kind != DiffAdded || !bytes.Equal(it.Key, key) {
+			t.Fatalf("unexpected %v key %x", it.Kind, it.Key)
+		}
+		found++
+	}
+	if it.Err != nil || found != 1 {
+		t.Fatalf("found %d differences, err %v", found, it.Err)
+	}
+	if reads := triedb.Stats().DiskReads; reads > 100 {
+		t.Fatalf("%d nodes read, shared subtries are not skipped", reads)
+	}
+
+	// an unchanged trie has no differences
+	it, _ = Diff(toRoot, toRoot, triedb)
+	if it.Next() || it.Err != nil {
+		t.Fatalf("differences of the same trie: %x %v", it.Key, it.Err)
+	}
+}
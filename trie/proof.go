@@ -0,0 +1,585 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb/memorydb"
+)
+
+var (
+	ErrProofNodeMissing = errors.New("proof node missing")
+	ErrInvalidProof     = errors.New("invalid proof")
+)
+
+// Prove constructs a Merkle proof for key. The result contains the encoded
+// nodes on the path to the value at key, starting with the root. The value
+// itself is included in the last node and can be retrieved by verifying the
+// proof.
+//
+// If the trie does not contain a value for key, the returned proof contains
+// all nodes of the longest existing prefix of the key, ending with the node
+// that proves the absence of the key. The proof of an empty trie is empty.
+func (t *Trie) Prove(key []byte) ([][]byte, error) {
+	// Collect all nodes on the path to key.
+	key = keybytesToHex(key)
+	var nodes []node
+	tn := t.root
+	for len(key) > 0 && tn != nil {
+		switch n := tn.(type) {
+		case *shortNode:
+			if len(key) < len(n.Key) || !bytes.Equal(n.Key, key[:len(n.Key)]) {
+				// The trie doesn't contain the key.
+				tn = nil
+			} else {
+				tn = n.Val
+				key = key[len(n.Key):]
+			}
+			nodes = append(nodes, n)
+		case *fullNode:
+			tn = n.Children[key[0]]
+			key = key[1:]
+			nodes = append(nodes, n)
+		case hashNode:
+			var err error
+			tn, err = t.resolveHash(n, nil)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", tn, tn))
+		}
+	}
+
+	hasher := newHasher(false)
+	defer returnHasherToPool(hasher)
+	proof := make([][]byte, 0, len(nodes))
+	for i, n := range nodes {
+		// Nodes smaller than 32 bytes are embedded in their parent, except
+		// the root which is always hashed.
+		collapsed, hashed := hasher.proofHash(n)
+		if _, ok := hashed.(hashNode); ok || i == 0 {
+			enc, err := encodeNode(collapsed)
+			if err != nil {
+				return nil, err
+			}
+			proof = append(proof, enc)
+		}
+	}
+	return proof, nil
+}
+
+// VerifyProof checks a Merkle proof made by Prove against the trie root hash
+// rootHash. It returns the value for key, or nil if the proof shows that the
+// trie does not contain key. An error is returned if the proof is incomplete
+// or doesn't match rootHash.
+func VerifyProof(rootHash common.Hash, key []byte, proof [][]byte) (value []byte, err error) {
+	if rootHash == (common.Hash{}) || rootHash == emptyRoot {
+		return nil, nil
+	}
+
+	hasher := newHasher(false)
+	defer returnHasherToPool(hasher)
+	proofNodes := make(map[common.Hash][]byte, len(proof))
+	for _, enc := range proof {
+		proofNodes[common.BytesToHash(hasher.hashData(enc))] = enc
+	}
+
+	key = keybytesToHex(key)
+	wantHash := rootHash
+	for i := 0; ; i++ {
+		enc, ok := proofNodes[wantHash]
+		if !ok {
+			return nil, fmt.Errorf("%w: node %d (hash %x)", ErrProofNodeMissing, i, wantHash)
+		}
+		n, err := decodeNode(wantHash[:], enc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: node %d: %v", ErrInvalidProof, i, err)
+		}
+		rest, child, err := getProofChild(n, key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: node %d: %v", ErrInvalidProof, i, err)
+		}
+		switch child := child.(type) {
+		case nil:
+			// The trie doesn't contain the key.
+			return nil, nil
+		case hashNode:
+			key = rest
+			wantHash = common.BytesToHash(child)
+		case valueNode:
+			return child, nil
+		}
+	}
+}
+
+// getProofChild follows key through n and the nodes embedded in it, returning
+// the rest of key and the first value or hash node reached, or nil if key is
+// not under n.
+func getProofChild(n node, key []byte) ([]byte, node, error) {
+	for {
+		switch nn := n.(type) {
+		case *shortNode:
+			if len(key) < len(nn.Key) || !bytes.Equal(nn.Key, key[:len(nn.Key)]) {
+				return nil, nil, nil
+			}
+			n = nn.Val
+			key = key[len(nn.Key):]
+		case *fullNode:
+			if len(key) == 0 {
+				return nil, nil, errors.New("key ends at full node")
+			}
+			n = nn.Children[key[0]]
+			key = key[1:]
+		case hashNode:
+			return key, nn, nil
+		case valueNode:
+			if len(key) != 0 {
+				return nil, nil, errors.New("value before end of key")
+			}
+			return nil, nn, nil
+		case nil:
+			return nil, nil, nil
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+		}
+	}
+}
+
+// VerifyRangeProof checks that keys and values are all the leaves of the trie
+// of rootHash from firstKey to lastKey, in increasing key order.  proof holds
+// the proofs of firstKey and lastKey made by Prove, either of them may prove
+// the absence of the key, so a range may start or end between leaves.  It
+// returns whether the trie has more leaves after the range.
+//
+// A nil proof claims the range is the whole trie, the leaves are hashed with a
+// StackTrie then.  A range without leaves is proven by the proof of firstKey
+// alone, and it is valid only if the trie has no leaves after firstKey.  An
+// empty firstKey starts the range at the first leaf of the trie, the proof of
+// lastKey alone proves that no leaf is before the range.
+//
+// Keys may be of different lengths, as long as no key of the trie, nor edge
+// key other than an empty firstKey, is a prefix of another.
+func VerifyRangeProof(rootHash common.Hash, firstKey, lastKey []byte, keys, values [][]byte, proof [][]byte) (bool, error) {
+	if len(keys) != len(values) {
+		return false, fmt.Errorf("%w: %d keys and %d values", ErrInvalidProof, len(keys), len(values))
+	}
+	for i := 0; i < len(keys)-1; i++ {
+		if bytes.Compare(keys[i], keys[i+1]) >= 0 {
+			return false, fmt.Errorf("%w: keys not in increasing order", ErrInvalidProof)
+		}
+	}
+	for _, value := range values {
+		if len(value) == 0 {
+			return false, fmt.Errorf("%w: empty value in range", ErrInvalidProof)
+		}
+	}
+
+	// the whole trie
+	if proof == nil {
+		if len(keys) == 0 {
+			if rootHash != (common.Hash{}) && rootHash != emptyRoot {
+				return false, fmt.Errorf("%w: no leaves for root %x", ErrInvalidProof, rootHash)
+			}
+			return false, nil
+		}
+		st := NewStackTrie(nil)
+		for i, key := range keys {
+			if err := st.TryUpdate(key, values[i]); err != nil {
+				return false, err
+			}
+		}
+		if have := st.Hash(); have != rootHash {
+			return false, fmt.Errorf("%w: root %x, want %x", ErrInvalidProof, have, rootHash)
+		}
+		return false, nil
+	}
+
+	hasher := newHasher(false)
+	proofNodes := make(map[common.Hash][]byte, len(proof))
+	for _, enc := range proof {
+		proofNodes[common.BytesToHash(hasher.hashData(enc))] = enc
+	}
+	returnHasherToPool(hasher)
+
+	// no leaves from firstKey on
+	if len(keys) == 0 {
+		if len(firstKey) == 0 {
+			if rootHash != (common.Hash{}) && rootHash != emptyRoot {
+				return false, fmt.Errorf("%w: no leaves for root %x", ErrInvalidProof, rootHash)
+			}
+			return false, nil
+		}
+		root, val, err := proofToPath(rootHash, nil, firstKey, proofNodes, true)
+		if err != nil {
+			return false, err
+		}
+		if val != nil || hasRightElement(root, firstKey) {
+			return false, fmt.Errorf("%w: leaves after %x left out", ErrInvalidProof, firstKey)
+		}
+		return false, nil
+	}
+
+	// a single leaf proven by one proof
+	if len(keys) == 1 && bytes.Equal(firstKey, lastKey) {
+		root, val, err := proofToPath(rootHash, nil, firstKey, proofNodes, false)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(firstKey, keys[0]) || !bytes.Equal(val, values[0]) {
+			return false, fmt.Errorf("%w: leaf %x not proven", ErrInvalidProof, keys[0])
+		}
+		return hasRightElement(root, firstKey), nil
+	}
+
+	// the range from the first leaf, the nodes on the left of the path of
+	// lastKey are dropped and refilled
+	if len(firstKey) == 0 {
+		if bytes.Compare(keys[len(keys)-1], lastKey) > 0 {
+			return false, fmt.Errorf("%w: keys beyond the edge keys", ErrInvalidProof)
+		}
+		root, _, err := proofToPath(rootHash, nil, lastKey, proofNodes, true)
+		if err != nil {
+			return false, err
+		}
+		empty, err := unsetLeft(root, lastKey)
+		if err != nil {
+			return false, err
+		}
+		return refillRange(rootHash, root, empty, keys, values)
+	}
+
+	if bytes.Compare(firstKey, lastKey) >= 0 {
+		return false, fmt.Errorf("%w: first key %x not below last key %x", ErrInvalidProof, firstKey, lastKey)
+	}
+	if bytes.HasPrefix(lastKey, firstKey) {
+		return false, fmt.Errorf("%w: first key %x prefixes last key", ErrInvalidProof, firstKey)
+	}
+	if bytes.Compare(keys[0], firstKey) < 0 || bytes.Compare(keys[len(keys)-1], lastKey) > 0 {
+		return false, fmt.Errorf("%w: keys beyond the edge keys", ErrInvalidProof)
+	}
+	// Resolve the paths of both edge keys into one trie, drop the nodes
+	// between them and refill them with the leaves, which gives the root
+	// of the original trie only if no leaf is left out or made up.
+	root, _, err := proofToPath(rootHash, nil, firstKey, proofNodes, true)
+	if err != nil {
+		return false, err
+	}
+	root, _, err = proofToPath(rootHash, root, lastKey, proofNodes, true)
+	if err != nil {
+		return false, err
+	}
+	empty, err := unsetInternal(root, firstKey, lastKey)
+	if err != nil {
+		return false, err
+	}
+	return refillRange(rootHash, root, empty, keys, values)
+}
+
+// refillRange inserts the leaves of a range into the trie root whose nodes in
+// the range are dropped, the whole trie if empty is set, and checks the hash of
+// the result against rootHash.  It returns whether the trie has more leaves
+// after the range.
+func refillRange(rootHash common.Hash, root node, empty bool, keys, values [][]byte) (bool, error) {
+	tr := &Trie{root: root, db: NewDatabase(memorydb.New())}
+	if empty {
+		tr.root = nil
+	}
+	for i, key := range keys {
+		if err := tr.TryUpdate(key, values[i]); err != nil {
+			return false, err
+		}
+	}
+	if have := tr.Hash(); have != rootHash {
+		return false, fmt.Errorf("%w: root %x, want %x", ErrInvalidProof, have, rootHash)
+	}
+	return hasRightElement(tr.root, keys[len(keys)-1]), nil
+}
+
+// proofToPath resolves the nodes on the path to key from proofNodes, linking
+// them to root, or the node of rootHash if root is nil.  Nodes off the path
+// are left as hash nodes.  It returns the root and the value at key, nil if
+// the key is proven absent and allowNonExistent is set.
+func proofToPath(rootHash common.Hash, root node, key []byte, proofNodes map[common.Hash][]byte, allowNonExistent bool) (node, []byte, error) {
+	resolve := func(hash common.Hash) (node, error) {
+		enc, ok := proofNodes[hash]
+		if !ok {
+			return nil, fmt.Errorf("%w: hash %x", ErrProofNodeMissing, hash)
+		}
+		n, err := decodeNode(hash[:], enc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+		}
+		return n, nil
+	}
+	if root == nil {
+		n, err := resolve(rootHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		root = n
+	}
+
+	var (
+		err     error
+		child   node
+		parent  = root
+		keyrest []byte
+		value   []byte
+	)
+	key = keybytesToHex(key)
+	for {
+		keyrest, child = getChild(parent, key)
+		switch c := child.(type) {
+		case nil:
+			if allowNonExistent {
+				return root, nil, nil
+			}
+			return nil, nil, fmt.Errorf("%w: key not in trie", ErrInvalidProof)
+		case *shortNode, *fullNode:
+			// resolved already
+			key, parent = keyrest, child
+			continue
+		case hashNode:
+			child, err = resolve(common.BytesToHash(c))
+			if err != nil {
+				return nil, nil, err
+			}
+		case valueNode:
+			value = c
+		}
+		switch p := parent.(type) {
+		case *shortNode:
+			p.Val = child
+		case *fullNode:
+			p.Children[key[0]] = child
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", p, p))
+		}
+		if len(value) > 0 {
+			return root, value, nil
+		}
+		key, parent = keyrest, child
+	}
+}
+
+// getChild returns the direct child of n under the hex key, with the rest of
+// the key, or a nil child if key is not under n.
+func getChild(n node, key []byte) ([]byte, node) {
+	switch n := n.(type) {
+	case *shortNode:
+		if len(key) < len(n.Key) || !bytes.Equal(n.Key, key[:len(n.Key)]) {
+			return nil, nil
+		}
+		return key[len(n.Key):], n.Val
+	case *fullNode:
+		return key[1:], n.Children[key[0]]
+	case hashNode:
+		return key, n
+	case valueNode:
+		return nil, n
+	case nil:
+		return key, nil
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// unsetInternal drops the nodes between the paths of left and right from the
+// trie n built by proofToPath, marking the nodes on the paths dirty as their
+// content changes.  It returns true if the whole trie is to be dropped.  left
+// must be lower than right.
+func unsetInternal(n node, left, right []byte) (bool, error) {
+	left, right = keybytesToHex(left), keybytesToHex(right)
+
+	// Step down to the node the paths fork at, either a full node or a short
+	// node whose key differs from one of the paths.
+	var (
+		pos    = 0
+		parent node
+
+		// 0 if the path matches the short node key, -1 or 1 if it is lower or
+		// higher
+		shortForkLeft, shortForkRight int
+	)
+findFork:
+	for {
+		switch rn := n.(type) {
+		case *shortNode:
+			rn.flags = nodeFlag{dirty: true}
+			if len(left)-pos < len(rn.Key) {
+				shortForkLeft = bytes.Compare(left[pos:], rn.Key)
+			} else {
+				shortForkLeft = bytes.Compare(left[pos:pos+len(rn.Key)], rn.Key)
+			}
+			if len(right)-pos < len(rn.Key) {
+				shortForkRight = bytes.Compare(right[pos:], rn.Key)
+			} else {
+				shortForkRight = bytes.Compare(right[pos:pos+len(rn.Key)], rn.Key)
+			}
+			if shortForkLeft != 0 || shortForkRight != 0 {
+				break findFork
+			}
+			parent = n
+			n, pos = rn.Val, pos+len(rn.Key)
+		case *fullNode:
+			rn.flags = nodeFlag{dirty: true}
+			leftnode, rightnode := rn.Children[left[pos]], rn.Children[right[pos]]
+			if leftnode == nil || rightnode == nil || leftnode != rightnode {
+				break findFork
+			}
+			parent = n
+			n, pos = rn.Children[left[pos]], pos+1
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+		}
+	}
+
+	switch rn := n.(type) {
+	case *shortNode:
+		// both paths below or above the short node
+		if shortForkLeft == shortForkRight {
+			return false, fmt.Errorf("%w: empty range", ErrInvalidProof)
+		}
+		// the short node is inside the range
+		if shortForkLeft != 0 && shortForkRight != 0 {
+			if parent == nil {
+				return true, nil
+			}
+			parent.(*fullNode).Children[left[pos-1]] = nil
+			return false, nil
+		}
+		// left path ends at the short node, right path is above it
+		if shortForkRight != 0 {
+			if _, ok := rn.Val.(valueNode); ok {
+				if parent == nil {
+					return true, nil
+				}
+				parent.(*fullNode).Children[left[pos-1]] = nil
+				return false, nil
+			}
+			return false, unset(rn, rn.Val, left[pos:], len(rn.Key), false)
+		}
+		// right path ends at the short node, left path is below it
+		if _, ok := rn.Val.(valueNode); ok {
+			if parent == nil {
+				return true, nil
+			}
+			parent.(*fullNode).Children[right[pos-1]] = nil
+			return false, nil
+		}
+		return false, unset(rn, rn.Val, right[pos:], len(rn.Key), true)
+	case *fullNode:
+		for i := left[pos] + 1; i < right[pos]; i++ {
+			rn.Children[i] = nil
+		}
+		if err := unset(rn, rn.Children[left[pos]], left[pos:], 1, false); err != nil {
+			return false, err
+		}
+		if err := unset(rn, rn.Children[right[pos]], right[pos:], 1, true); err != nil {
+			return false, err
+		}
+		return false, nil
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// unsetLeft drops the nodes on the left of the path of right from the trie n
+// built by proofToPath, and the leaf at right, marking the nodes on the path
+// dirty.  It returns true if the whole trie is to be dropped.
+func unsetLeft(n node, right []byte) (bool, error) {
+	key := keybytesToHex(right)
+	switch rn := n.(type) {
+	case *shortNode:
+		if len(key) < len(rn.Key) || !bytes.Equal(rn.Key, key[:len(rn.Key)]) {
+			if bytes.Compare(rn.Key, key) > 0 {
+				return false, fmt.Errorf("%w: empty range", ErrInvalidProof)
+			}
+			return true, nil
+		}
+		if _, ok := rn.Val.(valueNode); ok {
+			return true, nil
+		}
+		rn.flags = nodeFlag{dirty: true}
+		return false, unset(rn, rn.Val, key, len(rn.Key), true)
+	case *fullNode:
+		for i := 0; i < int(key[0]); i++ {
+			rn.Children[i] = nil
+		}
+		rn.flags = nodeFlag{dirty: true}
+		return false, unset(rn, rn.Children[key[0]], key, 1, true)
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// unset drops the nodes on the right of the path key from child, or those on
+// the left if removeLeft is set.  pos is the offset of child in key.  A path
+// ending in a short node that is inside the range drops the short node.
+func unset(parent node, child node, key []byte, pos int, removeLeft bool) error {
+	switch c := child.(type) {
+	case *fullNode:
+		if removeLeft {
+			for i := 0; i < int(key[pos]); i++ {
+				c.Children[i] = nil
+			}
+		} else {
+			for i := key[pos] + 1; i < 16; i++ {
+				c.Children[i] = nil
+			}
+		}
+		c.flags = nodeFlag{dirty: true}
+		return unset(c, c.Children[key[pos]], key, pos+1, removeLeft)
+	case *shortNode:
+		if len(key[pos:]) < len(c.Key) || !bytes.Equal(c.Key, key[pos:pos+len(c.Key)]) {
+			// The path is absent from the trie, the short node is dropped if
+			// it is inside the range and kept with its hash otherwise.
+			cmp := bytes.Compare(c.Key, key[pos:])
+			if (removeLeft && cmp < 0) || (!removeLeft && cmp > 0) {
+				parent.(*fullNode).Children[key[pos-1]] = nil
+			}
+			return nil
+		}
+		if _, ok := c.Val.(valueNode); ok {
+			parent.(*fullNode).Children[key[pos-1]] = nil
+			return nil
+		}
+		c.flags = nodeFlag{dirty: true}
+		return unset(c, c.Val, key, pos+len(c.Key), removeLeft)
+	case nil:
+		// an absent child of the full node the paths fork at
+		return nil
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", child, child))
+	}
+}
+
+// hasRightElement reports whether the trie n has leaves on the right of the
+// path of key, which must be resolved.
+func hasRightElement(n node, key []byte) bool {
+	pos, key := 0, keybytesToHex(key)
+	for n != nil {
+		switch rn := n.(type) {
+		case *fullNode:
+			for i := key[pos] + 1; i < 16; i++ {
+				if rn.Children[i] != nil {
+					return true
+				}
+			}
+			n, pos = rn.Children[key[pos]], pos+1
+		case *shortNode:
+			if len(key)-pos < len(rn.Key) || !bytes.Equal(rn.Key, key[pos:pos+len(rn.Key)]) {
+				return bytes.Compare(rn.Key, key[pos:]) > 0
+			}
+			n, pos = rn.Val, pos+len(rn.Key)
+		case valueNode:
+			return false
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+		}
+	}
+	return false
+}
@@ -0,0 +1,434 @@
+package trie
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	mrand "math/rand"
+	"sort"
+	"testing"
+
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb/memorydb"
+)
+
+func randBytes(n int) []byte {
+	r := make([]byte, n)
+	rand.Read(r)
+	return r
+}
+
+func randomTrie(n int) (*Trie, map[string][]byte) {
+	trie := newEmpty()
+	vals := make(map[string][]byte)
+	for i := byte(0); i < 100; i++ {
+		// short values end up embedded in their parent nodes
+		k1 := common.LeftPadBytes([]byte{i}, 32)
+		k2 := common.LeftPadBytes([]byte{i + 10}, 32)
+		trie.Update(k1, []byte{i})
+		trie.Update(k2, []byte{i})
+		vals[string(k1)] = []byte{i}
+		vals[string(k2)] = []byte{i}
+	}
+	for i := 0; i < n; i++ {
+		k, v := randBytes(32), randBytes(20)
+		trie.Update(k, v)
+		vals[string(k)] = v
+	}
+	return trie, vals
+}
+
+func TestProof(t *testing.T) {
+	trie, vals := randomTrie(500)
+	root := trie.Hash()
+	for k, v := range vals {
+		proof, err := trie.Prove([]byte(k))
+		if err != nil {
+			t.Fatalf("prove %x: %v", k, err)
+		}
+		val, err := VerifyProof(root, []byte(k), proof)
+		if err != nil {
+			t.Fatalf("verify proof for %x: %v", k, err)
+		}
+		if !bytes.Equal(val, v) {
+			t.Fatalf("verified value mismatch for key %x: have %x, want %x", k, val, v)
+		}
+	}
+}
+
+func TestProofCommitted(t *testing.T) {
+	trie, vals := randomTrie(500)
+	root, err := trie.Commit()
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	// prove from nodes resolved from the database
+	trie, err = New(root, trie.db)
+	if err != nil {
+		t.Fatalf("open trie: %v", err)
+	}
+	for k, v := range vals {
+		proof, err := trie.Prove([]byte(k))
+		if err != nil {
+			t.Fatalf("prove %x: %v", k, err)
+		}
+		val, err := VerifyProof(root, []byte(k), proof)
+		if err != nil {
+			t.Fatalf("verify proof for %x: %v", k, err)
+		}
+		if !bytes.Equal(val, v) {
+			t.Fatalf("verified value mismatch for key %x: have %x, want %x", k, val, v)
+		}
+	}
+}
+
+func TestOneElementProof(t *testing.T) {
+	trie := newEmpty()
+	updateString(trie, "k", "v")
+	proof, err := trie.Prove([]byte("k"))
+	if err != nil {
+		t.Fatalf("prove: %v", err)
+	}
+	if len(proof) != 1 {
+		t.Errorf("proof should have one element")
+	}
+	val, err := VerifyProof(trie.Hash(), []byte("k"), proof)
+	if err != nil {
+		t.Fatalf("verify proof: %v", err)
+	}
+	if !bytes.Equal(val, []byte("v")) {
+		t.Fatalf("verified value mismatch: have %x, want 'v'", val)
+	}
+}
+
+func TestMissingKeyProof(t *testing.T) {
+	trie := newEmpty()
+	updateString(trie, "k", "v")
+
+	for i, key := range []string{"a", "j", "l", "z"} {
+		proof, err := trie.Prove([]byte(key))
+		if err != nil {
+			t.Fatalf("prove %s: %v", key, err)
+		}
+		if len(proof) != 1 {
+			t.Errorf("test %d: proof should have one element", i)
+		}
+		val, err := VerifyProof(trie.Hash(), []byte(key), proof)
+		if err != nil {
+			t.Fatalf("test %d: failed to verify proof: %v\nraw proof: %x", i, err, proof)
+		}
+		if val != nil {
+			t.Fatalf("test %d: verified value mismatch: have %x, want nil", i, val)
+		}
+	}
+}
+
+func TestMissingKeyProofRandom(t *testing.T) {
+	trie, vals := randomTrie(500)
+	root := trie.Hash()
+	for i := 0; i < 100; i++ {
+		key := randBytes(32)
+		if _, ok := vals[string(key)]; ok {
+			continue
+		}
+		proof, err := trie.Prove(key)
+		if err != nil {
+			t.Fatalf("prove %x: %v", key, err)
+		}
+		val, err := VerifyProof(root, key, proof)
+		if err != nil {
+			t.Fatalf("verify proof for missing key %x: %v", key, err)
+		}
+		if val != nil {
+			t.Fatalf("verified value for missing key %x: %x", key, val)
+		}
+	}
+}
+
+func TestEmptyTrieProof(t *testing.T) {
+	trie, _ := New(common.Hash{}, NewDatabase(memorydb.New()))
+	proof, err := trie.Prove([]byte("k"))
+	if err != nil {
+		t.Fatalf("prove: %v", err)
+	}
+	if len(proof) != 0 {
+		t.Errorf("proof of empty trie should be empty")
+	}
+	val, err := VerifyProof(trie.Hash(), []byte("k"), proof)
+	if err != nil || val != nil {
+		t.Fatalf("verify proof of empty trie: value %x, err %v", val, err)
+	}
+}
+
+func TestBadProof(t *testing.T) {
+	trie, vals := randomTrie(800)
+	root := trie.Hash()
+	for k := range vals {
+		proof, err := trie.Prove([]byte(k))
+		if err != nil {
+			t.Fatalf("prove %x: %v", k, err)
+		}
+		if len(proof) == 0 {
+			t.Fatal("zero length proof")
+		}
+
+		// drop a node
+		i := mrand.Intn(len(proof))
+		dropped := append(append([][]byte{}, proof[:i]...), proof[i+1:]...)
+		if _, err := VerifyProof(root, []byte(k), dropped); !errors.Is(err, ErrProofNodeMissing) {
+			t.Fatalf("expected proof to fail for key %x with a dropped node, got %v", k, err)
+		}
+
+		// modify a node
+		tampered := append([][]byte{}, proof...)
+		enc := common.CopyBytes(tampered[i])
+		enc[mrand.Intn(len(enc))] ^= 1
+		tampered[i] = enc
+		if val, err := VerifyProof(root, []byte(k), tampered); err == nil {
+			t.Fatalf("expected proof to fail for key %x with a modified node, got %x", k, val)
+		}
+
+		// wrong root
+		if _, err := VerifyProof(common.BytesToHash(randBytes(32)), []byte(k), proof); !errors.Is(err, ErrProofNodeMissing) {
+			t.Fatalf("expected proof to fail for key %x against another root, got %v", k, err)
+		}
+	}
+}
+
+func sortedEntries(vals map[string][]byte) ([][]byte, [][]byte) {
+	keys := make([][]byte, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, []byte(k))
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	values := make([][]byte, len(keys))
+	for i, k := range keys {
+		values[i] = vals[string(k)]
+	}
+	return keys, values
+}
+
+func proveRange(t *testing.T, trie *Trie, first, last []byte) [][]byte {
+	proof, err := trie.Prove(first)
+	if err != nil {
+		t.Fatalf("prove %x: %v", first, err)
+	}
+	lastProof, err := trie.Prove(last)
+	if err != nil {
+		t.Fatalf("prove %x: %v", last, err)
+	}
+	return append(proof, lastProof...)
+}
+
+func TestRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	root := trie.Hash()
+	keys, values := sortedEntries(vals)
+	for i := 0; i < 100; i++ {
+		start := mrand.Intn(len(keys))
+		end := start + 1 + mrand.Intn(len(keys)-start)
+		proof := proveRange(t, trie, keys[start], keys[end-1])
+		more, err := VerifyRangeProof(root, keys[start], keys[end-1], keys[start:end], values[start:end], proof)
+		if err != nil {
+			t.Fatalf("range [%d, %d): %v", start, end, err)
+		}
+		if more != (end < len(keys)) {
+			t.Fatalf("range [%d, %d): more %v", start, end, more)
+		}
+	}
+}
+
+func TestRangeProofNonExistentEdges(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	root := trie.Hash()
+	keys, values := sortedEntries(vals)
+	for i := 0; i < 100; i++ {
+		start := 1 + mrand.Intn(len(keys)-2)
+		end := start + 1 + mrand.Intn(len(keys)-1-start)
+		// edge keys right next to the leaves of the range
+		first := common.CopyBytes(keys[start])
+		last := common.CopyBytes(keys[end-1])
+		if first[len(first)-1] == 0 || last[len(last)-1] == 0xff {
+			continue
+		}
+		first[len(first)-1]--
+		last[len(last)-1]++
+		if bytes.Equal(first, keys[start-1]) || bytes.Equal(last, keys[end]) {
+			continue
+		}
+		proof := proveRange(t, trie, first, last)
+		if _, err := VerifyRangeProof(root, first, last, keys[start:end], values[start:end], proof); err != nil {
+			t.Fatalf("range [%d, %d): %v", start, end, err)
+		}
+	}
+
+	// the range from the zero key includes the first leaf
+	first := make([]byte, 32)
+	proof := proveRange(t, trie, first, keys[99])
+	if _, err := VerifyRangeProof(root, first, keys[99], keys[:100], values[:100], proof); err != nil {
+		t.Fatalf("range from zero key: %v", err)
+	}
+}
+
+func TestRangeProofFromStart(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	root := trie.Hash()
+	keys, values := sortedEntries(vals)
+	for i := 0; i < 100; i++ {
+		end := 1 + mrand.Intn(len(keys))
+		proof, _ := trie.Prove(keys[end-1])
+		more, err := VerifyRangeProof(root, nil, keys[end-1], keys[:end], values[:end], proof)
+		if err != nil {
+			t.Fatalf("range [0, %d): %v", end, err)
+		}
+		if more != (end < len(keys)) {
+			t.Fatalf("range [0, %d): more %v", end, more)
+		}
+		// leading leaves left out
+		if end > 1 {
+			if _, err := VerifyRangeProof(root, nil, keys[end-1], keys[1:end], values[1:end], proof); err == nil {
+				t.Fatalf("range [0, %d) without first leaf verified", end)
+			}
+		}
+	}
+
+	// the last edge key between leaves
+	last := common.CopyBytes(keys[99])
+	last[len(last)-1]++
+	if !bytes.Equal(last, keys[100]) {
+		proof, _ := trie.Prove(last)
+		if _, err := VerifyRangeProof(root, nil, last, keys[:100], values[:100], proof); err != nil {
+			t.Fatalf("range from start to absent key: %v", err)
+		}
+	}
+
+	// a trie with leaves has some from the start
+	proof, _ := trie.Prove(nil)
+	if _, err := VerifyRangeProof(root, nil, nil, nil, nil, proof); err == nil {
+		t.Fatalf("empty range from start verified")
+	}
+}
+
+func TestRangeProofWholeTrie(t *testing.T) {
+	trie, vals := randomTrie(1000)
+	root := trie.Hash()
+	keys, values := sortedEntries(vals)
+	if _, err := VerifyRangeProof(root, nil, nil, keys, values, nil); err != nil {
+		t.Fatalf("whole trie: %v", err)
+	}
+	if _, err := VerifyRangeProof(root, nil, nil, keys[1:], values[1:], nil); err == nil {
+		t.Fatalf("whole trie without first leaf verified")
+	}
+	if _, err := VerifyRangeProof(emptyRoot, nil, nil, nil, nil, nil); err != nil {
+		t.Fatalf("empty trie: %v", err)
+	}
+}
+
+func TestRangeProofEnd(t *testing.T) {
+	trie, vals := randomTrie(1000)
+	root := trie.Hash()
+	keys, values := sortedEntries(vals)
+
+	// a single leaf
+	last := len(keys) - 1
+	proof, _ := trie.Prove(keys[last])
+	more, err := VerifyRangeProof(root, keys[last], keys[last], keys[last:], values[last:], proof)
+	if err != nil || more {
+		t.Fatalf("last leaf: more %v, err %v", more, err)
+	}
+	proof, _ = trie.Prove(keys[0])
+	more, err = VerifyRangeProof(root, keys[0], keys[0], keys[:1], values[:1], proof)
+	if err != nil || !more {
+		t.Fatalf("first leaf: more %v, err %v", more, err)
+	}
+
+	// no leaves after the last one
+	after := bytes.Repeat([]byte{0xff}, 32)
+	proof, _ = trie.Prove(after)
+	if _, err = VerifyRangeProof(root, after, nil, nil, nil, proof); err != nil {
+		t.Fatalf("empty range at end: %v", err)
+	}
+	proof, _ = trie.Prove(keys[last-1])
+	if _, err = VerifyRangeProof(root, keys[last-1], nil, nil, nil, proof); err == nil {
+		t.Fatalf("empty range before last leaf verified")
+	}
+}
+
+func TestBadRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	root := trie.Hash()
+	keys, values := sortedEntries(vals)
+	for i := 0; i < 100; i++ {
+		start := mrand.Intn(len(keys) - 3)
+		end := start + 3 + mrand.Intn(len(keys)-start-3)
+		proof := proveRange(t, trie, keys[start], keys[end-1])
+		rangeKeys := append([][]byte{}, keys[start:end]...)
+		rangeValues := append([][]byte{}, values[start:end]...)
+
+		j := 1 + mrand.Intn(end-start-2)
+		switch i % 3 {
+		case 0:
+			// modified value
+			rangeValues[j] = randBytes(20)
+		case 1:
+			// dropped leaf
+			rangeKeys = append(rangeKeys[:j:j], rangeKeys[j+1:]...)
+			rangeValues = append(rangeValues[:j:j], rangeValues[j+1:]...)
+		case 2:
+			// made up leaf
+			key := common.CopyBytes(rangeKeys[j])
+			key[len(key)-1]++
+			if bytes.Equal(key, rangeKeys[j+1]) {
+				continue
+			}
+			rangeKeys = append(rangeKeys[:j+1:j+1], append([][]byte{key}, rangeKeys[j+1:]...)...)
+			rangeValues = append(rangeValues[:j+1:j+1], append([][]byte{[]byte("value")}, rangeValues[j+1:]...)...)
+		}
+		if _, err := VerifyRangeProof(root, keys[start], keys[end-1], rangeKeys, rangeValues, proof); err == nil {
+			t.Fatalf("case %d: bad range [%d, %d) verified", i%3, start, end)
+		}
+	}
+}
+
+func TestRangeProofVariableKeys(t *testing.T) {
+	// keys shaped as those of the binding state
+	trie := newEmpty()
+	vals := map[string][]byte{"networkbinding": []byte("amount")}
+	for i := 0; i < 300; i++ {
+		vals[string(randBytes(22))] = randBytes(20)
+		vals["poolpk"+string(randBytes(33))] = randBytes(24)
+	}
+	for k, v := range vals {
+		trie.Update([]byte(k), v)
+	}
+	root := trie.Hash()
+	keys, values := sortedEntries(vals)
+
+	if _, err := VerifyRangeProof(root, nil, nil, keys, values, nil); err != nil {
+		t.Fatalf("whole trie: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		start := mrand.Intn(len(keys) - 1)
+		end := start + 2 + mrand.Intn(len(keys)-start-1)
+		proof := proveRange(t, trie, keys[start], keys[end-1])
+		if _, err := VerifyRangeProof(root, keys[start], keys[end-1], keys[start:end], values[start:end], proof); err != nil {
+			t.Fatalf("range [%d, %d): %v", start, end, err)
+		}
+		proof, _ = trie.Prove(keys[end-1])
+		if _, err := VerifyRangeProof(root, nil, keys[end-1], keys[:end], values[:end], proof); err != nil {
+			t.Fatalf("range [0, %d): %v", end, err)
+		}
+		if _, err := VerifyRangeProof(root, nil, keys[end-1], keys[1:end], values[1:end], proof); err == nil {
+			t.Fatalf("range [0, %d) without first leaf verified", end)
+		}
+		proof = proveRange(t, trie, keys[start], keys[end-1])
+		j := start + mrand.Intn(end-start)
+		dropped := append(append([][]byte{}, keys[start:j]...), keys[j+1:end]...)
+		droppedValues := append(append([][]byte{}, values[start:j]...), values[j+1:end]...)
+		if j != start && j != end-1 {
+			if _, err := VerifyRangeProof(root, keys[start], keys[end-1], dropped, droppedValues, proof); err == nil {
+				t.Fatalf("range [%d, %d) without leaf %d verified", start, end, j)
+			}
+		}
+	}
+}
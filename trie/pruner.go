@@ -0,0 +1,199 @@
+package trie
+
+import (
+	"fmt"
+
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb"
+)
+
+// PruneStats reports the work done by Prune.
+type PruneStats struct {
+	Roots   int // roots kept
+	Kept    int // trie nodes reachable from the roots
+	Deleted int // trie nodes deleted
+	Size    int // bytes of keys and values deleted
+}
+
+// Prune deletes from diskdb the trie nodes that are not reachable from any of
+// roots, by marking the nodes of the tries of roots and then sweeping the rest.
+// Nodes are keyed by their hash, other records of diskdb are kept.
+//
+// The tries of roots must be complete, otherwise a MissingNodeError is returned
+// before anything is deleted.  beforeSweep, if not nil, is called once the
+// tries are marked, so that a record of the pruning is on disk before nodes
+// are deleted.  diskdb must not be written to while pruning, or nodes of a
+// newly committed trie may be swept.
+//
+// Unreachable nodes are deleted parents first, so that a node left by an
+// interrupted sweep still has its whole subtrie on disk.
+func Prune(diskdb massdb.KeyValueStore, roots []common.Hash, beforeSweep func() error) (*PruneStats, error) {
+	stats := &PruneStats{}
+	marked := make(map[common.Hash]struct{})
+	for _, root := range roots {
+		if root == (common.Hash{}) || root == emptyRoot {
+			continue
+		}
+		if err := markTrie(diskdb, root, marked); err != nil {
+			return nil, err
+		}
+		stats.Roots++
+	}
+	stats.Kept = len(marked)
+
+	if beforeSweep != nil {
+		if err := beforeSweep(); err != nil {
+			return nil, err
+		}
+	}
+	if err := sweep(diskdb, marked, stats); err != nil {
+		return nil, err
+	}
+	// reclaim the space of the deleted nodes
+	if stats.Deleted > 0 {
+		if err := diskdb.Compact(nil, nil); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// sweepNode is a node not reachable from the marked tries.
+type sweepNode struct {
+	parents int  // references from unreachable nodes not deleted yet
+	present bool // stored in diskdb, not only referenced
+}
+
+// sweep deletes the nodes in diskdb which are not marked in topological
+// order, a node is deleted once all the unreachable nodes referencing it are.
+func sweep(diskdb massdb.KeyValueStore, marked map[common.Hash]struct{}, stats *PruneStats) error {
+	unreachable := make(map[common.Hash]sweepNode)
+	it := diskdb.NewIterator(nil, nil)
+	for it.Next() {
+		key := it.Key()
+		if len(key) != common.HashLength {
+			continue
+		}
+		hash := common.BytesToHash(key)
+		if _, ok := marked[hash]; ok {
+			continue
+		}
+		sn := unreachable[hash]
+		sn.present = true
+		unreachable[hash] = sn
+		if n, err := decodeNode(key, it.Value()); err == nil {
+			forHashChildren(n, func(child common.Hash) {
+				if _, ok := marked[child]; !ok {
+					sn := unreachable[child]
+					sn.parents++
+					unreachable[child] = sn
+				}
+			})
+		}
+	}
+	err := it.Error()
+	it.Release()
+	if err != nil {
+		return err
+	}
+
+	queue := make([]common.Hash, 0)
+	for hash, sn := range unreachable {
+		if sn.present && sn.parents == 0 {
+			queue = append(queue, hash)
+		}
+	}
+	batch := diskdb.NewBatch()
+	for len(queue) > 0 {
+		hash := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		enc, err := diskdb.Get(hash[:])
+		if err != nil {
+			return err
+		}
+		if err = batch.Delete(hash[:]); err != nil {
+			return err
+		}
+		stats.Deleted++
+		stats.Size += common.HashLength + len(enc)
+		if n, err := decodeNode(hash[:], enc); err == nil {
+			forHashChildren(n, func(child common.Hash) {
+				sn, ok := unreachable[child]
+				if !ok {
+					return
+				}
+				sn.parents--
+				unreachable[child] = sn
+				if sn.parents == 0 && sn.present {
+					queue = append(queue, child)
+				}
+			})
+		}
+		if batch.ValueSize() >= massdb.IdealBatchSize {
+			if err = batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	return batch.Write()
+}
+
+// Prune flushes the dirty node cache of db and deletes the nodes on disk which
+// are not reachable from any of roots, see the Prune function.  The clean node
+// cache is dropped as it may hold deleted nodes.
+func (db *Database) Prune(roots []common.Hash, beforeSweep func() error) (*PruneStats, error) {
+	if err := db.Flush(); err != nil {
+		return nil, err
+	}
+	stats, err := Prune(db.diskdb, roots, beforeSweep)
+	if db.cleans != nil {
+		db.cleans.reset()
+	}
+	return stats, err
+}
+
+// markTrie adds the hashes of all nodes of the trie of root stored in diskdb
+// to marked.  Subtries of nodes already marked are shared with a trie marked
+// before and are not walked again.
+func markTrie(diskdb massdb.KeyValueReader, root common.Hash, marked map[common.Hash]struct{}) error {
+	stack := []common.Hash{root}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := marked[hash]; ok {
+			continue
+		}
+		enc, err := diskdb.Get(hash[:])
+		if err != nil || len(enc) == 0 {
+			return &MissingNodeError{NodeHash: hash}
+		}
+		n, err := decodeNode(hash[:], enc)
+		if err != nil {
+			return fmt.Errorf("trie node %x: %v", hash, err)
+		}
+		marked[hash] = struct{}{}
+		forHashChildren(n, func(child common.Hash) {
+			stack = append(stack, child)
+		})
+	}
+	return nil
+}
+
+// forHashChildren calls onChild with the hash of every child of n stored as a
+// separate node, including the children of nodes embedded in n.
+func forHashChildren(n node, onChild func(common.Hash)) {
+	switch n := n.(type) {
+	case *shortNode:
+		forHashChildren(n.Val, onChild)
+	case *fullNode:
+		for i := 0; i < 16; i++ {
+			forHashChildren(n.Children[i], onChild)
+		}
+	case hashNode:
+		onChild(common.BytesToHash(n))
+	case valueNode, nil:
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
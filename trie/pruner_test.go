@@ -0,0 +1,166 @@
+package trie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb"
+	"github.com/wangxinyu2018/mass-core/trie/massdb/memorydb"
+)
+
+func TestPrune(t *testing.T) {
+	diskdb := memorydb.New()
+	triedb := NewDatabase(diskdb)
+	if err := diskdb.Put([]byte("meta"), []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	// commit a trie version per round, each updating some keys
+	trie, _ := New(common.Hash{}, triedb)
+	var roots []common.Hash
+	var versions []map[string][]byte
+	vals := make(map[string][]byte)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 200; i++ {
+			k, v := randBytes(32), randBytes(20)
+			if round > 0 && i%2 == 0 {
+				// overwrite a key of an earlier round
+				for old := range versions[round-1] {
+					k = []byte(old)
+					break
+				}
+			}
+			trie.Update(k, v)
+			vals[string(k)] = v
+		}
+		root, err := trie.Commit()
+		if err != nil {
+			t.Fatalf("commit round %d: %v", round, err)
+		}
+		roots = append(roots, root)
+		version := make(map[string][]byte, len(vals))
+		for k, v := range vals {
+			version[k] = v
+		}
+		versions = append(versions, version)
+	}
+	before := diskdb.Len()
+
+	// keep the first and last versions, the nodes are deleted after
+	// beforeSweep, parents first
+	nodes := make(map[common.Hash][]byte)
+	it := diskdb.NewIterator(nil, nil)
+	for it.Next() {
+		if len(it.Key()) == common.HashLength {
+			nodes[common.BytesToHash(it.Key())] = common.CopyBytes(it.Value())
+		}
+	}
+	it.Release()
+	recorder := &deleteRecorder{Database: diskdb}
+	swept := false
+	stats, err := Prune(recorder, []common.Hash{roots[0], roots[4], emptyRoot}, func() error {
+		swept = len(recorder.deleted) > 0
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if swept {
+		t.Fatalf("nodes deleted before beforeSweep")
+	}
+	if len(recorder.deleted) != stats.Deleted {
+		t.Fatalf("%d nodes deleted, stats %+v", len(recorder.deleted), stats)
+	}
+	order := make(map[common.Hash]int, len(recorder.deleted))
+	for i, hash := range recorder.deleted {
+		order[hash] = i
+	}
+	for i, hash := range recorder.deleted {
+		n, err := decodeNode(hash[:], nodes[hash])
+		if err != nil {
+			t.Fatalf("decode deleted node %x: %v", hash, err)
+		}
+		forHashChildren(n, func(child common.Hash) {
+			if j, ok := order[child]; ok && j < i {
+				t.Fatalf("node %x deleted after its child %x", hash, child)
+			}
+		})
+	}
+	if stats.Roots != 2 || stats.Deleted == 0 || stats.Kept+stats.Deleted+1 != before {
+		t.Fatalf("unexpected prune stats %+v, %d records before", stats, before)
+	}
+	if data, _ := diskdb.Get([]byte("meta")); !bytes.Equal(data, []byte("data")) {
+		t.Fatalf("record other than trie node deleted")
+	}
+
+	for _, i := range []int{0, 4} {
+		trie, err := New(roots[i], triedb)
+		if err != nil {
+			t.Fatalf("open kept root %d: %v", i, err)
+		}
+		for k, v := range versions[i] {
+			if have, err := trie.TryGet([]byte(k)); err != nil || !bytes.Equal(have, v) {
+				t.Fatalf("root %d key %x: have %x, want %x, err %v", i, k, have, v, err)
+			}
+		}
+		it := trie.NodeIterator(nil)
+		for it.Next(true) {
+		}
+		if err := it.Error(); err != nil {
+			t.Fatalf("iterate kept root %d: %v", i, err)
+		}
+	}
+	for _, i := range []int{1, 2, 3} {
+		if _, err := New(roots[i], triedb); err == nil {
+			t.Fatalf("pruned root %d still opens", i)
+		}
+	}
+
+	// pruning again with a pruned root fails before deleting anything
+	before = diskdb.Len()
+	if _, err := Prune(diskdb, []common.Hash{roots[4], roots[2]}, func() error {
+		t.Fatalf("beforeSweep called by failed prune")
+		return nil
+	}); err == nil {
+		t.Fatalf("prune with missing root succeeded")
+	} else if _, ok := err.(*MissingNodeError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if diskdb.Len() != before {
+		t.Fatalf("records deleted by failed prune")
+	}
+}
+
+// deleteRecorder records the keys deleted by the batches of a memory database
+// in the order they are written.
+type deleteRecorder struct {
+	*memorydb.Database
+	deleted []common.Hash
+}
+
+func (r *deleteRecorder) NewBatch() massdb.Batch {
+	return &recordingBatch{Batch: r.Database.NewBatch(), r: r}
+}
+
+type recordingBatch struct {
+	massdb.Batch
+	r       *deleteRecorder
+	deletes []common.Hash
+}
+
+func (b *recordingBatch) Delete(key []byte) error {
+	b.deletes = append(b.deletes, common.BytesToHash(key))
+	return b.Batch.Delete(key)
+}
+
+func (b *recordingBatch) Write() error {
+	b.r.deleted = append(b.r.deleted, b.deletes...)
+	b.deletes = nil
+	return b.Batch.Write()
+}
+
+func (b *recordingBatch) Reset() {
+	b.deletes = nil
+	b.Batch.Reset()
+}
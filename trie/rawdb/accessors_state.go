@@ -1,6 +1,8 @@
 package rawdb
 
 import (
+	"encoding/binary"
+
 	"github.com/wangxinyu2018/mass-core/logging"
 	"github.com/wangxinyu2018/mass-core/trie/common"
 	"github.com/wangxinyu2018/mass-core/trie/massdb"
@@ -18,3 +20,43 @@ func WriteTrieNode(db massdb.KeyValueWriter, hash common.Hash, node []byte) {
 		logging.CPrint(logging.PANIC, "failed to store trie node", logging.LogFormat{"err": err})
 	}
 }
+
+// prunedHeightKey holds the height, big endian, below which unreachable tries
+// have been pruned from the database.
+var prunedHeightKey = []byte("PrunedHeight")
+
+// ReadPrunedHeight retrieves the height the database has been pruned below,
+// and false if it has never been pruned.
+func ReadPrunedHeight(db massdb.KeyValueReader) (uint64, bool) {
+	data, _ := db.Get(prunedHeightKey)
+	if len(data) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(data), true
+}
+
+// WritePrunedHeight records the height the database has been pruned below.
+func WritePrunedHeight(db massdb.KeyValueWriter, height uint64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], height)
+	return db.Put(prunedHeightKey, buf[:])
+}
+
+// flushedRootKey holds the root of the last trie committed before the latest
+// flush of the trie database, whose nodes are all on disk.
+var flushedRootKey = []byte("FlushedRoot")
+
+// ReadFlushedRoot retrieves the root of the last trie wholly flushed to disk,
+// and false if none has been recorded.
+func ReadFlushedRoot(db massdb.KeyValueReader) (common.Hash, bool) {
+	data, _ := db.Get(flushedRootKey)
+	if len(data) != common.HashLength {
+		return common.Hash{}, false
+	}
+	return common.BytesToHash(data), true
+}
+
+// WriteFlushedRoot records the root of the last trie wholly flushed to disk.
+func WriteFlushedRoot(db massdb.KeyValueWriter, root common.Hash) error {
+	return db.Put(flushedRootKey, root.Bytes())
+}
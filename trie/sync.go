@@ -0,0 +1,147 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb"
+	"github.com/wangxinyu2018/mass-core/trie/rawdb"
+)
+
+// ErrNotRequested is returned by Sync.Process for a node which is not waited
+// for, it is not in the trie or has been delivered already.
+var ErrNotRequested = errors.New("trie node not requested")
+
+// syncRequest is a trie node waited for by a Sync.
+type syncRequest struct {
+	hash    common.Hash
+	enc     []byte         // encoded node, nil until delivered
+	parents []*syncRequest // delivered nodes referencing this one
+	deps    int            // children not committed yet
+}
+
+// Sync downloads the trie of a root node by node, verifying each node by its
+// hash.  A node is committed after all of its children are, so a node found
+// in the database always has its whole subtrie there, and an interrupted sync
+// started again skips the subtries it has written already.
+//
+// Sync is not safe for concurrent use.
+type Sync struct {
+	database massdb.KeyValueReader
+	requests map[common.Hash]*syncRequest // nodes waited for or delivered but not committed
+	queue    []common.Hash                // nodes to request, in scheduling order
+	membatch map[common.Hash][]byte       // nodes committed but not written
+	order    []common.Hash                // written order of membatch, children first
+}
+
+// NewSync creates a Sync of the trie of root missing in database.
+func NewSync(root common.Hash, database massdb.KeyValueReader) *Sync {
+	s := &Sync{
+		database: database,
+		requests: make(map[common.Hash]*syncRequest),
+		membatch: make(map[common.Hash][]byte),
+	}
+	if root != (common.Hash{}) && root != emptyRoot && !s.has(root) {
+		s.schedule(root, nil)
+	}
+	return s
+}
+
+// Missing returns the hashes of at most max nodes to be requested, 0 for no
+// limit.  Nodes are returned again until delivered.
+func (s *Sync) Missing(max int) []common.Hash {
+	var hashes []common.Hash
+	queue := s.queue[:0]
+	for _, hash := range s.queue {
+		if req, ok := s.requests[hash]; !ok || req.enc != nil {
+			continue
+		}
+		queue = append(queue, hash)
+		if max == 0 || len(hashes) < max {
+			hashes = append(hashes, hash)
+		}
+	}
+	s.queue = queue
+	return hashes
+}
+
+// Process verifies a delivered encoded node and schedules its children which
+// are not in the database.
+func (s *Sync) Process(enc []byte) error {
+	hasher := newHasher(false)
+	hash := common.BytesToHash(hasher.hashData(enc))
+	returnHasherToPool(hasher)
+
+	req, ok := s.requests[hash]
+	if !ok || req.enc != nil {
+		return fmt.Errorf("%w: %x", ErrNotRequested, hash)
+	}
+	n, err := decodeNode(hash[:], enc)
+	if err != nil {
+		return fmt.Errorf("trie node %x: %v", hash, err)
+	}
+	req.enc = enc
+
+	forHashChildren(n, func(child common.Hash) {
+		if s.has(child) {
+			return
+		}
+		s.schedule(child, req)
+		req.deps++
+	})
+	if req.deps == 0 {
+		s.commit(req)
+	}
+	return nil
+}
+
+// Commit writes the nodes whose subtries are complete to dbw, and returns the
+// number of nodes written.
+func (s *Sync) Commit(dbw massdb.KeyValueWriter) int {
+	for _, hash := range s.order {
+		rawdb.WriteTrieNode(dbw, hash, s.membatch[hash])
+	}
+	n := len(s.order)
+	s.membatch = make(map[common.Hash][]byte)
+	s.order = nil
+	return n
+}
+
+// Pending returns the number of nodes not committed yet, the trie is complete
+// once no node is pending.
+func (s *Sync) Pending() int {
+	return len(s.requests)
+}
+
+func (s *Sync) has(hash common.Hash) bool {
+	if _, ok := s.membatch[hash]; ok {
+		return true
+	}
+	return len(rawdb.ReadTrieNode(s.database, hash)) != 0
+}
+
+func (s *Sync) schedule(hash common.Hash, parent *syncRequest) {
+	req, ok := s.requests[hash]
+	if !ok {
+		req = &syncRequest{hash: hash}
+		s.requests[hash] = req
+		s.queue = append(s.queue, hash)
+	}
+	if parent != nil {
+		req.parents = append(req.parents, parent)
+	}
+}
+
+// commit moves req to the membatch, and so its parents whose children are
+// all committed.
+func (s *Sync) commit(req *syncRequest) {
+	s.membatch[req.hash] = req.enc
+	s.order = append(s.order, req.hash)
+	delete(s.requests, req.hash)
+	for _, parent := range req.parents {
+		if parent.deps--; parent.deps == 0 {
+			s.commit(parent)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/wangxinyu2018/mass-core/trie/common"
+	"github.com/wangxinyu2018/mass-core/trie/massdb/memorydb"
+)
+
+// syncTrie runs s against the trie nodes of srcdb, max nodes per round, and
+// stops after rounds rounds if it is not 0.
+func syncTrie(t *testing.T, s *Sync, srcdb *Database, dstdb *memorydb.Database, max, rounds int) {
+	for i := 0; s.Pending() > 0 && (rounds == 0 || i < rounds); i++ {
+		hashes := s.Missing(max)
+		if len(hashes) == 0 {
+			t.Fatalf("no missing nodes with %d pending", s.Pending())
+		}
+		for _, hash := range hashes {
+			enc, err := srcdb.Node(hash)
+			if err != nil {
+				t.Fatalf("node %x: %v", hash, err)
+			}
+			if err := s.Process(enc); err != nil {
+				t.Fatalf("process node %x: %v", hash, err)
+			}
+		}
+		batch := dstdb.NewBatch()
+		s.Commit(batch)
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkSyncedTrie(t *testing.T, root common.Hash, db *memorydb.Database, vals map[string][]byte) {
+	trie, err := New(root, NewDatabase(db))
+	if err != nil {
+		t.Fatalf("open synced trie: %v", err)
+	}
+	for k, v := range vals {
+		if have := trie.Get([]byte(k)); !bytes.Equal(have, v) {
+			t.Fatalf("key %x: have %x, want %x", k, have, v)
+		}
+	}
+	it := trie.NodeIterator(nil)
+	for it.Next(true) {
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iterate synced trie: %v", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	srcTrie, vals := randomTrie(2000)
+	srcdb := NewDatabase(memorydb.New())
+	srcTrie.db = srcdb
+	root, err := srcTrie.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, max := range []int{0, 1, 100} {
+		dstdb := memorydb.New()
+		s := NewSync(root, dstdb)
+		syncTrie(t, s, srcdb, dstdb, max, 0)
+		checkSyncedTrie(t, root, dstdb, vals)
+
+		// a synced trie needs nothing
+		if s := NewSync(root, dstdb); s.Pending() != 0 {
+			t.Fatalf("%d nodes pending for a synced trie", s.Pending())
+		}
+	}
+}
+
+func TestSyncResume(t *testing.T) {
+	srcTrie, vals := randomTrie(2000)
+	srcdb := NewDatabase(memorydb.New())
+	srcTrie.db = srcdb
+	root, _ := srcTrie.Commit()
+
+	dstdb := memorydb.New()
+	syncTrie(t, NewSync(root, dstdb), srcdb, dstdb, 50, 10)
+	written := dstdb.Len()
+	if written == 0 {
+		t.Fatal("no nodes written before interruption")
+	}
+
+	// the written subtries are complete and not requested again
+	s := NewSync(root, dstdb)
+	requested := 0
+	for s.Pending() > 0 {
+		hashes := s.Missing(0)
+		requested += len(hashes)
+		syncTrie(t, s, srcdb, dstdb, 0, 1)
+	}
+	checkSyncedTrie(t, root, dstdb, vals)
+	if requested+written != dstdb.Len() {
+		t.Fatalf("%d nodes requested after %d written, %d in total", requested, written, dstdb.Len())
+	}
+}
+
+func TestSyncBadNode(t *testing.T) {
+	srcTrie, _ := randomTrie(100)
+	srcdb := NewDatabase(memorydb.New())
+	srcTrie.db = srcdb
+	root, _ := srcTrie.Commit()
+
+	s := NewSync(root, memorydb.New())
+	enc, _ := srcdb.Node(root)
+	tampered := common.CopyBytes(enc)
+	tampered[len(tampered)-1] ^= 1
+	if err := s.Process(tampered); !errors.Is(err, ErrNotRequested) {
+		t.Fatalf("tampered node: %v", err)
+	}
+	if err := s.Process(enc); err != nil {
+		t.Fatalf("root node: %v", err)
+	}
+	if err := s.Process(enc); !errors.Is(err, ErrNotRequested) {
+		t.Fatalf("root node delivered twice: %v", err)
+	}
+
+	// an empty trie needs nothing
+	if s := NewSync(emptyRoot, memorydb.New()); s.Pending() != 0 || len(s.Missing(0)) != 0 {
+		t.Fatalf("nodes pending for the empty trie")
+	}
+}
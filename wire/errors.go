@@ -27,4 +27,11 @@ var (
 	errMisuseSignatureType       = errors.New("misuse of signature type")
 	errMisuseProofType           = errors.New("misuse of proof type")
 	errMisuseBindingRoot         = errors.New("misuse of binding root")
+	errPartialMerkleNoTx         = errors.New("partial merkle tree has no transactions")
+	errPartialMerkleTooManyTxs   = errors.New("partial merkle tree has too many transactions")
+	errPartialMerkleBadHashes    = errors.New("partial merkle tree has more hashes than transactions")
+	errPartialMerkleBadFlags     = errors.New("partial merkle tree has fewer flag bits than hashes")
+	errPartialMerkleOverflow     = errors.New("partial merkle tree overflowed its flags or hashes")
+	errPartialMerkleUnusedData   = errors.New("partial merkle tree has unused flags or hashes")
+	errPartialMerkleDuplicateLR  = errors.New("partial merkle tree has identical left and right branches")
 )
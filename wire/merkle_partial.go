@@ -0,0 +1,174 @@
+package wire
+
+// PartialMerkleTree represents a subset of the transaction merkle tree of a
+// block, which proves that some transactions are included in that block.
+//
+// The tree is encoded by a depth-first traversal.  One flag bit is used for
+// each node visited, it indicates whether the node is a parent of at least one
+// matched transaction.  Hashes are stored for nodes which are not descended
+// into, and for matched transactions themselves.
+type PartialMerkleTree struct {
+	NumTx  uint32
+	Hashes []*Hash
+	Flags  []byte
+}
+
+// calcTreeWidth returns the number of nodes at the given height, with 0 being
+// the height of transactions.
+func calcTreeWidth(numTx, height uint32) uint32 {
+	return (numTx + (1 << height) - 1) >> height
+}
+
+// calcTreeHeight returns the height of the merkle root.
+func calcTreeHeight(numTx uint32) uint32 {
+	height := uint32(0)
+	for calcTreeWidth(numTx, height) > 1 {
+		height++
+	}
+	return height
+}
+
+// NewPartialMerkleTree builds a partial merkle tree of transactions, matched
+// marks transactions to be proved and must have the same length as
+// transactions.
+func NewPartialMerkleTree(transactions []*MsgTx, matched []bool) *PartialMerkleTree {
+	numTx := uint32(len(transactions))
+	if numTx == 0 {
+		return &PartialMerkleTree{}
+	}
+	b := &partialMerkleBuilder{
+		numTx:   numTx,
+		store:   BuildMerkleTreeStoreTransactions(transactions, false),
+		levels:  make([]uint32, 0),
+		matched: matched,
+	}
+
+	// offset of each level in the linear array of merkle tree store
+	offset := uint32(0)
+	for width := uint32(nextPowerOfTwo(len(transactions))); width > 0; width >>= 1 {
+		b.levels = append(b.levels, offset)
+		offset += width
+	}
+
+	b.traverseAndBuild(calcTreeHeight(numTx), 0)
+
+	tree := &PartialMerkleTree{
+		NumTx:  numTx,
+		Hashes: b.hashes,
+		Flags:  make([]byte, (len(b.bits)+7)/8),
+	}
+	for i, bit := range b.bits {
+		if bit {
+			tree.Flags[i/8] |= 1 << (uint(i) % 8)
+		}
+	}
+	return tree
+}
+
+type partialMerkleBuilder struct {
+	numTx   uint32
+	store   []*Hash
+	levels  []uint32
+	matched []bool
+	hashes  []*Hash
+	bits    []bool
+}
+
+func (b *partialMerkleBuilder) traverseAndBuild(height, pos uint32) {
+	// whether this node is a parent of at least one matched transaction
+	isParent := false
+	for i := pos << height; i < (pos+1)<<height && i < b.numTx; i++ {
+		if b.matched[i] {
+			isParent = true
+			break
+		}
+	}
+	b.bits = append(b.bits, isParent)
+
+	if height == 0 || !isParent {
+		b.hashes = append(b.hashes, b.store[b.levels[height]+pos])
+		return
+	}
+
+	b.traverseAndBuild(height-1, pos*2)
+	if pos*2+1 < calcTreeWidth(b.numTx, height-1) {
+		b.traverseAndBuild(height-1, pos*2+1)
+	}
+}
+
+// ExtractMatches rebuilds the merkle root from the partial merkle tree, and
+// returns hashes of matched transactions in the order they appear in block.
+// Callers must check the root against BlockHeader.TransactionRoot.
+func (t *PartialMerkleTree) ExtractMatches() (root Hash, matches []Hash, err error) {
+	if t.NumTx == 0 {
+		return Hash{}, nil, errPartialMerkleNoTx
+	}
+	if t.NumTx > MaxTxPerBlock {
+		return Hash{}, nil, errPartialMerkleTooManyTxs
+	}
+	if uint32(len(t.Hashes)) > t.NumTx {
+		return Hash{}, nil, errPartialMerkleBadHashes
+	}
+	if len(t.Flags)*8 < len(t.Hashes) {
+		return Hash{}, nil, errPartialMerkleBadFlags
+	}
+
+	e := &partialMerkleExtractor{tree: t, matches: make([]Hash, 0)}
+	rootHash, err := e.traverseAndExtract(calcTreeHeight(t.NumTx), 0)
+	if err != nil {
+		return Hash{}, nil, err
+	}
+	// all flag bytes and hashes must be consumed
+	if (e.bitsUsed+7)/8 != len(t.Flags) || e.hashesUsed != len(t.Hashes) {
+		return Hash{}, nil, errPartialMerkleUnusedData
+	}
+	return *rootHash, e.matches, nil
+}
+
+type partialMerkleExtractor struct {
+	tree       *PartialMerkleTree
+	bitsUsed   int
+	hashesUsed int
+	matches    []Hash
+}
+
+func (e *partialMerkleExtractor) traverseAndExtract(height, pos uint32) (*Hash, error) {
+	if e.bitsUsed >= len(e.tree.Flags)*8 {
+		return nil, errPartialMerkleOverflow
+	}
+	isParent := e.tree.Flags[e.bitsUsed/8]&(1<<(uint(e.bitsUsed)%8)) != 0
+	e.bitsUsed++
+
+	if height == 0 || !isParent {
+		if e.hashesUsed >= len(e.tree.Hashes) {
+			return nil, errPartialMerkleOverflow
+		}
+		hash := e.tree.Hashes[e.hashesUsed]
+		e.hashesUsed++
+		if hash == nil {
+			return nil, errPartialMerkleOverflow
+		}
+		if height == 0 && isParent {
+			e.matches = append(e.matches, *hash)
+		}
+		return hash, nil
+	}
+
+	left, err := e.traverseAndExtract(height-1, pos*2)
+	if err != nil {
+		return nil, err
+	}
+	right := left
+	if pos*2+1 < calcTreeWidth(e.tree.NumTx, height-1) {
+		right, err = e.traverseAndExtract(height-1, pos*2+1)
+		if err != nil {
+			return nil, err
+		}
+		// identical branches make different transaction lists share
+		// the same merkle root (CVE-2012-2459)
+		if right.IsEqual(left) {
+			return nil, errPartialMerkleDuplicateLR
+		}
+	}
+	return HashMerkleBranches(left, right), nil
+}
@@ -0,0 +1,94 @@
+package wire
+
+import (
+	"testing"
+)
+
+func TestPartialMerkleTree(t *testing.T) {
+	tests := []struct {
+		txCount int
+		matched []int
+	}{
+		{1, []int{}},
+		{1, []int{0}},
+		{2, []int{1}},
+		{3, []int{2}},
+		{5, []int{0, 4}},
+		{7, []int{1, 2, 3}},
+		{9, []int{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+		{16, []int{15}},
+	}
+
+	for i, test := range tests {
+		blk := mockBlock(test.txCount)
+		merkles := BuildMerkleTreeStoreTransactions(blk.Transactions, false)
+		wantRoot := merkles[len(merkles)-1]
+
+		matched := make([]bool, test.txCount)
+		for _, idx := range test.matched {
+			matched[idx] = true
+		}
+		tree := NewPartialMerkleTree(blk.Transactions, matched)
+		root, matches, err := tree.ExtractMatches()
+		if err != nil {
+			t.Fatalf("%d, ExtractMatches error: %v", i, err)
+		}
+		if !root.IsEqual(wantRoot) {
+			t.Errorf("%d, merkle root not equal, got = %v, want = %v", i, root, wantRoot)
+		}
+		if len(matches) != len(test.matched) {
+			t.Fatalf("%d, matches count not equal, got = %d, want = %d", i, len(matches), len(test.matched))
+		}
+		for j, idx := range test.matched {
+			txHash := blk.Transactions[idx].TxHash()
+			if !matches[j].IsEqual(&txHash) {
+				t.Errorf("%d, match %d not equal, got = %v, want = %v", i, j, matches[j], txHash)
+			}
+		}
+	}
+}
+
+func TestPartialMerkleTreeMalformed(t *testing.T) {
+	blk := mockBlock(6)
+	matched := []bool{false, true, false, false, true, false}
+	merkles := BuildMerkleTreeStoreTransactions(blk.Transactions, false)
+	wantRoot := merkles[len(merkles)-1]
+
+	// tampered hash results in another root
+	tree := NewPartialMerkleTree(blk.Transactions, matched)
+	tampered := *tree.Hashes[0]
+	tampered[0] ^= 0xff
+	tree.Hashes[0] = &tampered
+	root, _, err := tree.ExtractMatches()
+	if err != nil {
+		t.Fatalf("ExtractMatches error: %v", err)
+	}
+	if root.IsEqual(wantRoot) {
+		t.Errorf("tampered tree should not match merkle root")
+	}
+
+	tree = NewPartialMerkleTree(blk.Transactions, matched)
+	tree.Hashes = tree.Hashes[:len(tree.Hashes)-1]
+	if _, _, err := tree.ExtractMatches(); err != errPartialMerkleOverflow {
+		t.Errorf("missing hash, got = %v, want = %v", err, errPartialMerkleOverflow)
+	}
+
+	tree = NewPartialMerkleTree(blk.Transactions, matched)
+	tree.Hashes = append(tree.Hashes, tree.Hashes[0])
+	if _, _, err := tree.ExtractMatches(); err != errPartialMerkleUnusedData {
+		t.Errorf("extra hash, got = %v, want = %v", err, errPartialMerkleUnusedData)
+	}
+
+	tree = NewPartialMerkleTree(blk.Transactions, matched)
+	tree.NumTx = 0
+	if _, _, err := tree.ExtractMatches(); err != errPartialMerkleNoTx {
+		t.Errorf("no transactions, got = %v, want = %v", err, errPartialMerkleNoTx)
+	}
+
+	// duplicated transactions at the end of block (CVE-2012-2459)
+	txs := append(blk.Transactions[:6:6], blk.Transactions[4], blk.Transactions[5])
+	tree = NewPartialMerkleTree(txs, []bool{false, false, false, false, false, false, false, true})
+	if _, _, err := tree.ExtractMatches(); err != errPartialMerkleDuplicateLR {
+		t.Errorf("duplicated branches, got = %v, want = %v", err, errPartialMerkleDuplicateLR)
+	}
+}